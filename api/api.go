@@ -268,6 +268,7 @@ func (h *caHandler) Route(r Router) {
 	r.MethodFunc("POST", "/ssh/config/{type}", h.SSHConfig)
 	r.MethodFunc("POST", "/ssh/check-host", h.SSHCheckHost)
 	r.MethodFunc("GET", "/ssh/hosts", h.SSHGetHosts)
+	r.MethodFunc("GET", "/ssh/krl", h.SSHKRL)
 	r.MethodFunc("POST", "/ssh/bastion", h.SSHBastion)
 
 	// For compatibility with old code:
@@ -395,6 +396,21 @@ func logOtt(w http.ResponseWriter, token string) {
 	}
 }
 
+func logAuthorizedIdentity(w http.ResponseWriter, signOpts []provisioner.SignOption) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		if id, ok := provisioner.GetAuthorizedIdentity(signOpts); ok {
+			rl.WithFields(map[string]interface{}{
+				"identity-subject":        id.Subject,
+				"identity-tenant-id":      id.TenantID,
+				"identity-resource-group": id.ResourceGroup,
+				"identity-name":           id.Name,
+				"identity-vm-id":          id.VMID,
+				"identity-credential":     id.Credential,
+			})
+		}
+	}
+}
+
 func logCertificate(w http.ResponseWriter, cert *x509.Certificate) {
 	if rl, ok := w.(logging.ResponseLogger); ok {
 		m := map[string]interface{}{
@@ -570,6 +570,7 @@ type mockAuthority struct {
 	getSSHConfig                 func(ctx context.Context, typ string, data map[string]string) ([]templates.Output, error)
 	checkSSHHost                 func(ctx context.Context, principal, token string) (bool, error)
 	getSSHBastion                func(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	getSSHKRL                    func(ctx context.Context) ([]byte, error)
 	version                      func() authority.Version
 }
 
@@ -739,6 +740,13 @@ func (m *mockAuthority) GetSSHBastion(ctx context.Context, user string, hostname
 	return m.ret1.(*authority.Bastion), m.err
 }
 
+func (m *mockAuthority) GetSSHKRL(ctx context.Context) ([]byte, error) {
+	if m.getSSHKRL != nil {
+		return m.getSSHKRL(ctx)
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAuthority) Version() authority.Version {
 	if m.version != nil {
 		return m.version()
@@ -68,6 +68,7 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errs.UnauthorizedErr(err))
 		return
 	}
+	logAuthorizedIdentity(w, signOpts)
 
 	certChain, err := h.Authority.Sign(body.CsrPEM.CertificateRequest, opts, signOpts...)
 	if err != nil {
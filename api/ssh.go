@@ -29,6 +29,7 @@ type SSHAuthority interface {
 	CheckSSHHost(ctx context.Context, principal string, token string) (bool, error)
 	GetSSHHosts(ctx context.Context, cert *x509.Certificate) ([]sshutil.Host, error)
 	GetSSHBastion(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	GetSSHKRL(ctx context.Context) ([]byte, error)
 }
 
 // SSHSignRequest is the request body of an SSH certificate request.
@@ -459,6 +460,20 @@ func (h *caHandler) SSHGetHosts(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// SSHKRL is an HTTP handler that returns the OpenSSH Key Revocation List with
+// the revoked SSH certificates. The response can be used in the RevokedKeys
+// option of sshd.
+func (h *caHandler) SSHKRL(w http.ResponseWriter, r *http.Request) {
+	krl, err := h.Authority.GetSSHKRL(r.Context())
+	if err != nil {
+		WriteError(w, errs.InternalServerErr(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(krl)
+}
+
 // SSHBastion provides returns the bastion configured if any.
 func (h *caHandler) SSHBastion(w http.ResponseWriter, r *http.Request) {
 	var body SSHBastionRequest
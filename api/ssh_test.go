@@ -21,6 +21,7 @@ import (
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
@@ -619,6 +620,53 @@ func Test_caHandler_SSHGetHosts(t *testing.T) {
 	}
 }
 
+func Test_caHandler_SSHKRL(t *testing.T) {
+	krl := []byte("SSHKRL\n\x00")
+	tests := []struct {
+		name       string
+		krl        []byte
+		err        error
+		statusCode int
+	}{
+		{"ok", krl, nil, http.StatusOK},
+		{"not found", nil, errs.NotFound("ssh is not configured"), http.StatusNotFound},
+		{"not implemented", nil, errs.NotImplemented("not implemented"), http.StatusNotImplemented},
+		{"error", nil, fmt.Errorf("an error"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				getSSHKRL: func(context.Context) ([]byte, error) {
+					return tt.krl, tt.err
+				},
+			}).(*caHandler)
+
+			req := httptest.NewRequest("GET", "http://example.com/ssh/krl", http.NoBody)
+			w := httptest.NewRecorder()
+			h.SSHKRL(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.SSHKRL StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.SSHKRL unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if ct := res.Header.Get("Content-Type"); ct != "application/octet-stream" {
+					t.Errorf("caHandler.SSHKRL Content-Type = %s, wants application/octet-stream", ct)
+				}
+				if !bytes.Equal(body, tt.krl) {
+					t.Errorf("caHandler.SSHKRL Body = %x, wants %x", body, tt.krl)
+				}
+			}
+		})
+	}
+}
+
 func Test_caHandler_SSHBastion(t *testing.T) {
 	bastion := &authority.Bastion{
 		Hostname: "bastion.local",
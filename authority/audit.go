@@ -0,0 +1,99 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewJSONIssuanceFunc returns an IssuanceFunc that writes every issuance event
+// as a line of JSON to the given writer, e.g. a file or a pipe read by a log
+// shipper. Writes are serialized, and write errors are ignored as they must
+// not fail the already signed certificate.
+func NewJSONIssuanceFunc(w io.Writer) provisioner.IssuanceFunc {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(ctx context.Context, event *provisioner.IssuanceEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(event)
+	}
+}
+
+// newIssuanceEvent returns an IssuanceEvent with the provisioner, token subject
+// and identity in the given sign options.
+func newIssuanceEvent(signOpts []provisioner.SignOption) *provisioner.IssuanceEvent {
+	event := &provisioner.IssuanceEvent{
+		Time: time.Now().UTC(),
+	}
+	if req, ok := provisioner.GetAuthorizedRequest(signOpts); ok {
+		event.ProvisionerID = req.ProvisionerID
+		event.ProvisionerName = req.ProvisionerName
+		event.ProvisionerType = req.ProvisionerType.String()
+		event.TokenSubject = req.TokenSubject
+	}
+	if identity, ok := provisioner.GetAuthorizedIdentity(signOpts); ok {
+		event.Identity = identity
+	}
+	return event
+}
+
+// auditX509Issuance sends an issuance event for the given X.509 certificate to
+// the issuance function if one is configured. If the sign options do not
+// contain the authorized request, e.g. on renewals, the provisioner is loaded
+// from the certificate extension.
+func (a *Authority) auditX509Issuance(ctx context.Context, cert *x509.Certificate, signOpts []provisioner.SignOption) {
+	if a.issuanceFunc == nil {
+		return
+	}
+
+	event := newIssuanceEvent(signOpts)
+	if event.ProvisionerID == "" {
+		if p, ok := a.provisioners.LoadByCertificate(cert); ok {
+			event.ProvisionerID = p.GetID()
+			event.ProvisionerName = p.GetName()
+			event.ProvisionerType = p.GetType().String()
+		}
+	}
+	event.CertificateType = "x509"
+	event.SerialNumber = cert.SerialNumber.String()
+	event.Subject = cert.Subject.CommonName
+	event.SANs = append(event.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		event.SANs = append(event.SANs, ip.String())
+	}
+	event.SANs = append(event.SANs, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		event.SANs = append(event.SANs, u.String())
+	}
+	event.NotBefore = cert.NotBefore
+	event.NotAfter = cert.NotAfter
+	a.issuanceFunc(ctx, event)
+}
+
+// auditSSHIssuance sends an issuance event for the given SSH certificate to the
+// issuance function if one is configured. The principals of the certificate
+// are sent as the SANs, and NotAfter is zero if the certificate never expires.
+func (a *Authority) auditSSHIssuance(ctx context.Context, cert *ssh.Certificate, signOpts []provisioner.SignOption) {
+	if a.issuanceFunc == nil {
+		return
+	}
+
+	event := newIssuanceEvent(signOpts)
+	event.CertificateType = "ssh"
+	event.SerialNumber = strconv.FormatUint(cert.Serial, 10)
+	event.Subject = cert.KeyId
+	event.SANs = append(event.SANs, cert.ValidPrincipals...)
+	event.NotBefore = time.Unix(int64(cert.ValidAfter), 0).UTC()
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		event.NotAfter = time.Unix(int64(cert.ValidBefore), 0).UTC()
+	}
+	a.issuanceFunc(ctx, event)
+}
@@ -0,0 +1,57 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func TestNewJSONIssuanceFunc(t *testing.T) {
+	var buf bytes.Buffer
+	fn := NewJSONIssuanceFunc(&buf)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	fn(context.Background(), &provisioner.IssuanceEvent{
+		Time:            now,
+		ProvisionerName: "azure",
+		ProvisionerType: "Azure",
+		TokenSubject:    "https://management.azure.com/",
+		CertificateType: "x509",
+		SerialNumber:    "1234",
+		Subject:         "virtualMachine",
+		SANs:            []string{"virtualMachine"},
+		NotBefore:       now,
+		NotAfter:        now.Add(24 * time.Hour),
+		Identity:        &provisioner.AuthorizedIdentity{TenantID: "tenant", VMID: "vm-id"},
+	})
+	fn(context.Background(), &provisioner.IssuanceEvent{
+		Time:            now,
+		CertificateType: "ssh",
+		SerialNumber:    "5678",
+		Subject:         "user@smallstep.com",
+	})
+
+	dec := json.NewDecoder(&buf)
+	var events []map[string]interface{}
+	for dec.More() {
+		var m map[string]interface{}
+		assert.FatalError(t, dec.Decode(&m))
+		events = append(events, m)
+	}
+	if assert.Len(t, 2, events) {
+		assert.Equals(t, "azure", events[0]["provisionerName"])
+		assert.Equals(t, "Azure", events[0]["provisionerType"])
+		assert.Equals(t, "https://management.azure.com/", events[0]["tokenSubject"])
+		assert.Equals(t, "1234", events[0]["serialNumber"])
+		assert.Equals(t, []interface{}{"virtualMachine"}, events[0]["sans"])
+		assert.Equals(t, "vm-id", events[0]["identity"].(map[string]interface{})["VMID"])
+		assert.Equals(t, "ssh", events[1]["certificateType"])
+		_, ok := events[1]["identity"]
+		assert.False(t, ok)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"io"
 	"log"
 	"sync"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/fips"
 	"github.com/smallstep/certificates/kms"
 	kmsapi "github.com/smallstep/certificates/kms/apiv1"
 	"github.com/smallstep/certificates/sshutil"
@@ -52,11 +54,19 @@ type Authority struct {
 	initOnce  bool
 	startTime time.Time
 
+	// Certificates signed by each provisioner and subject
+	rateLimiter provisioner.RateLimiter
+
 	// Custom functions
 	sshBastionFunc   func(ctx context.Context, user, hostname string) (*Bastion, error)
 	sshCheckHostFunc func(ctx context.Context, principal string, tok string, roots []*x509.Certificate) (bool, error)
 	sshGetHostsFunc  func(ctx context.Context, cert *x509.Certificate) ([]sshutil.Host, error)
 	getIdentityFunc  provisioner.GetIdentityFunc
+	auditFunc        provisioner.AuditFunc
+	issuanceFunc     provisioner.IssuanceFunc
+
+	// Source of randomness of the certificates
+	rand io.Reader
 }
 
 // New creates and initiates a new Authority type.
@@ -133,6 +143,12 @@ func (a *Authority) init() error {
 
 	var err error
 
+	// Restrict the algorithms to the FIPS approved ones. The mode is global
+	// and it also applies to the key manager and the provisioners.
+	if a.config.FIPS {
+		fips.Enable()
+	}
+
 	// Initialize key manager if it has not been set in the options.
 	if a.keyManager == nil {
 		var options kmsapi.Options
@@ -198,6 +214,9 @@ func (a *Authority) init() error {
 		if err != nil {
 			return err
 		}
+		if err := fips.CheckPublicKey(signer.Public()); err != nil {
+			return errors.Wrap(err, "error loading intermediate key")
+		}
 		a.x509Signer = signer
 		a.x509Issuer = crt
 	}
@@ -213,6 +232,9 @@ func (a *Authority) init() error {
 			if err != nil {
 				return err
 			}
+			if err := fips.CheckPublicKey(signer.Public()); err != nil {
+				return errors.Wrap(err, "error loading ssh host key")
+			}
 			a.sshCAHostCertSignKey, err = ssh.NewSignerFromSigner(signer)
 			if err != nil {
 				return errors.Wrap(err, "error creating ssh signer")
@@ -229,6 +251,9 @@ func (a *Authority) init() error {
 			if err != nil {
 				return err
 			}
+			if err := fips.CheckPublicKey(signer.Public()); err != nil {
+				return errors.Wrap(err, "error loading ssh user key")
+			}
 			a.sshCAUserCertSignKey, err = ssh.NewSignerFromSigner(signer)
 			if err != nil {
 				return errors.Wrap(err, "error creating ssh signer")
@@ -267,31 +292,12 @@ func (a *Authority) init() error {
 		tmplVars.SSH.UserFederatedKeys = append(tmplVars.SSH.UserFederatedKeys, a.sshCAUserFederatedCerts[1:]...)
 	}
 
-	// Merge global and configuration claims
-	claimer, err := provisioner.NewClaimer(a.config.AuthorityConfig.Claims, globalProvisionerClaims)
-	if err != nil {
-		return err
-	}
-	// TODO: should we also be combining the ssh federated roots here?
-	// If we rotate ssh roots keys, sshpop provisioner will lose ability to
-	// validate old SSH certificates, unless they are added as federated certs.
-	sshKeys, err := a.GetSSHRoots(context.Background())
+	// Initialize provisioners
+	config, err := a.provisionerConfig()
 	if err != nil {
 		return err
 	}
-	// Initialize provisioners
-	audiences := a.config.getAudiences()
-	a.provisioners = provisioner.NewCollection(audiences)
-	config := provisioner.Config{
-		Claims:    claimer.Claims(),
-		Audiences: audiences,
-		DB:        a.db,
-		SSHKeys: &provisioner.SSHKeys{
-			UserKeys: sshKeys.UserKeys,
-			HostKeys: sshKeys.HostKeys,
-		},
-		GetIdentityFunc: a.getIdentityFunc,
-	}
+	a.provisioners = provisioner.NewCollection(config.Audiences)
 	// Store all the provisioners
 	for _, p := range a.config.AuthorityConfig.Provisioners {
 		if err := p.Init(config); err != nil {
@@ -323,6 +329,46 @@ func (a *Authority) init() error {
 	return nil
 }
 
+// provisionerConfig returns the configuration used to initialize the
+// provisioners.
+func (a *Authority) provisionerConfig() (provisioner.Config, error) {
+	// Merge global and configuration claims
+	claimer, err := provisioner.NewClaimer(a.config.AuthorityConfig.Claims, globalProvisionerClaims)
+	if err != nil {
+		return provisioner.Config{}, err
+	}
+	// TODO: should we also be combining the ssh federated roots here?
+	// If we rotate ssh roots keys, sshpop provisioner will lose ability to
+	// validate old SSH certificates, unless they are added as federated certs.
+	sshKeys, err := a.GetSSHRoots(context.Background())
+	if err != nil {
+		return provisioner.Config{}, err
+	}
+	return provisioner.Config{
+		Claims:    claimer.Claims(),
+		Audiences: a.config.getAudiences(),
+		DB:        a.db,
+		SSHKeys: &provisioner.SSHKeys{
+			UserKeys: sshKeys.UserKeys,
+			HostKeys: sshKeys.HostKeys,
+		},
+		GetIdentityFunc: a.getIdentityFunc,
+	}, nil
+}
+
+// ReloadProvisioners initializes the given list of provisioners and replaces
+// the ones used by the authority without interrupting the issuance. If any of
+// the provisioners fails to initialize the current ones are kept and an error
+// is returned. The configuration is not modified, the provisioners in use are
+// always the ones in the collection.
+func (a *Authority) ReloadProvisioners(list provisioner.List) error {
+	config, err := a.provisionerConfig()
+	if err != nil {
+		return err
+	}
+	return a.provisioners.Reload(list, config)
+}
+
 // GetDatabase returns the authority database. If the configuration does not
 // define a database, GetDatabase will return a db.SimpleDB instance.
 func (a *Authority) GetDatabase() db.AuthDB {
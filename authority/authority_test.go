@@ -1,11 +1,13 @@
 package authority
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"net"
 	"reflect"
@@ -189,6 +191,37 @@ func TestAuthority_GetDatabase(t *testing.T) {
 	}
 }
 
+func TestAuthority_ReloadProvisioners(t *testing.T) {
+	a := testAuthority(t)
+	maxjwk, err := stepJOSE.ParseKey("testdata/secrets/max_pub.jwk")
+	assert.FatalError(t, err)
+
+	initial, _ := a.provisioners.Find("", provisioner.DefaultProvisionersMax)
+	reloaded := &provisioner.JWK{Name: "reloaded", Type: "JWK", Key: maxjwk}
+	tests := []struct {
+		name     string
+		list     provisioner.List
+		want     provisioner.Interface
+		wantList provisioner.List
+		wantErr  bool
+	}{
+		{"fail", provisioner.List{&provisioner.JWK{Name: "bad", Type: "JWK"}}, a.config.AuthorityConfig.Provisioners[0], initial, true},
+		{"ok", provisioner.List{reloaded}, reloaded, provisioner.List{reloaded}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.ReloadProvisioners(tt.list); (err != nil) != tt.wantErr {
+				t.Errorf("Authority.ReloadProvisioners() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, ok := a.provisioners.Load(tt.want.GetID())
+			assert.True(t, ok)
+			assert.Equals(t, tt.want, got)
+			list, _ := a.provisioners.Find("", provisioner.DefaultProvisionersMax)
+			assert.Equals(t, tt.wantList, list)
+		})
+	}
+}
+
 func TestNewEmbedded(t *testing.T) {
 	caPEM, err := ioutil.ReadFile("testdata/certs/root_ca.crt")
 	assert.FatalError(t, err)
@@ -308,3 +341,53 @@ func TestNewEmbedded_GetTLSCertificate(t *testing.T) {
 	assert.True(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
 	assert.True(t, cert.Leaf.IPAddresses[1].Equal(net.ParseIP("::1")))
 }
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestNewEmbedded_WithRandReader(t *testing.T) {
+	caPEM, err := ioutil.ReadFile("testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+
+	crt, err := pemutil.ReadCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	key, err := pemutil.Read("testdata/secrets/intermediate_ca_key", pemutil.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	cr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"foo.bar.zar"},
+	}, key)
+	assert.FatalError(t, err)
+	csr, err := x509.ParseCertificateRequest(cr)
+	assert.FatalError(t, err)
+
+	// Sign uses the reader for the serial number and the signature
+	r := &countingReader{r: rand.Reader}
+	a, err := NewEmbedded(WithX509RootBundle(caPEM), WithX509Signer(crt, key.(crypto.Signer)), WithRandReader(r))
+	assert.FatalError(t, err)
+	cert, err := a.Sign(csr, provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"foo.bar.zar"}, cert[0].DNSNames)
+	assert.True(t, r.n > 0)
+
+	// GetTLSCertificate uses it too
+	r.n = 0
+	_, err = a.GetTLSCertificate()
+	assert.FatalError(t, err)
+	assert.True(t, r.n > 0)
+
+	// Sign fails if the reader fails
+	a, err = NewEmbedded(WithX509RootBundle(caPEM), WithX509Signer(crt, key.(crypto.Signer)), WithRandReader(bytes.NewReader(nil)))
+	assert.FatalError(t, err)
+	_, err = a.Sign(csr, provisioner.Options{})
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
@@ -70,7 +71,7 @@ func (a *Authority) authorizeToken(ctx context.Context, token string) (provision
 
 	// Store the token to protect against reuse unless it's skipped.
 	if !SkipTokenReuseFromContext(ctx) {
-		if reuseKey, err := p.GetTokenID(token); err == nil {
+		if reuseKey, err := getTokenID(ctx, p, token); err == nil {
 			ok, err := a.db.UseToken(reuseKey, token)
 			if err != nil {
 				return nil, errs.Wrap(http.StatusInternalServerError, err,
@@ -85,6 +86,16 @@ func (a *Authority) authorizeToken(ctx context.Context, token string) (provision
 	return p, nil
 }
 
+// getTokenID returns the identifier used to prevent the reuse of the token,
+// it depends on the method in the context if the provisioner implements
+// provisioner.MethodTokenIDGetter.
+func getTokenID(ctx context.Context, p provisioner.Interface, token string) (string, error) {
+	if g, ok := p.(provisioner.MethodTokenIDGetter); ok {
+		return g.GetTokenIDForMethod(provisioner.MethodFromContext(ctx), token)
+	}
+	return p.GetTokenID(token)
+}
+
 // Authorize grabs the method from the context and authorizes the request by
 // validating the one-time-token.
 func (a *Authority) Authorize(ctx context.Context, token string) ([]provisioner.SignOption, error) {
@@ -126,13 +137,105 @@ func (a *Authority) Authorize(ctx context.Context, token string) ([]provisioner.
 func (a *Authority) authorizeSign(ctx context.Context, token string) ([]provisioner.SignOption, error) {
 	p, err := a.authorizeToken(ctx, token)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSign")
+		err = errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSign")
+		a.auditSign(ctx, token, nil, nil, err)
+		return nil, err
 	}
 	signOpts, err := p.AuthorizeSign(ctx, token)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSign")
+		err = errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSign")
+		a.auditSign(ctx, token, p, nil, err)
+		return nil, err
+	}
+	reservation, err := a.checkRateLimit(p, signOpts)
+	if err != nil {
+		err = errs.Wrap(http.StatusTooManyRequests, err, "authority.authorizeSign")
+		a.auditSign(ctx, token, p, signOpts, err)
+		return nil, err
+	}
+	a.auditSign(ctx, token, p, signOpts, nil)
+	return append(signOpts, newAuthorizedRequest(p, token, reservation)), nil
+}
+
+// auditSign sends the decision of a signing request to the audit function if
+// one is configured. The provisioner will be nil if the token could not be
+// matched with one.
+func (a *Authority) auditSign(ctx context.Context, token string, p provisioner.Interface, signOpts []provisioner.SignOption, err error) {
+	if a.auditFunc == nil {
+		return
 	}
-	return signOpts, nil
+
+	event := &provisioner.AuditEvent{
+		Time:       time.Now().UTC(),
+		Authorized: err == nil,
+		Error:      err,
+	}
+	// The subject is only informative, the token might not even be valid.
+	event.Subject = tokenSubject(token)
+	if p != nil {
+		event.ProvisionerID = p.GetID()
+		event.ProvisionerName = p.GetName()
+		event.ProvisionerType = p.GetType()
+	}
+	if identity, ok := provisioner.GetAuthorizedIdentity(signOpts); ok {
+		event.Identity = identity
+	}
+	a.auditFunc(ctx, event)
+}
+
+// checkRateLimit returns a 429 error if the provisioner has a rate limit and it
+// has already signed too many certificates with the credential that authorized
+// the request. The credential is taken from the identity in the sign options
+// returned by the provisioner, not from the token claims, so a requester cannot
+// avoid the limit using a new subject; if the provisioner does not return an
+// identity the limit applies to the provisioner. The certificate is given back
+// with refundRateLimit if it is not signed.
+func (a *Authority) checkRateLimit(p provisioner.Interface, signOpts []provisioner.SignOption) (*provisioner.RateLimitReservation, error) {
+	g, ok := p.(provisioner.RateLimitGetter)
+	if !ok {
+		return nil, nil
+	}
+	var credential string
+	if identity, ok := provisioner.GetAuthorizedIdentity(signOpts); ok {
+		credential = identity.Credential
+	}
+	return a.rateLimiter.Allow(p.GetID(), credential, g.GetRateLimit())
+}
+
+// newAuthorizedRequest returns the sign option used to audit the certificates
+// issued with the given provisioner and token, and to refund the given rate
+// limit reservation.
+func newAuthorizedRequest(p provisioner.Interface, token string, reservation *provisioner.RateLimitReservation) *provisioner.AuthorizedRequest {
+	return &provisioner.AuthorizedRequest{
+		ProvisionerID:   p.GetID(),
+		ProvisionerName: p.GetName(),
+		ProvisionerType: p.GetType(),
+		TokenSubject:    tokenSubject(token),
+		RateLimit:       reservation,
+	}
+}
+
+// refundRateLimit gives back the certificate recorded by checkRateLimit for the
+// request authorized with the given sign options, it is called when the
+// certificate cannot be signed.
+func (a *Authority) refundRateLimit(signOpts []provisioner.SignOption) {
+	if req, ok := provisioner.GetAuthorizedRequest(signOpts); ok {
+		a.rateLimiter.Refund(req.RateLimit)
+	}
+}
+
+// tokenSubject returns the subject of the given token without validating it,
+// or an empty string if the token cannot be parsed.
+func tokenSubject(token string) string {
+	tok, err := jose.ParseSigned(token)
+	if err != nil {
+		return ""
+	}
+	var claims jose.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return ""
+	}
+	return claims.Subject
 }
 
 // AuthorizeSign authorizes a signature request by validating and authenticating
@@ -197,7 +300,11 @@ func (a *Authority) authorizeSSHSign(ctx context.Context, token string) ([]provi
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.authorizeSSHSign")
 	}
-	return signOpts, nil
+	reservation, err := a.checkRateLimit(p, signOpts)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusTooManyRequests, err, "authority.authorizeSSHSign")
+	}
+	return append(signOpts, newAuthorizedRequest(p, token, reservation)), nil
 }
 
 // authorizeSSHRenew authorizes an SSH certificate renewal request, by
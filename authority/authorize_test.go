@@ -17,6 +17,7 @@ import (
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/randutil"
 	"github.com/smallstep/cli/jose"
@@ -449,13 +450,146 @@ func TestAuthority_authorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 6, got)
+					assert.Len(t, 8, got)
 				}
 			}
 		})
 	}
 }
 
+func TestAuthority_authorizeSign_audit(t *testing.T) {
+	a := testAuthority(t)
+
+	var events []*provisioner.AuditEvent
+	a.auditFunc = func(ctx context.Context, event *provisioner.AuditEvent) {
+		events = append(events, event)
+	}
+
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+
+	now := time.Now().UTC()
+	newToken := func(sub, id string) string {
+		raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+			Subject:   sub,
+			Issuer:    "step-cli",
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+			Audience:  []string{"https://example.com/sign"},
+			ID:        id,
+		}).CompactSerialize()
+		assert.FatalError(t, err)
+		return raw
+	}
+
+	tests := []struct {
+		name            string
+		token           string
+		provisionerName string
+		provisionerType provisioner.Type
+		subject         string
+		authorized      bool
+	}{
+		{"fail/invalid-token", "foo", "", 0, "", false},
+		{"fail/invalid-subject", newToken("", "audit-1"), "step-cli", provisioner.TypeJWK, "", false},
+		{"fail/unknown-provisioner", func() string {
+			raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+				Subject:  "test.smallstep.com",
+				Issuer:   "unknown",
+				Audience: []string{"https://example.com/sign"},
+			}).CompactSerialize()
+			assert.FatalError(t, err)
+			return raw
+		}(), "", 0, "test.smallstep.com", false},
+		{"ok", newToken("test.smallstep.com", "audit-2"), "step-cli", provisioner.TypeJWK, "test.smallstep.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			_, err := a.authorizeSign(context.Background(), tt.token)
+			assert.Equals(t, tt.authorized, err == nil)
+			if assert.Len(t, 1, events) {
+				event := events[0]
+				assert.Equals(t, tt.authorized, event.Authorized)
+				assert.Equals(t, err, event.Error)
+				assert.Equals(t, tt.subject, event.Subject)
+				assert.Equals(t, tt.provisionerName, event.ProvisionerName)
+				assert.Equals(t, tt.provisionerType, event.ProvisionerType)
+				if tt.provisionerName != "" {
+					assert.Equals(t, "step-cli:"+jwk.KeyID, event.ProvisionerID)
+				} else {
+					assert.Equals(t, "", event.ProvisionerID)
+				}
+				if tt.authorized {
+					assert.Equals(t, &provisioner.AuthorizedIdentity{Subject: tt.subject, Credential: jwk.KeyID}, event.Identity)
+				} else {
+					assert.Nil(t, event.Identity)
+				}
+				assert.False(t, event.Time.IsZero())
+			}
+		})
+	}
+}
+
+func TestAuthority_authorizeSign_rateLimit(t *testing.T) {
+	a := testAuthority(t)
+	p, ok := a.config.AuthorityConfig.Provisioners[1].(*provisioner.JWK)
+	assert.Fatal(t, ok)
+	assert.Equals(t, "step-cli", p.Name)
+	p.Claims.RateLimit = &provisioner.RateLimit{Count: 2, Window: &provisioner.Duration{Duration: time.Hour}}
+
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	authorize := func(sub string, code int) []provisioner.SignOption {
+		t.Helper()
+		raw, err := generateToken(sub, "step-cli", "https://example.com/sign", nil, now, jwk)
+		assert.FatalError(t, err)
+		signOpts, err := a.authorizeSign(context.Background(), raw)
+		if code == 0 {
+			assert.FatalError(t, err)
+			return signOpts
+		}
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+		}
+		return nil
+	}
+
+	authorize("test.smallstep.com", 0)
+	signOpts := authorize("test.smallstep.com", 0)
+	authorize("test.smallstep.com", http.StatusTooManyRequests)
+	// The limit is keyed on the credential, the JWK, not on the subject of
+	// the token.
+	authorize("other.smallstep.com", http.StatusTooManyRequests)
+
+	// A certificate that fails to be signed does not count, the common name of
+	// the CSR does not match the subject of the token.
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{}, signOpts...)
+	assert.NotNil(t, err)
+	authorize("other.smallstep.com", 0)
+	authorize("test.smallstep.com", http.StatusTooManyRequests)
+
+	// SSH certificates share the limit and fail with the same status.
+	raw, err := generateSimpleSSHUserToken("step-cli", "https://example.com/ssh/sign", jwk)
+	assert.FatalError(t, err)
+	_, err = a.authorizeSSHSign(context.Background(), raw)
+	if assert.NotNil(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusTooManyRequests, sc.StatusCode())
+	}
+}
+
 func TestAuthority_Authorize(t *testing.T) {
 	a := testAuthority(t)
 
@@ -568,6 +702,28 @@ func TestAuthority_Authorize(t *testing.T) {
 				ctx:   provisioner.NewContextWithMethod(context.Background(), provisioner.SSHSignMethod),
 			}
 		},
+		"fail/sshSign/sign-audience": func(t *testing.T) *authorizeTest {
+			raw, err := generateSimpleSSHUserToken(validIssuer, testAudiences.Sign[0], jwk)
+			assert.FatalError(t, err)
+			return &authorizeTest{
+				auth:  a,
+				token: raw,
+				ctx:   provisioner.NewContextWithMethod(context.Background(), provisioner.SSHSignMethod),
+				err:   errors.New("authority.Authorize: authority.authorizeSSHSign: jwk.AuthorizeSSHSign: jwk.authorizeToken; invalid jwk token audience claim (aud)"),
+				code:  http.StatusUnauthorized,
+			}
+		},
+		"fail/sign/ssh-audience": func(t *testing.T) *authorizeTest {
+			raw, err := generateSimpleSSHUserToken(validIssuer, testAudiences.SSHSign[0], jwk)
+			assert.FatalError(t, err)
+			return &authorizeTest{
+				auth:  a,
+				token: raw,
+				ctx:   provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod),
+				err:   errors.New("authority.Authorize: authority.authorizeSign: jwk.AuthorizeSign: jwk.authorizeToken; invalid jwk token audience claim (aud)"),
+				code:  http.StatusUnauthorized,
+			}
+		},
 		"fail/sshRenew/invalid-token": func(t *testing.T) *authorizeTest {
 			return &authorizeTest{
 				auth:  a,
@@ -961,7 +1117,7 @@ func TestAuthority_authorizeSSHSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 11, got)
+					assert.Len(t, 13, got)
 				}
 			}
 		})
@@ -64,6 +64,7 @@ type Config struct {
 	TLS              *tlsutil.TLSOptions  `json:"tls,omitempty"`
 	Password         string               `json:"password,omitempty"`
 	Templates        *templates.Templates `json:"templates,omitempty"`
+	FIPS             bool                 `json:"fips,omitempty"`
 }
 
 // AuthConfig represents the configuration options for the authority.
@@ -238,17 +239,13 @@ func (c *Config) getAudiences() provisioner.Audiences {
 	for _, name := range c.DNSNames {
 		audiences.Sign = append(audiences.Sign,
 			fmt.Sprintf("https://%s/1.0/sign", name),
-			fmt.Sprintf("https://%s/sign", name),
-			fmt.Sprintf("https://%s/1.0/ssh/sign", name),
-			fmt.Sprintf("https://%s/ssh/sign", name))
+			fmt.Sprintf("https://%s/sign", name))
 		audiences.Revoke = append(audiences.Revoke,
 			fmt.Sprintf("https://%s/1.0/revoke", name),
 			fmt.Sprintf("https://%s/revoke", name))
 		audiences.SSHSign = append(audiences.SSHSign,
 			fmt.Sprintf("https://%s/1.0/ssh/sign", name),
-			fmt.Sprintf("https://%s/ssh/sign", name),
-			fmt.Sprintf("https://%s/1.0/sign", name),
-			fmt.Sprintf("https://%s/sign", name))
+			fmt.Sprintf("https://%s/ssh/sign", name))
 		audiences.SSHRevoke = append(audiences.SSHRevoke,
 			fmt.Sprintf("https://%s/1.0/ssh/revoke", name),
 			fmt.Sprintf("https://%s/ssh/revoke", name))
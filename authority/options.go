@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/pem"
+	"io"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority/provisioner"
@@ -53,6 +54,25 @@ func WithGetIdentityFunc(fn func(ctx context.Context, p provisioner.Interface, e
 	}
 }
 
+// WithAuditFunc sets a function that will receive an audit event every time
+// a signing request is authorized or denied.
+func WithAuditFunc(fn provisioner.AuditFunc) Option {
+	return func(a *Authority) error {
+		a.auditFunc = fn
+		return nil
+	}
+}
+
+// WithIssuanceFunc sets a function that will receive an issuance event every
+// time a certificate is signed, renewed or rekeyed. NewJSONIssuanceFunc can be
+// used to write the events to an external sink.
+func WithIssuanceFunc(fn provisioner.IssuanceFunc) Option {
+	return func(a *Authority) error {
+		a.issuanceFunc = fn
+		return nil
+	}
+}
+
 // WithSSHBastionFunc sets a custom function to get the bastion for a
 // given user-host pair.
 func WithSSHBastionFunc(fn func(ctx context.Context, user, host string) (*Bastion, error)) Option {
@@ -81,6 +101,16 @@ func WithSSHCheckHost(fn func(ctx context.Context, principal string, tok string,
 	}
 }
 
+// WithRandReader sets the source of randomness used in the serial numbers and
+// the signatures of the X.509 and SSH certificates, e.g. a FIPS DRBG. By
+// default crypto/rand.Reader is used.
+func WithRandReader(r io.Reader) Option {
+	return func(a *Authority) error {
+		a.rand = r
+		return nil
+	}
+}
+
 // WithKeyManager defines the key manager used to get and create keys, and sign
 // certificates.
 func WithKeyManager(k kms.KeyManager) Option {
@@ -13,11 +13,19 @@ import (
 // provisioning flow.
 type ACME struct {
 	*base
-	Type    string  `json:"type"`
-	Name    string  `json:"name"`
-	Claims  *Claims `json:"claims,omitempty"`
-	ForceCN bool    `json:"forceCN,omitempty"`
-	claimer *Claimer
+	Type            string           `json:"type"`
+	Name            string           `json:"name"`
+	NameConstraints *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook         *Webhook         `json:"webhook,omitempty"`
+	Claims          *Claims          `json:"claims,omitempty"`
+	ForceCN         bool             `json:"forceCN,omitempty"`
+	claimer         *Claimer
+}
+
+func init() {
+	Register(TypeACME.String(), func() Interface {
+		return &ACME{}
+	})
 }
 
 // GetID returns the provisioner unique identifier.
@@ -65,6 +73,19 @@ func (p *ACME) Init(config Config) (err error) {
 		return err
 	}
 
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return err
 }
 
@@ -72,15 +93,28 @@ func (p *ACME) Init(config Config) (err error) {
 // in the ACME protocol. This method returns a list of modifiers / constraints
 // on the resulting certificate.
 func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	return []SignOption{
+	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeACME, p.Name, ""),
 		newForceCNOption(p.ForceCN),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(p.claimer),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+	}
+
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured. ACME requests do
+	// not have a token, the orders are authorized by their challenges.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeACME, p.Name, nil, nil))
+	}
+
+	return so, nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
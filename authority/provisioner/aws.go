@@ -142,19 +142,27 @@ type awsInstanceIdentityDocument struct {
 // https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-identity-documents.html
 type AWS struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	Accounts               []string `json:"accounts"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	IMDSVersions           []string `json:"imdsVersions"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string           `json:"type"`
+	Name                   string           `json:"name"`
+	Accounts               []string         `json:"accounts"`
+	DisableCustomSANs      bool             `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool             `json:"disableTrustOnFirstUse"`
+	IMDSVersions           []string         `json:"imdsVersions"`
+	InstanceAge            Duration         `json:"instanceAge,omitempty"`
+	NameConstraints        *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook                *Webhook         `json:"webhook,omitempty"`
+	Claims                 *Claims          `json:"claims,omitempty"`
 	claimer                *Claimer
 	config                 *awsConfig
 	audiences              Audiences
 }
 
+func init() {
+	Register(TypeAWS.String(), func() Interface {
+		return &AWS{}
+	})
+}
+
 // GetID returns the provisioner unique identifier.
 func (p *AWS) GetID() string {
 	return "aws/" + p.Name
@@ -162,7 +170,7 @@ func (p *AWS) GetID() string {
 
 // GetTokenID returns the identifier of the token.
 func (p *AWS) GetTokenID(token string) (string, error) {
-	payload, err := p.authorizeToken(token)
+	payload, err := p.authorizeToken(token, p.audiences.All())
 	if err != nil {
 		return "", err
 	}
@@ -190,14 +198,31 @@ func (p *AWS) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *AWS) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
 // GetIdentityToken retrieves the identity document and it's signature and
-// generates a token with them.
+// generates a token with them. The token is cached and reused until shortly
+// before its expiration.
 func (p *AWS) GetIdentityToken(subject, caURL string) (string, error) {
 	// Initialize the config if this method is used from the cli.
 	if err := p.assertConfig(); err != nil {
 		return "", err
 	}
 
+	key := identityTokenKey(p.GetID(), p.config.identityURL, subject, caURL)
+	return identityTokens.GetOrFetch(key, func() (string, error) {
+		return p.getIdentityToken(subject, caURL)
+	})
+}
+
+// getIdentityToken retrieves the identity document and it's signature and
+// generates a token with them.
+func (p *AWS) getIdentityToken(subject, caURL string) (string, error) {
 	var idoc awsInstanceIdentityDocument
 	doc, err := p.readURL(p.config.identityURL)
 	if err != nil {
@@ -277,6 +302,18 @@ func (p *AWS) Init(config Config) (err error) {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
 	// Add default config
 	if p.config, err = newAWSConfig(); err != nil {
 		return err
@@ -304,16 +341,20 @@ func (p *AWS) Init(config Config) (err error) {
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	payload, err := p.authorizeToken(token)
+	payload, err := p.authorizeToken(token, p.audiences.Sign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSign")
 	}
 
 	doc := payload.document
+	identity := &AuthorizedIdentity{
+		Subject:    payload.Claims.Subject,
+		Credential: doc.AccountID + "/" + doc.InstanceID,
+	}
 	// Enforce known CN and default DNS and IP if configured.
 	// By default we'll accept the CN and SANs in the CSR.
 	// There's no way to trust them other than TOFU.
-	var so []SignOption
+	so := []SignOption{identity}
 	if p.DisableCustomSANs {
 		so = append(so, dnsNamesValidator([]string{
 			fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region),
@@ -325,12 +366,27 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		so = append(so, urisValidator(nil))
 	}
 
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeAWS, p.Name, identity, payload))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeAWS, p.Name, identity, payload))
+	}
+
 	return append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAWS, p.Name, doc.AccountID, "InstanceID", doc.InstanceID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(p.claimer),
 		commonNameValidator(payload.Claims.Subject),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
 	), nil
@@ -461,7 +517,7 @@ func (p *AWS) readResponseBody(resp *http.Response) ([]byte, error) {
 // authorizeToken performs common jwt authorization actions and returns the
 // claims for case specific downstream parsing.
 // e.g. a Sign request will auth/validate different fields than a Revoke request.
-func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
+func (p *AWS) authorizeToken(token string, audiences []string) (*awsPayload, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "aws.authorizeToken; error parsing aws token")
@@ -507,12 +563,12 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 	if err = payload.ValidateWithLeeway(jose.Expected{
 		Issuer: awsIssuer,
 		Time:   now,
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "aws.authorizeToken; invalid aws token")
 	}
 
 	// validate audiences with the defaults
-	if !matchesAudience(payload.Audience, p.audiences.Sign) {
+	if !matchesAudience(payload.Audience, audiences) {
 		return nil, errs.Unauthorized("aws.authorizeToken; invalid token - invalid audience claim (aud)")
 	}
 
@@ -555,14 +611,19 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("aws.AuthorizeSSHSign; ssh ca is disabled for aws provisioner %s", p.GetID())
 	}
-	claims, err := p.authorizeToken(token)
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
 	}
 
 	doc := claims.document
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: doc.AccountID + "/" + doc.InstanceID,
+	}
 
 	signOptions := []SignOption{
+		identity,
 		// set the key id to the instance id
 		sshCertKeyIDModifier(doc.InstanceID),
 	}
@@ -587,6 +648,11 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeAWS, p.Name, identity, claims))
+	}
+
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
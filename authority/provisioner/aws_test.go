@@ -473,7 +473,7 @@ func TestAWS_authorizeToken(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if claims, err := tc.p.authorizeToken(tc.token); err != nil {
+			if claims, err := tc.p.authorizeToken(tc.token, tc.p.audiences.Sign); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -595,11 +595,11 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1, "foo.local"}, 5, http.StatusOK, false},
-		{"ok", p2, args{t2, "instance-id"}, 9, http.StatusOK, false},
-		{"ok", p2, args{t2Hostname, "ip-127-0-0-1.us-west-1.compute.internal"}, 9, http.StatusOK, false},
-		{"ok", p2, args{t2PrivateIP, "127.0.0.1"}, 9, http.StatusOK, false},
-		{"ok", p1, args{t4, "instance-id"}, 5, http.StatusOK, false},
+		{"ok", p1, args{t1, "foo.local"}, 6, http.StatusOK, false},
+		{"ok", p2, args{t2, "instance-id"}, 10, http.StatusOK, false},
+		{"ok", p2, args{t2Hostname, "ip-127-0-0-1.us-west-1.compute.internal"}, 10, http.StatusOK, false},
+		{"ok", p2, args{t2PrivateIP, "127.0.0.1"}, 10, http.StatusOK, false},
+		{"ok", p1, args{t4, "instance-id"}, 6, http.StatusOK, false},
 		{"fail account", p3, args{token: t3}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{token: "token"}, 0, http.StatusUnauthorized, true},
 		{"fail subject", p1, args{token: failSubject}, 0, http.StatusUnauthorized, true},
@@ -650,6 +650,9 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 						assert.Equals(t, v, nil)
 					case dnsNamesValidator:
 						assert.Equals(t, []string(v), []string{"ip-127-0-0-1.us-west-1.compute.internal"})
+					case *AuthorizedIdentity:
+						assert.Equals(t, v.Subject, tt.args.cn)
+						assert.Equals(t, v.Credential, tt.aws.Accounts[0]+"/instance-id")
 					default:
 						assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 					}
@@ -682,10 +685,26 @@ func TestAWS_AuthorizeSSHSign(t *testing.T) {
 	p3.claimer, err = NewClaimer(p3.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
-	t1, err := p1.GetIdentityToken("127.0.0.1", "https://ca.smallstep.com")
+	block, _ := pem.Decode([]byte(awsTestKey))
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		t.Fatal("error decoding AWS key")
+	}
+	awsKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	assert.FatalError(t, err)
 
-	t2, err := p2.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	t1, err := generateAWSTokenWithAudience(
+		"127.0.0.1", awsIssuer, p1.audiences.SSHSign[0], p1.Accounts[0], "instance-id",
+		"127.0.0.1", "us-west-1", time.Now(), awsKey)
+	assert.FatalError(t, err)
+
+	t2, err := generateAWSTokenWithAudience(
+		"foo.local", awsIssuer, p2.audiences.SSHSign[0], p2.Accounts[0], "instance-id",
+		"127.0.0.1", "us-west-1", time.Now(), awsKey)
+	assert.FatalError(t, err)
+
+	// Tokens for the X.509 sign endpoint cannot be used to get an ssh
+	// certificate.
+	failAudience, err := p1.GetIdentityToken("127.0.0.1", "https://ca.smallstep.com")
 	assert.FatalError(t, err)
 
 	key, err := generateJSONWebKey()
@@ -746,6 +765,7 @@ func TestAWS_AuthorizeSSHSign(t *testing.T) {
 		{"fail-extra-principal", p1, args{t1, SSHOptions{Principals: []string{"127.0.0.1", "ip-127-0-0-1.us-west-1.compute.internal", "smallstep.com"}}, pub}, nil, http.StatusOK, false, true},
 		{"fail-sshCA-disabled", p3, args{"foo", SSHOptions{}, pub}, expectedHostOptions, http.StatusUnauthorized, true, false},
 		{"fail-invalid-token", p1, args{"foo", SSHOptions{}, pub}, expectedHostOptions, http.StatusUnauthorized, true, false},
+		{"fail-sign-audience", p1, args{failAudience, SSHOptions{}, pub}, expectedHostOptions, http.StatusUnauthorized, true, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -20,12 +21,61 @@ import (
 // azureOIDCBaseURL is the base discovery url for Microsoft Azure tokens.
 const azureOIDCBaseURL = "https://login.microsoftonline.com"
 
-// azureIdentityTokenURL is the URL to get the identity token for an instance.
-const azureIdentityTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F"
+// azureIMDSURL is the default base URL of the Azure Instance Metadata Service.
+const azureIMDSURL = "http://169.254.169.254"
+
+// azureInitTimeout is the default time to wait for the openid-configuration
+// and the JWK set in Init, a slow identity provider must not block the start of
+// the CA.
+const azureInitTimeout = 30 * time.Second
+
+// azureIMDSAPIVersion is the default API version used to get the identity
+// token from the Azure Instance Metadata Service.
+const azureIMDSAPIVersion = "2018-02-01"
+
+// azureIdentityTokenPath is the path used to get the identity token for an
+// instance, the resource query parameter is the audience of the cloud.
+const azureIdentityTokenPath = "/metadata/identity/oauth2/token"
 
 // azureDefaultAudience is the default audience used.
 const azureDefaultAudience = "https://management.azure.com/"
 
+// Azure clouds supported by the Azure provisioner.
+const (
+	// AzurePublicCloud is the Azure public cloud, it is the default cloud.
+	AzurePublicCloud = "AzurePublic"
+	// AzureUSGovCloud is the Azure US Government cloud.
+	AzureUSGovCloud = "AzureUSGov"
+	// AzureChinaCloud is the Azure China cloud operated by 21Vianet.
+	AzureChinaCloud = "AzureChina"
+	// AzureGermanyCloud is the Azure Germany cloud.
+	AzureGermanyCloud = "AzureGermany"
+)
+
+// azureEnvironment contains the default discovery base url and audience of an
+// Azure cloud.
+type azureEnvironment struct {
+	oidcBaseURL string
+	audience    string
+}
+
+var azureEnvironments = map[string]azureEnvironment{
+	AzurePublicCloud:  {azureOIDCBaseURL, azureDefaultAudience},
+	AzureUSGovCloud:   {"https://login.microsoftonline.us", "https://management.usgovcloudapi.net/"},
+	AzureChinaCloud:   {"https://login.chinacloudapi.cn", "https://management.chinacloudapi.cn/"},
+	AzureGermanyCloud: {"https://login.microsoftonline.de", "https://management.microsoftazure.de/"},
+}
+
+// getAzureEnvironment returns the environment of the given cloud, an empty
+// cloud is the Azure public cloud.
+func getAzureEnvironment(cloud string) (azureEnvironment, bool) {
+	if cloud == "" {
+		cloud = AzurePublicCloud
+	}
+	env, ok := azureEnvironments[cloud]
+	return env, ok
+}
+
 // azureXMSMirIDRegExp is the regular expression used to parse the xms_mirid claim.
 // Using case insensitive as resourceGroups appears as resourcegroups.
 var azureXMSMirIDRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)$`)
@@ -33,12 +83,41 @@ var azureXMSMirIDRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resour
 type azureConfig struct {
 	oidcDiscoveryURL string
 	identityTokenURL string
+	initTimeout      time.Duration
+}
+
+// timeout returns the time to wait for the identity provider in Init, by
+// default azureInitTimeout.
+func (c *azureConfig) timeout() time.Duration {
+	if c.initTimeout > 0 {
+		return c.initTimeout
+	}
+	return azureInitTimeout
 }
 
-func newAzureConfig(tenantID string) *azureConfig {
+// newAzureConfig returns the config for the given tenant and cloud. If
+// discoveryURL is not empty it is used instead of the default one of the
+// cloud, and imdsURL and imdsAPIVersion replace the defaults of the instance
+// metadata service if they are not empty. Unknown clouds use the public cloud
+// endpoints, they are rejected in Init.
+func newAzureConfig(tenantID, cloud, discoveryURL, imdsURL, imdsAPIVersion string) *azureConfig {
+	env, ok := getAzureEnvironment(cloud)
+	if !ok {
+		env = azureEnvironments[AzurePublicCloud]
+	}
+	if discoveryURL == "" {
+		discoveryURL = env.oidcBaseURL + "/" + tenantID + "/.well-known/openid-configuration"
+	}
+	if imdsURL == "" {
+		imdsURL = azureIMDSURL
+	}
+	if imdsAPIVersion == "" {
+		imdsAPIVersion = azureIMDSAPIVersion
+	}
 	return &azureConfig{
-		oidcDiscoveryURL: azureOIDCBaseURL + "/" + tenantID + "/.well-known/openid-configuration",
-		identityTokenURL: azureIdentityTokenURL,
+		oidcDiscoveryURL: discoveryURL,
+		identityTokenURL: strings.TrimSuffix(imdsURL, "/") + azureIdentityTokenPath +
+			"?api-version=" + url.QueryEscape(imdsAPIVersion) + "&resource=" + url.QueryEscape(env.audience),
 	}
 }
 
@@ -56,13 +135,16 @@ type azureIdentityToken struct {
 
 type azurePayload struct {
 	jose.Claims
-	AppID            string `json:"appid"`
-	AppIDAcr         string `json:"appidacr"`
-	IdentityProvider string `json:"idp"`
-	ObjectID         string `json:"oid"`
-	TenantID         string `json:"tid"`
-	Version          string `json:"ver"`
-	XMSMirID         string `json:"xms_mirid"`
+	AppID            string    `json:"appid"`
+	AppIDAcr         string    `json:"appidacr"`
+	IdentityProvider string    `json:"idp"`
+	ObjectID         string    `json:"oid"`
+	TenantID         string    `json:"tid"`
+	Version          string    `json:"ver"`
+	XMSMirID         string    `json:"xms_mirid"`
+	XMSAzRID         string    `json:"xms_az_rid"`
+	VMID             string    `json:"vmId"`
+	Validity         *Duration `json:"validity,omitempty"`
 }
 
 // Azure is the provisioner that supports identity tokens created from the
@@ -70,30 +152,121 @@ type azurePayload struct {
 //
 // The default audience is "https://management.azure.com/".
 //
+// Cloud selects the Azure cloud used, "AzurePublic", "AzureUSGov",
+// "AzureChina" or "AzureGermany", and with it the default discovery URL and
+// audience. It defaults to "AzurePublic". The discovery URL and the audience
+// can be overridden using DiscoveryURL and Audience.
+//
 // If DisableCustomSANs is true, only the internal DNS and IP will be added as a
 // SAN. By default it will accept any SAN in the CSR.
 //
+// If DisableDefaultSANs is true together with DisableCustomSANs, the common
+// name and DNS names will not be forced to the virtual machine name, and the
+// ones in the CSR will be used, but IPs, emails and URIs will still be
+// rejected. Without DisableCustomSANs the CSR values are always used.
+//
 // If DisableTrustOnFirstUse is true, multiple sign request for this provisioner
 // with the same instance will be accepted. By default only the first request
-// will be accepted.
+// will be accepted. DisableSSHTrustOnFirstUse does the same for the SSH sign
+// requests, allowing, for example, repeated SSH certificates while keeping
+// trust on first use for X.509 certificates. If it is not set, SSH sign
+// requests use DisableTrustOnFirstUse.
+//
+// If AllowXMSAzRID is true, tokens with a missing or invalid xms_mirid claim
+// will get the virtual machine identity from the xms_az_rid claim. Some managed
+// identity configurations, like user-assigned identities, set the xms_mirid
+// claim to the identity resource instead of the virtual machine.
+//
+// If VMIDs is set, only tokens with a vmId claim in that list will be
+// accepted, pinning the issuance to known virtual machine instances. The vmId
+// is added to the provisioner extension of the issued certificates.
+//
+// If AllowedExtKeyUsages is set, the issued certificates will only contain
+// those extended key usages, e.g. ["clientAuth"].
+//
+// If AllowedPublicKeyTypes is set, only certificate requests with a public key
+// of those types will be accepted, e.g. ["ECDSA"] or ["ECDSA-P256", "RSA-3072"].
+// RSA sizes are the minimum size allowed.
+//
+// KeyTypeOptions sets the default sign options of the requests with a public
+// key of a given type, "ECDSA", "RSA" or "Ed25519", e.g. a larger minimum size
+// for RSA keys or different extended key usages for each type. The extended
+// key usages must be allowed by AllowedExtKeyUsages if it is set.
+//
+// If Webhook is set, the certificate requests will be sent to an external
+// service that must approve them, see Webhook for the details.
+//
+// If the token has a validity claim, e.g. "validity": "1h", it is used as the
+// duration of the certificate instead of the default one, limited to the
+// minimum and maximum durations of the provisioner.
+//
+// IMDSURL and IMDSAPIVersion configure the base URL and the API version of the
+// instance metadata service used by GetIdentityToken, e.g. in Azure Stack Hub.
+// By default the public cloud values are used.
+//
+// If RequiredACR is set, only tokens with an appidacr claim greater or equal
+// than it will be accepted. Azure uses "0" for public clients, "1" for clients
+// authenticated with a client secret and "2" for clients authenticated with a
+// certificate.
+//
+// If MaxTokenAge is set, only tokens with an iat claim within the given period
+// will be accepted, even if the token has not expired yet.
+//
+// If CheckRenewalIdentity is true, the resource group of the virtual machine
+// is added to the provisioner extension of the issued certificates, and
+// AuthorizeRenew rejects the certificates whose resource group or vmId are no
+// longer allowed by ResourceGroups or VMIDs. Renewals do not include an
+// identity token, so this does not detect if the virtual machine still exists.
+//
+// NotBeforeLeeway and ExpiryLeeway override the clock skew allowed in the
+// validation of the nbf and exp claims, e.g. to accept tokens from virtual
+// machines with clocks running ahead while expired tokens are still strictly
+// rejected. Both default to the clock skew in the claims.
 //
 // Microsoft Azure identity docs are available at
 // https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
 // and https://docs.microsoft.com/en-us/azure/virtual-machines/windows/instance-metadata-service
 type Azure struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	TenantID               string   `json:"tenantID"`
-	ResourceGroups         []string `json:"resourceGroups"`
-	Audience               string   `json:"audience,omitempty"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	Claims                 *Claims  `json:"claims,omitempty"`
-	claimer                *Claimer
-	config                 *azureConfig
-	oidcConfig             openIDConfiguration
-	keyStore               *keyStore
+	Type                      string                     `json:"type"`
+	Name                      string                     `json:"name"`
+	TenantID                  string                     `json:"tenantID"`
+	ResourceGroups            []string                   `json:"resourceGroups"`
+	VMIDs                     []string                   `json:"vmIDs,omitempty"`
+	RequiredACR               string                     `json:"requiredACR,omitempty"`
+	Cloud                     string                     `json:"cloud,omitempty"`
+	DiscoveryURL              string                     `json:"discoveryURL,omitempty"`
+	IMDSURL                   string                     `json:"imdsURL,omitempty"`
+	IMDSAPIVersion            string                     `json:"imdsAPIVersion,omitempty"`
+	Audience                  string                     `json:"audience,omitempty"`
+	DisableCustomSANs         bool                       `json:"disableCustomSANs"`
+	DisableDefaultSANs        bool                       `json:"disableDefaultSANs,omitempty"`
+	DisableTrustOnFirstUse    bool                       `json:"disableTrustOnFirstUse"`
+	DisableSSHTrustOnFirstUse *bool                      `json:"disableSSHTrustOnFirstUse,omitempty"`
+	AllowXMSAzRID             bool                       `json:"allowXMSAzRID,omitempty"`
+	MaxTokenAge               Duration                   `json:"maxTokenAge,omitempty"`
+	NotBeforeLeeway           *Duration                  `json:"notBeforeLeeway,omitempty"`
+	ExpiryLeeway              *Duration                  `json:"expiryLeeway,omitempty"`
+	AllowedExtKeyUsages       []string                   `json:"allowedExtKeyUsages,omitempty"`
+	AllowedPublicKeyTypes     []string                   `json:"allowedPublicKeyTypes,omitempty"`
+	KeyTypeOptions            map[string]*KeyTypeOptions `json:"keyTypeOptions,omitempty"`
+	Webhook                   *Webhook                   `json:"webhook,omitempty"`
+	NameConstraints           *NameConstraints           `json:"nameConstraints,omitempty"`
+	CheckRenewalIdentity      bool                       `json:"checkRenewalIdentity,omitempty"`
+	Claims                    *Claims                    `json:"claims,omitempty"`
+	claimer                   *Claimer
+	extKeyUsages              []x509.ExtKeyUsage
+	publicKeyTypes            []publicKeyType
+	keyTypeOptions            keyTypeOptions
+	config                    *azureConfig
+	oidcConfig                openIDConfiguration
+	keyStore                  *keyStore
+}
+
+func init() {
+	Register(TypeAzure.String(), func() Interface {
+		return &Azure{}
+	})
 }
 
 // GetID returns the provisioner unique identifier.
@@ -102,9 +275,16 @@ func (p *Azure) GetID() string {
 }
 
 // GetTokenID returns the identifier of the token. The default value for Azure
-// the SHA256 of "xms_mirid", but if DisableTrustOnFirstUse is set to true, then
-// it will be the token kid.
+// the SHA256 of "xms_mirid", or "xms_az_rid" if it is used as the identity of
+// the virtual machine, but if DisableTrustOnFirstUse is set to true, then it
+// will be the token kid.
 func (p *Azure) GetTokenID(token string) (string, error) {
+	return p.GetTokenIDForMethod(SignMethod, token)
+}
+
+// GetTokenIDForMethod returns the identifier of the token like GetTokenID, but
+// for the SSH sign method it uses DisableSSHTrustOnFirstUse if it is set.
+func (p *Azure) GetTokenIDForMethod(m Method, token string) (string, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return "", errors.Wrap(err, "error parsing token")
@@ -119,14 +299,28 @@ func (p *Azure) GetTokenID(token string) (string, error) {
 	}
 
 	// If TOFU is disabled create return the token kid
-	if p.DisableTrustOnFirstUse {
+	if p.isTrustOnFirstUseDisabled(m) {
 		return claims.ID, nil
 	}
 
-	sum := sha256.Sum256([]byte(claims.XMSMirID))
+	resourceID := claims.XMSMirID
+	if id, _, _, ok := p.parseResourceID(&claims); ok {
+		resourceID = id
+	}
+
+	sum := sha256.Sum256([]byte(resourceID))
 	return strings.ToLower(hex.EncodeToString(sum[:])), nil
 }
 
+// isTrustOnFirstUseDisabled returns if trust on first use is disabled for the
+// given method.
+func (p *Azure) isTrustOnFirstUseDisabled(m Method) bool {
+	if m == SSHSignMethod && p.DisableSSHTrustOnFirstUse != nil {
+		return *p.DisableSSHTrustOnFirstUse
+	}
+	return p.DisableTrustOnFirstUse
+}
+
 // GetName returns the name of the provisioner.
 func (p *Azure) GetName() string {
 	return p.Name
@@ -142,12 +336,27 @@ func (p *Azure) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *Azure) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
 // GetIdentityToken retrieves from the metadata service the identity token and
-// returns it.
+// returns it. The token is cached and reused until shortly before its
+// expiration.
 func (p *Azure) GetIdentityToken(subject, caURL string) (string, error) {
 	// Initialize the config if this method is used from the cli.
 	p.assertConfig()
 
+	key := identityTokenKey(p.GetID(), p.config.identityTokenURL, subject)
+	return identityTokens.GetOrFetch(key, p.getIdentityToken)
+}
+
+// getIdentityToken retrieves from the metadata service the identity token and
+// returns it.
+func (p *Azure) getIdentityToken() (string, error) {
 	req, err := http.NewRequest("GET", p.config.identityTokenURL, http.NoBody)
 	if err != nil {
 		return "", errors.Wrap(err, "error creating request")
@@ -184,8 +393,29 @@ func (p *Azure) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	case p.TenantID == "":
 		return errors.New("provisioner tenantId cannot be empty")
-	case p.Audience == "": // use default audience
-		p.Audience = azureDefaultAudience
+	case p.RequiredACR != "" && p.RequiredACR != "0" && p.RequiredACR != "1" && p.RequiredACR != "2":
+		return errors.New("provisioner requiredACR must be 0, 1 or 2")
+	case p.MaxTokenAge.Value() < 0:
+		return errors.New("provisioner maxTokenAge cannot be negative")
+	case p.NotBeforeLeeway.Value() < 0:
+		return errors.New("provisioner notBeforeLeeway cannot be negative")
+	case p.ExpiryLeeway.Value() < 0:
+		return errors.New("provisioner expiryLeeway cannot be negative")
+	}
+
+	env, ok := getAzureEnvironment(p.Cloud)
+	if !ok {
+		return errors.Errorf("provisioner cloud '%s' is not valid; options are %s, %s, %s or %s",
+			p.Cloud, AzurePublicCloud, AzureUSGovCloud, AzureChinaCloud, AzureGermanyCloud)
+	}
+	if p.Audience == "" { // use default audience
+		p.Audience = env.audience
+	}
+	if p.IMDSURL != "" {
+		u, err := url.Parse(p.IMDSURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			return errors.Errorf("provisioner imdsURL '%s' is not valid; it must be an http or https URL", p.IMDSURL)
+		}
 	}
 	// Initialize config
 	p.assertConfig()
@@ -195,15 +425,47 @@ func (p *Azure) Init(config Config) (err error) {
 		return err
 	}
 
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Parse the allowed extended key usages
+	if p.extKeyUsages, err = parseExtKeyUsages(p.AllowedExtKeyUsages); err != nil {
+		return err
+	}
+
+	// Parse the allowed public key types
+	if p.publicKeyTypes, err = parsePublicKeyTypes(p.AllowedPublicKeyTypes); err != nil {
+		return err
+	}
+
+	// Parse the default options of the public key types
+	if p.keyTypeOptions, err = parseKeyTypeOptions(p.KeyTypeOptions, p.extKeyUsages); err != nil {
+		return err
+	}
+
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Both requests must complete within the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.timeout())
+	defer cancel()
+
 	// Decode and validate openid-configuration endpoint
-	if err := getAndDecode(p.config.oidcDiscoveryURL, &p.oidcConfig); err != nil {
+	if err := getAndDecodeWithContext(ctx, p.config.oidcDiscoveryURL, &p.oidcConfig); err != nil {
 		return err
 	}
 	if err := p.oidcConfig.Validate(); err != nil {
 		return errors.Wrapf(err, "error parsing %s", p.config.oidcDiscoveryURL)
 	}
 	// Get JWK key set
-	if p.keyStore, err = newKeyStore(p.oidcConfig.JWKSetURI); err != nil {
+	if p.keyStore, err = newKeyStoreWithContext(ctx, p.oidcConfig.JWKSetURI); err != nil {
 		return err
 	}
 
@@ -220,60 +482,114 @@ func (p *Azure) authorizeToken(token string) (*azurePayload, string, string, err
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token missing header")
 	}
 
+	// Accept any algorithm advertised in the discovery document, the key type
+	// restricts the algorithms that will validate the token.
+	if alg := jwt.Headers[0].Algorithm; !p.oidcConfig.supportsAlgorithm(alg) {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token signing algorithm %s is not supported", alg)
+	}
+
 	var found bool
 	var claims azurePayload
-	keys := p.keyStore.Get(jwt.Headers[0].KeyID)
+	kid := jwt.Headers[0].KeyID
+	keys := p.keyStore.Get(kid)
 	for _, key := range keys {
 		if err := jwt.Claims(key.Public(), &claims); err == nil {
 			found = true
 			break
 		}
 	}
+	// The reason is only logged, the client gets the default message.
 	if !found {
-		return nil, "", "", errs.Unauthorized("azure.authorizeToken; cannot validate azure token")
+		if len(keys) == 0 {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; cannot validate azure token - key id '%s' not found", kid)
+		}
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; cannot validate azure token - invalid signature with key id '%s'", kid)
 	}
 
+	// The payload is validated with the largest leeway, and nbf and exp are
+	// validated again with their own one.
+	now := time.Now()
+	nbfLeeway, expLeeway := p.leeways()
+	leeway := nbfLeeway
+	if expLeeway > leeway {
+		leeway = expLeeway
+	}
 	if err := claims.ValidateWithLeeway(jose.Expected{
 		Audience: []string{p.Audience},
 		Issuer:   p.oidcConfig.Issuer,
-		Time:     time.Now(),
-	}, 1*time.Minute); err != nil {
+		Time:     now,
+	}, leeway); err != nil {
 		return nil, "", "", errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; failed to validate azure token payload")
 	}
+	if claims.NotBefore != nil && now.Add(nbfLeeway).Before(claims.NotBefore.Time()) {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - token not valid yet (nbf)")
+	}
+	if claims.Expiry != nil && now.Add(-expLeeway).After(claims.Expiry.Time()) {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - token is expired (exp)")
+	}
+
+	// validate token age
+	if d := p.MaxTokenAge.Value(); d > 0 {
+		if claims.IssuedAt == nil {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - missing issued at claim (iat)")
+		}
+		if now.Sub(claims.IssuedAt.Time()) > d {
+			return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - issued at claim (iat) is too old")
+		}
+	}
 
 	// Validate TenantID
 	if claims.TenantID != p.TenantID {
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid tenant id claim (tid)")
 	}
 
-	re := azureXMSMirIDRegExp.FindStringSubmatch(claims.XMSMirID)
-	if len(re) != 4 {
+	// Validate the authentication context class of the application
+	if p.RequiredACR != "" && !acrSatisfies(claims.AppIDAcr, p.RequiredACR) {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - insufficient authentication context claim (appidacr)")
+	}
+
+	_, group, name, ok := p.parseResourceID(&claims)
+	if !ok {
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; error parsing xms_mirid claim - %s", claims.XMSMirID)
 	}
-	group, name := re[2], re[3]
+
+	// Filter by virtual machine id
+	if !p.allowsVMID(claims.VMID) {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid vm id claim (vmId)")
+	}
+
 	return &claims, name, group, nil
 }
 
+// newAzureIdentity returns the identity authorized by the given token claims.
+// The credential is the id of the virtual machine if available, or the subject
+// of the token, the managed identity, otherwise.
+func newAzureIdentity(claims *azurePayload, name, group string) *AuthorizedIdentity {
+	credential := claims.VMID
+	if credential == "" {
+		credential = claims.Subject
+	}
+	return &AuthorizedIdentity{
+		Subject:       claims.Subject,
+		TenantID:      claims.TenantID,
+		ResourceGroup: group,
+		Name:          name,
+		VMID:          claims.VMID,
+		Credential:    credential,
+	}
+}
+
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	_, name, group, err := p.authorizeToken(token)
+	claims, name, group, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSign")
 	}
 
 	// Filter by resource group
-	if len(p.ResourceGroups) > 0 {
-		var found bool
-		for _, g := range p.ResourceGroups {
-			if g == group {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil, errs.Unauthorized("azure.AuthorizeSign; azure token validation failed - invalid resource group")
-		}
+	if !p.allowsResourceGroup(group) {
+		return nil, errs.Unauthorized("azure.AuthorizeSign; azure token validation failed - invalid resource group")
 	}
 
 	// Enforce known common name and default DNS if configured.
@@ -282,24 +598,75 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 	var so []SignOption
 	if p.DisableCustomSANs {
 		// name will work only inside the virtual network
-		so = append(so, commonNameValidator(name))
-		so = append(so, dnsNamesValidator([]string{name}))
+		if !p.DisableDefaultSANs {
+			so = append(so, commonNameValidator(name))
+			so = append(so, dnsNamesValidator([]string{name}))
+		}
 		so = append(so, ipAddressesValidator(nil))
 		so = append(so, emailAddressesValidator(nil))
 		so = append(so, urisValidator(nil))
 	}
 
+	// Restrict the extended key usages if configured.
+	if len(p.extKeyUsages) > 0 {
+		so = append(so, extKeyUsageModifier(p.extKeyUsages))
+		so = append(so, extKeyUsageValidator(p.extKeyUsages))
+	}
+
+	// Restrict the public key types if configured.
+	if len(p.publicKeyTypes) > 0 {
+		so = append(so, publicKeyTypeValidator(p.publicKeyTypes))
+	}
+
+	// Set the extended key usages of the public key type if configured.
+	if e := p.keyTypeOptions.extKeyUsageEnforcer(); e != nil {
+		so = append(so, e)
+	}
+
+	// Add the vm id to the provisioner extension if available
+	var keyValuePairs []string
+	if claims.VMID != "" {
+		keyValuePairs = []string{"VMID", claims.VMID}
+	}
+	// and the resource group to check it on renewals
+	if p.CheckRenewalIdentity {
+		keyValuePairs = append(keyValuePairs, "ResourceGroup", group)
+	}
+
+	identity := newAzureIdentity(claims, name, group)
+
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeAzure, p.Name, identity, claims))
+	}
+
+	// Use the validity requested in the token, within the allowed durations.
+	validity := newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration())
+	duration := p.claimer.DefaultTLSCertDuration()
+	if claims.Validity != nil && claims.Validity.Duration > 0 {
+		duration = validity.clamp(claims.Validity.Duration)
+	}
+
 	return append(so,
 		// modifiers / withOptions
-		newProvisionerExtensionOption(TypeAzure, p.Name, p.TenantID),
-		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		newProvisionerExtensionOption(TypeAzure, p.Name, p.TenantID, keyValuePairs...),
+		profileDefaultDuration(duration),
+		// authorized identity for auditing
+		identity,
 		// validators
-		defaultPublicKeyValidator{},
-		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		p.keyTypeOptions.publicKeyValidator(p.claimer),
+		validity,
 	), nil
 }
 
-// AuthorizeRenew returns an error if the renewal is disabled.
+// AuthorizeRenew returns an error if the renewal is disabled, or if
+// CheckRenewalIdentity is set and the resource group or the vm id in the
+// provisioner extension of the certificate are not allowed anymore.
 // NOTE: This method does not actually validate the certificate or check it's
 // revocation status. Just confirms that the provisioner that created the
 // certificate was configured to allow renewals.
@@ -307,20 +674,79 @@ func (p *Azure) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) erro
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("azure.AuthorizeRenew; renew is disabled for azure provisioner %s", p.GetID())
 	}
+	if !p.CheckRenewalIdentity {
+		return nil
+	}
+
+	ext, ok := GetProvisionerExtension(cert)
+	if !ok || ext.Type != TypeAzure {
+		return errs.Unauthorized("azure.AuthorizeRenew; certificate does not have an azure provisioner extension")
+	}
+	var group, vmID string
+	var hasGroup bool
+	for i := 0; i+1 < len(ext.KeyValuePairs); i += 2 {
+		switch ext.KeyValuePairs[i] {
+		case "ResourceGroup":
+			group, hasGroup = ext.KeyValuePairs[i+1], true
+		case "VMID":
+			vmID = ext.KeyValuePairs[i+1]
+		}
+	}
+	// Certificates issued before enabling the option cannot be checked.
+	if !hasGroup {
+		return errs.Unauthorized("azure.AuthorizeRenew; certificate does not have a resource group")
+	}
+	if !p.allowsResourceGroup(group) {
+		return errs.Unauthorized("azure.AuthorizeRenew; certificate resource group %s is not allowed", group)
+	}
+	if !p.allowsVMID(vmID) {
+		return errs.Unauthorized("azure.AuthorizeRenew; certificate vm id %s is not allowed", vmID)
+	}
 	return nil
 }
 
+// allowsResourceGroup returns true if ResourceGroups is empty or if it
+// contains the given resource group.
+func (p *Azure) allowsResourceGroup(group string) bool {
+	if len(p.ResourceGroups) == 0 {
+		return true
+	}
+	for _, g := range p.ResourceGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsVMID returns true if VMIDs is empty or if it contains the given vm id,
+// vm ids are compared case insensitively.
+func (p *Azure) allowsVMID(id string) bool {
+	if len(p.VMIDs) == 0 {
+		return true
+	}
+	for _, v := range p.VMIDs {
+		if id != "" && strings.EqualFold(v, id) {
+			return true
+		}
+	}
+	return false
+}
+
 // AuthorizeSSHSign returns the list of SignOption for a SignSSH request.
 func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("azure.AuthorizeSSHSign; sshCA is disabled for provisioner %s", p.GetID())
 	}
 
-	_, name, _, err := p.authorizeToken(token)
+	claims, name, group, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSSHSign")
 	}
+	identity := newAzureIdentity(claims, name, group)
 	signOptions := []SignOption{
+		// authorized identity for auditing
+		identity,
 		// set the key id to the instance name
 		sshCertKeyIDModifier(name),
 	}
@@ -341,6 +767,11 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeAzure, p.Name, identity, claims))
+	}
+
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
@@ -355,9 +786,37 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	), nil
 }
 
+// parseResourceID returns the resource id of the virtual machine, its resource
+// group and name. They are parsed from the xms_mirid claim, or from the
+// xms_az_rid claim if the first one cannot be parsed and AllowXMSAzRID is set.
+func (p *Azure) parseResourceID(claims *azurePayload) (string, string, string, bool) {
+	if re := azureXMSMirIDRegExp.FindStringSubmatch(claims.XMSMirID); len(re) == 4 {
+		return claims.XMSMirID, re[2], re[3], true
+	}
+	if p.AllowXMSAzRID {
+		if re := azureXMSMirIDRegExp.FindStringSubmatch(claims.XMSAzRID); len(re) == 4 {
+			return claims.XMSAzRID, re[2], re[3], true
+		}
+	}
+	return "", "", "", false
+}
+
+// leeways returns the leeways used to validate the nbf and exp claims, by
+// default the clock skew of the provisioner.
+func (p *Azure) leeways() (time.Duration, time.Duration) {
+	nbf, exp := p.claimer.ClockSkew(), p.claimer.ClockSkew()
+	if p.NotBeforeLeeway != nil {
+		nbf = p.NotBeforeLeeway.Duration
+	}
+	if p.ExpiryLeeway != nil {
+		exp = p.ExpiryLeeway.Duration
+	}
+	return nbf, exp
+}
+
 // assertConfig initializes the config if it has not been initialized
 func (p *Azure) assertConfig() {
 	if p.config == nil {
-		p.config = newAzureConfig(p.TenantID)
+		p.config = newAzureConfig(p.TenantID, p.Cloud, p.DiscoveryURL, p.IMDSURL, p.IMDSAPIVersion)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"fmt"
 	"net/http"
@@ -90,6 +91,55 @@ func TestAzure_GetTokenID(t *testing.T) {
 	}
 }
 
+func TestAzure_GetTokenIDForMethod(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	t1, err := p1.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256([]byte("/subscriptions/subscriptionID/resourceGroups/resourceGroup/providers/Microsoft.Compute/virtualMachines/virtualMachine"))
+	w1 := strings.ToLower(hex.EncodeToString(sum[:]))
+
+	tru, fals := true, false
+	type fields struct {
+		DisableTrustOnFirstUse    bool
+		DisableSSHTrustOnFirstUse *bool
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		method  Method
+		want    string
+		wantErr bool
+	}{
+		{"ok sign", fields{false, nil}, SignMethod, w1, false},
+		{"ok ssh sign", fields{false, nil}, SSHSignMethod, w1, false},
+		{"ok sign no TOFU", fields{true, nil}, SignMethod, "the-jti", false},
+		{"ok ssh sign no TOFU", fields{true, nil}, SSHSignMethod, "the-jti", false},
+		{"ok sign with ssh no TOFU", fields{false, &tru}, SignMethod, w1, false},
+		{"ok ssh sign with ssh no TOFU", fields{false, &tru}, SSHSignMethod, "the-jti", false},
+		{"ok sign with ssh TOFU", fields{true, &fals}, SignMethod, "the-jti", false},
+		{"ok ssh sign with ssh TOFU", fields{true, &fals}, SSHSignMethod, w1, false},
+		{"ok revoke with ssh no TOFU", fields{false, &tru}, RevokeMethod, w1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p1.DisableTrustOnFirstUse = tt.fields.DisableTrustOnFirstUse
+			p1.DisableSSHTrustOnFirstUse = tt.fields.DisableSSHTrustOnFirstUse
+			got, err := p1.GetTokenIDForMethod(tt.method, t1)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.GetTokenIDForMethod() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Azure.GetTokenIDForMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestAzure_GetIdentityToken(t *testing.T) {
 	p1, err := generateAzure()
 	assert.FatalError(t, err)
@@ -145,6 +195,53 @@ func TestAzure_GetIdentityToken(t *testing.T) {
 	}
 }
 
+func TestAzure_GetIdentityToken_imds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path != "/metadata/identity/oauth2/token":
+			http.NotFound(w, r)
+		case q.Get("api-version") != "2019-08-01" || q.Get("resource") != azureDefaultAudience:
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		case r.Header.Get("Metadata") != "true":
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		default:
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"the-token"}`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		imdsURL        string
+		imdsAPIVersion string
+		want           string
+		wantErr        bool
+	}{
+		{"ok", srv.URL, "2019-08-01", "the-token", false},
+		{"ok trailing slash", srv.URL + "/", "2019-08-01", "the-token", false},
+		{"fail api version", srv.URL, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Azure{
+				TenantID:       "tenantID",
+				IMDSURL:        tt.imdsURL,
+				IMDSAPIVersion: tt.imdsAPIVersion,
+			}
+			got, err := p.GetIdentityToken("subject", "caURL")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.GetIdentityToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Azure.GetIdentityToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestAzure_Init(t *testing.T) {
 	p1, srv, err := generateAzureWithServer()
 	assert.FatalError(t, err)
@@ -171,11 +268,13 @@ func TestAzure_Init(t *testing.T) {
 	}
 
 	type fields struct {
-		Type     string
-		Name     string
-		TenantID string
-		Claims   *Claims
-		config   *azureConfig
+		Type                  string
+		Name                  string
+		TenantID              string
+		Claims                *Claims
+		config                *azureConfig
+		AllowedExtKeyUsages   []string
+		AllowedPublicKeyTypes []string
 	}
 	type args struct {
 		config Config
@@ -186,24 +285,30 @@ func TestAzure_Init(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{"ok", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config}, args{config}, false},
-		{"ok with config", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config}, args{config}, false},
-		{"fail type", fields{"", p1.Name, p1.TenantID, nil, p1.config}, args{config}, true},
-		{"fail name", fields{p1.Type, "", p1.TenantID, nil, p1.config}, args{config}, true},
-		{"fail tenant id", fields{p1.Type, p1.Name, "", nil, p1.config}, args{config}, true},
-		{"fail claims", fields{p1.Type, p1.Name, p1.TenantID, badClaims, p1.config}, args{config}, true},
-		{"fail discovery URL", fields{p1.Type, p1.Name, p1.TenantID, nil, badDiscoveryURL}, args{config}, true},
-		{"fail JWK URL", fields{p1.Type, p1.Name, p1.TenantID, nil, badJWKURL}, args{config}, true},
-		{"fail config Validate", fields{p1.Type, p1.Name, p1.TenantID, nil, badAzureConfig}, args{config}, true},
+		{"ok", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config, nil, nil}, args{config}, false},
+		{"ok with config", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config, nil, nil}, args{config}, false},
+		{"ok with ext key usages", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config, []string{"clientAuth"}, nil}, args{config}, false},
+		{"ok with public key types", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config, nil, []string{"ECDSA"}}, args{config}, false},
+		{"fail type", fields{"", p1.Name, p1.TenantID, nil, p1.config, nil, nil}, args{config}, true},
+		{"fail name", fields{p1.Type, "", p1.TenantID, nil, p1.config, nil, nil}, args{config}, true},
+		{"fail tenant id", fields{p1.Type, p1.Name, "", nil, p1.config, nil, nil}, args{config}, true},
+		{"fail ext key usages", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config, []string{"foo"}, nil}, args{config}, true},
+		{"fail public key types", fields{p1.Type, p1.Name, p1.TenantID, nil, p1.config, nil, []string{"DSA"}}, args{config}, true},
+		{"fail claims", fields{p1.Type, p1.Name, p1.TenantID, badClaims, p1.config, nil, nil}, args{config}, true},
+		{"fail discovery URL", fields{p1.Type, p1.Name, p1.TenantID, nil, badDiscoveryURL, nil, nil}, args{config}, true},
+		{"fail JWK URL", fields{p1.Type, p1.Name, p1.TenantID, nil, badJWKURL, nil, nil}, args{config}, true},
+		{"fail config Validate", fields{p1.Type, p1.Name, p1.TenantID, nil, badAzureConfig, nil, nil}, args{config}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			p := &Azure{
-				Type:     tt.fields.Type,
-				Name:     tt.fields.Name,
-				TenantID: tt.fields.TenantID,
-				Claims:   tt.fields.Claims,
-				config:   tt.fields.config,
+				Type:                  tt.fields.Type,
+				Name:                  tt.fields.Name,
+				TenantID:              tt.fields.TenantID,
+				Claims:                tt.fields.Claims,
+				AllowedExtKeyUsages:   tt.fields.AllowedExtKeyUsages,
+				AllowedPublicKeyTypes: tt.fields.AllowedPublicKeyTypes,
+				config:                tt.fields.config,
 			}
 			if err := p.Init(tt.args.config); (err != nil) != tt.wantErr {
 				t.Errorf("Azure.Init() error = %v, wantErr %v", err, tt.wantErr)
@@ -212,6 +317,226 @@ func TestAzure_Init(t *testing.T) {
 	}
 }
 
+func TestAzure_Init_cloud(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+
+	tests := []struct {
+		name         string
+		cloud        string
+		audience     string
+		wantAudience string
+		wantErr      bool
+	}{
+		{"ok default", "", "", azureDefaultAudience, false},
+		{"ok public", AzurePublicCloud, "", azureDefaultAudience, false},
+		{"ok us gov", AzureUSGovCloud, "", "https://management.usgovcloudapi.net/", false},
+		{"ok china", AzureChinaCloud, "", "https://management.chinacloudapi.cn/", false},
+		{"ok germany", AzureGermanyCloud, "", "https://management.microsoftazure.de/", false},
+		{"ok audience", AzureUSGovCloud, "https://example.com/", "https://example.com/", false},
+		{"fail cloud", "AzureMoon", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Azure{
+				Type:     p1.Type,
+				Name:     p1.Name,
+				TenantID: p1.TenantID,
+				Cloud:    tt.cloud,
+				Audience: tt.audience,
+				config:   p1.config,
+			}
+			err := p.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.wantAudience, p.Audience)
+			}
+		})
+	}
+}
+
+func TestAzure_Init_keyTypeOptions(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+
+	tests := []struct {
+		name                string
+		keyTypeOptions      map[string]*KeyTypeOptions
+		allowedExtKeyUsages []string
+		want                keyTypeOptions
+		wantErr             bool
+	}{
+		{"ok", map[string]*KeyTypeOptions{"rsa": {MinRSAKeySize: 3072}}, nil, keyTypeOptions{"RSA": {MinRSAKeySize: 3072}}, false},
+		{"ok allowed", map[string]*KeyTypeOptions{"ECDSA": {ExtKeyUsages: []string{"clientAuth"}}}, []string{"clientAuth"},
+			keyTypeOptions{"ECDSA": {ExtKeyUsages: []string{"clientAuth"}, extKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}}, false},
+		{"ok empty", nil, nil, keyTypeOptions{}, false},
+		{"fail type", map[string]*KeyTypeOptions{"DSA": {}}, nil, nil, true},
+		{"fail min rsa size", map[string]*KeyTypeOptions{"RSA": {MinRSAKeySize: 1024}}, nil, nil, true},
+		{"fail not allowed", map[string]*KeyTypeOptions{"ECDSA": {ExtKeyUsages: []string{"serverAuth"}}}, []string{"clientAuth"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Azure{
+				Type:                p1.Type,
+				Name:                p1.Name,
+				TenantID:            p1.TenantID,
+				KeyTypeOptions:      tt.keyTypeOptions,
+				AllowedExtKeyUsages: tt.allowedExtKeyUsages,
+				config:              p1.config,
+			}
+			err := p.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.want, p.keyTypeOptions)
+			}
+		})
+	}
+}
+
+func TestAzure_Init_timeout(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	// The slow server does not respond until the client cancels the request.
+	done := make(chan struct{})
+	var slowURL string
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/openid-configuration" {
+			fmt.Fprintf(w, `{"issuer":"the-issuer","jwks_uri":"%s/jwks_uri"}`, slowURL)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	slowURL = slow.URL
+	defer slow.Close()
+	defer close(done)
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+
+	tests := []struct {
+		name         string
+		discoveryURL string
+		wantErr      bool
+	}{
+		{"ok", p1.config.oidcDiscoveryURL, false},
+		{"fail discovery", slow.URL + "/hang", true},
+		{"fail jwks", slow.URL + "/openid-configuration", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Azure{
+				Type:     p1.Type,
+				Name:     p1.Name,
+				TenantID: p1.TenantID,
+				config: &azureConfig{
+					oidcDiscoveryURL: tt.discoveryURL,
+					identityTokenURL: p1.config.identityTokenURL,
+					initTimeout:      100 * time.Millisecond,
+				},
+			}
+			start := time.Now()
+			if err := p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("Azure.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("Azure.Init() took %s, want less than 5s", d)
+			}
+		})
+	}
+}
+
+func TestAzure_Init_imds(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+
+	tests := []struct {
+		name    string
+		imdsURL string
+		wantErr bool
+	}{
+		{"ok default", "", false},
+		{"ok http", "http://169.254.169.254", false},
+		{"ok https", "https://imds.azurestack.local/", false},
+		{"fail parse", "://imds.azurestack.local", true},
+		{"fail scheme", "ftp://imds.azurestack.local", true},
+		{"fail host", "http:///metadata", true},
+		{"fail query", "http://169.254.169.254?api-version=2018-02-01", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Azure{
+				Type:     p1.Type,
+				Name:     p1.Name,
+				TenantID: p1.TenantID,
+				IMDSURL:  tt.imdsURL,
+				config:   p1.config,
+			}
+			if err := p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("Azure.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newAzureConfig(t *testing.T) {
+	tests := []struct {
+		name                 string
+		cloud                string
+		discoveryURL         string
+		imdsURL              string
+		imdsAPIVersion       string
+		wantOIDCDiscoveryURL string
+		wantIdentityTokenURL string
+	}{
+		{"public", "", "", "", "", "https://login.microsoftonline.com/tenant/.well-known/openid-configuration",
+			"http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F"},
+		{"us gov", AzureUSGovCloud, "", "", "", "https://login.microsoftonline.us/tenant/.well-known/openid-configuration",
+			"http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.usgovcloudapi.net%2F"},
+		{"china", AzureChinaCloud, "", "", "", "https://login.chinacloudapi.cn/tenant/.well-known/openid-configuration",
+			"http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.chinacloudapi.cn%2F"},
+		{"discovery url", AzureUSGovCloud, "https://example.com/.well-known/openid-configuration", "", "", "https://example.com/.well-known/openid-configuration",
+			"http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.usgovcloudapi.net%2F"},
+		{"imds url", "", "", "https://imds.azurestack.local/", "2019-08-01", "https://login.microsoftonline.com/tenant/.well-known/openid-configuration",
+			"https://imds.azurestack.local/metadata/identity/oauth2/token?api-version=2019-08-01&resource=https%3A%2F%2Fmanagement.azure.com%2F"},
+		{"imds api version", AzureUSGovCloud, "", "", "2019-08-01", "https://login.microsoftonline.us/tenant/.well-known/openid-configuration",
+			"http://169.254.169.254/metadata/identity/oauth2/token?api-version=2019-08-01&resource=https%3A%2F%2Fmanagement.usgovcloudapi.net%2F"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newAzureConfig("tenant", tt.cloud, tt.discoveryURL, tt.imdsURL, tt.imdsAPIVersion)
+			assert.Equals(t, tt.wantOIDCDiscoveryURL, got.oidcDiscoveryURL)
+			assert.Equals(t, tt.wantIdentityTokenURL, got.identityTokenURL)
+		})
+	}
+}
+
 func TestAzure_authorizeToken(t *testing.T) {
 	type test struct {
 		p     *Azure
@@ -244,7 +569,25 @@ func TestAzure_authorizeToken(t *testing.T) {
 				p:     p,
 				token: tok,
 				code:  http.StatusUnauthorized,
-				err:   errors.New("azure.authorizeToken; cannot validate azure token"),
+				err:   errors.New("azure.authorizeToken; cannot validate azure token - key id '"),
+			}
+		},
+		"fail/cannot-validate-sig-kid": func(t *testing.T) test {
+			p, srv, err := generateAzureWithServer()
+			assert.FatalError(t, err)
+			defer srv.Close()
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			jwk.KeyID = p.keyStore.keySet.Keys[0].KeyID
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now(), jwk)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("azure.authorizeToken; cannot validate azure token - invalid signature with key id '" + jwk.KeyID + "'"),
 			}
 		},
 		"fail/invalid-token-issuer": func(t *testing.T) test {
@@ -316,6 +659,38 @@ func TestAzure_authorizeToken(t *testing.T) {
 				err:   errors.New("azure.authorizeToken; error parsing xms_mirid claim - foo"),
 			}
 		},
+		"fail/invalid-vm-id": func(t *testing.T) test {
+			p, srv, err := generateAzureWithServer()
+			assert.FatalError(t, err)
+			defer srv.Close()
+			p.VMIDs = []string{"foo", "bar"}
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now(), &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("azure.authorizeToken; azure token validation failed - invalid vm id claim (vmId)"),
+			}
+		},
+		"fail/unsupported-algorithm": func(t *testing.T) test {
+			p, srv, err := generateAzureWithServer()
+			assert.FatalError(t, err)
+			defer srv.Close()
+			p.oidcConfig.SigningAlgorithms = []string{"RS256"}
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now(), &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("azure.authorizeToken; azure token signing algorithm ES256 is not supported"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, srv, err := generateAzureWithServer()
 			assert.FatalError(t, err)
@@ -329,6 +704,37 @@ func TestAzure_authorizeToken(t *testing.T) {
 				token: tok,
 			}
 		},
+		"ok/rs256": func(t *testing.T) test {
+			p, srv, err := generateAzureWithServer()
+			assert.FatalError(t, err)
+			defer srv.Close()
+			jwk, err := jose.GenerateJWK("RSA", "", "RS256", "sig", "rsa-key", 2048)
+			assert.FatalError(t, err)
+			p.keyStore.keySet.Keys = append(p.keyStore.keySet.Keys, *jwk)
+			p.oidcConfig.SigningAlgorithms = []string{"RS256", "ES256"}
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now(), jwk)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
+		"ok/vm-id": func(t *testing.T) test {
+			p, srv, err := generateAzureWithServer()
+			assert.FatalError(t, err)
+			defer srv.Close()
+			p.VMIDs = []string{"foo", "THE-VMID"}
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now(), &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -346,6 +752,8 @@ func TestAzure_authorizeToken(t *testing.T) {
 					assert.Equals(t, claims.Issuer, tc.p.oidcConfig.Issuer)
 					assert.Equals(t, claims.Audience[0], azureDefaultAudience)
 
+					assert.Equals(t, claims.VMID, "the-vmid")
+
 					assert.Equals(t, name, "virtualMachine")
 					assert.Equals(t, group, "resourceGroup")
 				}
@@ -354,6 +762,229 @@ func TestAzure_authorizeToken(t *testing.T) {
 	}
 }
 
+func TestAzure_authorizeToken_clockSkew(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	// Tokens are valid for 5 minutes after the issued at time.
+	tests := []struct {
+		name    string
+		skew    *Duration
+		iat     time.Duration
+		wantErr bool
+	}{
+		{"ok default nbf", nil, 50 * time.Second, false},
+		{"ok default exp", nil, -5*time.Minute - 50*time.Second, false},
+		{"fail default nbf", nil, 70 * time.Second, true},
+		{"fail default exp", nil, -5*time.Minute - 70*time.Second, true},
+		{"ok loose nbf", &Duration{5 * time.Minute}, 4 * time.Minute, false},
+		{"ok loose exp", &Duration{5 * time.Minute}, -9 * time.Minute, false},
+		{"fail loose nbf", &Duration{5 * time.Minute}, 6 * time.Minute, true},
+		{"fail loose exp", &Duration{5 * time.Minute}, -11 * time.Minute, true},
+		{"ok strict nbf", &Duration{10 * time.Second}, 5 * time.Second, false},
+		{"ok strict exp", &Duration{10 * time.Second}, -5*time.Minute - 5*time.Second, false},
+		{"fail strict nbf", &Duration{10 * time.Second}, 20 * time.Second, true},
+		{"fail strict exp", &Duration{10 * time.Second}, -5*time.Minute - 20*time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.claimer, err = NewClaimer(&Claims{ClockSkew: tt.skew}, globalProvisionerClaims)
+			assert.FatalError(t, err)
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now().Add(tt.iat), &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			_, _, _, err = p.authorizeToken(tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAzure_authorizeToken_leeway(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	// Tokens are valid for 5 minutes after the issued at time, and the default
+	// clock skew is 1 minute.
+	tests := []struct {
+		name    string
+		nbf     *Duration
+		exp     *Duration
+		iat     time.Duration
+		wantErr bool
+	}{
+		{"ok default nbf", nil, nil, 50 * time.Second, false},
+		{"fail default nbf", nil, nil, 70 * time.Second, true},
+		{"ok default exp", nil, nil, -5*time.Minute - 50*time.Second, false},
+		{"fail default exp", nil, nil, -5*time.Minute - 70*time.Second, true},
+		{"ok lenient nbf", &Duration{10 * time.Minute}, &Duration{0}, 6 * time.Minute, false},
+		{"fail lenient nbf", &Duration{10 * time.Minute}, &Duration{0}, 11 * time.Minute, true},
+		{"fail strict exp", &Duration{10 * time.Minute}, &Duration{0}, -5*time.Minute - 10*time.Second, true},
+		{"ok lenient exp", &Duration{0}, &Duration{10 * time.Minute}, -14 * time.Minute, false},
+		{"fail strict nbf", &Duration{0}, &Duration{10 * time.Minute}, 10 * time.Second, true},
+		{"ok only nbf", &Duration{5 * time.Minute}, nil, -5*time.Minute - 50*time.Second, false},
+		{"fail only nbf", &Duration{5 * time.Minute}, nil, -5*time.Minute - 70*time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.NotBeforeLeeway = tt.nbf
+			p.ExpiryLeeway = tt.exp
+			tok, err := generateAzureToken("subject", p.oidcConfig.Issuer, azureDefaultAudience,
+				p.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
+				time.Now().Add(tt.iat), &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			_, _, _, err = p.authorizeToken(tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAzure_authorizeToken_requiredACR(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	xmsMirID := "/subscriptions/subscriptionID/resourceGroups/resourceGroup/providers/Microsoft.Compute/virtualMachines/virtualMachine"
+	tests := []struct {
+		name     string
+		required string
+		acr      interface{}
+		wantErr  bool
+	}{
+		{"ok not required", "", "0", false},
+		{"ok equal", "1", "1", false},
+		{"ok greater", "1", "2", false},
+		{"fail lower", "2", "1", true},
+		{"fail public client", "1", "0", true},
+		{"fail missing", "1", nil, true},
+		{"fail not numeric", "1", "the-appidacr", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.RequiredACR = tt.required
+			claims := map[string]interface{}{
+				"tid":       p.TenantID,
+				"xms_mirid": xmsMirID,
+			}
+			if tt.acr != nil {
+				claims["appidacr"] = tt.acr
+			}
+			tok, err := generateTokenWithClaims(p.oidcConfig.Issuer, azureDefaultAudience, claims, &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			_, _, _, err = p.authorizeToken(tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAzure_authorizeToken_maxTokenAge(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	xmsMirID := "/subscriptions/subscriptionID/resourceGroups/resourceGroup/providers/Microsoft.Compute/virtualMachines/virtualMachine"
+	now := time.Now()
+	tests := []struct {
+		name        string
+		maxTokenAge Duration
+		iat         interface{}
+		wantErr     bool
+	}{
+		{"ok not set", Duration{}, now.Add(-2 * time.Minute).Unix(), false},
+		{"ok not set missing", Duration{}, nil, false},
+		{"ok fresh", Duration{Duration: time.Minute}, now.Add(-30 * time.Second).Unix(), false},
+		{"fail too old", Duration{Duration: time.Minute}, now.Add(-2 * time.Minute).Unix(), true},
+		{"fail missing", Duration{Duration: time.Minute}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.MaxTokenAge = tt.maxTokenAge
+			claims := map[string]interface{}{
+				"tid":       p.TenantID,
+				"xms_mirid": xmsMirID,
+				"iat":       tt.iat,
+			}
+			tok, err := generateTokenWithClaims(p.oidcConfig.Issuer, azureDefaultAudience, claims, &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			_, _, _, err = p.authorizeToken(tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestAzure_authorizeToken_xmsAzRID(t *testing.T) {
+	p, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	vmID := "/subscriptions/subscriptionID/resourceGroups/resourceGroup/providers/Microsoft.Compute/virtualMachines/virtualMachine"
+	identityID := "/subscriptions/subscriptionID/resourcegroups/identityGroup/providers/Microsoft.ManagedIdentity/userAssignedIdentities/identity"
+	tests := []struct {
+		name      string
+		allow     bool
+		xmsMirID  interface{}
+		xmsAzRID  interface{}
+		wantGroup string
+		wantName  string
+		wantErr   bool
+	}{
+		{"ok xms_mirid", false, vmID, nil, "resourceGroup", "virtualMachine", false},
+		{"ok xms_mirid preferred", true, vmID, "/subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/virtualMachines/vm", "resourceGroup", "virtualMachine", false},
+		{"ok missing xms_mirid", true, nil, vmID, "resourceGroup", "virtualMachine", false},
+		{"ok user assigned identity", true, identityID, vmID, "resourceGroup", "virtualMachine", false},
+		{"fail not allowed", false, nil, vmID, "", "", true},
+		{"fail user assigned identity not allowed", false, identityID, vmID, "", "", true},
+		{"fail invalid xms_az_rid", true, identityID, "foo", "", "", true},
+		{"fail missing both", true, nil, nil, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.AllowXMSAzRID = tt.allow
+			claims := map[string]interface{}{
+				"tid": p.TenantID,
+			}
+			if tt.xmsMirID != nil {
+				claims["xms_mirid"] = tt.xmsMirID
+			}
+			if tt.xmsAzRID != nil {
+				claims["xms_az_rid"] = tt.xmsAzRID
+			}
+			tok, err := generateTokenWithClaims(p.oidcConfig.Issuer, azureDefaultAudience, claims, &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			_, name, group, err := p.authorizeToken(tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Azure.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.wantName, name)
+			assert.Equals(t, tt.wantGroup, group)
+
+			// The token id must be the hash of the resource id used.
+			if err == nil && tt.xmsMirID != vmID {
+				id, err := p.GetTokenID(tok)
+				assert.FatalError(t, err)
+				sum := sha256.Sum256([]byte(vmID))
+				assert.Equals(t, strings.ToLower(hex.EncodeToString(sum[:])), id)
+			}
+		})
+	}
+}
+
 func TestAzure_AuthorizeSign(t *testing.T) {
 	p1, srv, err := generateAzureWithServer()
 	assert.FatalError(t, err)
@@ -382,6 +1013,32 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 	p4.oidcConfig = p1.oidcConfig
 	p4.keyStore = p1.keyStore
 
+	p5, err := generateAzure()
+	assert.FatalError(t, err)
+	p5.TenantID = p1.TenantID
+	p5.config = p1.config
+	p5.oidcConfig = p1.oidcConfig
+	p5.keyStore = p1.keyStore
+	p5.extKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	p5.publicKeyTypes = []publicKeyType{{"ECDSA", 0}}
+
+	p6, err := generateAzure()
+	assert.FatalError(t, err)
+	p6.TenantID = p1.TenantID
+	p6.config = p1.config
+	p6.oidcConfig = p1.oidcConfig
+	p6.keyStore = p1.keyStore
+	p6.DisableCustomSANs = true
+	p6.DisableDefaultSANs = true
+
+	p7, err := generateAzure()
+	assert.FatalError(t, err)
+	p7.TenantID = p1.TenantID
+	p7.config = p1.config
+	p7.oidcConfig = p1.oidcConfig
+	p7.keyStore = p1.keyStore
+	p7.Webhook = &Webhook{URL: "https://webhook.smallstep.com"}
+
 	badKey, err := generateJSONWebKey()
 	assert.FatalError(t, err)
 
@@ -393,6 +1050,12 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 	assert.FatalError(t, err)
 	t4, err := p4.GetIdentityToken("subject", "caURL")
 	assert.FatalError(t, err)
+	t5, err := p5.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	t6, err := p6.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	t7, err := p7.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
 
 	t11, err := generateAzureToken("subject", p1.oidcConfig.Issuer, azureDefaultAudience,
 		p1.TenantID, "subscriptionID", "resourceGroup", "virtualMachine",
@@ -431,9 +1094,12 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 9, http.StatusOK, false},
-		{"ok", p1, args{t11}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 5, http.StatusOK, false},
+		{"ok", p2, args{t2}, 10, http.StatusOK, false},
+		{"ok", p1, args{t11}, 5, http.StatusOK, false},
+		{"ok ext key usages and public key types", p5, args{t5}, 8, http.StatusOK, false},
+		{"ok disable default sans", p6, args{t6}, 8, http.StatusOK, false},
+		{"ok webhook", p7, args{t7}, 6, http.StatusOK, false},
 		{"fail tenant", p3, args{t3}, 0, http.StatusUnauthorized, true},
 		{"fail resource group", p4, args{t4}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
@@ -462,7 +1128,7 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 						assert.Equals(t, v.Type, int(TypeAzure))
 						assert.Equals(t, v.Name, tt.azure.GetName())
 						assert.Equals(t, v.CredentialID, tt.azure.TenantID)
-						assert.Len(t, 0, v.KeyValuePairs)
+						assert.Equals(t, v.KeyValuePairs, []string{"VMID", "the-vmid"})
 					case profileDefaultDuration:
 						assert.Equals(t, time.Duration(v), tt.azure.claimer.DefaultTLSCertDuration())
 					case commonNameValidator:
@@ -479,6 +1145,27 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 						assert.Equals(t, v, nil)
 					case dnsNamesValidator:
 						assert.Equals(t, []string(v), []string{"virtualMachine"})
+					case *AuthorizedIdentity:
+						assert.Equals(t, v, &AuthorizedIdentity{
+							Subject:       "subject",
+							TenantID:      tt.azure.TenantID,
+							ResourceGroup: "resourceGroup",
+							Name:          "virtualMachine",
+							VMID:          "the-vmid",
+							Credential:    "the-vmid",
+						})
+					case publicKeyTypeValidator:
+						assert.Equals(t, []publicKeyType(v), tt.azure.publicKeyTypes)
+					case *webhookValidator:
+						assert.Equals(t, v.webhook, tt.azure.Webhook)
+						assert.Equals(t, v.provisionerType, TypeAzure)
+						assert.Equals(t, v.provisionerName, tt.azure.Name)
+						assert.Equals(t, v.identity.Name, "virtualMachine")
+					case extKeyUsageModifier:
+						assert.Equals(t, []x509.ExtKeyUsage(v), tt.azure.extKeyUsages)
+					case extKeyUsageValidator:
+						assert.Equals(t, []x509.ExtKeyUsage(v), tt.azure.extKeyUsages)
+						assert.Error(t, v.Valid(&x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, Options{}))
 					default:
 						assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 					}
@@ -488,6 +1175,121 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 	}
 }
 
+func TestAzure_AuthorizeSign_validity(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	// generateToken returns a token with the given validity claim.
+	generateToken := func(validity *Duration) string {
+		jwk := &p1.keyStore.keySet.Keys[0]
+		sig, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.SignatureAlgorithm(jwk.Algorithm), Key: jwk.Key},
+			new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+		)
+		assert.FatalError(t, err)
+		now := time.Now()
+		tok, err := jose.Signed(sig).Claims(azurePayload{
+			Claims: jose.Claims{
+				Subject:   "subject",
+				Issuer:    p1.oidcConfig.Issuer,
+				IssuedAt:  jose.NewNumericDate(now),
+				NotBefore: jose.NewNumericDate(now),
+				Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+				Audience:  []string{azureDefaultAudience},
+				ID:        "the-jti",
+			},
+			TenantID: p1.TenantID,
+			XMSMirID: "/subscriptions/subscriptionID/resourceGroups/resourceGroup/providers/Microsoft.Compute/virtualMachines/virtualMachine",
+			VMID:     "the-vmid",
+			Validity: validity,
+		}).CompactSerialize()
+		assert.FatalError(t, err)
+		return tok
+	}
+
+	tests := []struct {
+		name     string
+		validity *Duration
+		want     time.Duration
+	}{
+		{"default", nil, 24 * time.Hour},
+		{"below min", &Duration{time.Minute}, 5 * time.Minute},
+		{"in range", &Duration{time.Hour}, time.Hour},
+		{"above max", &Duration{48 * time.Hour}, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewContextWithMethod(context.Background(), SignMethod)
+			got, err := p1.AuthorizeSign(ctx, generateToken(tt.validity))
+			assert.FatalError(t, err)
+			var found bool
+			for _, o := range got {
+				if v, ok := o.(profileDefaultDuration); ok {
+					assert.Equals(t, time.Duration(v), tt.want)
+					found = true
+				}
+			}
+			assert.True(t, found, "profileDefaultDuration not found")
+		})
+	}
+}
+
+func TestAzure_AuthorizeSign_checkRenewalIdentity(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p1.CheckRenewalIdentity = true
+
+	token, err := p1.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+
+	ctx := NewContextWithMethod(context.Background(), SignMethod)
+	opts, err := p1.AuthorizeSign(ctx, token)
+	assert.FatalError(t, err)
+
+	var found bool
+	for _, o := range opts {
+		if v, ok := o.(*provisionerExtensionOption); ok {
+			found = true
+			assert.Equals(t, v.KeyValuePairs, []string{"VMID", "the-vmid", "ResourceGroup", "resourceGroup"})
+		}
+	}
+	assert.True(t, found, "provisioner extension option not found")
+}
+
+func TestAzure_AuthorizeSign_keyTypeOptions(t *testing.T) {
+	p1, srv, err := generateAzureWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p1.keyTypeOptions, err = parseKeyTypeOptions(map[string]*KeyTypeOptions{
+		"rsa":   {MinRSAKeySize: 3072},
+		"ECDSA": {ExtKeyUsages: []string{"serverAuth"}},
+	}, nil)
+	assert.FatalError(t, err)
+
+	token, err := p1.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+
+	ctx := NewContextWithMethod(context.Background(), SignMethod)
+	opts, err := p1.AuthorizeSign(ctx, token)
+	assert.FatalError(t, err)
+
+	var foundValidator, foundEnforcer bool
+	for _, o := range opts {
+		switch v := o.(type) {
+		case defaultPublicKeyValidator:
+			foundValidator = true
+			assert.Equals(t, v, defaultPublicKeyValidator{minRSABits: 3072})
+		case keyTypeExtKeyUsageEnforcer:
+			foundEnforcer = true
+			assert.Equals(t, v, keyTypeExtKeyUsageEnforcer{"ECDSA": {x509.ExtKeyUsageServerAuth}})
+		}
+	}
+	assert.True(t, foundValidator, "public key validator not found")
+	assert.True(t, foundEnforcer, "extended key usage enforcer not found")
+}
+
 func TestAzure_AuthorizeRenew(t *testing.T) {
 	p1, err := generateAzure()
 	assert.FatalError(t, err)
@@ -500,6 +1302,19 @@ func TestAzure_AuthorizeRenew(t *testing.T) {
 	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	// check the identity in the certificate
+	p3, err := generateAzure()
+	assert.FatalError(t, err)
+	p3.CheckRenewalIdentity = true
+	p3.ResourceGroups = []string{"resourceGroup"}
+	p3.VMIDs = []string{"the-vmid"}
+
+	mustCert := func(typ Type, keyValuePairs ...string) *x509.Certificate {
+		ext, err := createProvisionerExtension(int(typ), p3.Name, p3.TenantID, keyValuePairs...)
+		assert.FatalError(t, err)
+		return &x509.Certificate{Extensions: []pkix.Extension{ext}}
+	}
+
 	type args struct {
 		cert *x509.Certificate
 	}
@@ -511,7 +1326,14 @@ func TestAzure_AuthorizeRenew(t *testing.T) {
 		wantErr bool
 	}{
 		{"ok", p1, args{nil}, http.StatusOK, false},
+		{"ok/check-identity", p3, args{mustCert(TypeAzure, "VMID", "THE-VMID", "ResourceGroup", "resourceGroup")}, http.StatusOK, false},
 		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"fail/no-extension", p3, args{&x509.Certificate{}}, http.StatusUnauthorized, true},
+		{"fail/not-azure", p3, args{mustCert(TypeGCP, "VMID", "the-vmid", "ResourceGroup", "resourceGroup")}, http.StatusUnauthorized, true},
+		{"fail/no-resource-group", p3, args{mustCert(TypeAzure, "VMID", "the-vmid")}, http.StatusUnauthorized, true},
+		{"fail/resource-group", p3, args{mustCert(TypeAzure, "VMID", "the-vmid", "ResourceGroup", "otherGroup")}, http.StatusUnauthorized, true},
+		{"fail/vmid", p3, args{mustCert(TypeAzure, "VMID", "other-vmid", "ResourceGroup", "resourceGroup")}, http.StatusUnauthorized, true},
+		{"fail/no-vmid", p3, args{mustCert(TypeAzure, "ResourceGroup", "resourceGroup")}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -22,8 +22,19 @@ type Claims struct {
 	MaxHostSSHDur     *Duration `json:"maxHostSSHCertDuration,omitempty"`
 	DefaultHostSSHDur *Duration `json:"defaultHostSSHCertDuration,omitempty"`
 	EnableSSHCA       *bool     `json:"enableSSHCA,omitempty"`
+	// Token validation properties
+	ClockSkew *Duration `json:"clockSkew,omitempty"`
+	// Public key properties
+	MinRSAKeySize *int    `json:"minRSAKeySize,omitempty"`
+	MinECDSACurve *string `json:"minECDSACurve,omitempty"`
+	// Issuance properties
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
 }
 
+// DefaultClockSkew is the default clock skew allowed when the time claims of a
+// token are validated.
+const DefaultClockSkew = time.Minute
+
 // Claimer is the type that controls claims. It provides an interface around the
 // current claim and the global one.
 type Claimer struct {
@@ -41,7 +52,10 @@ func NewClaimer(claims *Claims, global Claims) (*Claimer, error) {
 func (c *Claimer) Claims() Claims {
 	disableRenewal := c.IsDisableRenewal()
 	enableSSHCA := c.IsSSHCAEnabled()
+	minRSAKeySize := c.MinRSAKeySize()
+	minECDSACurve := c.MinECDSACurve()
 	return Claims{
+		ClockSkew:         &Duration{c.ClockSkew()},
 		MinTLSDur:         &Duration{c.MinTLSCertDuration()},
 		MaxTLSDur:         &Duration{c.MaxTLSCertDuration()},
 		DefaultTLSDur:     &Duration{c.DefaultTLSCertDuration()},
@@ -53,6 +67,9 @@ func (c *Claimer) Claims() Claims {
 		MaxHostSSHDur:     &Duration{c.MaxHostSSHCertDuration()},
 		DefaultHostSSHDur: &Duration{c.DefaultHostSSHCertDuration()},
 		EnableSSHCA:       &enableSSHCA,
+		MinRSAKeySize:     &minRSAKeySize,
+		MinECDSACurve:     &minECDSACurve,
+		RateLimit:         c.RateLimit(),
 	}
 }
 
@@ -181,13 +198,97 @@ func (c *Claimer) IsSSHCAEnabled() bool {
 	return *c.claims.EnableSSHCA
 }
 
+// ClockSkew returns the clock skew allowed in the validation of the time claims
+// (nbf, iat and exp) of a token. If the property is not set within the
+// provisioner, then the global value from the authority configuration will be
+// used, and if it is not set either DefaultClockSkew will be used.
+func (c *Claimer) ClockSkew() time.Duration {
+	switch {
+	case c == nil:
+		return DefaultClockSkew
+	case c.claims != nil && c.claims.ClockSkew != nil:
+		return c.claims.ClockSkew.Duration
+	case c.global.ClockSkew != nil:
+		return c.global.ClockSkew.Duration
+	default:
+		return DefaultClockSkew
+	}
+}
+
+// MinRSAKeySize returns the minimum size in bits of the RSA keys in the
+// certificate requests. If the property is not set within the provisioner,
+// then the global value from the authority configuration will be used, and if
+// it is not set either 2048 bits will be used.
+func (c *Claimer) MinRSAKeySize() int {
+	switch {
+	case c == nil:
+		return defaultMinRSAKeySize
+	case c.claims != nil && c.claims.MinRSAKeySize != nil:
+		return *c.claims.MinRSAKeySize
+	case c.global.MinRSAKeySize != nil:
+		return *c.global.MinRSAKeySize
+	default:
+		return defaultMinRSAKeySize
+	}
+}
+
+// MinECDSACurve returns the smallest curve allowed for the ECDSA keys in the
+// certificate requests, "P-256", "P-384" or "P-521". If the property is not
+// set within the provisioner, then the global value from the authority
+// configuration will be used. An empty string allows any curve.
+func (c *Claimer) MinECDSACurve() string {
+	switch {
+	case c == nil:
+		return ""
+	case c.claims != nil && c.claims.MinECDSACurve != nil:
+		return *c.claims.MinECDSACurve
+	case c.global.MinECDSACurve != nil:
+		return *c.global.MinECDSACurve
+	default:
+		return ""
+	}
+}
+
+// RateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time. If the property is not set
+// within the provisioner, then the global value from the authority
+// configuration will be used. It returns nil if there is no limit.
+func (c *Claimer) RateLimit() *RateLimit {
+	switch {
+	case c == nil:
+		return nil
+	case c.claims != nil && c.claims.RateLimit != nil:
+		return c.claims.RateLimit
+	default:
+		return c.global.RateLimit
+	}
+}
+
+// ecdsaCurveSizes maps the names of the curves used in the claims with their
+// size in bits.
+var ecdsaCurveSizes = map[string]int{
+	"":      0,
+	"P-256": 256,
+	"P-384": 384,
+	"P-521": 521,
+}
+
+// MinECDSAKeySize returns the size in bits of the curve returned by
+// MinECDSACurve, or 0 if any curve is allowed.
+func (c *Claimer) MinECDSAKeySize() int {
+	return ecdsaCurveSizes[c.MinECDSACurve()]
+}
+
 // Validate validates and modifies the Claims with default values.
 func (c *Claimer) Validate() error {
 	var (
-		min = c.MinTLSCertDuration()
-		max = c.MaxTLSCertDuration()
-		def = c.DefaultTLSCertDuration()
+		min  = c.MinTLSCertDuration()
+		max  = c.MaxTLSCertDuration()
+		def  = c.DefaultTLSCertDuration()
+		skew = c.ClockSkew()
 	)
+	_, validCurve := ecdsaCurveSizes[c.MinECDSACurve()]
+	rateLimit := c.RateLimit()
 	switch {
 	case min <= 0:
 		return errors.Errorf("claims: MinTLSCertDuration must be greater than 0")
@@ -202,6 +303,16 @@ func (c *Claimer) Validate() error {
 		return errors.Errorf("claims: DefaultCertDuration cannot be less than MinCertDuration: DefaultCertDuration - %v, MinCertDuration - %v", def, min)
 	case max < def:
 		return errors.Errorf("claims: MaxCertDuration cannot be less than DefaultCertDuration: MaxCertDuration - %v, DefaultCertDuration - %v", max, def)
+	case skew < 0:
+		return errors.Errorf("claims: ClockSkew cannot be negative: ClockSkew - %v", skew)
+	case c.MinRSAKeySize() < defaultMinRSAKeySize:
+		return errors.Errorf("claims: MinRSAKeySize cannot be less than %d: MinRSAKeySize - %d", defaultMinRSAKeySize, c.MinRSAKeySize())
+	case !validCurve:
+		return errors.Errorf("claims: MinECDSACurve must be P-256, P-384 or P-521: MinECDSACurve - %s", c.MinECDSACurve())
+	case rateLimit != nil && rateLimit.Count <= 0:
+		return errors.Errorf("claims: RateLimit count must be greater than 0: RateLimit count - %d", rateLimit.Count)
+	case rateLimit != nil && (rateLimit.Window == nil || rateLimit.Window.Duration <= 0):
+		return errors.Errorf("claims: RateLimit window must be greater than 0")
 	default:
 		return nil
 	}
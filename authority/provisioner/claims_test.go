@@ -49,3 +49,142 @@ func TestClaimer_DefaultSSHCertDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestClaimer_ClockSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		claimer *Claimer
+		want    time.Duration
+	}{
+		{"default", &Claimer{global: globalProvisionerClaims}, DefaultClockSkew},
+		{"nil", nil, DefaultClockSkew},
+		{"global", &Claimer{global: Claims{ClockSkew: &Duration{2 * time.Minute}}}, 2 * time.Minute},
+		{"provisioner", &Claimer{global: Claims{ClockSkew: &Duration{2 * time.Minute}}, claims: &Claims{ClockSkew: &Duration{30 * time.Second}}}, 30 * time.Second},
+		{"provisioner zero", &Claimer{global: globalProvisionerClaims, claims: &Claims{ClockSkew: &Duration{}}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claimer.ClockSkew(); got != tt.want {
+				t.Errorf("Claimer.ClockSkew() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClaimer_clockSkew(t *testing.T) {
+	if _, err := NewClaimer(&Claims{ClockSkew: &Duration{time.Minute}}, globalProvisionerClaims); err != nil {
+		t.Errorf("NewClaimer() error = %v", err)
+	}
+	if _, err := NewClaimer(&Claims{ClockSkew: &Duration{-time.Minute}}, globalProvisionerClaims); err == nil {
+		t.Error("NewClaimer() with a negative clock skew did not fail")
+	}
+}
+
+func TestClaimer_MinRSAKeySize(t *testing.T) {
+	size2048, size3072 := 2048, 3072
+	tests := []struct {
+		name    string
+		claimer *Claimer
+		want    int
+	}{
+		{"default", &Claimer{global: globalProvisionerClaims}, 2048},
+		{"nil", nil, 2048},
+		{"global", &Claimer{global: Claims{MinRSAKeySize: &size3072}}, 3072},
+		{"provisioner", &Claimer{global: Claims{MinRSAKeySize: &size3072}, claims: &Claims{MinRSAKeySize: &size2048}}, 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claimer.MinRSAKeySize(); got != tt.want {
+				t.Errorf("Claimer.MinRSAKeySize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimer_MinECDSACurve(t *testing.T) {
+	p256, p384 := "P-256", "P-384"
+	tests := []struct {
+		name     string
+		claimer  *Claimer
+		want     string
+		wantSize int
+	}{
+		{"default", &Claimer{global: globalProvisionerClaims}, "", 0},
+		{"nil", nil, "", 0},
+		{"global", &Claimer{global: Claims{MinECDSACurve: &p384}}, "P-384", 384},
+		{"provisioner", &Claimer{global: Claims{MinECDSACurve: &p384}, claims: &Claims{MinECDSACurve: &p256}}, "P-256", 256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claimer.MinECDSACurve(); got != tt.want {
+				t.Errorf("Claimer.MinECDSACurve() = %v, want %v", got, tt.want)
+			}
+			if got := tt.claimer.MinECDSAKeySize(); got != tt.wantSize {
+				t.Errorf("Claimer.MinECDSAKeySize() = %v, want %v", got, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestNewClaimer_publicKeys(t *testing.T) {
+	size1024, size3072 := 1024, 3072
+	p384, p224 := "P-384", "P-224"
+	tests := []struct {
+		name    string
+		claims  *Claims
+		wantErr bool
+	}{
+		{"ok", &Claims{MinRSAKeySize: &size3072, MinECDSACurve: &p384}, false},
+		{"fail rsa", &Claims{MinRSAKeySize: &size1024}, true},
+		{"fail curve", &Claims{MinECDSACurve: &p224}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClaimer(tt.claims, globalProvisionerClaims); (err != nil) != tt.wantErr {
+				t.Errorf("NewClaimer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimer_RateLimit(t *testing.T) {
+	hourly := &RateLimit{Count: 10, Window: &Duration{time.Hour}}
+	daily := &RateLimit{Count: 100, Window: &Duration{24 * time.Hour}}
+	tests := []struct {
+		name    string
+		claimer *Claimer
+		want    *RateLimit
+	}{
+		{"default", &Claimer{global: globalProvisionerClaims}, nil},
+		{"nil", nil, nil},
+		{"global", &Claimer{global: Claims{RateLimit: daily}}, daily},
+		{"provisioner", &Claimer{global: Claims{RateLimit: daily}, claims: &Claims{RateLimit: hourly}}, hourly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claimer.RateLimit(); got != tt.want {
+				t.Errorf("Claimer.RateLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClaimer_rateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  *Claims
+		wantErr bool
+	}{
+		{"ok", &Claims{RateLimit: &RateLimit{Count: 10, Window: &Duration{time.Hour}}}, false},
+		{"fail count", &Claims{RateLimit: &RateLimit{Count: 0, Window: &Duration{time.Hour}}}, true},
+		{"fail no window", &Claims{RateLimit: &RateLimit{Count: 10}}, true},
+		{"fail window", &Claims{RateLimit: &RateLimit{Count: 10, Window: &Duration{-time.Hour}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClaimer(tt.claims, globalProvisionerClaims); (err != nil) != tt.wantErr {
+				t.Errorf("NewClaimer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
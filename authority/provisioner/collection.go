@@ -3,7 +3,6 @@ package provisioner
 import (
 	"crypto/sha1"
 	"crypto/x509"
-	"encoding/asn1"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -37,13 +36,15 @@ func (p provisionerSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 // provisioner.
 type loadByTokenPayload struct {
 	jose.Claims
-	AuthorizedParty    string `json:"azp"`                                               // OIDC client id
-	TenantID           string `json:"tid"`                                               // Microsoft Azure tenant id
-	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name"` // Kubernetes Service Acct Name
+	AuthorizedParty    string                 `json:"azp"`                                               // OIDC client id
+	TenantID           string                 `json:"tid"`                                               // Microsoft Azure tenant id
+	ServiceAccountName string                 `json:"kubernetes.io/serviceaccount/service-account.name"` // Kubernetes Service Acct Name
+	Kubernetes         *k8sSAKubernetesClaims `json:"kubernetes.io"`                                     // Kubernetes projected token
 }
 
 // Collection is a memory map of provisioners.
 type Collection struct {
+	mutex     sync.RWMutex
 	byID      *sync.Map
 	byKey     *sync.Map
 	sorted    provisionerSlice
@@ -62,11 +63,29 @@ func NewCollection(audiences Audiences) *Collection {
 
 // Load a provisioner by the ID.
 func (c *Collection) Load(id string) (Interface, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return loadProvisioner(c.byID, id)
 }
 
 // LoadByToken parses the token claims and loads the provisioner associated.
 func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims) (Interface, bool) {
+	// The ID will be just the clientID stored in azp, aud or tid.
+	var payload loadByTokenPayload
+	if err := token.UnsafeClaimsWithoutVerification(&payload); err != nil {
+		return nil, false
+	}
+
+	// Kubernetes Service Account tokens. Projected tokens can use the CA as the
+	// audience, so they are checked before the server audiences.
+	if len(payload.ServiceAccountName) > 0 || payload.Kubernetes != nil {
+		if p, ok := c.Load(K8sSAID); ok {
+			return p, ok
+		}
+		// Kubernetes service account provisioner not found
+		return nil, false
+	}
+
 	var audiences []string
 	// Get all audiences with the given fragment
 	fragment := extractFragment(claims.Audience)
@@ -87,21 +106,6 @@ func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims)
 		return c.Load(claims.Issuer + ":" + token.Headers[0].KeyID)
 	}
 
-	// The ID will be just the clientID stored in azp, aud or tid.
-	var payload loadByTokenPayload
-	if err := token.UnsafeClaimsWithoutVerification(&payload); err != nil {
-		return nil, false
-	}
-
-	// Kubernetes Service Account tokens.
-	if len(payload.ServiceAccountName) > 0 {
-		if p, ok := c.Load(K8sSAID); ok {
-			return p, ok
-		}
-		// Kubernetes service account provisioner not found
-		return nil, false
-	}
-
 	// Audience is required for non k8sSA tokens.
 	if len(payload.Audience) == 0 {
 		return nil, false
@@ -126,40 +130,42 @@ func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims)
 // LoadByCertificate looks for the provisioner extension and extracts the
 // proper id to load the provisioner.
 func (c *Collection) LoadByCertificate(cert *x509.Certificate) (Interface, bool) {
-	for _, e := range cert.Extensions {
-		if e.Id.Equal(stepOIDProvisioner) {
-			var provisioner stepProvisionerASN1
-			if _, err := asn1.Unmarshal(e.Value, &provisioner); err != nil {
-				return nil, false
-			}
-			switch Type(provisioner.Type) {
-			case TypeJWK:
-				return c.Load(string(provisioner.Name) + ":" + string(provisioner.CredentialID))
-			case TypeAWS:
-				return c.Load("aws/" + string(provisioner.Name))
-			case TypeGCP:
-				return c.Load("gcp/" + string(provisioner.Name))
-			case TypeACME:
-				return c.Load("acme/" + string(provisioner.Name))
-			case TypeX5C:
-				return c.Load("x5c/" + string(provisioner.Name))
-			case TypeK8sSA:
-				return c.Load(K8sSAID)
-			default:
-				return c.Load(string(provisioner.CredentialID))
-			}
-		}
+	provisioner, err := getProvisionerExtension(cert)
+	switch {
+	case err != nil:
+		return nil, false
+	case provisioner == nil:
+		// Default to noop provisioner if an extension is not found. This allows
+		// to accept a renewal of a cert without the provisioner extension.
+		return &noop{}, true
 	}
 
-	// Default to noop provisioner if an extension is not found. This allows to
-	// accept a renewal of a cert without the provisioner extension.
-	return &noop{}, true
+	switch provisioner.Type {
+	case TypeJWK:
+		return c.Load(provisioner.Name + ":" + provisioner.CredentialID)
+	case TypeAWS:
+		return c.Load("aws/" + provisioner.Name)
+	case TypeGCP:
+		return c.Load("gcp/" + provisioner.Name)
+	case TypeACME:
+		return c.Load("acme/" + provisioner.Name)
+	case TypeX5C:
+		return c.Load("x5c/" + provisioner.Name)
+	case TypeK8sSA:
+		return c.Load(K8sSAID)
+	case TypeTPM:
+		return c.Load("tpm/" + provisioner.Name)
+	default:
+		return c.Load(provisioner.CredentialID)
+	}
 }
 
 // LoadEncryptedKey returns an encrypted key by indexed by KeyID. At this moment
 // only JWK encrypted keys are indexed by KeyID.
 func (c *Collection) LoadEncryptedKey(keyID string) (string, bool) {
+	c.mutex.RLock()
 	p, ok := loadProvisioner(c.byKey, keyID)
+	c.mutex.RUnlock()
 	if !ok {
 		return "", false
 	}
@@ -170,6 +176,9 @@ func (c *Collection) LoadEncryptedKey(keyID string) (string, bool) {
 // Store adds a provisioner to the collection and enforces the uniqueness of
 // provisioner IDs.
 func (c *Collection) Store(p Interface) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	// Store provisioner always in byID. ID must be unique.
 	if _, loaded := c.byID.LoadOrStore(p.GetID(), p); loaded {
 		return errors.New("cannot add multiple provisioners with the same id")
@@ -196,8 +205,32 @@ func (c *Collection) Store(p Interface) error {
 	return nil
 }
 
+// Reload initializes the given list of provisioners and replaces the ones in
+// the collection with them. If any of the provisioners fails to initialize,
+// or if there are duplicated ids, the collection is not modified and an error
+// is returned.
+func (c *Collection) Reload(list List, config Config) error {
+	n := NewCollection(c.audiences)
+	for _, p := range list {
+		if err := p.Init(config); err != nil {
+			return errors.Wrapf(err, "error initializing provisioner %s", p.GetName())
+		}
+		if err := n.Store(p); err != nil {
+			return errors.Wrapf(err, "error storing provisioner %s", p.GetName())
+		}
+	}
+
+	c.mutex.Lock()
+	c.byID, c.byKey, c.sorted = n.byID, n.byKey, n.sorted
+	c.mutex.Unlock()
+	return nil
+}
+
 // Find implements pagination on a list of sorted provisioners.
 func (c *Collection) Find(cursor string, limit int) (List, string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	switch {
 	case limit <= 0:
 		limit = DefaultProvisionersLimit
@@ -104,6 +104,10 @@ func TestCollection_LoadByToken(t *testing.T) {
 	assert.FatalError(t, err)
 	t5, c5, err := parseToken(token)
 	assert.FatalError(t, err)
+	token, err = generateK8sSAToken(jwk, getK8sSAProjectedPayload("https://kubernetes.default.svc", testAudiences.Sign[0]))
+	assert.FatalError(t, err)
+	t6, c6, err := parseToken(token)
+	assert.FatalError(t, err)
 
 	type fields struct {
 		byID      *sync.Map
@@ -124,9 +128,11 @@ func TestCollection_LoadByToken(t *testing.T) {
 		{"ok2", fields{byID, testAudiences}, args{t2, c2}, p2, true},
 		{"ok3", fields{byID, testAudiences}, args{t3, c3}, p3, true},
 		{"ok4", fields{byID, testAudiences}, args{t5, c5}, p4, true},
+		{"ok5", fields{byID, testAudiences}, args{t6, c6}, p4, true},
 		{"bad", fields{byID, testAudiences}, args{t4, c4}, nil, false},
 		{"fail", fields{byID, Audiences{Sign: []string{"https://foo"}}}, args{t1, c1}, nil, false},
 		{"fail-no-k8sSa-provisioner", fields{byID2, testAudiences}, args{t5, c5}, nil, false},
+		{"fail-no-k8sSa-provisioner-projected", fields{byID2, testAudiences}, args{t6, c6}, nil, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -292,6 +298,53 @@ func TestCollection_Store(t *testing.T) {
 	}
 }
 
+func TestCollection_Reload(t *testing.T) {
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	p2, err := generateJWK()
+	assert.FatalError(t, err)
+	p3, err := generateJWK()
+	assert.FatalError(t, err)
+	bad, err := generateJWK()
+	assert.FatalError(t, err)
+	bad.Name = ""
+
+	config := Config{Claims: globalProvisionerClaims, Audiences: testAudiences}
+
+	c := NewCollection(testAudiences)
+	assert.FatalError(t, c.Store(p1))
+
+	type args struct {
+		list List
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    List
+		wantErr bool
+	}{
+		{"ok", args{List{p2, p3}}, List{p2, p3}, false},
+		{"ok empty", args{List{}}, List{}, false},
+		{"ok again", args{List{p1, p2}}, List{p1, p2}, false},
+		{"fail init", args{List{p3, bad}}, List{p1, p2}, true},
+		{"fail duplicated", args{List{p3, p3}}, List{p1, p2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Reload(tt.args.list, config); (err != nil) != tt.wantErr {
+				t.Errorf("Collection.Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, _ := c.Find("", DefaultProvisionersMax)
+			assert.Len(t, len(tt.want), got)
+			for _, p := range tt.want {
+				v, ok := c.Load(p.GetID())
+				assert.True(t, ok)
+				assert.Equals(t, p, v)
+			}
+		})
+	}
+}
+
 func TestCollection_Find(t *testing.T) {
 	c, err := generateCollection(10, 10)
 	assert.FatalError(t, err)
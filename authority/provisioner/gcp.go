@@ -69,6 +69,9 @@ func newGCPConfig() *gcpConfig {
 // with the same instance will be accepted. By default only the first request
 // will be accepted.
 //
+// If ProjectIDs or Zones are set, only the instances in the given projects or
+// zones will be accepted.
+//
 // If InstanceAge is set, only the instances with an instance_creation_timestamp
 // within the given period will be accepted.
 //
@@ -76,20 +79,29 @@ func newGCPConfig() *gcpConfig {
 // https://cloud.google.com/compute/docs/instances/verifying-instance-identity
 type GCP struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	ServiceAccounts        []string `json:"serviceAccounts"`
-	ProjectIDs             []string `json:"projectIDs"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string           `json:"type"`
+	Name                   string           `json:"name"`
+	ServiceAccounts        []string         `json:"serviceAccounts"`
+	ProjectIDs             []string         `json:"projectIDs"`
+	Zones                  []string         `json:"zones,omitempty"`
+	DisableCustomSANs      bool             `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool             `json:"disableTrustOnFirstUse"`
+	InstanceAge            Duration         `json:"instanceAge,omitempty"`
+	NameConstraints        *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook                *Webhook         `json:"webhook,omitempty"`
+	Claims                 *Claims          `json:"claims,omitempty"`
 	claimer                *Claimer
 	config                 *gcpConfig
 	keyStore               *keyStore
 	audiences              Audiences
 }
 
+func init() {
+	Register(TypeGCP.String(), func() Interface {
+		return &GCP{}
+	})
+}
+
 // GetID returns the provisioner unique identifier. The name should uniquely
 // identify any GCP provisioner.
 func (p *GCP) GetID() string {
@@ -140,6 +152,13 @@ func (p *GCP) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *GCP) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
 // GetIdentityURL returns the url that generates the GCP token.
 func (p *GCP) GetIdentityURL(audience string) string {
 	// Initialize config if required
@@ -152,14 +171,24 @@ func (p *GCP) GetIdentityURL(audience string) string {
 	return fmt.Sprintf("%s?%s", p.config.IdentityURL, q.Encode())
 }
 
-// GetIdentityToken does an HTTP request to the identity url.
+// GetIdentityToken does an HTTP request to the identity url. The token is
+// cached and reused until shortly before its expiration.
 func (p *GCP) GetIdentityToken(subject, caURL string) (string, error) {
 	audience, err := generateSignAudience(caURL, p.GetID())
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("GET", p.GetIdentityURL(audience), http.NoBody)
+	identityURL := p.GetIdentityURL(audience)
+	key := identityTokenKey(p.GetID(), identityURL, subject)
+	return identityTokens.GetOrFetch(key, func() (string, error) {
+		return p.getIdentityToken(identityURL)
+	})
+}
+
+// getIdentityToken does an HTTP request to the given identity url.
+func (p *GCP) getIdentityToken(identityURL string) (string, error) {
+	req, err := http.NewRequest("GET", identityURL, http.NoBody)
 	if err != nil {
 		return "", errors.Wrap(err, "error creating identity request")
 	}
@@ -196,6 +225,18 @@ func (p *GCP) Init(config Config) error {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
 	// Initialize key store
 	p.keyStore, err = newKeyStore(p.config.CertsURL)
 	if err != nil {
@@ -209,7 +250,7 @@ func (p *GCP) Init(config Config) error {
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	claims, err := p.authorizeToken(token)
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSign")
 	}
@@ -218,7 +259,14 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 	// Enforce known common name and default DNS if configured.
 	// By default we we'll accept the CN and SANs in the CSR.
 	// There's no way to trust them other than TOFU.
-	var so []SignOption
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Name:       ce.InstanceName,
+		Credential: ce.ProjectID + "/" + ce.InstanceID,
+	}
+	so := []SignOption{
+		identity,
+	}
 	if p.DisableCustomSANs {
 		dnsName1 := fmt.Sprintf("%s.c.%s.internal", ce.InstanceName, ce.ProjectID)
 		dnsName2 := fmt.Sprintf("%s.%s.c.%s.internal", ce.InstanceName, ce.Zone, ce.ProjectID)
@@ -233,12 +281,22 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		so = append(so, urisValidator(nil))
 	}
 
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeGCP, p.Name, identity, claims))
+	}
+
 	return append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "InstanceID", ce.InstanceID, "InstanceName", ce.InstanceName),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(p.claimer),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
 	), nil
 }
@@ -261,7 +319,7 @@ func (p *GCP) assertConfig() {
 // authorizeToken performs common jwt authorization actions and returns the
 // claims for case specific downstream parsing.
 // e.g. a Sign request will auth/validate different fields than a Revoke request.
-func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
+func (p *GCP) authorizeToken(token string, audiences []string) (*gcpPayload, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "gcp.authorizeToken; error parsing gcp token")
@@ -290,12 +348,12 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: "https://accounts.google.com",
 		Time:   now,
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "gcp.authorizeToken; invalid gcp token payload")
 	}
 
 	// validate audiences with the defaults
-	if !matchesAudience(claims.Audience, p.audiences.Sign) {
+	if !matchesAudience(claims.Audience, audiences) {
 		return nil, errs.Unauthorized("gcp.authorizeToken; invalid gcp token - invalid audience claim (aud)")
 	}
 
@@ -327,6 +385,20 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 		}
 	}
 
+	// validate zones
+	if len(p.Zones) > 0 {
+		var found bool
+		for _, z := range p.Zones {
+			if z == claims.Google.ComputeEngine.Zone {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errs.Unauthorized("gcp.authorizeToken; invalid gcp token - invalid zone")
+		}
+	}
+
 	// validate instance age
 	if d := p.InstanceAge.Value(); d > 0 {
 		if now.Sub(claims.Google.ComputeEngine.InstanceCreationTimestamp.Time()) > d {
@@ -353,14 +425,20 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("gcp.AuthorizeSSHSign; sshCA is disabled for gcp provisioner %s", p.GetID())
 	}
-	claims, err := p.authorizeToken(token)
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSSHSign")
 	}
 
 	ce := claims.Google.ComputeEngine
 
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Name:       ce.InstanceName,
+		Credential: ce.ProjectID + "/" + ce.InstanceID,
+	}
 	signOptions := []SignOption{
+		identity,
 		// set the key id to the instance name
 		sshCertKeyIDModifier(ce.InstanceName),
 	}
@@ -384,6 +462,11 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeGCP, p.Name, identity, claims))
+	}
+
 	return append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
@@ -295,6 +295,22 @@ func TestGCP_authorizeToken(t *testing.T) {
 				err:   errors.New("gcp.authorizeToken; invalid gcp token - invalid project id"),
 			}
 		},
+		"fail/invalid-zone": func(t *testing.T) test {
+			p, err := generateGCP()
+			assert.FatalError(t, err)
+			p.Zones = []string{"us-central1-a", "us-central1-b"}
+			tok, err := generateGCPToken(p.ServiceAccounts[0],
+				"https://accounts.google.com", p.GetID(),
+				"instance-id", "instance-name", "project-id", "zone",
+				time.Now(), &p.keyStore.keySet.Keys[0])
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("gcp.authorizeToken; invalid gcp token - invalid zone"),
+			}
+		},
 		"fail/instance-age": func(t *testing.T) test {
 			p, err := generateGCP()
 			assert.FatalError(t, err)
@@ -388,7 +404,7 @@ func TestGCP_authorizeToken(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if claims, err := tc.p.authorizeToken(tc.token); err != nil {
+			if claims, err := tc.p.authorizeToken(tc.token, tc.p.audiences.Sign); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -424,6 +440,11 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 	p3.ServiceAccounts = []string{"foo@developer.gserviceaccount.com"}
 	p3.InstanceAge = Duration{1 * time.Minute}
 
+	p4, err := generateGCP()
+	assert.FatalError(t, err)
+	p4.ProjectIDs = []string{"other-project-id", "project-id"}
+	p4.Zones = []string{"other-zone", "zone"}
+
 	aKey, err := generateJSONWebKey()
 	assert.FatalError(t, err)
 
@@ -443,6 +464,12 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		time.Now(), &p3.keyStore.keySet.Keys[0])
 	assert.FatalError(t, err)
 
+	t4, err := generateGCPToken(p4.ServiceAccounts[0],
+		"https://accounts.google.com", p4.GetID(),
+		"instance-id", "instance-name", "project-id", "zone",
+		time.Now(), &p4.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
 	failKey, err := generateGCPToken(p1.ServiceAccounts[0],
 		"https://accounts.google.com", p1.GetID(),
 		"instance-id", "instance-name", "project-id", "zone",
@@ -483,6 +510,16 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		"instance-id", "instance-name", "other-project-id", "zone",
 		time.Now().Add(-1*time.Minute), &p3.keyStore.keySet.Keys[0])
 	assert.FatalError(t, err)
+	failInvalidProjectIDAllowList, err := generateGCPToken(p4.ServiceAccounts[0],
+		"https://accounts.google.com", p4.GetID(),
+		"instance-id", "instance-name", "another-project-id", "zone",
+		time.Now(), &p4.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+	failInvalidZone, err := generateGCPToken(p4.ServiceAccounts[0],
+		"https://accounts.google.com", p4.GetID(),
+		"instance-id", "instance-name", "project-id", "another-zone",
+		time.Now(), &p4.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
 	failInstanceID, err := generateGCPToken(p1.ServiceAccounts[0],
 		"https://accounts.google.com", p1.GetID(),
 		"", "instance-name", "project-id", "zone",
@@ -515,9 +552,10 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 9, http.StatusOK, false},
-		{"ok", p3, args{t3}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 5, http.StatusOK, false},
+		{"ok", p2, args{t2}, 10, http.StatusOK, false},
+		{"ok", p3, args{t3}, 5, http.StatusOK, false},
+		{"ok", p4, args{t4}, 5, http.StatusOK, false},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail key", p1, args{failKey}, 0, http.StatusUnauthorized, true},
 		{"fail iss", p1, args{failIss}, 0, http.StatusUnauthorized, true},
@@ -527,6 +565,8 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		{"fail service account", p1, args{failServiceAccount}, 0, http.StatusUnauthorized, true},
 		{"fail invalid project id", p3, args{failInvalidProjectID}, 0, http.StatusUnauthorized, true},
 		{"fail invalid instance age", p3, args{failInvalidInstanceAge}, 0, http.StatusUnauthorized, true},
+		{"fail invalid project id allow list", p4, args{failInvalidProjectIDAllowList}, 0, http.StatusUnauthorized, true},
+		{"fail invalid zone", p4, args{failInvalidZone}, 0, http.StatusUnauthorized, true},
 		{"fail instance id", p1, args{failInstanceID}, 0, http.StatusUnauthorized, true},
 		{"fail instance name", p1, args{failInstanceName}, 0, http.StatusUnauthorized, true},
 		{"fail project id", p1, args{failProjectID}, 0, http.StatusUnauthorized, true},
@@ -568,6 +608,9 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 						assert.Equals(t, v, nil)
 					case dnsNamesValidator:
 						assert.Equals(t, []string(v), []string{"instance-name.c.project-id.internal", "instance-name.zone.c.project-id.internal"})
+					case *AuthorizedIdentity:
+						assert.Equals(t, v.Name, "instance-name")
+						assert.HasSuffix(t, v.Credential, "project-id/instance-id")
 					default:
 						assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 					}
@@ -597,18 +640,26 @@ func TestGCP_AuthorizeSSHSign(t *testing.T) {
 	p3.claimer, err = NewClaimer(p3.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
-	t1, err := generateGCPToken(p1.ServiceAccounts[0],
-		"https://accounts.google.com", p1.GetID(),
+	t1, err := generateGCPTokenWithAudience(p1.ServiceAccounts[0],
+		"https://accounts.google.com", p1.audiences.SSHSign[0],
 		"instance-id", "instance-name", "project-id", "zone",
 		time.Now(), &p1.keyStore.keySet.Keys[0])
 	assert.FatalError(t, err)
 
-	t2, err := generateGCPToken(p2.ServiceAccounts[0],
-		"https://accounts.google.com", p2.GetID(),
+	t2, err := generateGCPTokenWithAudience(p2.ServiceAccounts[0],
+		"https://accounts.google.com", p2.audiences.SSHSign[0],
 		"instance-id", "instance-name", "project-id", "zone",
 		time.Now(), &p2.keyStore.keySet.Keys[0])
 	assert.FatalError(t, err)
 
+	// Tokens for the X.509 sign endpoint cannot be used to get an ssh
+	// certificate.
+	failAudience, err := generateGCPToken(p1.ServiceAccounts[0],
+		"https://accounts.google.com", p1.GetID(),
+		"instance-id", "instance-name", "project-id", "zone",
+		time.Now(), &p1.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
 	key, err := generateJSONWebKey()
 	assert.FatalError(t, err)
 
@@ -667,6 +718,7 @@ func TestGCP_AuthorizeSSHSign(t *testing.T) {
 		{"fail-extra-principal", p1, args{t1, SSHOptions{Principals: []string{"instance-name.c.project-id.internal", "instance-name.zone.c.project-id.internal", "smallstep.com"}}, pub}, nil, http.StatusOK, false, true},
 		{"fail-sshCA-disabled", p3, args{"foo", SSHOptions{}, pub}, expectedHostOptions, http.StatusUnauthorized, true, false},
 		{"fail-invalid-token", p1, args{"foo", SSHOptions{}, pub}, expectedHostOptions, http.StatusUnauthorized, true, false},
+		{"fail-sign-audience", p1, args{failAudience, SSHOptions{}, pub}, expectedHostOptions, http.StatusUnauthorized, true, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
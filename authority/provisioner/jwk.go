@@ -26,13 +26,21 @@ type stepPayload struct {
 // signature requests.
 type JWK struct {
 	*base
-	Type         string           `json:"type"`
-	Name         string           `json:"name"`
-	Key          *jose.JSONWebKey `json:"key"`
-	EncryptedKey string           `json:"encryptedKey,omitempty"`
-	Claims       *Claims          `json:"claims,omitempty"`
-	claimer      *Claimer
-	audiences    Audiences
+	Type            string           `json:"type"`
+	Name            string           `json:"name"`
+	Key             *jose.JSONWebKey `json:"key"`
+	EncryptedKey    string           `json:"encryptedKey,omitempty"`
+	NameConstraints *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook         *Webhook         `json:"webhook,omitempty"`
+	Claims          *Claims          `json:"claims,omitempty"`
+	claimer         *Claimer
+	audiences       Audiences
+}
+
+func init() {
+	Register(TypeJWK.String(), func() Interface {
+		return &JWK{}
+	})
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -74,6 +82,13 @@ func (p *JWK) GetEncryptedKey() (string, string, bool) {
 	return p.Key.KeyID, p.EncryptedKey, len(p.EncryptedKey) > 0
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *JWK) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
 // Init initializes and validates the fields of a JWK type.
 func (p *JWK) Init(config Config) (err error) {
 	switch {
@@ -90,6 +105,19 @@ func (p *JWK) Init(config Config) (err error) {
 		return err
 	}
 
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
 	p.audiences = config.Audiences
 	return err
 }
@@ -113,7 +141,7 @@ func (p *JWK) authorizeToken(token string, audiences []string) (*jwtPayload, err
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: p.Name,
 		Time:   time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "jwk.authorizeToken; invalid jwk claims")
 	}
 
@@ -151,16 +179,33 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		claims.SANs = []string{claims.Subject}
 	}
 
-	return []SignOption{
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: p.Key.KeyID,
+	}
+	so := []SignOption{
+		identity,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeJWK, p.Name, p.Key.KeyID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		commonNameValidator(claims.Subject),
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(p.claimer),
 		defaultSANsValidator(claims.SANs),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+	}
+
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeJWK, p.Name, identity, claims))
+	}
+
+	return so, nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -188,7 +233,12 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	}
 
 	opts := claims.Step.SSH
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: p.Key.KeyID,
+	}
 	signOptions := []SignOption{
+		identity,
 		// validates user's SSHOptions with the ones in the token
 		sshCertOptionsValidator(*opts),
 	}
@@ -217,6 +267,11 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Default to a user certificate with no principals if not set
 	signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
 
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeJWK, p.Name, identity, claims))
+	}
+
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
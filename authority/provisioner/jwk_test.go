@@ -80,11 +80,22 @@ func TestJWK_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-webhook": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, audiences: testAudiences, Webhook: &Webhook{URL: "http://webhook.smallstep.com"}},
+				err: errors.New("webhook url http://webhook.smallstep.com must use https, http is only allowed with a secret"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, audiences: testAudiences},
 			}
 		},
+		"ok-webhook": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, audiences: testAudiences, Webhook: &Webhook{URL: "https://webhook.smallstep.com"}},
+			}
+		},
 	}
 
 	config := Config{
@@ -295,7 +306,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.NotNil(t, got) {
-					assert.Len(t, 6, got)
+					assert.Len(t, 7, got)
 					for _, o := range got {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -313,6 +324,9 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
 						case defaultSANsValidator:
 							assert.Equals(t, []string(v), tt.sans)
+						case *AuthorizedIdentity:
+							assert.Equals(t, v.Subject, "subject")
+							assert.Equals(t, v.Credential, tt.prov.Key.KeyID)
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -467,6 +481,48 @@ func TestJWK_AuthorizeSSHSign(t *testing.T) {
 	}
 }
 
+func TestJWK_webhook(t *testing.T) {
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	p1.Webhook = &Webhook{URL: "https://webhook.smallstep.com"}
+	key1, err := decryptJSONWebKey(p1.EncryptedKey)
+	assert.FatalError(t, err)
+
+	t1, err := generateToken("subject", p1.Name, testAudiences.Sign[0], "name@smallstep.com", []string{"127.0.0.1"}, time.Now(), key1)
+	assert.FatalError(t, err)
+	t2, err := generateSimpleSSHUserToken(p1.Name, testAudiences.SSHSign[0], key1)
+	assert.FatalError(t, err)
+
+	ctx := NewContextWithMethod(context.Background(), SignMethod)
+	opts, err := p1.AuthorizeSign(ctx, t1)
+	assert.FatalError(t, err)
+	var found bool
+	for _, o := range opts {
+		if v, ok := o.(*webhookValidator); ok {
+			found = true
+			assert.Equals(t, v.webhook, p1.Webhook)
+			assert.Equals(t, v.provisionerType, TypeJWK)
+			assert.Equals(t, v.provisionerName, p1.Name)
+			assert.Equals(t, v.identity, &AuthorizedIdentity{Subject: "subject", Credential: p1.Key.KeyID})
+		}
+	}
+	assert.True(t, found, "AuthorizeSign options do not include the webhook")
+
+	ctx = NewContextWithMethod(context.Background(), SSHSignMethod)
+	opts, err = p1.AuthorizeSSHSign(ctx, t2)
+	assert.FatalError(t, err)
+	found = false
+	for _, o := range opts {
+		if v, ok := o.(*sshWebhookValidator); ok {
+			found = true
+			assert.Equals(t, v.webhook, p1.Webhook)
+			assert.Equals(t, v.provisionerType, TypeJWK)
+			assert.Equals(t, v.identity.Credential, p1.Key.KeyID)
+		}
+	}
+	assert.True(t, found, "AuthorizeSSHSign options do not include the webhook")
+}
+
 func TestJWK_AuthorizeSign_SSHOptions(t *testing.T) {
 	tm, fn := mockNow()
 	defer fn()
@@ -7,10 +7,12 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ed25519"
 )
@@ -25,29 +27,87 @@ const (
 	// K8sSAID is the default ID for kubernetes service account provisioners.
 	K8sSAID     = "k8ssa/" + K8sSAName
 	k8sSAIssuer = "kubernetes/serviceaccount"
+	// k8sSAUsernamePrefix is the prefix of the username of a service account,
+	// system:serviceaccount:<namespace>:<name>.
+	k8sSAUsernamePrefix = "system:serviceaccount:"
+	// k8sSAInitTimeout is the default time to wait for the JWK set in Init, a
+	// slow API server must not block the start of the CA.
+	k8sSAInitTimeout = 30 * time.Second
 )
 
 // jwtPayload extends jwt.Claims with step attributes.
 type k8sSAPayload struct {
 	jose.Claims
-	Namespace          string `json:"kubernetes.io/serviceaccount/namespace,omitempty"`
-	SecretName         string `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
-	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
-	ServiceAccountUID  string `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	Namespace          string                 `json:"kubernetes.io/serviceaccount/namespace,omitempty"`
+	SecretName         string                 `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
+	ServiceAccountName string                 `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
+	ServiceAccountUID  string                 `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	Kubernetes         *k8sSAKubernetesClaims `json:"kubernetes.io,omitempty"`
+}
+
+// k8sSAKubernetesClaims are the claims added by Kubernetes to the projected
+// service account tokens.
+type k8sSAKubernetesClaims struct {
+	Namespace      string                `json:"namespace"`
+	ServiceAccount k8sSAObjectReference  `json:"serviceaccount"`
+	Pod            *k8sSAObjectReference `json:"pod,omitempty"`
+}
+
+type k8sSAObjectReference struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+}
+
+// namespace returns the namespace of the service account in the legacy or in
+// the projected token.
+func (c *k8sSAPayload) namespace() string {
+	if c.Kubernetes != nil {
+		return c.Kubernetes.Namespace
+	}
+	return c.Namespace
+}
+
+// serviceAccountName returns the name of the service account in the legacy or
+// in the projected token.
+func (c *k8sSAPayload) serviceAccountName() string {
+	if c.Kubernetes != nil {
+		return c.Kubernetes.ServiceAccount.Name
+	}
+	return c.ServiceAccountName
+}
+
+// username returns the Kubernetes username of the service account,
+// system:serviceaccount:<namespace>:<name>.
+func (c *k8sSAPayload) username() string {
+	return k8sSAUsernamePrefix + c.namespace() + ":" + c.serviceAccountName()
 }
 
 // K8sSA represents a Kubernetes ServiceAccount provisioner; an
 // entity trusted to make signature requests.
 type K8sSA struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Claims    *Claims `json:"claims,omitempty"`
-	PubKeys   []byte  `json:"publicKeys,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
+	Type             string           `json:"type"`
+	Name             string           `json:"name"`
+	NameConstraints  *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook          *Webhook         `json:"webhook,omitempty"`
+	Claims           *Claims          `json:"claims,omitempty"`
+	PubKeys          []byte           `json:"publicKeys,omitempty"`
+	Issuer           string           `json:"issuer,omitempty"`
+	JWKSetURL        string           `json:"jwksURL,omitempty"`
+	Audience         string           `json:"audience,omitempty"`
+	ServiceAccountCN bool             `json:"serviceAccountCN,omitempty"`
+	claimer          *Claimer
+	audiences        Audiences
 	//kauthn    kauthn.AuthenticationV1Interface
-	pubKeys []interface{}
+	pubKeys     []interface{}
+	keyStore    *keyStore
+	initTimeout time.Duration
+}
+
+func init() {
+	Register(TypeK8sSA.String(), func() Interface {
+		return &K8sSA{}
+	})
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -77,6 +137,13 @@ func (p *K8sSA) GetEncryptedKey() (string, string, bool) {
 	return "", "", false
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *K8sSA) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
 // Init initializes and validates the fields of a K8sSA type.
 func (p *K8sSA) Init(config Config) (err error) {
 	switch {
@@ -86,6 +153,19 @@ func (p *K8sSA) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	}
 
+	if p.PubKeys == nil && p.JWKSetURL == "" {
+		// TODO: Use the TokenReview API if no pub keys provided. This will need to
+		// be configured with additional attributes in the K8sSA struct for
+		// connecting to the kubernetes API server.
+		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys or jwksURL")
+	}
+	if p.Audience != "" && p.Issuer == "" {
+		return errors.New("K8s Service Account provisioner audience requires an issuer")
+	}
+	if p.JWKSetURL != "" && p.Issuer == "" {
+		return errors.New("K8s Service Account provisioner jwksURL requires an issuer")
+	}
+
 	if p.PubKeys != nil {
 		var (
 			block *pem.Block
@@ -107,11 +187,19 @@ func (p *K8sSA) Init(config Config) (err error) {
 			}
 			p.pubKeys = append(p.pubKeys, key)
 		}
-	} else {
-		// TODO: Use the TokenReview API if no pub keys provided. This will need to
-		// be configured with additional attributes in the K8sSA struct for
-		// connecting to the kubernetes API server.
-		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys")
+	}
+	// The keys of projected tokens are published by the API server in
+	// /openid/v1/jwks.
+	if p.JWKSetURL != "" {
+		timeout := p.initTimeout
+		if timeout <= 0 {
+			timeout = k8sSAInitTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if p.keyStore, err = newKeyStoreWithContext(ctx, p.JWKSetURL); err != nil {
+			return errors.Wrapf(err, "error loading jwksURL in provisioner %s", p.GetID())
+		}
 	}
 	/*
 		// NOTE: Not sure if we should be doing this initialization here ...
@@ -130,6 +218,19 @@ func (p *K8sSA) Init(config Config) (err error) {
 		return err
 	}
 
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
 	p.audiences = config.Audiences
 	return err
 }
@@ -148,7 +249,7 @@ func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload,
 		valid  bool
 		claims k8sSAPayload
 	)
-	if p.pubKeys == nil {
+	if p.pubKeys == nil && p.keyStore == nil {
 		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented")
 		/* NOTE: We plan to support the TokenReview API in a future release.
 		         Below is some code that should be useful when we prioritize
@@ -176,20 +277,52 @@ func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload,
 			break
 		}
 	}
+	if !valid && p.keyStore != nil {
+		for _, key := range p.keyStore.Get(jwt.Headers[0].KeyID) {
+			if err = jwt.Claims(key.Public(), &claims); err == nil {
+				valid = true
+				break
+			}
+		}
+	}
 	if !valid {
 		return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token and extracting claims")
 	}
 
 	// According to "rfc7519 JSON Web Token" acceptable skew should be no
 	// more than a few minutes.
+	// Projected tokens use the issuer of the cluster.
+	issuer := k8sSAIssuer
+	projected := p.Issuer != "" && claims.Issuer == p.Issuer
+	if projected {
+		issuer = p.Issuer
+	}
 	if err = claims.Validate(jose.Expected{
-		Issuer: k8sSAIssuer,
+		Issuer: issuer,
 	}); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; invalid k8sSA token claims")
 	}
 
-	if claims.Subject == "" {
+	// Projected tokens are bound to an audience and expire.
+	if projected {
+		if p.Audience != "" {
+			audiences = []string{p.Audience}
+		}
+		if !matchesAudience(claims.Audience, audiences) {
+			return nil, errs.Unauthorized("k8ssa.authorizeToken; invalid k8sSA token audience claim (aud)")
+		}
+		if claims.Expiry == nil {
+			return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token expiration cannot be empty")
+		}
+	}
+
+	switch {
+	case claims.Subject == "":
 		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token subject cannot be empty")
+	case claims.namespace() == "" || claims.serviceAccountName() == "":
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token service account cannot be empty")
+	case claims.Subject != claims.username():
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token subject does not match the service account %s", claims.username())
 	}
 
 	return &claims, nil
@@ -202,20 +335,50 @@ func (p *K8sSA) AuthorizeRevoke(ctx context.Context, token string) error {
 	return errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeRevoke")
 }
 
+// identity returns the service account authorized by the token. The subject,
+// signed by the cluster, is also the credential of the request.
+func (c *k8sSAPayload) identity() *AuthorizedIdentity {
+	return &AuthorizedIdentity{
+		Subject:    c.Subject,
+		Name:       c.namespace() + "/" + c.serviceAccountName(),
+		Credential: c.Subject,
+	}
+}
+
 // AuthorizeSign validates the given token.
 func (p *K8sSA) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	if _, err := p.authorizeToken(token, p.audiences.Sign); err != nil {
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSign")
 	}
 
-	return []SignOption{
+	identity := claims.identity()
+	so := []SignOption{
+		identity,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeK8sSA, p.Name, ""),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(p.claimer),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+	}
+
+	// Set the common name to the service account if configured.
+	if p.ServiceAccountCN {
+		so = append(so, k8sSAIdentityModifier(claims.username()))
+	}
+
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeK8sSA, p.Name, identity, claims))
+	}
+
+	return so, nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -231,12 +394,22 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("k8ssa.AuthorizeSSHSign; sshCA is disabled for k8sSA provisioner %s", p.GetID())
 	}
-	if _, err := p.authorizeToken(token, p.audiences.SSHSign); err != nil {
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSSHSign")
 	}
 
-	// Default to a user certificate with no principals if not set
-	signOptions := []SignOption{sshCertDefaultsModifier{CertType: SSHUserCert}}
+	identity := claims.identity()
+	signOptions := []SignOption{
+		identity,
+		// Default to a user certificate with no principals if not set
+		sshCertDefaultsModifier{CertType: SSHUserCert},
+	}
+
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeK8sSA, p.Name, identity, claims))
+	}
 
 	return append(signOptions,
 		// Set the default extensions.
@@ -252,6 +425,18 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	), nil
 }
 
+// k8sSAIdentityModifier is a ProfileModifier that sets the subject common
+// name of the certificate to the username of the service account,
+// system:serviceaccount:<namespace>:<name>.
+type k8sSAIdentityModifier string
+
+func (m k8sSAIdentityModifier) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		p.Subject().Subject.CommonName = string(m)
+		return nil
+	}
+}
+
 /*
 func checkAccess(authz kauthz.AuthorizationV1Interface) error {
 	r := &kauthzApi.SelfSubjectAccessReview{
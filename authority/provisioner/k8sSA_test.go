@@ -3,13 +3,16 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
 )
 
@@ -33,7 +36,67 @@ func TestK8sSA_Getters(t *testing.T) {
 	}
 }
 
+func TestK8sSA_Init(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	// The slow server does not respond until the client cancels the request.
+	done := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer slow.Close()
+	defer close(done)
+
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+
+	tests := []struct {
+		name string
+		p    *K8sSA
+		err  error
+	}{
+		{"ok", &K8sSA{Type: "K8sSA", Name: K8sSAName, Issuer: "the-issuer", JWKSetURL: srv.URL + "/private"}, nil},
+		{"fail/no-keys", &K8sSA{Type: "K8sSA", Name: K8sSAName, Issuer: "the-issuer"},
+			errors.New("K8s Service Account provisioner cannot be initialized without pub keys or jwksURL")},
+		{"fail/audience-no-issuer", &K8sSA{Type: "K8sSA", Name: K8sSAName, JWKSetURL: srv.URL + "/private", Audience: "step-ca"},
+			errors.New("K8s Service Account provisioner audience requires an issuer")},
+		{"fail/jwks-no-issuer", &K8sSA{Type: "K8sSA", Name: K8sSAName, JWKSetURL: srv.URL + "/private"},
+			errors.New("K8s Service Account provisioner jwksURL requires an issuer")},
+		{"fail/jwks-timeout", &K8sSA{Type: "K8sSA", Name: K8sSAName, Issuer: "the-issuer", JWKSetURL: slow.URL, initTimeout: 100 * time.Millisecond},
+			errors.New("error loading jwksURL in provisioner " + K8sSAID)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := tt.p.Init(config)
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("K8sSA.Init() took %s, want less than 5s", d)
+			}
+			if err != nil {
+				if assert.NotNil(t, tt.err) {
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+			} else if assert.Nil(t, tt.err) {
+				assert.NotNil(t, tt.p.keyStore)
+				tt.p.keyStore.Close()
+			}
+		})
+	}
+}
+
 func TestK8sSA_authorizeToken(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	ks, err := newKeyStore(srv.URL + "/private")
+	assert.FatalError(t, err)
+	defer ks.Close()
+
 	type test struct {
 		p     *K8sSA
 		token string
@@ -98,6 +161,84 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 				err:   errors.New("k8ssa.authorizeToken; invalid k8sSA token claims: square/go-jose/jwt: validation failed, invalid issuer claim (iss)"),
 			}
 		},
+		"fail/subject-mismatch": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			claims := getK8sSAPayload()
+			claims.Claims.Subject = "system:serviceaccount:ns-bar:san-bar"
+			tok, err := generateK8sSAToken(jwk, claims)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("k8ssa.authorizeToken; k8sSA token subject does not match the service account system:serviceaccount:ns-foo:san-foo"),
+			}
+		},
+		"fail/empty-service-account": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			claims := getK8sSAPayload()
+			claims.ServiceAccountName = ""
+			tok, err := generateK8sSAToken(jwk, claims)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("k8ssa.authorizeToken; k8sSA token service account cannot be empty"),
+			}
+		},
+		"fail/projected-not-configured": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			tok, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload("https://kubernetes.default.svc", testAudiences.Sign[0]))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("k8ssa.authorizeToken; invalid k8sSA token claims: square/go-jose/jwt: validation failed, invalid issuer claim (iss)"),
+			}
+		},
+		"fail/projected-audience": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.Issuer = "https://kubernetes.default.svc"
+			tok, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload(p.Issuer, "https://kubernetes.default.svc"))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("k8ssa.authorizeToken; invalid k8sSA token audience claim (aud)"),
+			}
+		},
+		"fail/projected-expiry": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.Issuer = "https://kubernetes.default.svc"
+			claims := getK8sSAProjectedPayload(p.Issuer, testAudiences.Sign[0])
+			claims.Expiry = nil
+			tok, err := generateK8sSAToken(jwk, claims)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("k8ssa.authorizeToken; k8sSA token expiration cannot be empty"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 			assert.FatalError(t, err)
@@ -110,6 +251,46 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 				token: tok,
 			}
 		},
+		"ok/projected": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.Issuer = "https://kubernetes.default.svc"
+			tok, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload(p.Issuer, testAudiences.Sign[0]))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
+		"ok/projected-audience": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.Issuer = "https://kubernetes.default.svc"
+			p.Audience = "step-ca"
+			tok, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload(p.Issuer, "step-ca"))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
+		"ok/jwks": func(t *testing.T) test {
+			p, err := generateK8sSA(nil)
+			assert.FatalError(t, err)
+			p.Issuer = "https://kubernetes.default.svc"
+			p.pubKeys = nil
+			p.keyStore = ks
+			tok, err := generateK8sSAToken(&ks.keySet.Keys[0], getK8sSAProjectedPayload(p.Issuer, testAudiences.Sign[0]))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -257,6 +438,19 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 				token: tok,
 			}
 		},
+		"ok/service-account-cn": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			p, err := generateK8sSA(jwk.Public().Key)
+			assert.FatalError(t, err)
+			p.ServiceAccountCN = true
+			tok, err := generateK8sSAToken(jwk, nil)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -274,6 +468,12 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 						tot := 0
 						for _, o := range opts {
 							switch v := o.(type) {
+							case *AuthorizedIdentity:
+								assert.Equals(t, v.Subject, "system:serviceaccount:ns-foo:san-foo")
+								assert.Equals(t, v.Name, "ns-foo/san-foo")
+								assert.Equals(t, v.Credential, "system:serviceaccount:ns-foo:san-foo")
+							case k8sSAIdentityModifier:
+								assert.Equals(t, string(v), "system:serviceaccount:ns-foo:san-foo")
 							case *provisionerExtensionOption:
 								assert.Equals(t, v.Type, int(TypeK8sSA))
 								assert.Equals(t, v.Name, tc.p.GetName())
@@ -290,7 +490,11 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 4)
+						if tc.p.ServiceAccountCN {
+							assert.Equals(t, tot, 6)
+						} else {
+							assert.Equals(t, tot, 5)
+						}
 					}
 				}
 			}
@@ -360,6 +564,8 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 						tot := 0
 						for _, o := range opts {
 							switch v := o.(type) {
+							case *AuthorizedIdentity:
+								assert.Equals(t, v.Credential, "system:serviceaccount:ns-foo:san-foo")
 							case sshCertDefaultsModifier:
 								assert.Equals(t, v.CertType, SSHUserCert)
 							case *sshDefaultExtensionModifier:
@@ -374,10 +580,23 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 6)
+						assert.Equals(t, tot, 7)
 					}
 				}
 			}
 		})
 	}
 }
+
+func Test_k8sSAIdentityModifier_Option(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "foo.example.com"},
+		DNSNames: []string{"foo.example.com"},
+	}
+	prof := &x509util.Leaf{}
+	prof.SetSubject(cert)
+	m := k8sSAIdentityModifier("system:serviceaccount:ns-foo:san-foo")
+	assert.FatalError(t, m.Option(Options{})(prof))
+	assert.Equals(t, prof.Subject().Subject.CommonName, "system:serviceaccount:ns-foo:san-foo")
+	assert.Equals(t, prof.Subject().DNSNames, []string{"foo.example.com"})
+}
@@ -1,6 +1,7 @@
 package provisioner
 
 import (
+	"context"
 	"encoding/json"
 	"math/rand"
 	"net/http"
@@ -30,7 +31,14 @@ type keyStore struct {
 }
 
 func newKeyStore(uri string) (*keyStore, error) {
-	keys, age, err := getKeysFromJWKsURI(uri)
+	return newKeyStoreWithContext(context.Background(), uri)
+}
+
+// newKeyStoreWithContext is like newKeyStore, but the initial request of the
+// keys is canceled when the given context is done. Later reloads do not use
+// the context.
+func newKeyStoreWithContext(ctx context.Context, uri string) (*keyStore, error) {
+	keys, age, err := getKeysFromJWKsURI(ctx, uri)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +72,7 @@ func (ks *keyStore) Get(kid string) (keys []jose.JSONWebKey) {
 
 func (ks *keyStore) reload() {
 	var next time.Duration
-	keys, age, err := getKeysFromJWKsURI(ks.uri)
+	keys, age, err := getKeysFromJWKsURI(context.Background(), ks.uri)
 	if err != nil {
 		next = ks.nextReloadDuration(ks.jitter / 2)
 	} else {
@@ -90,9 +98,13 @@ func (ks *keyStore) nextReloadDuration(age time.Duration) time.Duration {
 	return abs(age)
 }
 
-func getKeysFromJWKsURI(uri string) (jose.JSONWebKeySet, time.Duration, error) {
+func getKeysFromJWKsURI(ctx context.Context, uri string) (jose.JSONWebKeySet, time.Duration, error) {
 	var keys jose.JSONWebKeySet
-	resp, err := http.Get(uri)
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, http.NoBody)
+	if err != nil {
+		return keys, 0, errors.Wrapf(err, "failed to connect to %s", uri)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return keys, 0, errors.Wrapf(err, "failed to connect to %s", uri)
 	}
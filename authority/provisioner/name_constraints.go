@@ -0,0 +1,198 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// NameConstraints are the constraints that the subject alternative names of a
+// certificate request must satisfy to be signed by a provisioner. They work
+// like the X.509 name constraints extension, but they are applied at issuance
+// time and they are not added to the certificate.
+//
+// A name that matches an excluded constraint is always rejected. If there are
+// permitted constraints for a type of name, the names of that type must match
+// at least one of them. Types without constraints are not restricted.
+//
+// DNS and email domains match the domain itself and all its subdomains, a
+// domain starting with a dot, like ".example.com", only matches the
+// subdomains. IP ranges use the CIDR notation, and URIs are matched by scheme.
+//
+// If ForbidWildcards is set, wildcard DNS names like "*.example.com" are
+// rejected in the subject alternative names and in the common name.
+type NameConstraints struct {
+	PermittedDNSDomains   []string `json:"permittedDNSDomains,omitempty"`
+	ExcludedDNSDomains    []string `json:"excludedDNSDomains,omitempty"`
+	PermittedIPRanges     []string `json:"permittedIPRanges,omitempty"`
+	ExcludedIPRanges      []string `json:"excludedIPRanges,omitempty"`
+	PermittedEmailDomains []string `json:"permittedEmailDomains,omitempty"`
+	ExcludedEmailDomains  []string `json:"excludedEmailDomains,omitempty"`
+	PermittedURISchemes   []string `json:"permittedURISchemes,omitempty"`
+	ExcludedURISchemes    []string `json:"excludedURISchemes,omitempty"`
+	ForbidWildcards       bool     `json:"forbidWildcards,omitempty"`
+	permittedIPNets       []*net.IPNet
+	excludedIPNets        []*net.IPNet
+}
+
+// Validate validates and initializes the name constraints.
+func (c *NameConstraints) Validate() (err error) {
+	for _, s := range append(append([]string{}, c.PermittedDNSDomains...), c.ExcludedDNSDomains...) {
+		if strings.Trim(s, ".") == "" {
+			return errors.Errorf("name constraints dns domain '%s' is not valid", s)
+		}
+	}
+	for _, s := range append(append([]string{}, c.PermittedEmailDomains...), c.ExcludedEmailDomains...) {
+		if strings.Trim(s, ".") == "" || strings.Contains(s, "@") {
+			return errors.Errorf("name constraints email domain '%s' is not valid", s)
+		}
+	}
+	for _, s := range append(append([]string{}, c.PermittedURISchemes...), c.ExcludedURISchemes...) {
+		if s == "" {
+			return errors.New("name constraints uri scheme cannot be empty")
+		}
+	}
+	if c.permittedIPNets, err = parseIPRanges(c.PermittedIPRanges); err != nil {
+		return err
+	}
+	if c.excludedIPNets, err = parseIPRanges(c.ExcludedIPRanges); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseIPRanges(ranges []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range ranges {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Errorf("name constraints ip range '%s' is not valid", s)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// matchDomain returns true if name is the given domain or one of its
+// subdomains. If domain starts with a dot only the subdomains will match.
+func matchDomain(name, domain string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if strings.HasPrefix(domain, ".") {
+		return strings.HasSuffix(name, domain)
+	}
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+func matchAny(name string, constraints []string, match func(name, constraint string) bool) bool {
+	for _, c := range constraints {
+		if match(name, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConstraints returns an error if name matches one of the excluded
+// constraints or if there are permitted constraints and none of them matches.
+func checkConstraints(typ, name string, permitted, excluded []string, match func(name, constraint string) bool) error {
+	if matchAny(name, excluded, match) {
+		return errs.Forbidden("certificate request %s '%s' is excluded by the provisioner name constraints", typ, name)
+	}
+	if len(permitted) > 0 && !matchAny(name, permitted, match) {
+		return errs.Forbidden("certificate request %s '%s' is not permitted by the provisioner name constraints", typ, name)
+	}
+	return nil
+}
+
+// nameConstraintsValidator validates the SANs of a certificate request using
+// the provisioner name constraints.
+type nameConstraintsValidator struct {
+	*NameConstraints
+}
+
+func newNameConstraintsValidator(c *NameConstraints) nameConstraintsValidator {
+	return nameConstraintsValidator{c}
+}
+
+// Valid checks that all the DNS names, IP addresses, email addresses and URIs
+// in the certificate request satisfy the name constraints.
+func (v nameConstraintsValidator) Valid(req *x509.CertificateRequest) error {
+	if v.ForbidWildcards {
+		if err := wildcardsValidator(req); err != nil {
+			return err
+		}
+	}
+	for _, name := range req.DNSNames {
+		if err := checkConstraints("dns name", name, v.PermittedDNSDomains, v.ExcludedDNSDomains, matchDomain); err != nil {
+			return err
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if matchIPNets(ip, v.excludedIPNets) {
+			return errs.Forbidden("certificate request ip address '%s' is excluded by the provisioner name constraints", ip)
+		}
+		if len(v.permittedIPNets) > 0 && !matchIPNets(ip, v.permittedIPNets) {
+			return errs.Forbidden("certificate request ip address '%s' is not permitted by the provisioner name constraints", ip)
+		}
+	}
+	for _, email := range req.EmailAddresses {
+		if err := checkConstraints("email address", email, v.PermittedEmailDomains, v.ExcludedEmailDomains, matchEmailDomain); err != nil {
+			return err
+		}
+	}
+	for _, u := range req.URIs {
+		if err := checkConstraints("uri", u.String(), v.PermittedURISchemes, v.ExcludedURISchemes, matchURIScheme); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wildcardsValidator rejects the certificate requests with a wildcard DNS name
+// or common name.
+func wildcardsValidator(req *x509.CertificateRequest) error {
+	if isWildcard(req.Subject.CommonName) {
+		return errs.Forbidden("certificate request common name '%s' is a wildcard forbidden by the provisioner name constraints", req.Subject.CommonName)
+	}
+	for _, name := range req.DNSNames {
+		if isWildcard(name) {
+			return errs.Forbidden("certificate request dns name '%s' is a wildcard forbidden by the provisioner name constraints", name)
+		}
+	}
+	return nil
+}
+
+// isWildcard returns true if the name is a wildcard DNS name, like
+// "*.example.com".
+func isWildcard(name string) bool {
+	return strings.HasPrefix(name, "*")
+}
+
+func matchIPNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchEmailDomain(email, domain string) bool {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	return matchDomain(email[i+1:], domain)
+}
+
+func matchURIScheme(uri, scheme string) bool {
+	i := strings.Index(uri, ":")
+	if i < 0 {
+		return false
+	}
+	return strings.EqualFold(uri[:i], scheme)
+}
@@ -0,0 +1,144 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestNameConstraints_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints *NameConstraints
+		wantErr     bool
+	}{
+		{"ok empty", &NameConstraints{}, false},
+		{"ok", &NameConstraints{
+			PermittedDNSDomains:   []string{"smallstep.com", ".internal"},
+			ExcludedDNSDomains:    []string{"admin.smallstep.com"},
+			PermittedIPRanges:     []string{"10.0.0.0/8", "2001:db8::/32"},
+			ExcludedIPRanges:      []string{"10.0.0.0/24"},
+			PermittedEmailDomains: []string{"smallstep.com"},
+			ExcludedEmailDomains:  []string{".smallstep.com"},
+			PermittedURISchemes:   []string{"spiffe"},
+			ExcludedURISchemes:    []string{"http"},
+		}, false},
+		{"fail dns", &NameConstraints{PermittedDNSDomains: []string{"."}}, true},
+		{"fail excluded dns", &NameConstraints{ExcludedDNSDomains: []string{""}}, true},
+		{"fail ip", &NameConstraints{PermittedIPRanges: []string{"10.0.0.1"}}, true},
+		{"fail excluded ip", &NameConstraints{ExcludedIPRanges: []string{"10.0.0.0/33"}}, true},
+		{"fail email", &NameConstraints{PermittedEmailDomains: []string{"max@smallstep.com"}}, true},
+		{"fail excluded email", &NameConstraints{ExcludedEmailDomains: []string{""}}, true},
+		{"fail uri", &NameConstraints{PermittedURISchemes: []string{""}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.constraints.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("NameConstraints.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_nameConstraintsValidator_Valid(t *testing.T) {
+	permitted := &NameConstraints{
+		PermittedDNSDomains:   []string{"smallstep.com", ".internal"},
+		ExcludedDNSDomains:    []string{"admin.smallstep.com"},
+		PermittedIPRanges:     []string{"10.0.0.0/8"},
+		ExcludedIPRanges:      []string{"10.0.0.0/24"},
+		PermittedEmailDomains: []string{"smallstep.com"},
+		PermittedURISchemes:   []string{"spiffe"},
+	}
+	if err := permitted.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	excluded := &NameConstraints{
+		ExcludedDNSDomains:   []string{".smallstep.com"},
+		ExcludedIPRanges:     []string{"127.0.0.0/8"},
+		ExcludedEmailDomains: []string{"smallstep.com"},
+		ExcludedURISchemes:   []string{"http", "https"},
+	}
+	if err := excluded.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	wildcards := &NameConstraints{
+		PermittedDNSDomains: []string{"smallstep.com"},
+		ForbidWildcards:     true,
+	}
+	if err := wildcards.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	parseURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	tests := []struct {
+		name        string
+		constraints *NameConstraints
+		req         *x509.CertificateRequest
+		wantErr     bool
+	}{
+		{"ok empty", permitted, &x509.CertificateRequest{}, false},
+		{"ok permitted", permitted, &x509.CertificateRequest{
+			DNSNames:       []string{"smallstep.com", "ca.smallstep.com", "CA.SmallStep.COM.", "foo.internal"},
+			IPAddresses:    []net.IP{net.ParseIP("10.1.0.1")},
+			EmailAddresses: []string{"max@smallstep.com"},
+			URIs:           []*url.URL{parseURL("spiffe://smallstep.com/ca")},
+		}, false},
+		{"ok excluded", excluded, &x509.CertificateRequest{
+			DNSNames:       []string{"smallstep.com", "example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("::1")},
+			EmailAddresses: []string{"max@example.com"},
+			URIs:           []*url.URL{parseURL("spiffe://smallstep.com/ca")},
+		}, false},
+		{"ok wildcards", permitted, &x509.CertificateRequest{DNSNames: []string{"*.smallstep.com"}}, false},
+		{"ok forbid wildcards", wildcards, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "ca.smallstep.com"},
+			DNSNames: []string{"smallstep.com", "ca.smallstep.com"},
+		}, false},
+		{"fail dns not permitted", permitted, &x509.CertificateRequest{DNSNames: []string{"example.com"}}, true},
+		{"fail dns suffix", permitted, &x509.CertificateRequest{DNSNames: []string{"notsmallstep.com"}}, true},
+		{"fail dns subdomains only", permitted, &x509.CertificateRequest{DNSNames: []string{"internal"}}, true},
+		{"fail dns excluded", permitted, &x509.CertificateRequest{DNSNames: []string{"ca.admin.smallstep.com"}}, true},
+		{"fail dns excluded subdomain", excluded, &x509.CertificateRequest{DNSNames: []string{"ca.smallstep.com"}}, true},
+		{"fail ip not permitted", permitted, &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}}, true},
+		{"fail ip excluded", permitted, &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}, true},
+		{"fail ip excluded loopback", excluded, &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}, true},
+		{"fail email not permitted", permitted, &x509.CertificateRequest{EmailAddresses: []string{"max@example.com"}}, true},
+		{"fail email excluded", excluded, &x509.CertificateRequest{EmailAddresses: []string{"max@smallstep.com"}}, true},
+		{"fail uri not permitted", permitted, &x509.CertificateRequest{URIs: []*url.URL{parseURL("https://smallstep.com")}}, true},
+		{"fail dns wildcard", wildcards, &x509.CertificateRequest{DNSNames: []string{"ca.smallstep.com", "*.smallstep.com"}}, true},
+		{"fail dns wildcard label", wildcards, &x509.CertificateRequest{DNSNames: []string{"*ca.smallstep.com"}}, true},
+		{"fail common name wildcard", wildcards, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "*.smallstep.com"}}, true},
+		{"fail uri excluded", excluded, &x509.CertificateRequest{URIs: []*url.URL{parseURL("HTTPS://smallstep.com")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newNameConstraintsValidator(tt.constraints).Valid(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("nameConstraintsValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				if !ok {
+					t.Fatalf("nameConstraintsValidator.Valid() error %T does not implement errs.StatusCoder", err)
+				}
+				if sc.StatusCode() != http.StatusForbidden {
+					t.Errorf("nameConstraintsValidator.Valid() status code = %d, want %d", sc.StatusCode(), http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,8 +20,9 @@ import (
 // openIDConfiguration contains the necessary properties in the
 // `/.well-known/openid-configuration` document.
 type openIDConfiguration struct {
-	Issuer    string `json:"issuer"`
-	JWKSetURI string `json:"jwks_uri"`
+	Issuer            string   `json:"issuer"`
+	JWKSetURI         string   `json:"jwks_uri"`
+	SigningAlgorithms []string `json:"id_token_signing_alg_values_supported,omitempty"`
 }
 
 // Validate validates the values in a well-known OpenID configuration endpoint.
@@ -35,6 +37,20 @@ func (c openIDConfiguration) Validate() error {
 	}
 }
 
+// supportsAlgorithm returns true if the given signing algorithm is advertised
+// in the configuration or if the configuration does not advertise any.
+func (c openIDConfiguration) supportsAlgorithm(alg string) bool {
+	if len(c.SigningAlgorithms) == 0 {
+		return true
+	}
+	for _, a := range c.SigningAlgorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
 // openIDPayload represents the fields on the id_token JWT payload.
 type openIDPayload struct {
 	jose.Claims
@@ -50,19 +66,28 @@ type openIDPayload struct {
 // OIDC represents an OAuth 2.0 OpenID Connect provider.
 //
 // ClientSecret is mandatory, but it can be an empty string.
+//
+// If RequiredACR is set, only tokens with an acr claim that satisfies it will
+// be accepted. If both values are numeric, the acr claim must be greater or
+// equal than RequiredACR, otherwise they must be equal.
 type OIDC struct {
 	*base
-	Type                  string   `json:"type"`
-	Name                  string   `json:"name"`
-	ClientID              string   `json:"clientID"`
-	ClientSecret          string   `json:"clientSecret"`
-	ConfigurationEndpoint string   `json:"configurationEndpoint"`
-	TenantID              string   `json:"tenantID,omitempty"`
-	Admins                []string `json:"admins,omitempty"`
-	Domains               []string `json:"domains,omitempty"`
-	Groups                []string `json:"groups,omitempty"`
-	ListenAddress         string   `json:"listenAddress,omitempty"`
-	Claims                *Claims  `json:"claims,omitempty"`
+	Type                  string           `json:"type"`
+	Name                  string           `json:"name"`
+	ClientID              string           `json:"clientID"`
+	ClientSecret          string           `json:"clientSecret"`
+	ConfigurationEndpoint string           `json:"configurationEndpoint"`
+	TenantID              string           `json:"tenantID,omitempty"`
+	Admins                []string         `json:"admins,omitempty"`
+	Domains               []string         `json:"domains,omitempty"`
+	Groups                []string         `json:"groups,omitempty"`
+	GroupsClaim           string           `json:"groupsClaim,omitempty"`
+	GroupsToOU            bool             `json:"groupsToOU,omitempty"`
+	RequiredACR           string           `json:"requiredACR,omitempty"`
+	ListenAddress         string           `json:"listenAddress,omitempty"`
+	NameConstraints       *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook               *Webhook         `json:"webhook,omitempty"`
+	Claims                *Claims          `json:"claims,omitempty"`
 	configuration         openIDConfiguration
 	keyStore              *keyStore
 	claimer               *Claimer
@@ -88,6 +113,12 @@ func sanitizeEmail(email string) string {
 	return email
 }
 
+func init() {
+	Register(TypeOIDC.String(), func() Interface {
+		return &OIDC{}
+	})
+}
+
 // GetID returns the provisioner unique identifier, the OIDC provisioner the
 // uses the clientID for this.
 func (o *OIDC) GetID() string {
@@ -128,6 +159,13 @@ func (o *OIDC) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (o *OIDC) GetRateLimit() *RateLimit {
+	return o.claimer.RateLimit()
+}
+
 // Init validates and initializes the OIDC provider.
 func (o *OIDC) Init(config Config) (err error) {
 	switch {
@@ -153,6 +191,19 @@ func (o *OIDC) Init(config Config) (err error) {
 		return err
 	}
 
+	if o.NameConstraints != nil {
+		if err := o.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the webhook if configured
+	if o.Webhook != nil {
+		if err := o.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
 	// Decode and validate openid-configuration endpoint
 	u, err := url.Parse(o.ConfigurationEndpoint)
 	if err != nil {
@@ -194,7 +245,7 @@ func (o *OIDC) ValidatePayload(p openIDPayload) error {
 		Issuer:   o.configuration.Issuer,
 		Audience: jose.Audience{o.ClientID},
 		Time:     time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, o.claimer.ClockSkew()); err != nil {
 		return errs.Wrap(http.StatusUnauthorized, err, "validatePayload: failed to validate oidc token payload")
 	}
 
@@ -271,6 +322,23 @@ func (o *OIDC) authorizeToken(token string) (*openIDPayload, error) {
 		return nil, errs.Unauthorized("oidc.AuthorizeToken; cannot validate oidc token")
 	}
 
+	// Read the groups from a custom claim and the acr claim, that can be a
+	// string or a number. The signature has already been verified at this
+	// point.
+	if o.GroupsClaim != "" || o.RequiredACR != "" {
+		var raw map[string]interface{}
+		if err := jwt.UnsafeClaimsWithoutVerification(&raw); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err,
+				"oidc.AuthorizeToken; error parsing oidc token claims")
+		}
+		if o.GroupsClaim != "" {
+			claims.Groups = groupsFromClaim(raw[o.GroupsClaim])
+		}
+		if o.RequiredACR != "" && !acrSatisfies(acrFromClaim(raw["acr"]), o.RequiredACR) {
+			return nil, errs.Unauthorized("oidc.AuthorizeToken; oidc token validation failed - insufficient authentication context claim (acr)")
+		}
+	}
+
 	if err := o.ValidatePayload(claims); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeToken")
 	}
@@ -301,14 +369,30 @@ func (o *OIDC) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSign")
 	}
 
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Email,
+		Credential: claims.Subject,
+	}
 	so := []SignOption{
+		identity,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeOIDC, o.Name, o.ClientID),
 		profileDefaultDuration(o.claimer.DefaultTLSCertDuration()),
 		// validators
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(o.claimer),
 		newValidityValidator(o.claimer.MinTLSCertDuration(), o.claimer.MaxTLSCertDuration()),
 	}
+	if o.GroupsToOU {
+		so = append(so, groupsToOUEnforcer(claims.Groups))
+	}
+	// Restrict the SANs if name constraints are configured.
+	if o.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(o.NameConstraints))
+	}
+	// Ask the webhook to approve the request if configured.
+	if o.Webhook != nil {
+		so = append(so, newWebhookValidator(o.Webhook, TypeOIDC, o.Name, identity, claims))
+	}
 	// Admins should be able to authorize any SAN
 	if o.IsAdmin(claims.Email) {
 		return so, nil
@@ -337,7 +421,12 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
 	}
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Email,
+		Credential: claims.Subject,
+	}
 	signOptions := []SignOption{
+		identity,
 		// set the key id to the token email
 		sshCertKeyIDModifier(claims.Email),
 	}
@@ -364,6 +453,11 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	// are not set.
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
+	// Ask the webhook to approve the certificate if configured.
+	if o.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(o.Webhook, TypeOIDC, o.Name, identity, claims))
+	}
+
 	return append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
@@ -392,8 +486,80 @@ func (o *OIDC) AuthorizeSSHRevoke(ctx context.Context, token string) error {
 	return nil
 }
 
+// groupsFromClaim returns the groups in a claim that can be a list of strings
+// or a single string.
+func groupsFromClaim(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
+// acrFromClaim returns the authentication context class reference in a claim
+// that can be a string or a number.
+func acrFromClaim(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// acrSatisfies returns true if the given authentication context class
+// reference satisfies the required one. Numeric values are compared as
+// levels, any other value must be equal to the required one.
+func acrSatisfies(acr, required string) bool {
+	if acr == "" {
+		return false
+	}
+	a, errA := strconv.Atoi(acr)
+	r, errR := strconv.Atoi(required)
+	if errA == nil && errR == nil {
+		return a >= r
+	}
+	return acr == required
+}
+
+// groupsToOUEnforcer is a CertificateEnforcer that replaces the organizational
+// units in the subject of the certificate with the groups in the token.
+type groupsToOUEnforcer []string
+
+// Enforce sets the groups as the organizational units of the certificate, the
+// organizational units in the request are always discarded.
+func (e groupsToOUEnforcer) Enforce(cert *x509.Certificate) error {
+	if len(e) == 0 {
+		cert.Subject.OrganizationalUnit = nil
+		return nil
+	}
+	cert.Subject.OrganizationalUnit = append([]string{}, e...)
+	return nil
+}
+
 func getAndDecode(uri string, v interface{}) error {
-	resp, err := http.Get(uri)
+	return getAndDecodeWithContext(context.Background(), uri, v)
+}
+
+// getAndDecodeWithContext is like getAndDecode, but the request is canceled
+// when the given context is done.
+func getAndDecodeWithContext(ctx context.Context, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, http.NoBody)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %s", uri)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return errors.Wrapf(err, "failed to connect to %s", uri)
 	}
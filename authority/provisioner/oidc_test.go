@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"net/http"
 	"strings"
@@ -46,6 +47,31 @@ func Test_openIDConfiguration_Validate(t *testing.T) {
 	}
 }
 
+func Test_openIDConfiguration_supportsAlgorithm(t *testing.T) {
+	tests := []struct {
+		name              string
+		signingAlgorithms []string
+		alg               string
+		want              bool
+	}{
+		{"ok", []string{"RS256", "PS256"}, "PS256", true},
+		{"ok not advertised", nil, "ES256", true},
+		{"fail", []string{"RS256"}, "ES256", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := openIDConfiguration{
+				Issuer:            "the-issuer",
+				JWKSetURI:         "the-jwks-uri",
+				SigningAlgorithms: tt.signingAlgorithms,
+			}
+			if got := c.supportsAlgorithm(tt.alg); got != tt.want {
+				t.Errorf("openIDConfiguration.supportsAlgorithm() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestOIDC_Getters(t *testing.T) {
 	p, err := generateOIDC()
 	assert.FatalError(t, err)
@@ -323,9 +349,9 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.NotNil(t, got) {
 					if tt.name == "admin" {
-						assert.Len(t, 4, got)
-					} else {
 						assert.Len(t, 5, got)
+					} else {
+						assert.Len(t, 6, got)
 					}
 					for _, o := range got {
 						switch v := o.(type) {
@@ -342,6 +368,8 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
 						case emailOnlyIdentity:
 							assert.Equals(t, string(v), "name@smallstep.com")
+						case *AuthorizedIdentity:
+							assert.Equals(t, v.Credential, "subject")
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -352,6 +380,119 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 	}
 }
 
+func TestOIDC_authorizeToken_requiredACR(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	config := Config{Claims: globalProvisionerClaims}
+	newProvisioner := func(requiredACR string) *OIDC {
+		p, err := generateOIDC()
+		assert.FatalError(t, err)
+		p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+		p.RequiredACR = requiredACR
+		assert.FatalError(t, p.Init(config))
+		return p
+	}
+	p1 := newProvisioner("")
+	p2 := newProvisioner("2")
+	p3 := newProvisioner("urn:mace:incommon:iap:silver")
+
+	tests := []struct {
+		name    string
+		prov    *OIDC
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{"ok not required", p1, nil, false},
+		{"ok equal", p2, map[string]interface{}{"acr": "2"}, false},
+		{"ok greater", p2, map[string]interface{}{"acr": "3"}, false},
+		{"ok number", p2, map[string]interface{}{"acr": 2}, false},
+		{"ok uri", p3, map[string]interface{}{"acr": "urn:mace:incommon:iap:silver"}, false},
+		{"fail lower", p2, map[string]interface{}{"acr": "1"}, true},
+		{"fail lower number", p2, map[string]interface{}{"acr": 1}, true},
+		{"fail missing", p2, nil, true},
+		{"fail uri", p3, map[string]interface{}{"acr": "urn:mace:incommon:iap:bronze"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := generateTokenWithClaims("the-issuer", tt.prov.ClientID, tt.claims, &keys.Keys[0])
+			assert.FatalError(t, err)
+			_, err = tt.prov.authorizeToken(tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDC_AuthorizeSign_groupsToOU(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	config := Config{Claims: globalProvisionerClaims}
+	newProvisioner := func(groupsClaim string, groups []string) *OIDC {
+		p, err := generateOIDC()
+		assert.FatalError(t, err)
+		p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+		p.GroupsClaim = groupsClaim
+		p.GroupsToOU = true
+		p.Groups = groups
+		assert.FatalError(t, p.Init(config))
+		return p
+	}
+	p1 := newProvisioner("", nil)
+	p2 := newProvisioner("roles", nil)
+	p3 := newProvisioner("roles", []string{"ops"})
+	p4 := newProvisioner("role", nil)
+
+	tests := []struct {
+		name    string
+		prov    *OIDC
+		claims  map[string]interface{}
+		want    []string
+		wantErr bool
+	}{
+		{"ok groups", p1, map[string]interface{}{"groups": []string{"dev", "ops"}}, []string{"dev", "ops"}, false},
+		{"ok no groups", p1, nil, nil, false},
+		{"ok custom claim", p2, map[string]interface{}{"groups": []string{"other"}, "roles": []string{"dev", "ops"}}, []string{"dev", "ops"}, false},
+		{"ok custom claim filter", p3, map[string]interface{}{"roles": []string{"ops"}}, []string{"ops"}, false},
+		{"ok custom string claim", p4, map[string]interface{}{"role": "admin"}, []string{"admin"}, false},
+		{"fail custom claim filter", p3, map[string]interface{}{"groups": []string{"ops"}, "roles": []string{"dev"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := generateTokenWithClaims("the-issuer", tt.prov.ClientID, tt.claims, &keys.Keys[0])
+			assert.FatalError(t, err)
+			got, err := tt.prov.AuthorizeSign(context.Background(), tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var found bool
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "foo", OrganizationalUnit: []string{"requested"}}}
+			for _, o := range got {
+				if v, ok := o.(groupsToOUEnforcer); ok {
+					found = true
+					assert.FatalError(t, v.Enforce(cert))
+				}
+			}
+			assert.True(t, found)
+			assert.Equals(t, tt.want, cert.Subject.OrganizationalUnit)
+			assert.Equals(t, "foo", cert.Subject.CommonName)
+		})
+	}
+}
+
 func TestOIDC_AuthorizeRevoke(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
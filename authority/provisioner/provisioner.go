@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/db"
@@ -31,6 +32,15 @@ type Interface interface {
 	AuthorizeSSHRekey(ctx context.Context, token string) (*ssh.Certificate, []SignOption, error)
 }
 
+// MethodTokenIDGetter is an optional interface implemented by the provisioners
+// whose token identifier depends on the method authorized, e.g. an Azure
+// provisioner with trust on first use enabled only for X.509 certificates. If
+// a provisioner implements it, the authority uses GetTokenIDForMethod instead
+// of GetTokenID to prevent the reuse of tokens.
+type MethodTokenIDGetter interface {
+	GetTokenIDForMethod(m Method, token string) (string, error)
+}
+
 // Audiences stores all supported audiences by request type.
 type Audiences struct {
 	Sign      []string
@@ -141,6 +151,8 @@ const (
 	TypeK8sSA Type = 8
 	// TypeSSHPOP is used to indicate the SSHPOP provisioners.
 	TypeSSHPOP Type = 9
+	// TypeTPM is used to indicate the TPM provisioners.
+	TypeTPM Type = 10
 )
 
 // String returns the string representation of the type.
@@ -164,6 +176,8 @@ func (t Type) String() string {
 		return "K8sSA"
 	case TypeSSHPOP:
 		return "SSHPOP"
+	case TypeTPM:
+		return "TPM"
 	default:
 		return ""
 	}
@@ -212,27 +226,8 @@ func (l *List) UnmarshalJSON(data []byte) error {
 		if err := json.Unmarshal(data, &typ); err != nil {
 			return errors.Errorf("error unmarshaling provisioner")
 		}
-		var p Interface
-		switch strings.ToLower(typ.Type) {
-		case "jwk":
-			p = &JWK{}
-		case "oidc":
-			p = &OIDC{}
-		case "gcp":
-			p = &GCP{}
-		case "aws":
-			p = &AWS{}
-		case "azure":
-			p = &Azure{}
-		case "acme":
-			p = &ACME{}
-		case "x5c":
-			p = &X5C{}
-		case "k8ssa":
-			p = &K8sSA{}
-		case "sshpop":
-			p = &SSHPOP{}
-		default:
+		newFunc, ok := LoadNewFunc(typ.Type)
+		if !ok {
 			// Skip unsupported provisioners. A client using this method may be
 			// compiled with a version of smallstep/certificates that does not
 			// support a specific provisioner type. If we don't skip unknown
@@ -244,9 +239,14 @@ func (l *List) UnmarshalJSON(data []byte) error {
 			// step/certificates and recompile.
 			continue
 		}
+		p := newFunc()
 		if err := json.Unmarshal(data, p); err != nil {
 			return errors.Errorf("error unmarshaling provisioner")
 		}
+		// Registered types must match the type of the provisioner they create.
+		if t := p.GetType().String(); t != "" && !strings.EqualFold(t, typ.Type) {
+			return errors.Errorf("error unmarshaling provisioner: type %s does not match %s", typ.Type, t)
+		}
 		*l = append(*l, p)
 	}
 
@@ -332,6 +332,51 @@ type Identity struct {
 // GetIdentityFunc is a function that returns an identity.
 type GetIdentityFunc func(ctx context.Context, p Interface, email string) (*Identity, error)
 
+// AuditEvent is the structured event generated every time a provisioner
+// authorizes or denies a signing request.
+type AuditEvent struct {
+	Time            time.Time
+	ProvisionerID   string
+	ProvisionerName string
+	ProvisionerType Type
+	Subject         string
+	Authorized      bool
+	Error           error
+	// Identity is the identity authorized by the provisioner if the
+	// provisioner returns one, e.g. the Azure virtual machine.
+	Identity *AuthorizedIdentity
+}
+
+// AuditFunc is a function that receives the audit events. It must not block
+// as it is called synchronously on every request.
+type AuditFunc func(ctx context.Context, event *AuditEvent)
+
+// IssuanceEvent is the structured event generated every time a certificate is
+// issued. Renewed and rekeyed certificates are not authorized by a token, so
+// their events do not have a token subject.
+type IssuanceEvent struct {
+	Time            time.Time `json:"time"`
+	ProvisionerID   string    `json:"provisionerID,omitempty"`
+	ProvisionerName string    `json:"provisionerName,omitempty"`
+	ProvisionerType string    `json:"provisionerType,omitempty"`
+	TokenSubject    string    `json:"tokenSubject,omitempty"`
+	// CertificateType is "x509" or "ssh".
+	CertificateType string    `json:"certificateType"`
+	SerialNumber    string    `json:"serialNumber"`
+	Subject         string    `json:"subject"`
+	SANs            []string  `json:"sans,omitempty"`
+	NotBefore       time.Time `json:"notBefore"`
+	NotAfter        time.Time `json:"notAfter"`
+	// Identity is the identity authorized by the provisioner if the
+	// provisioner returns one, e.g. the Azure virtual machine.
+	Identity *AuthorizedIdentity `json:"identity,omitempty"`
+}
+
+// IssuanceFunc is a function that receives the issuance events, e.g. to write
+// them to an external audit sink. It must not block as it is called
+// synchronously after a certificate is signed and stored.
+type IssuanceFunc func(ctx context.Context, event *IssuanceEvent)
+
 // DefaultIdentityFunc return a default identity depending on the provisioner type.
 func DefaultIdentityFunc(ctx context.Context, p Interface, email string) (*Identity, error) {
 	switch k := p.(type) {
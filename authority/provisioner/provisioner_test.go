@@ -22,6 +22,7 @@ func TestType_String(t *testing.T) {
 		{"AWS", TypeAWS, "AWS"},
 		{"Azure", TypeAzure, "Azure"},
 		{"GCP", TypeGCP, "GCP"},
+		{"TPM", TypeTPM, "TPM"},
 		{"noop", noopType, ""},
 		{"notFound", 1000, ""},
 	}
@@ -171,6 +172,10 @@ func TestUnimplementedMethods(t *testing.T) {
 		{"k8ssa/sshRekey", &K8sSA{}, SSHRekeyMethod},
 		{"k8ssa/sshRenew", &K8sSA{}, SSHRenewMethod},
 		{"k8ssa/sshRevoke", &K8sSA{}, SSHRevokeMethod},
+		{"tpm/revoke", &TPM{}, RevokeMethod},
+		{"tpm/sshRenew", &TPM{}, SSHRenewMethod},
+		{"tpm/sshRekey", &TPM{}, SSHRekeyMethod},
+		{"tpm/sshRevoke", &TPM{}, SSHRevokeMethod},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,136 @@
+package provisioner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/smallstep/certificates/errs"
+)
+
+// RateLimit is the maximum number of certificates that a provisioner can sign
+// with the same credential in a window of time, e.g. 10 certificates every
+// hour. It limits the certificates that can be minted with a leaked credential.
+type RateLimit struct {
+	Count  int       `json:"count"`
+	Window *Duration `json:"window"`
+}
+
+// RateLimitGetter is an optional interface implemented by the provisioners
+// that support the RateLimit claim. The authority uses it to limit the
+// certificates signed by the provisioner.
+type RateLimitGetter interface {
+	GetRateLimit() *RateLimit
+}
+
+// rateLimitSweepInterval is the minimum time between two sweeps of the empty
+// windows of a RateLimiter.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter keeps track of the certificates signed by each provisioner for
+// each credential. The zero value is ready to use.
+type RateLimiter struct {
+	sync.Mutex
+	windows map[string]*rateLimitWindow
+	swept   time.Time
+}
+
+// RateLimitReservation is a certificate recorded by RateLimiter.Allow. It is
+// used to give back that certificate with RateLimiter.Refund.
+type RateLimitReservation struct {
+	key    string
+	issued time.Time
+}
+
+type rateLimitWindow struct {
+	window time.Duration
+	issued []*RateLimitReservation
+}
+
+// prune removes the times older than the window, it returns false if the
+// window is empty.
+func (w *rateLimitWindow) prune(now time.Time) bool {
+	i := 0
+	for i < len(w.issued) && now.Sub(w.issued[i].issued) >= w.window {
+		i++
+	}
+	w.issued = w.issued[i:]
+	return len(w.issued) > 0
+}
+
+func rateLimitKey(id, credential string) string {
+	return id + "\x00" + credential
+}
+
+// sweep removes the empty windows to keep the map small. It only walks the map
+// once every rateLimitSweepInterval.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimitSweepInterval {
+		return
+	}
+	for k, w := range l.windows {
+		if !w.prune(now) {
+			delete(l.windows, k)
+		}
+	}
+	l.swept = now
+}
+
+// Allow records a new certificate signed by the provisioner with the given id
+// for the given verified credential. It returns a 429 error without recording
+// it if the provisioner already signed limit.Count certificates with the
+// credential in the last limit.Window. A nil limit always allows it and returns
+// a nil reservation. If the certificate is not signed after all, the caller
+// must give it back with Refund.
+func (l *RateLimiter) Allow(id, credential string, limit *RateLimit) (*RateLimitReservation, error) {
+	if limit == nil || limit.Window == nil {
+		return nil, nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	if l.windows == nil {
+		l.windows = make(map[string]*rateLimitWindow)
+	}
+	l.sweep(now)
+
+	key := rateLimitKey(id, credential)
+	w, ok := l.windows[key]
+	if !ok {
+		w = &rateLimitWindow{}
+		l.windows[key] = w
+	}
+	w.window = limit.Window.Duration
+	w.prune(now)
+	if len(w.issued) >= limit.Count {
+		return nil, errs.TooManyRequests("provisioner.RateLimiter; rate limit of %d certificates every %s exceeded for provisioner '%s'",
+			limit.Count, limit.Window.Duration, id)
+	}
+	r := &RateLimitReservation{key: key, issued: now}
+	w.issued = append(w.issued, r)
+	return r, nil
+}
+
+// Refund removes the certificate recorded by Allow with the given reservation,
+// it is used when the signing of an allowed certificate fails. Nil or already
+// expired reservations are ignored.
+func (l *RateLimiter) Refund(r *RateLimitReservation) {
+	if r == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	w, ok := l.windows[r.key]
+	if !ok {
+		return
+	}
+	for i, v := range w.issued {
+		if v == r {
+			w.issued = append(w.issued[:i], w.issued[i+1:]...)
+			break
+		}
+	}
+	if len(w.issued) == 0 {
+		delete(l.windows, r.key)
+	}
+}
@@ -0,0 +1,105 @@
+package provisioner
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	limit := &RateLimit{Count: 2, Window: &Duration{time.Hour}}
+
+	var l RateLimiter
+	allow := func(id, credential string, limit *RateLimit, wantErr bool) {
+		t.Helper()
+		r, err := l.Allow(id, credential, limit)
+		if (err != nil) != wantErr {
+			t.Fatalf("RateLimiter.Allow() error = %v, wantErr %v", err, wantErr)
+		}
+		if err != nil {
+			sc, ok := err.(errs.StatusCoder)
+			if !ok || sc.StatusCode() != http.StatusTooManyRequests {
+				t.Errorf("RateLimiter.Allow() error = %v, want a 429 error", err)
+			}
+		}
+		if wantLimit := limit != nil && !wantErr; (r != nil) != wantLimit {
+			t.Errorf("RateLimiter.Allow() reservation = %v, want reservation %v", r, wantLimit)
+		}
+	}
+
+	// No limit.
+	for i := 0; i < 10; i++ {
+		allow("p1", "foo", nil, false)
+	}
+
+	allow("p1", "foo", limit, false)
+	allow("p1", "foo", limit, false)
+	allow("p1", "foo", limit, true)
+	allow("p1", "foo", limit, true)
+
+	// Other credentials and provisioners have their own count.
+	allow("p1", "bar", limit, false)
+	allow("p2", "foo", limit, false)
+
+	// Certificates signed before the window do not count.
+	l.windows["p1\x00foo"].issued[0].issued = time.Now().Add(-2 * time.Hour)
+	allow("p1", "foo", limit, false)
+	allow("p1", "foo", limit, true)
+
+	// Empty windows are removed on the next sweep.
+	for _, w := range l.windows {
+		for i := range w.issued {
+			w.issued[i].issued = time.Now().Add(-2 * time.Hour)
+		}
+	}
+	allow("p3", "foo", limit, false)
+	if len(l.windows) != 4 {
+		t.Errorf("RateLimiter windows = %d, want 4", len(l.windows))
+	}
+	l.swept = time.Now().Add(-rateLimitSweepInterval)
+	allow("p3", "foo", limit, false)
+	if len(l.windows) != 1 {
+		t.Errorf("RateLimiter windows = %d, want 1", len(l.windows))
+	}
+}
+
+func TestRateLimiter_Refund(t *testing.T) {
+	limit := &RateLimit{Count: 2, Window: &Duration{time.Hour}}
+
+	var l RateLimiter
+	// Nil reservations are ignored.
+	l.Refund(nil)
+
+	r1, err := l.Allow("p1", "foo", limit)
+	if err != nil {
+		t.Fatalf("RateLimiter.Allow() error = %v", err)
+	}
+	r2, err := l.Allow("p1", "foo", limit)
+	if err != nil {
+		t.Fatalf("RateLimiter.Allow() error = %v", err)
+	}
+	if _, err := l.Allow("p1", "foo", limit); err == nil {
+		t.Fatal("RateLimiter.Allow() error = nil, want a 429 error")
+	}
+
+	// Refund removes exactly the given certificate, not the last one.
+	l.Refund(r1)
+	if w := l.windows["p1\x00foo"]; len(w.issued) != 1 || w.issued[0] != r2 {
+		t.Errorf("RateLimiter issued = %v, want [%v]", w.issued, r2)
+	}
+	// Refunding it again is a no-op.
+	l.Refund(r1)
+	if w := l.windows["p1\x00foo"]; len(w.issued) != 1 {
+		t.Errorf("RateLimiter issued = %d, want 1", len(w.issued))
+	}
+
+	l.Refund(r2)
+	if len(l.windows) != 0 {
+		t.Errorf("RateLimiter windows = %d, want 0", len(l.windows))
+	}
+	if _, err := l.Allow("p1", "foo", limit); err != nil {
+		t.Errorf("RateLimiter.Allow() error = %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package provisioner
+
+import (
+	"strings"
+	"sync"
+)
+
+var registry = new(sync.Map)
+
+// NewFunc is the type that represents the method to create a new empty
+// provisioner that will be populated unmarshaling its JSON representation.
+type NewFunc func() Interface
+
+// Register adds to the registry a method to create a provisioner of the given
+// type. The type is the value of the "type" property in the JSON
+// representation of the provisioner, and it's case insensitive.
+func Register(typ string, fn NewFunc) {
+	registry.Store(strings.ToLower(typ), fn)
+}
+
+// LoadNewFunc returns the function used to create a provisioner of the given
+// type.
+func LoadNewFunc(typ string) (NewFunc, bool) {
+	v, ok := registry.Load(strings.ToLower(typ))
+	if !ok {
+		return nil, false
+	}
+	fn, ok := v.(NewFunc)
+	return fn, ok
+}
@@ -0,0 +1,90 @@
+package provisioner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadNewFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  string
+		want Type
+		ok   bool
+	}{
+		{"jwk", "JWK", TypeJWK, true},
+		{"oidc", "oidc", TypeOIDC, true},
+		{"gcp", "GCP", TypeGCP, true},
+		{"aws", "aws", TypeAWS, true},
+		{"azure", "Azure", TypeAzure, true},
+		{"acme", "ACME", TypeACME, true},
+		{"x5c", "X5C", TypeX5C, true},
+		{"k8sSA", "k8ssa", TypeK8sSA, true},
+		{"sshpop", "SSHPOP", TypeSSHPOP, true},
+		{"tpm", "TPM", TypeTPM, true},
+		{"fail", "foo", noopType, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, ok := LoadNewFunc(tt.typ)
+			if ok != tt.ok {
+				t.Fatalf("LoadNewFunc() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			p := fn()
+			if got := p.GetType(); got != tt.want {
+				t.Errorf("LoadNewFunc().GetType() = %v, want %v", got, tt.want)
+			}
+			if !strings.EqualFold(p.GetType().String(), tt.typ) {
+				t.Errorf("LoadNewFunc().GetType().String() = %v, want %v", p.GetType().String(), tt.typ)
+			}
+		})
+	}
+}
+
+func TestList_UnmarshalJSON(t *testing.T) {
+	Register("test-provisioner", func() Interface {
+		return &MockProvisioner{
+			MgetID:   func() string { return "test-provisioner" },
+			MgetType: func() Type { return noopType },
+		}
+	})
+	Register("test-mismatch", func() Interface {
+		return &ACME{}
+	})
+
+	tests := []struct {
+		name    string
+		data    string
+		wantIDs []string
+		wantErr bool
+	}{
+		{"ok", `[{"type":"ACME","name":"foo"},{"type":"test-provisioner"}]`, []string{"acme/foo", "test-provisioner"}, false},
+		{"ok unknown", `[{"type":"unknown"},{"type":"test-provisioner"}]`, []string{"test-provisioner"}, false},
+		{"ok empty", `[]`, []string{}, false},
+		{"fail type", `[{"type":"test-mismatch","name":"foo"}]`, nil, true},
+		{"fail json", `{"type":"ACME"}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l List
+			if err := l.UnmarshalJSON([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Errorf("List.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(l) != len(tt.wantIDs) {
+				t.Fatalf("List.UnmarshalJSON() len = %d, want %d", len(l), len(tt.wantIDs))
+			}
+			for i, p := range l {
+				if got := p.GetID(); got != tt.wantIDs[i] {
+					t.Errorf("List.UnmarshalJSON() [%d].GetID() = %v, want %v", i, got, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"fmt"
 	"net"
 	"net/url"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/fips"
 	"github.com/smallstep/cli/crypto/x509util"
 	"golang.org/x/crypto/ed25519"
 )
@@ -55,6 +58,59 @@ type CertificateEnforcer interface {
 	Enforce(cert *x509.Certificate) error
 }
 
+// AuthorizedIdentity is a SignOption that contains the identity authorized by
+// a provisioner. It does not modify nor validate the certificate, it can be
+// used by the callers of AuthorizeSign to audit who requested a certificate.
+//
+// Credential identifies the verified credential used to authorize the request,
+// e.g. the key id of a JWK, the fingerprint of a X5C leaf certificate or the id
+// of a cloud instance. Unlike the subject of the token, it cannot be chosen by
+// the requester, so the authority uses it to enforce the rate limits.
+type AuthorizedIdentity struct {
+	Subject       string
+	TenantID      string
+	ResourceGroup string
+	Name          string
+	VMID          string
+	Credential    string
+}
+
+// GetAuthorizedIdentity returns the AuthorizedIdentity in the given list of
+// sign options if present.
+func GetAuthorizedIdentity(opts []SignOption) (*AuthorizedIdentity, bool) {
+	for _, op := range opts {
+		if v, ok := op.(*AuthorizedIdentity); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// AuthorizedRequest is a SignOption added by the authority to the options
+// returned by AuthorizeSign and AuthorizeSSHSign with the provisioner and the
+// subject of the token that authorized the request. Like AuthorizedIdentity, it
+// does not modify nor validate the certificate, it is used to audit the issued
+// certificates and to refund the rate limit reservation if the certificate is
+// not signed.
+type AuthorizedRequest struct {
+	ProvisionerID   string
+	ProvisionerName string
+	ProvisionerType Type
+	TokenSubject    string
+	RateLimit       *RateLimitReservation
+}
+
+// GetAuthorizedRequest returns the AuthorizedRequest in the given list of sign
+// options if present.
+func GetAuthorizedRequest(opts []SignOption) (*AuthorizedRequest, bool) {
+	for _, op := range opts {
+		if v, ok := op.(*AuthorizedRequest); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
 // profileWithOption is a wrapper against x509util.WithOption to conform the
 // interface.
 type profileWithOption x509util.WithOption
@@ -88,21 +144,50 @@ func (e emailOnlyIdentity) Valid(req *x509.CertificateRequest) error {
 	}
 }
 
+// defaultMinRSAKeySize is the minimum size in bits of the RSA keys accepted by
+// defaultPublicKeyValidator.
+const defaultMinRSAKeySize = 2048
+
 // defaultPublicKeyValidator validates the public key of a certificate request.
-type defaultPublicKeyValidator struct{}
+// RSA keys must have at least minRSABits, or defaultMinRSAKeySize if it is not
+// set, and ECDSA keys must use a curve of at least minECDSABits.
+type defaultPublicKeyValidator struct {
+	minRSABits   int
+	minECDSABits int
+}
+
+// newDefaultPublicKeyValidator returns a defaultPublicKeyValidator with the
+// minimum key sizes in the given claims.
+func newDefaultPublicKeyValidator(c *Claimer) defaultPublicKeyValidator {
+	return defaultPublicKeyValidator{
+		minRSABits:   c.MinRSAKeySize(),
+		minECDSABits: c.MinECDSAKeySize(),
+	}
+}
 
 // Valid checks that certificate request common name matches the one configured.
 func (v defaultPublicKeyValidator) Valid(req *x509.CertificateRequest) error {
 	switch k := req.PublicKey.(type) {
 	case *rsa.PublicKey:
-		if k.Size() < 256 {
-			return errors.New("rsa key in CSR must be at least 2048 bits (256 bytes)")
+		min := v.minRSABits
+		if min == 0 {
+			min = defaultMinRSAKeySize
+		}
+		if k.Size() < min/8 {
+			return errors.Errorf("rsa key in CSR must be at least %d bits (%d bytes), got %d bits", min, min/8, k.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if size := k.Curve.Params().BitSize; size < v.minECDSABits {
+			return errors.Errorf("ecdsa key in CSR must use a curve of at least %d bits, got %s", v.minECDSABits, k.Curve.Params().Name)
 		}
-	case *ecdsa.PublicKey, ed25519.PublicKey:
+	case ed25519.PublicKey:
 	default:
 		return errors.Errorf("unrecognized public key of type '%T' in CSR", k)
 	}
-	return nil
+	if err := fips.CheckPublicKey(req.PublicKey); err != nil {
+		return err
+	}
+	return fips.CheckSignatureAlgorithm(req.SignatureAlgorithm)
 }
 
 // commonNameValidator validates the common name of a certificate request.
@@ -236,6 +321,273 @@ func (v defaultSANsValidator) Valid(req *x509.CertificateRequest) (err error) {
 	return
 }
 
+// extKeyUsageNames maps the names used in the provisioner configuration with
+// the x509 extended key usages.
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// parseExtKeyUsages returns the x509 extended key usages for the given names,
+// e.g. serverAuth or clientAuth.
+func parseExtKeyUsages(names []string) ([]x509.ExtKeyUsage, error) {
+	var ekus []x509.ExtKeyUsage
+	for _, name := range names {
+		eku, ok := extKeyUsageNames[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.Errorf("unsupported extended key usage '%s'", name)
+		}
+		ekus = append(ekus, eku)
+	}
+	return ekus, nil
+}
+
+// extKeyUsageModifier is a ProfileModifier that sets the extended key usages
+// of the certificate to the configured ones.
+type extKeyUsageModifier []x509.ExtKeyUsage
+
+func (v extKeyUsageModifier) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.ExtKeyUsage = append([]x509.ExtKeyUsage(nil), v...)
+		return nil
+	}
+}
+
+// extKeyUsageValidator validates the extended key usages of a certificate.
+type extKeyUsageValidator []x509.ExtKeyUsage
+
+// Valid checks that all the extended key usages in the certificate are in the
+// list of allowed ones.
+func (v extKeyUsageValidator) Valid(cert *x509.Certificate, o Options) error {
+	if len(cert.UnknownExtKeyUsage) > 0 {
+		return errors.Errorf("certificate extended key usage %v is not allowed", cert.UnknownExtKeyUsage)
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		var found bool
+		for _, allowed := range v {
+			if eku == allowed || allowed == x509.ExtKeyUsageAny {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("certificate extended key usage %s is not allowed", extKeyUsageName(eku))
+		}
+	}
+	return nil
+}
+
+// extKeyUsageName returns the configuration name of an extended key usage.
+func extKeyUsageName(eku x509.ExtKeyUsage) string {
+	switch eku {
+	case x509.ExtKeyUsageAny:
+		return "any"
+	case x509.ExtKeyUsageServerAuth:
+		return "serverAuth"
+	case x509.ExtKeyUsageClientAuth:
+		return "clientAuth"
+	case x509.ExtKeyUsageCodeSigning:
+		return "codeSigning"
+	case x509.ExtKeyUsageEmailProtection:
+		return "emailProtection"
+	case x509.ExtKeyUsageTimeStamping:
+		return "timeStamping"
+	case x509.ExtKeyUsageOCSPSigning:
+		return "ocspSigning"
+	default:
+		return fmt.Sprintf("unknown(%d)", eku)
+	}
+}
+
+// publicKeyType is an allowed type of public key. For ECDSA keys size is the
+// size of the curve, for RSA keys it is the minimum size of the key in bits.
+// A size of 0 allows any size.
+type publicKeyType struct {
+	Type string
+	Size int
+}
+
+// publicKeyTypeNames maps the names used in the provisioner configuration with
+// the public key types.
+var publicKeyTypeNames = map[string]publicKeyType{
+	"ecdsa":      {"ECDSA", 0},
+	"ecdsa-p256": {"ECDSA", 256},
+	"ecdsa-p384": {"ECDSA", 384},
+	"ecdsa-p521": {"ECDSA", 521},
+	"rsa":        {"RSA", 0},
+	"rsa-2048":   {"RSA", 2048},
+	"rsa-3072":   {"RSA", 3072},
+	"rsa-4096":   {"RSA", 4096},
+	"ed25519":    {"Ed25519", 0},
+}
+
+// parsePublicKeyTypes returns the public key types for the given names, e.g.
+// ECDSA, ECDSA-P256, RSA-3072 or Ed25519.
+func parsePublicKeyTypes(names []string) ([]publicKeyType, error) {
+	var types []publicKeyType
+	for _, name := range names {
+		typ, ok := publicKeyTypeNames[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.Errorf("unsupported public key type '%s'", name)
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
+// publicKeyTypeValidator validates the type and size of the public key of a
+// certificate request.
+type publicKeyTypeValidator []publicKeyType
+
+// Valid checks that the public key in the certificate request is one of the
+// allowed types.
+func (v publicKeyTypeValidator) Valid(req *x509.CertificateRequest) error {
+	var typ string
+	var size int
+	switch k := req.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		typ, size = "ECDSA", k.Curve.Params().BitSize
+	case *rsa.PublicKey:
+		typ, size = "RSA", k.N.BitLen()
+	case ed25519.PublicKey:
+		typ = "Ed25519"
+	default:
+		return errors.Errorf("unrecognized public key of type '%T' in CSR", k)
+	}
+	for _, allowed := range v {
+		if allowed.Type != typ {
+			continue
+		}
+		switch {
+		case allowed.Size == 0:
+			return nil
+		case typ == "RSA" && size >= allowed.Size:
+			return nil
+		case size == allowed.Size:
+			return nil
+		}
+	}
+	if size > 0 {
+		return errors.Errorf("public key of type %s with %d bits is not allowed", typ, size)
+	}
+	return errors.Errorf("public key of type %s is not allowed", typ)
+}
+
+// publicKeyTypeName returns the name of the type of the given public key,
+// "ECDSA", "RSA" or "Ed25519", or an empty string if it is not supported.
+func publicKeyTypeName(pub interface{}) string {
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA"
+	case *rsa.PublicKey:
+		return "RSA"
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return ""
+	}
+}
+
+// KeyTypeOptions are the default sign options of the certificate requests with
+// a public key of a given type.
+type KeyTypeOptions struct {
+	// MinRSAKeySize is the minimum size in bits of the RSA keys, it cannot be
+	// lower than the default of 2048 bits. It can only be used with RSA keys.
+	MinRSAKeySize int `json:"minRSAKeySize,omitempty"`
+	// ExtKeyUsages are the extended key usages of the certificates, e.g.
+	// ["serverAuth"].
+	ExtKeyUsages []string `json:"extKeyUsages,omitempty"`
+	extKeyUsages []x509.ExtKeyUsage
+}
+
+// keyTypeOptions maps the public key types, "ECDSA", "RSA" or "Ed25519", with
+// their default sign options.
+type keyTypeOptions map[string]*KeyTypeOptions
+
+// parseKeyTypeOptions validates the given options and returns them by the
+// name of the public key type. The names of the types are case insensitive.
+// If allowed is not empty, the extended key usages must be in it.
+func parseKeyTypeOptions(opts map[string]*KeyTypeOptions, allowed []x509.ExtKeyUsage) (keyTypeOptions, error) {
+	ret := make(keyTypeOptions)
+	for name, o := range opts {
+		typ, ok := publicKeyTypeNames[strings.ToLower(name)]
+		if !ok || typ.Size != 0 {
+			return nil, errors.Errorf("unsupported public key type '%s' in keyTypeOptions; options are ECDSA, RSA or Ed25519", name)
+		}
+		if _, ok := ret[typ.Type]; ok {
+			return nil, errors.Errorf("public key type '%s' is duplicated in keyTypeOptions", name)
+		}
+		if o == nil {
+			o = &KeyTypeOptions{}
+		}
+		switch {
+		case o.MinRSAKeySize != 0 && typ.Type != "RSA":
+			return nil, errors.Errorf("keyTypeOptions '%s' cannot set minRSAKeySize; it can only be used with RSA keys", name)
+		case o.MinRSAKeySize < 0 || (o.MinRSAKeySize != 0 && o.MinRSAKeySize < defaultMinRSAKeySize):
+			return nil, errors.Errorf("keyTypeOptions '%s' minRSAKeySize must be at least %d", name, defaultMinRSAKeySize)
+		}
+		ekus, err := parseExtKeyUsages(o.ExtKeyUsages)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing keyTypeOptions '%s'", name)
+		}
+		if len(allowed) > 0 {
+			if err := extKeyUsageValidator(allowed).Valid(&x509.Certificate{ExtKeyUsage: ekus}, Options{}); err != nil {
+				return nil, errors.Wrapf(err, "error validating keyTypeOptions '%s'", name)
+			}
+		}
+		ret[typ.Type] = &KeyTypeOptions{
+			MinRSAKeySize: o.MinRSAKeySize,
+			ExtKeyUsages:  o.ExtKeyUsages,
+			extKeyUsages:  ekus,
+		}
+	}
+	return ret, nil
+}
+
+// publicKeyValidator returns the defaultPublicKeyValidator of the given claims
+// with the minimum size of the RSA keys configured if it is larger.
+func (o keyTypeOptions) publicKeyValidator(c *Claimer) defaultPublicKeyValidator {
+	v := newDefaultPublicKeyValidator(c)
+	if rsaOptions, ok := o["RSA"]; ok && rsaOptions.MinRSAKeySize > v.minRSABits {
+		v.minRSABits = rsaOptions.MinRSAKeySize
+	}
+	return v
+}
+
+// extKeyUsageEnforcer returns the enforcer of the extended key usages
+// configured, or nil if no type has extended key usages.
+func (o keyTypeOptions) extKeyUsageEnforcer() keyTypeExtKeyUsageEnforcer {
+	var e keyTypeExtKeyUsageEnforcer
+	for typ, opts := range o {
+		if len(opts.extKeyUsages) > 0 {
+			if e == nil {
+				e = make(keyTypeExtKeyUsageEnforcer)
+			}
+			e[typ] = opts.extKeyUsages
+		}
+	}
+	return e
+}
+
+// keyTypeExtKeyUsageEnforcer is a CertificateEnforcer that sets the extended
+// key usages of the certificate to the ones configured for the type of its
+// public key. Certificates with other types of keys are not modified.
+type keyTypeExtKeyUsageEnforcer map[string][]x509.ExtKeyUsage
+
+// Enforce sets the extended key usages of the type of the public key.
+func (e keyTypeExtKeyUsageEnforcer) Enforce(cert *x509.Certificate) error {
+	if ekus, ok := e[publicKeyTypeName(cert.PublicKey)]; ok {
+		cert.ExtKeyUsage = append([]x509.ExtKeyUsage(nil), ekus...)
+	}
+	return nil
+}
+
 // ExtraExtsEnforcer enforces only those extra extensions that are strictly
 // managed by step-ca. All other "extra extensions" are dropped.
 type ExtraExtsEnforcer struct{}
@@ -333,6 +685,18 @@ func newValidityValidator(min, max time.Duration) *validityValidator {
 	return &validityValidator{min: min, max: max}
 }
 
+// clamp returns the given duration limited to the minimum and maximum
+// durations of the validator, so a requested duration is always valid.
+func (v *validityValidator) clamp(d time.Duration) time.Duration {
+	if d < v.min {
+		return v.min
+	}
+	if d > v.max {
+		return v.max
+	}
+	return d
+}
+
 // Valid validates the certificate validity settings (notBefore/notAfter) and
 // and total duration.
 func (v *validityValidator) Valid(cert *x509.Certificate, o Options) error {
@@ -377,6 +741,48 @@ type stepProvisionerASN1 struct {
 	KeyValuePairs []string `asn1:"optional,omitempty"`
 }
 
+// ProvisionerExtension is the decoded step provisioner extension. The
+// extension is added to all the certificates signed using a provisioner, and
+// it identifies the provisioner that authorized it.
+type ProvisionerExtension struct {
+	Type          Type
+	Name          string
+	CredentialID  string
+	KeyValuePairs []string
+}
+
+// GetProvisionerExtension returns the step provisioner extension of the given
+// certificate. It returns false if the certificate does not contain the
+// extension or if the extension cannot be decoded.
+func GetProvisionerExtension(cert *x509.Certificate) (*ProvisionerExtension, bool) {
+	ext, err := getProvisionerExtension(cert)
+	if err != nil || ext == nil {
+		return nil, false
+	}
+	return ext, true
+}
+
+// getProvisionerExtension returns the step provisioner extension of the given
+// certificate, nil if the certificate does not contain it, or an error if the
+// extension cannot be decoded.
+func getProvisionerExtension(cert *x509.Certificate) (*ProvisionerExtension, error) {
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(stepOIDProvisioner) {
+			var provisioner stepProvisionerASN1
+			if _, err := asn1.Unmarshal(e.Value, &provisioner); err != nil {
+				return nil, errors.Wrap(err, "error unmarshaling provisioner extension")
+			}
+			return &ProvisionerExtension{
+				Type:          Type(provisioner.Type),
+				Name:          string(provisioner.Name),
+				CredentialID:  string(provisioner.CredentialID),
+				KeyValuePairs: provisioner.KeyValuePairs,
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
 type forceCNOption struct {
 	ForceCN bool
 }
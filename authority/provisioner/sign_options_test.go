@@ -1,8 +1,13 @@
 package provisioner
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"fmt"
 	"net"
 	"net/url"
@@ -12,10 +17,33 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/fips"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/x509util"
+	"golang.org/x/crypto/ed25519"
 )
 
+func TestGetAuthorizedIdentity(t *testing.T) {
+	id := &AuthorizedIdentity{Subject: "subject", TenantID: "tenant", ResourceGroup: "group", Name: "name", VMID: "vmid"}
+	tests := []struct {
+		name   string
+		opts   []SignOption
+		want   *AuthorizedIdentity
+		wantOK bool
+	}{
+		{"ok", []SignOption{defaultPublicKeyValidator{}, id, profileDefaultDuration(0)}, id, true},
+		{"not found", []SignOption{defaultPublicKeyValidator{}, profileDefaultDuration(0)}, nil, false},
+		{"empty", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := GetAuthorizedIdentity(tt.opts)
+			assert.Equals(t, tt.want, got)
+			assert.Equals(t, tt.wantOK, ok)
+		})
+	}
+}
+
 func Test_emailOnlyIdentity_Valid(t *testing.T) {
 	uri, err := url.Parse("https://example.com/1.0/getUser")
 	if err != nil {
@@ -114,6 +142,59 @@ func Test_defaultPublicKeyValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_defaultPublicKeyValidator_Valid_fips(t *testing.T) {
+	fips.Enable()
+	defer fips.Disable()
+
+	_rsa, err := pemutil.Read("./testdata/certs/rsa.csr")
+	assert.FatalError(t, err)
+	rsaCSR, ok := _rsa.(*x509.CertificateRequest)
+	assert.Fatal(t, ok)
+
+	_ecdsa, err := pemutil.Read("./testdata/certs/ecdsa.csr")
+	assert.FatalError(t, err)
+	ecdsaCSR, ok := _ecdsa.(*x509.CertificateRequest)
+	assert.Fatal(t, ok)
+
+	_ed25519, err := pemutil.Read("./testdata/certs/ed25519.csr")
+	assert.FatalError(t, err)
+	ed25519CSR, ok := _ed25519.(*x509.CertificateRequest)
+	assert.Fatal(t, ok)
+
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.FatalError(t, err)
+
+	v := defaultPublicKeyValidator{}
+	tests := []struct {
+		name string
+		csr  *x509.CertificateRequest
+		err  error
+	}{
+		{"ok/rsa", rsaCSR, nil},
+		{"ok/ecdsa", ecdsaCSR, nil},
+		{"fail/ed25519", ed25519CSR, errors.New("Ed25519 is not permitted in FIPS mode")},
+		{"fail/p224", &x509.CertificateRequest{
+			PublicKey:          p224.Public(),
+			SignatureAlgorithm: x509.ECDSAWithSHA256,
+		}, errors.New("ECDSA P-224 is not permitted in FIPS mode")},
+		{"fail/sha1", &x509.CertificateRequest{
+			PublicKey:          ecdsaCSR.PublicKey,
+			SignatureAlgorithm: x509.ECDSAWithSHA1,
+		}, errors.New("ECDSA-SHA1 is not permitted in FIPS mode")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Valid(tt.csr); err != nil {
+				if assert.NotNil(t, tt.err) {
+					assert.Equals(t, tt.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tt.err)
+			}
+		})
+	}
+}
+
 func Test_commonNameValidator_Valid(t *testing.T) {
 	type args struct {
 		req *x509.CertificateRequest
@@ -352,6 +433,266 @@ func Test_defaultSANsValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_parseExtKeyUsages(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []x509.ExtKeyUsage
+		wantErr bool
+	}{
+		{"ok", []string{"serverAuth", "clientAuth"}, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, false},
+		{"ok case insensitive", []string{"CLIENTAUTH"}, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, false},
+		{"ok empty", nil, nil, false},
+		{"fail unknown", []string{"clientAuth", "foo"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtKeyUsages(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseExtKeyUsages() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, got, tt.want)
+		})
+	}
+}
+
+func Test_extKeyUsageModifier_Option(t *testing.T) {
+	prof := &x509util.Leaf{}
+	prof.SetSubject(&x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+	v := extKeyUsageModifier{x509.ExtKeyUsageClientAuth}
+	assert.FatalError(t, v.Option(Options{})(prof))
+	assert.Equals(t, prof.Subject().ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+}
+
+func Test_extKeyUsageValidator_Valid(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       extKeyUsageValidator
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", extKeyUsageValidator{x509.ExtKeyUsageClientAuth}, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, false},
+		{"ok empty", extKeyUsageValidator{x509.ExtKeyUsageClientAuth}, &x509.Certificate{}, false},
+		{"ok any", extKeyUsageValidator{x509.ExtKeyUsageAny}, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, false},
+		{"fail serverAuth", extKeyUsageValidator{x509.ExtKeyUsageClientAuth}, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}, true},
+		{"fail unknown", extKeyUsageValidator{x509.ExtKeyUsageClientAuth}, &x509.Certificate{UnknownExtKeyUsage: []asn1.ObjectIdentifier{{1, 2, 3, 4}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.cert, Options{}); (err != nil) != tt.wantErr {
+				t.Errorf("extKeyUsageValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parsePublicKeyTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []publicKeyType
+		wantErr bool
+	}{
+		{"ok", []string{"ECDSA", "rsa-3072", "Ed25519"}, []publicKeyType{{"ECDSA", 0}, {"RSA", 3072}, {"Ed25519", 0}}, false},
+		{"ok curves", []string{"ECDSA-P256", "ECDSA-P384", "ECDSA-P521"}, []publicKeyType{{"ECDSA", 256}, {"ECDSA", 384}, {"ECDSA", 521}}, false},
+		{"ok empty", nil, nil, false},
+		{"fail unknown", []string{"ECDSA", "DSA"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePublicKeyTypes(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePublicKeyTypes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, got, tt.want)
+		})
+	}
+}
+
+func Test_publicKeyTypeValidator_Valid(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.FatalError(t, err)
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+
+	ecdsaOnly := publicKeyTypeValidator{{"ECDSA", 0}}
+	tests := []struct {
+		name    string
+		v       publicKeyTypeValidator
+		req     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"ok ecdsa", ecdsaOnly, &x509.CertificateRequest{PublicKey: p256.Public()}, false},
+		{"ok ecdsa p384", ecdsaOnly, &x509.CertificateRequest{PublicKey: p384.Public()}, false},
+		{"ok ecdsa curve", publicKeyTypeValidator{{"ECDSA", 384}}, &x509.CertificateRequest{PublicKey: p384.Public()}, false},
+		{"ok rsa", publicKeyTypeValidator{{"ECDSA", 0}, {"RSA", 2048}}, &x509.CertificateRequest{PublicKey: rsa2048.Public()}, false},
+		{"ok ed25519", publicKeyTypeValidator{{"Ed25519", 0}}, &x509.CertificateRequest{PublicKey: edPub}, false},
+		{"fail rsa", ecdsaOnly, &x509.CertificateRequest{PublicKey: rsa2048.Public()}, true},
+		{"fail rsa size", publicKeyTypeValidator{{"RSA", 3072}}, &x509.CertificateRequest{PublicKey: rsa2048.Public()}, true},
+		{"fail ed25519", ecdsaOnly, &x509.CertificateRequest{PublicKey: edPub}, true},
+		{"fail ecdsa curve", publicKeyTypeValidator{{"ECDSA", 384}}, &x509.CertificateRequest{PublicKey: p256.Public()}, true},
+		{"fail unknown", ecdsaOnly, &x509.CertificateRequest{PublicKey: "foo"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("publicKeyTypeValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_defaultPublicKeyValidator_minRSABits(t *testing.T) {
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	rsa3072, err := rsa.GenerateKey(rand.Reader, 3072)
+	assert.FatalError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		v       defaultPublicKeyValidator
+		req     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"ok default", defaultPublicKeyValidator{}, &x509.CertificateRequest{PublicKey: rsa2048.Public()}, false},
+		{"ok 2048", defaultPublicKeyValidator{minRSABits: 2048}, &x509.CertificateRequest{PublicKey: rsa2048.Public()}, false},
+		{"ok 3072", defaultPublicKeyValidator{minRSABits: 3072}, &x509.CertificateRequest{PublicKey: rsa3072.Public()}, false},
+		{"ok ecdsa", defaultPublicKeyValidator{minRSABits: 3072}, &x509.CertificateRequest{PublicKey: p256.Public()}, false},
+		{"ok p384", defaultPublicKeyValidator{minECDSABits: 384}, &x509.CertificateRequest{PublicKey: p384.Public()}, false},
+		{"fail 3072", defaultPublicKeyValidator{minRSABits: 3072}, &x509.CertificateRequest{PublicKey: rsa2048.Public()}, true},
+		{"fail p384", defaultPublicKeyValidator{minECDSABits: 384}, &x509.CertificateRequest{PublicKey: p256.Public()}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("defaultPublicKeyValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newDefaultPublicKeyValidator(t *testing.T) {
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	rsa3072, err := rsa.GenerateKey(rand.Reader, 3072)
+	assert.FatalError(t, err)
+
+	minRSAKeySize, minECDSACurve := 3072, "P-384"
+	claimer, err := NewClaimer(&Claims{MinRSAKeySize: &minRSAKeySize, MinECDSACurve: &minECDSACurve}, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	v := newDefaultPublicKeyValidator(claimer)
+	assert.Equals(t, defaultPublicKeyValidator{minRSABits: 3072, minECDSABits: 384}, v)
+	assert.FatalError(t, v.Valid(&x509.CertificateRequest{PublicKey: rsa3072.Public()}))
+	err = v.Valid(&x509.CertificateRequest{PublicKey: rsa2048.Public()})
+	if assert.Error(t, err) {
+		assert.Equals(t, "rsa key in CSR must be at least 3072 bits (384 bytes), got 2048 bits", err.Error())
+	}
+
+	assert.Equals(t, defaultPublicKeyValidator{minRSABits: 2048}, newDefaultPublicKeyValidator(nil))
+}
+
+func Test_parseKeyTypeOptions(t *testing.T) {
+	type args struct {
+		opts    map[string]*KeyTypeOptions
+		allowed []x509.ExtKeyUsage
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    keyTypeOptions
+		wantErr bool
+	}{
+		{"ok", args{map[string]*KeyTypeOptions{
+			"rsa":   {MinRSAKeySize: 3072, ExtKeyUsages: []string{"clientAuth"}},
+			"ECDSA": {ExtKeyUsages: []string{"serverAuth", "clientAuth"}},
+		}, nil}, keyTypeOptions{
+			"RSA":   {MinRSAKeySize: 3072, ExtKeyUsages: []string{"clientAuth"}, extKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}},
+			"ECDSA": {ExtKeyUsages: []string{"serverAuth", "clientAuth"}, extKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}},
+		}, false},
+		{"ok allowed", args{map[string]*KeyTypeOptions{
+			"Ed25519": {ExtKeyUsages: []string{"clientAuth"}},
+		}, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, keyTypeOptions{
+			"Ed25519": {ExtKeyUsages: []string{"clientAuth"}, extKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}},
+		}, false},
+		{"ok nil options", args{map[string]*KeyTypeOptions{"RSA": nil}, nil}, keyTypeOptions{"RSA": {}}, false},
+		{"ok empty", args{nil, nil}, keyTypeOptions{}, false},
+		{"fail type", args{map[string]*KeyTypeOptions{"RSA-2048": {}}, nil}, nil, true},
+		{"fail duplicated", args{map[string]*KeyTypeOptions{"RSA": {}, "rsa": {}}, nil}, nil, true},
+		{"fail min rsa type", args{map[string]*KeyTypeOptions{"ECDSA": {MinRSAKeySize: 3072}}, nil}, nil, true},
+		{"fail min rsa size", args{map[string]*KeyTypeOptions{"RSA": {MinRSAKeySize: 1024}}, nil}, nil, true},
+		{"fail ext key usages", args{map[string]*KeyTypeOptions{"RSA": {ExtKeyUsages: []string{"foo"}}}, nil}, nil, true},
+		{"fail not allowed", args{map[string]*KeyTypeOptions{
+			"RSA": {ExtKeyUsages: []string{"serverAuth"}},
+		}, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyTypeOptions(tt.args.opts, tt.args.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseKeyTypeOptions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, got, tt.want)
+		})
+	}
+}
+
+func Test_keyTypeOptions(t *testing.T) {
+	opts := keyTypeOptions{
+		"RSA":   {MinRSAKeySize: 3072, extKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}},
+		"ECDSA": {},
+	}
+	assert.Equals(t, defaultPublicKeyValidator{minRSABits: 3072}, opts.publicKeyValidator(nil))
+	assert.Equals(t, keyTypeExtKeyUsageEnforcer{"RSA": {x509.ExtKeyUsageClientAuth}}, opts.extKeyUsageEnforcer())
+
+	var empty keyTypeOptions
+	assert.Equals(t, defaultPublicKeyValidator{minRSABits: 2048}, empty.publicKeyValidator(nil))
+
+	// The larger minimum size is used.
+	minRSAKeySize := 4096
+	claimer := &Claimer{global: globalProvisionerClaims, claims: &Claims{MinRSAKeySize: &minRSAKeySize}}
+	assert.Equals(t, defaultPublicKeyValidator{minRSABits: 4096}, opts.publicKeyValidator(claimer))
+	assert.Nil(t, empty.extKeyUsageEnforcer())
+}
+
+func Test_keyTypeExtKeyUsageEnforcer_Enforce(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	e := keyTypeExtKeyUsageEnforcer{
+		"ECDSA": {x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want []x509.ExtKeyUsage
+	}{
+		{"ok ecdsa", &x509.Certificate{PublicKey: p256.Public(), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}}, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}},
+		{"ok rsa", &x509.Certificate{PublicKey: rsa2048.Public(), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}}, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.FatalError(t, e.Enforce(tt.cert))
+			assert.Equals(t, tt.want, tt.cert.ExtKeyUsage)
+		})
+	}
+}
+
 func Test_ExtraExtsEnforcer_Enforce(t *testing.T) {
 	e1 := pkix.Extension{Id: []int{1, 2, 3, 4, 5}, Critical: false, Value: []byte("foo")}
 	e2 := pkix.Extension{Id: []int{2, 2, 2}, Critical: false, Value: []byte("bar")}
@@ -397,6 +738,28 @@ func Test_ExtraExtsEnforcer_Enforce(t *testing.T) {
 	}
 }
 
+func Test_validityValidator_clamp(t *testing.T) {
+	v := newValidityValidator(5*time.Minute, 24*time.Hour)
+	tests := []struct {
+		name string
+		d    time.Duration
+		want time.Duration
+	}{
+		{"below min", time.Minute, 5 * time.Minute},
+		{"min", 5 * time.Minute, 5 * time.Minute},
+		{"in range", time.Hour, time.Hour},
+		{"max", 24 * time.Hour, 24 * time.Hour},
+		{"above max", 48 * time.Hour, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := v.clamp(tt.d); got != tt.want {
+				t.Errorf("validityValidator.clamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_validityValidator_Valid(t *testing.T) {
 	type test struct {
 		cert *x509.Certificate
@@ -710,6 +1073,33 @@ func Test_newProvisionerExtension_Option(t *testing.T) {
 	}
 }
 
+func TestGetProvisionerExtension(t *testing.T) {
+	ext, err := createProvisionerExtension(int(TypeAWS), "aws", "123456789", "InstanceID", "i-0123456789")
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		cert *x509.Certificate
+		want *ProvisionerExtension
+		ok   bool
+	}{
+		"ok": {&x509.Certificate{Extensions: []pkix.Extension{{Id: []int{1, 2, 3}}, ext}}, &ProvisionerExtension{
+			Type:          TypeAWS,
+			Name:          "aws",
+			CredentialID:  "123456789",
+			KeyValuePairs: []string{"InstanceID", "i-0123456789"},
+		}, true},
+		"fail/missing":   {&x509.Certificate{Extensions: []pkix.Extension{{Id: []int{1, 2, 3}}}}, nil, false},
+		"fail/bad-value": {&x509.Certificate{Extensions: []pkix.Extension{{Id: stepOIDProvisioner, Value: []byte("foo")}}}, nil, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := GetProvisionerExtension(tt.cert)
+			assert.Equals(t, tt.ok, ok)
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
 func Test_profileLimitDuration_Option(t *testing.T) {
 	n, fn := mockNow()
 	defer fn()
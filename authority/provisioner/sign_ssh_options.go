@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/fips"
 	"github.com/smallstep/cli/crypto/keys"
 	"golang.org/x/crypto/ssh"
 )
@@ -394,6 +395,15 @@ func (v sshDefaultPublicKeyValidator) Valid(cert *ssh.Certificate, o SSHOptions)
 	if cert.Key == nil {
 		return errors.New("ssh certificate key cannot be nil")
 	}
+	if fips.Enabled() {
+		key, ok := cert.Key.(ssh.CryptoPublicKey)
+		if !ok {
+			return fips.ErrNotPermitted{Algorithm: cert.Key.Type()}
+		}
+		if err := fips.CheckPublicKey(key.CryptoPublicKey()); err != nil {
+			return err
+		}
+	}
 	switch cert.Key.Type() {
 	case ssh.KeyAlgoRSA:
 		_, in, ok := sshParseString(cert.Key.Marshal())
@@ -8,6 +8,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/fips"
 	"github.com/smallstep/cli/crypto/keys"
 	"golang.org/x/crypto/ssh"
 )
@@ -670,6 +671,41 @@ func Test_sshCertDefaultValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_sshDefaultPublicKeyValidator_Valid_fips(t *testing.T) {
+	fips.Enable()
+	defer fips.Disable()
+
+	newKey := func(t *testing.T, kty, crv string, size int) ssh.PublicKey {
+		pub, _, err := keys.GenerateKeyPair(kty, crv, size)
+		assert.FatalError(t, err)
+		key, err := ssh.NewPublicKey(pub)
+		assert.FatalError(t, err)
+		return key
+	}
+
+	v := sshDefaultPublicKeyValidator{}
+	tests := []struct {
+		name string
+		key  ssh.PublicKey
+		err  error
+	}{
+		{"ok/rsa", newKey(t, "RSA", "", 2048), nil},
+		{"ok/ecdsa", newKey(t, "EC", "P-256", 0), nil},
+		{"fail/ed25519", newKey(t, "OKP", "Ed25519", 0), errors.New("Ed25519 is not permitted in FIPS mode")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Valid(&ssh.Certificate{Key: tt.key}, SSHOptions{}); err != nil {
+				if assert.NotNil(t, tt.err) {
+					assert.Equals(t, tt.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tt.err)
+			}
+		})
+	}
+}
+
 func Test_sshCertValidityValidator(t *testing.T) {
 	p, err := generateX5C(nil)
 	assert.FatalError(t, err)
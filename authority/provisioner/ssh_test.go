@@ -64,6 +64,8 @@ func signSSHCertificate(key crypto.PublicKey, opts SSHOptions, signOpts []SignOp
 			if err := o.Valid(opts); err != nil {
 				return nil, err
 			}
+		case *AuthorizedIdentity:
+			// only used for auditing and rate limits
 		default:
 			return nil, fmt.Errorf("signSSH: invalid extra option type %T", o)
 		}
@@ -35,6 +35,12 @@ type SSHPOP struct {
 	sshPubKeys *SSHKeys
 }
 
+func init() {
+	Register(TypeSSHPOP.String(), func() Interface {
+		return &SSHPOP{}
+	})
+}
+
 // GetID returns the provisioner unique identifier. The name and credential id
 // should uniquely identify any SSH-POP provisioner.
 func (p *SSHPOP) GetID() string {
@@ -164,7 +170,7 @@ func (p *SSHPOP) authorizeToken(token string, audiences []string) (*sshPOPPayloa
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: p.Name,
 		Time:   time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "sshpop.authorizeToken; invalid sshpop token")
 	}
 
@@ -0,0 +1,110 @@
+package provisioner
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallstep/cli/jose"
+)
+
+const (
+	// identityTokenExpiryMargin is the time before the expiration of an
+	// identity token when a new one will be requested.
+	identityTokenExpiryMargin = time.Minute
+	// identityTokenMaxCacheAge is the maximum time an identity token is cached,
+	// regardless of its expiration.
+	identityTokenMaxCacheAge = 30 * time.Minute
+)
+
+// identityTokens caches the identity tokens requested by the cloud
+// provisioners, so multiple calls to GetIdentityToken do not hit the metadata
+// services every time.
+var identityTokens = &identityTokenCache{}
+
+type cachedIdentityToken struct {
+	token   string
+	refresh time.Time
+}
+
+// identityTokenCache is a cache of identity tokens. Tokens are refreshed
+// shortly before their expiration, with a random jitter so the instances
+// booted at the same time do not request them at the same time.
+type identityTokenCache struct {
+	sync.Mutex
+	tokens map[string]cachedIdentityToken
+}
+
+// identityTokenKey returns the key used to cache a token, it must include all
+// the parameters used to request it, e.g. the provisioner, the subject and the
+// audience or identity URL.
+func identityTokenKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+// GetOrFetch returns the cached token for the given key if it is still fresh,
+// otherwise it requests a new one using fn. Tokens without an expiration, or
+// that are not JWTs, are not cached.
+func (c *identityTokenCache) GetOrFetch(key string, fn func() (string, error)) (string, error) {
+	c.Lock()
+	if t, ok := c.tokens[key]; ok && time.Now().Before(t.refresh) {
+		c.Unlock()
+		return t.token, nil
+	}
+	c.Unlock()
+
+	token, err := fn()
+	if err != nil {
+		return "", err
+	}
+	c.set(key, token)
+	return token, nil
+}
+
+func (c *identityTokenCache) set(key, token string) {
+	age := identityTokenCacheAge(token)
+	if age <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	n := time.Now()
+	if c.tokens == nil {
+		c.tokens = make(map[string]cachedIdentityToken)
+	}
+	// Remove stale tokens to keep the cache small.
+	for k, t := range c.tokens {
+		if n.After(t.refresh) {
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[key] = cachedIdentityToken{
+		token:   token,
+		refresh: n.Add(age),
+	}
+}
+
+// identityTokenCacheAge returns the time that the given token can be cached.
+// It's the time until the token expiration minus a margin, capped to
+// identityTokenMaxCacheAge, and minus a random jitter up to 10% of it.
+func identityTokenCacheAge(token string) time.Duration {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return 0
+	}
+	var claims jose.Claims
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil || claims.Expiry == nil {
+		return 0
+	}
+
+	age := time.Until(claims.Expiry.Time()) - identityTokenExpiryMargin
+	if age > identityTokenMaxCacheAge {
+		age = identityTokenMaxCacheAge
+	}
+	if jitter := int64(age / 10); jitter > 0 {
+		age -= time.Duration(rand.Int63n(jitter))
+	}
+	return age
+}
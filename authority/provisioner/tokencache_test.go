@@ -0,0 +1,107 @@
+package provisioner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func Test_identityTokenCacheAge(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	// generateToken creates tokens that expire 5 minutes after iat.
+	newToken := func(expiresIn time.Duration) string {
+		tok, err := generateToken("subject", "issuer", "audience", "name@smallstep.com", nil, time.Now().Add(expiresIn-5*time.Minute), jwk)
+		assert.FatalError(t, err)
+		return tok
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		min, max time.Duration
+	}{
+		{"ok", newToken(10 * time.Minute), 8 * time.Minute, 9 * time.Minute},
+		{"ok capped", newToken(2 * time.Hour), 27 * time.Minute, identityTokenMaxCacheAge},
+		{"fail expires soon", newToken(30 * time.Second), 0, 0},
+		{"fail expired", newToken(-time.Minute), 0, 0},
+		{"fail not a jwt", "the-token", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := identityTokenCacheAge(tt.token)
+			if tt.max == 0 {
+				assert.True(t, got <= 0, fmt.Sprintf("identityTokenCacheAge() = %s, want <= 0", got))
+				return
+			}
+			assert.True(t, got > tt.min && got <= tt.max, fmt.Sprintf("identityTokenCacheAge() = %s, want between %s and %s", got, tt.min, tt.max))
+		})
+	}
+}
+
+func TestAzure_GetIdentityToken_cache(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	var requests int32
+	var expiresIn int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		iat := time.Now().Add(time.Duration(atomic.LoadInt64(&expiresIn)) - 5*time.Minute)
+		tok, err := generateToken("subject", "issuer", "audience", "name@smallstep.com", nil, iat, jwk)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"access_token":"%s"}`, tok)))
+	}))
+	defer srv.Close()
+
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+	p.config.identityTokenURL = srv.URL
+
+	// Two rapid calls reuse the cached token.
+	atomic.StoreInt64(&expiresIn, int64(10*time.Minute))
+	t1, err := p.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	t2, err := p.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	assert.Equals(t, t1, t2)
+	assert.Equals(t, int32(1), atomic.LoadInt32(&requests))
+
+	// A different subject does not collide with the cached token.
+	t3, err := p.GetIdentityToken("other-subject", "caURL")
+	assert.FatalError(t, err)
+	assert.NotEquals(t, t1, t3)
+	assert.Equals(t, int32(2), atomic.LoadInt32(&requests))
+
+	// An expired token triggers a refetch.
+	key := identityTokenKey(p.GetID(), srv.URL, "subject")
+	identityTokens.Lock()
+	c := identityTokens.tokens[key]
+	c.refresh = time.Now().Add(-time.Second)
+	identityTokens.tokens[key] = c
+	identityTokens.Unlock()
+	t4, err := p.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	assert.NotEquals(t, t1, t4)
+	assert.Equals(t, int32(3), atomic.LoadInt32(&requests))
+
+	// Tokens about to expire are not cached.
+	p.config.identityTokenURL = srv.URL + "/expiring"
+	atomic.StoreInt64(&expiresIn, int64(30*time.Second))
+	t5, err := p.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	t6, err := p.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	assert.NotEquals(t, t5, t6)
+	assert.Equals(t, int32(5), atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,597 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+// tpmDeviceIDPrefix is the prefix of the URI SAN with the device identifier
+// added to the certificates signed using a TPM provisioner.
+const tpmDeviceIDPrefix = "urn:ek:sha256:"
+
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// tpmAttestation contains the TPM 2.0 structures used to prove that a key
+// resides in the same TPM as an endorsement key.
+type tpmAttestation struct {
+	// EKCerts is the endorsement key certificate followed by the
+	// intermediates, in DER format.
+	EKCerts [][]byte `json:"ekCerts"`
+	// AKCerts is the attestation key certificate followed by the
+	// intermediates, in DER format. It is issued by an attestation CA after a
+	// credential activation with the endorsement key, and it must contain the
+	// device identifier of the endorsement key as a URI SAN.
+	AKCerts [][]byte `json:"akCerts"`
+	// AKPublic is the TPMT_PUBLIC of the attestation key.
+	AKPublic []byte `json:"akPublic"`
+	// Public is the TPMT_PUBLIC of the attested key.
+	Public []byte `json:"public"`
+	// CertifyInfo is the TPMS_ATTEST generated by TPM2_Certify on the attested
+	// key, using the SHA-256 of the token id as the qualifying data.
+	CertifyInfo []byte `json:"certifyInfo"`
+	// Signature is the TPMT_SIGNATURE of the CertifyInfo by the attestation
+	// key.
+	Signature []byte `json:"signature"`
+}
+
+// tpmPayload extends jwt.Claims with step attributes.
+type tpmPayload struct {
+	jose.Claims
+	SANs        []string        `json:"sans,omitempty"`
+	Attestation *tpmAttestation `json:"attestation"`
+	ekCert      *x509.Certificate
+	deviceID    *url.URL
+	key         crypto.PublicKey
+}
+
+// TPM is the provisioner that authorizes certificates for keys attested by a
+// TPM 2.0. The endorsement key certificate must chain to one of the configured
+// manufacturer roots, and the key in the certificate request must be the one
+// certified by the TPM.
+//
+// A TPM token is a JWT, signed by the attested key, with an attestation claim
+// containing the endorsement key certificate, the attestation key and its
+// certificate, and the TPM2_Certify output. The endorsement key certificates
+// are public, so the attestation key certificate binds the attestation key to
+// the endorsement key: it must chain to the attestation roots and contain the
+// device identifier of the endorsement key.
+//
+// The subject and SANs of the token are chosen by the client, so unless name
+// constraints are configured the certificates can only identify the attested
+// device.
+type TPM struct {
+	*base
+	Type              string           `json:"type"`
+	Name              string           `json:"name"`
+	ManufacturerRoots []byte           `json:"manufacturerRoots"`
+	AttestationRoots  []byte           `json:"attestationRoots"`
+	NameConstraints   *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook           *Webhook         `json:"webhook,omitempty"`
+	Claims            *Claims          `json:"claims,omitempty"`
+	claimer           *Claimer
+	audiences         Audiences
+	rootPool          *x509.CertPool
+	attestationPool   *x509.CertPool
+}
+
+func init() {
+	Register(TypeTPM.String(), func() Interface {
+		return &TPM{}
+	})
+}
+
+// GetID returns the provisioner unique identifier. The name should uniquely
+// identify any TPM provisioner.
+func (p *TPM) GetID() string {
+	return "tpm/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *TPM) GetTokenID(ott string) (string, error) {
+	token, err := jose.ParseSigned(ott)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+
+	// Get claims w/out verification.
+	var claims jose.Claims
+	if err = token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	return claims.ID, nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *TPM) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *TPM) GetType() Type {
+	return TypeTPM
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (p *TPM) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *TPM) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
+// Init initializes and validates the fields of a TPM type.
+func (p *TPM) Init(config Config) error {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case len(p.ManufacturerRoots) == 0:
+		return errors.New("provisioner manufacturer root(s) cannot be empty")
+	case len(p.AttestationRoots) == 0:
+		return errors.New("provisioner attestation root(s) cannot be empty")
+	}
+
+	var err error
+	if p.rootPool, err = parseTPMRoots(p.ManufacturerRoots); err != nil {
+		return err
+	}
+	if len(p.rootPool.Subjects()) == 0 {
+		return errors.Errorf("no x509 certificates found in manufacturerRoots attribute for provisioner %s", p.GetName())
+	}
+	if p.attestationPool, err = parseTPMRoots(p.AttestationRoots); err != nil {
+		return err
+	}
+	if len(p.attestationPool.Subjects()) == 0 {
+		return errors.Errorf("no x509 certificates found in attestationRoots attribute for provisioner %s", p.GetName())
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
+	p.audiences = config.Audiences.WithFragment(p.GetID())
+	return nil
+}
+
+// parseTPMRoots returns a pool with the certificates in the given PEM data.
+func parseTPMRoots(data []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	var (
+		block *pem.Block
+		rest  = data
+	)
+	for rest != nil {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing x509 certificate from PEM block")
+		}
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// verifyEKCertificate parses the endorsement key certificate chain and
+// verifies it using the manufacturer roots.
+func (p *TPM) verifyEKCertificate(ders [][]byte) (*x509.Certificate, error) {
+	return verifyTPMCertificate(ders, p.rootPool, "endorsement key")
+}
+
+// verifyAKCertificate parses the attestation key certificate chain, verifies
+// it using the attestation roots, and checks that it certifies the given
+// attestation key for the device with the given identifier.
+func (p *TPM) verifyAKCertificate(ders [][]byte, ak crypto.PublicKey, deviceID *url.URL) error {
+	cert, err := verifyTPMCertificate(ders, p.attestationPool, "attestation key")
+	if err != nil {
+		return err
+	}
+	want, err := x509.MarshalPKIXPublicKey(ak)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling attestation key")
+	}
+	got, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling attestation key certificate public key")
+	}
+	if !bytes.Equal(want, got) {
+		return errors.New("attestation key certificate does not certify the attestation key")
+	}
+	for _, u := range cert.URIs {
+		if u.String() == deviceID.String() {
+			return nil
+		}
+	}
+	return errors.New("attestation key certificate does not certify the endorsement key")
+}
+
+// verifyTPMCertificate parses the given certificate chain and verifies it
+// using the given roots.
+func verifyTPMCertificate(ders [][]byte, roots *x509.CertPool, name string) (*x509.Certificate, error) {
+	if len(ders) == 0 {
+		return nil, errors.Errorf("%s certificate cannot be empty", name)
+	}
+	certs := make([]*x509.Certificate, len(ders))
+	for i, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s certificate", name)
+		}
+		// TPM certificates use a critical subject alternative name with a
+		// directory name that is not handled by crypto/x509.
+		unhandled := cert.UnhandledCriticalExtensions[:0]
+		for _, oid := range cert.UnhandledCriticalExtensions {
+			if !oid.Equal(oidExtensionSubjectAltName) {
+				unhandled = append(unhandled, oid)
+			}
+		}
+		cert.UnhandledCriticalExtensions = unhandled
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error verifying %s certificate", name)
+	}
+	return certs[0], nil
+}
+
+// verifyAttestation verifies the TPM attestation of the device with the given
+// identifier and returns the attested public key.
+func (p *TPM) verifyAttestation(att *tpmAttestation, tokenID string, deviceID *url.URL) (crypto.PublicKey, error) {
+	ak, err := parseTPMPublic(att.AKPublic)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing attestation key")
+	}
+	if !ak.HasAttributes(tpmAttrFixedTPM | tpmAttrFixedParent | tpmAttrSensitiveDataOrigin | tpmAttrRestricted | tpmAttrSign) {
+		return nil, errors.New("attestation key is not a restricted signing key generated by the tpm")
+	}
+	// The attributes of the attestation key are self-declared, the certificate
+	// proves that it lives in the same TPM as the endorsement key.
+	if err := p.verifyAKCertificate(att.AKCerts, ak.Key, deviceID); err != nil {
+		return nil, err
+	}
+	if err := verifyTPMSignature(ak.Key, att.CertifyInfo, att.Signature); err != nil {
+		return nil, err
+	}
+
+	info, err := parseTPMCertifyInfo(att.CertifyInfo)
+	if err != nil {
+		return nil, err
+	}
+	nonce := sha256.Sum256([]byte(tokenID))
+	if !bytes.Equal(info.ExtraData, nonce[:]) {
+		return nil, errors.New("tpm attestation is not bound to the token id")
+	}
+
+	pub, err := parseTPMPublic(att.Public)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing attested key")
+	}
+	name, err := pub.Name()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.Name, name) {
+		return nil, errors.New("tpm attestation does not certify the attested key")
+	}
+	if !pub.HasAttributes(tpmAttrFixedTPM) {
+		return nil, errors.New("attested key is not bound to the tpm")
+	}
+	return pub.Key, nil
+}
+
+// authorizeToken performs common jwt authorization actions and returns the
+// claims for case specific downstream parsing.
+// e.g. a Sign request will auth/validate different fields than a Revoke request.
+func (p *TPM) authorizeToken(token string, audiences []string) (*tpmPayload, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm token")
+	}
+
+	// The attestation contains the key used to sign the token, so we need to
+	// read it before verifying the token.
+	var unsafeClaims tpmPayload
+	if err = jwt.UnsafeClaimsWithoutVerification(&unsafeClaims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm claims")
+	}
+	if unsafeClaims.Attestation == nil {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token must contain an attestation")
+	}
+	if unsafeClaims.ID == "" {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token id cannot be empty")
+	}
+
+	ekCert, err := p.verifyEKCertificate(unsafeClaims.Attestation.EKCerts)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken")
+	}
+	deviceID, err := tpmDeviceID(ekCert)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.authorizeToken")
+	}
+	key, err := p.verifyAttestation(unsafeClaims.Attestation, unsafeClaims.ID, deviceID)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken")
+	}
+
+	// Using the attested key to validate the claims asserts that the token
+	// has been signed with a key that lives in the TPM, and that the claims
+	// have not been tampered with.
+	var claims tpmPayload
+	if err = jwt.Claims(key, &claims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm claims")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = claims.ValidateWithLeeway(jose.Expected{
+		Issuer: p.Name,
+		Time:   time.Now().UTC(),
+	}, p.claimer.ClockSkew()); err != nil {
+		return nil, errs.Wrapf(http.StatusUnauthorized, err, "tpm.authorizeToken; invalid tpm claims")
+	}
+
+	// validate audiences with the defaults
+	if !matchesAudience(claims.Audience, audiences) {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token has invalid audience "+
+			"claim (aud); expected %s, but got %s", audiences, claims.Audience)
+	}
+
+	if claims.Subject == "" {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token subject cannot be empty")
+	}
+
+	claims.ekCert = ekCert
+	claims.deviceID = deviceID
+	claims.key = key
+	return &claims, nil
+}
+
+// AuthorizeSign validates the given token.
+func (p *TPM) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSign")
+	}
+
+	// NOTE: This is for backwards compatibility with older versions of cli
+	// and certificates. Older versions added the token subject as the only SAN
+	// in a CSR by default.
+	if len(claims.SANs) == 0 {
+		claims.SANs = []string{claims.Subject}
+	}
+
+	// Without name constraints the certificate can only identify the attested
+	// device.
+	if p.NameConstraints == nil {
+		deviceID := claims.deviceID.String()
+		if claims.Subject != deviceID {
+			return nil, errs.Unauthorized("tpm.AuthorizeSign; tpm token subject must be the device identifier %s without name constraints", deviceID)
+		}
+		for _, san := range claims.SANs {
+			if san != deviceID {
+				return nil, errs.Unauthorized("tpm.AuthorizeSign; tpm token SAN %s is not allowed, only the device identifier %s is allowed without name constraints", san, deviceID)
+			}
+		}
+	}
+
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: claims.deviceID.String(),
+	}
+	so := []SignOption{
+		identity,
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeTPM, p.Name, ""),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		tpmDeviceIDModifier{claims.deviceID},
+		// validators
+		commonNameValidator(claims.Subject),
+		defaultSANsValidator(claims.SANs),
+		tpmPublicKeyValidator{claims.key},
+		newDefaultPublicKeyValidator(p.claimer),
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+	}
+
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeTPM, p.Name, identity, claims))
+	}
+
+	return so, nil
+}
+
+// AuthorizeSSHSign returns the list of SignOption for a SignSSH request. Only
+// host certificates are supported, the key id is set to the device identifier,
+// and the certificate key must be the key attested by the TPM. The device
+// identifier is only trusted because the attestation key certificate binds the
+// attestation key to the endorsement key.
+func (p *TPM) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
+	if !p.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("tpm.AuthorizeSSHSign; ssh ca is disabled for tpm provisioner %s", p.GetID())
+	}
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSSHSign")
+	}
+
+	// The principals default to the token subject.
+	principals := claims.SANs
+	if len(principals) == 0 {
+		principals = []string{claims.Subject}
+	}
+
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: claims.deviceID.String(),
+	}
+	signOptions := []SignOption{
+		identity,
+		// set the key id to the device identifier
+		sshCertKeyIDModifier(claims.deviceID.String()),
+	}
+
+	// Default to cert type to host
+	defaults := SSHOptions{
+		CertType:   SSHHostCert,
+		Principals: principals,
+	}
+
+	// Validate user options
+	signOptions = append(signOptions, sshCertOptionsValidator(defaults))
+	// Set defaults if not given as user options
+	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
+
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeTPM, p.Name, identity, claims))
+	}
+
+	return append(signOptions,
+		// Set the default extensions.
+		&sshDefaultExtensionModifier{},
+		// Set the validity bounds if not set.
+		&sshDefaultDuration{p.claimer},
+		// Validate public key
+		&sshDefaultPublicKeyValidator{},
+		// Validate that the key is the attested one
+		tpmSSHPublicKeyValidator{claims.key},
+		// Validate the validity period.
+		&sshCertValidityValidator{p.claimer},
+		// Require all the fields in the SSH certificate
+		&sshCertDefaultValidator{},
+	), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *TPM) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("tpm.AuthorizeRenew; renew is disabled for tpm provisioner %s", p.GetID())
+	}
+	return nil
+}
+
+// tpmDeviceID returns the device identifier derived from the endorsement key,
+// the SHA-256 of its public key.
+func tpmDeviceID(ekCert *x509.Certificate) (*url.URL, error) {
+	sum := sha256.Sum256(ekCert.RawSubjectPublicKeyInfo)
+	u, err := url.Parse(tpmDeviceIDPrefix + hex.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating device identifier")
+	}
+	return u, nil
+}
+
+// tpmDeviceIDModifier is a ProfileModifier that adds the device identifier
+// URI to the certificate SANs.
+type tpmDeviceIDModifier struct {
+	deviceID *url.URL
+}
+
+func (v tpmDeviceIDModifier) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		for _, u := range crt.URIs {
+			if u.String() == v.deviceID.String() {
+				return nil
+			}
+		}
+		crt.URIs = append(crt.URIs, v.deviceID)
+		return nil
+	}
+}
+
+// tpmPublicKeyValidator validates that the public key in the certificate
+// request is the one attested by the TPM.
+type tpmPublicKeyValidator struct {
+	key crypto.PublicKey
+}
+
+// Valid checks that the certificate request public key matches the attested
+// key.
+func (v tpmPublicKeyValidator) Valid(req *x509.CertificateRequest) error {
+	want, err := x509.MarshalPKIXPublicKey(v.key)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling attested key")
+	}
+	got, err := x509.MarshalPKIXPublicKey(req.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling certificate request public key")
+	}
+	if !bytes.Equal(want, got) {
+		return errors.New("certificate request public key does not match the tpm attested key")
+	}
+	return nil
+}
+
+// tpmSSHPublicKeyValidator validates that the key in the SSH certificate is the
+// one attested by the TPM.
+type tpmSSHPublicKeyValidator struct {
+	key crypto.PublicKey
+}
+
+// Valid checks that the SSH certificate key matches the attested key.
+func (v tpmSSHPublicKeyValidator) Valid(cert *ssh.Certificate, o SSHOptions) error {
+	if cert.Key == nil {
+		return errors.New("ssh certificate key cannot be nil")
+	}
+	want, err := ssh.NewPublicKey(v.key)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling attested key")
+	}
+	if !bytes.Equal(want.Marshal(), cert.Key.Marshal()) {
+		return errors.New("ssh certificate key does not match the tpm attested key")
+	}
+	return nil
+}
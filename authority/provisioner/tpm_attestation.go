@@ -0,0 +1,316 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// TPM 2.0 constants used to parse the attestation structures, see the TPM 2.0
+// Library specification, Part 2: Structures.
+const (
+	tpmGeneratedValue  uint32 = 0xff544347
+	tpmSTAttestCertify uint16 = 0x8017
+
+	tpmAlgRSA    uint16 = 0x0001
+	tpmAlgSHA1   uint16 = 0x0004
+	tpmAlgSHA256 uint16 = 0x000B
+	tpmAlgSHA384 uint16 = 0x000C
+	tpmAlgSHA512 uint16 = 0x000D
+	tpmAlgNull   uint16 = 0x0010
+	tpmAlgRSASSA uint16 = 0x0014
+	tpmAlgRSAPSS uint16 = 0x0016
+	tpmAlgECDSA  uint16 = 0x0018
+	tpmAlgECDAA  uint16 = 0x001A
+	tpmAlgECC    uint16 = 0x0023
+
+	tpmECCNistP256 uint16 = 0x0003
+	tpmECCNistP384 uint16 = 0x0004
+	tpmECCNistP521 uint16 = 0x0005
+
+	tpmAttrFixedTPM            uint32 = 1 << 1
+	tpmAttrFixedParent         uint32 = 1 << 4
+	tpmAttrSensitiveDataOrigin uint32 = 1 << 5
+	tpmAttrRestricted          uint32 = 1 << 16
+	tpmAttrSign                uint32 = 1 << 18
+)
+
+// tpmReader reads the big-endian TPM 2.0 wire format. The first error is
+// stored and all the following reads return zero values.
+type tpmReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newTPMReader(b []byte) *tpmReader {
+	return &tpmReader{r: bytes.NewReader(b)}
+}
+
+func (r *tpmReader) read(v interface{}) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.BigEndian, v)
+	}
+}
+
+func (r *tpmReader) u8() (v uint8) {
+	r.read(&v)
+	return
+}
+
+func (r *tpmReader) u16() (v uint16) {
+	r.read(&v)
+	return
+}
+
+func (r *tpmReader) u32() (v uint32) {
+	r.read(&v)
+	return
+}
+
+func (r *tpmReader) u64() (v uint64) {
+	r.read(&v)
+	return
+}
+
+// tpm2b reads a TPM2B structure, a 16 bit size followed by the data.
+func (r *tpmReader) tpm2b() []byte {
+	size := r.u16()
+	if r.err != nil {
+		return nil
+	}
+	if int(size) > r.r.Len() {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := make([]byte, size)
+	r.read(b)
+	return b
+}
+
+// done returns the first error found or an error if there is unread data.
+func (r *tpmReader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.r.Len() > 0 {
+		return errors.Errorf("unexpected %d bytes of trailing data", r.r.Len())
+	}
+	return nil
+}
+
+// tpmHash returns the crypto.Hash for the given TPM hash algorithm.
+func tpmHash(alg uint16) (crypto.Hash, error) {
+	switch alg {
+	case tpmAlgSHA1:
+		return crypto.SHA1, nil
+	case tpmAlgSHA256:
+		return crypto.SHA256, nil
+	case tpmAlgSHA384:
+		return crypto.SHA384, nil
+	case tpmAlgSHA512:
+		return crypto.SHA512, nil
+	default:
+		return 0, errors.Errorf("unsupported tpm hash algorithm 0x%04x", alg)
+	}
+}
+
+// tpmPublic is a parsed TPMT_PUBLIC structure.
+type tpmPublic struct {
+	Type       uint16
+	NameAlg    uint16
+	Attributes uint32
+	Key        crypto.PublicKey
+	raw        []byte
+}
+
+// parseTPMPublic parses a TPMT_PUBLIC structure with an RSA or ECC key.
+func parseTPMPublic(b []byte) (*tpmPublic, error) {
+	r := newTPMReader(b)
+	pub := &tpmPublic{
+		Type:       r.u16(),
+		NameAlg:    r.u16(),
+		Attributes: r.u32(),
+		raw:        b,
+	}
+	r.tpm2b() // authPolicy
+
+	// TPMT_SYM_DEF_OBJECT
+	if alg := r.u16(); alg != tpmAlgNull {
+		r.u16() // keyBits
+		r.u16() // mode
+	}
+	// TPMT_RSA_SCHEME or TPMT_ECC_SCHEME
+	switch scheme := r.u16(); scheme {
+	case tpmAlgNull:
+	case tpmAlgECDAA:
+		return nil, errors.New("unsupported tpm key scheme ECDAA")
+	default:
+		r.u16() // hashAlg
+	}
+
+	switch pub.Type {
+	case tpmAlgRSA:
+		bits := r.u16()
+		exponent := r.u32()
+		modulus := r.tpm2b()
+		if err := r.done(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if exponent == 0 {
+			exponent = 65537
+		}
+		if len(modulus)*8 != int(bits) {
+			return nil, errors.Errorf("tpm rsa modulus size does not match the key size %d", bits)
+		}
+		pub.Key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(exponent),
+		}
+	case tpmAlgECC:
+		curveID := r.u16()
+		// TPMT_KDF_SCHEME
+		if kdf := r.u16(); kdf != tpmAlgNull {
+			r.u16() // hashAlg
+		}
+		x, y := r.tpm2b(), r.tpm2b()
+		if err := r.done(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		var curve elliptic.Curve
+		switch curveID {
+		case tpmECCNistP256:
+			curve = elliptic.P256()
+		case tpmECCNistP384:
+			curve = elliptic.P384()
+		case tpmECCNistP521:
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported tpm ecc curve 0x%04x", curveID)
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("tpm ecc point is not on the curve")
+		}
+		pub.Key = key
+	default:
+		return nil, errors.Errorf("unsupported tpm key type 0x%04x", pub.Type)
+	}
+
+	return pub, nil
+}
+
+// Name returns the TPM name of the object, the name algorithm followed by the
+// digest of the public area.
+func (p *tpmPublic) Name() ([]byte, error) {
+	h, err := tpmHash(p.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	hash := h.New()
+	hash.Write(p.raw)
+	name := make([]byte, 2, 2+hash.Size())
+	binary.BigEndian.PutUint16(name, p.NameAlg)
+	return hash.Sum(name), nil
+}
+
+// HasAttributes returns true if all the given object attributes are set.
+func (p *tpmPublic) HasAttributes(attrs uint32) bool {
+	return p.Attributes&attrs == attrs
+}
+
+// tpmCertifyInfo is the parsed TPMS_ATTEST structure generated by
+// TPM2_Certify.
+type tpmCertifyInfo struct {
+	ExtraData     []byte
+	Name          []byte
+	QualifiedName []byte
+}
+
+// parseTPMCertifyInfo parses a TPMS_ATTEST structure of type
+// TPM_ST_ATTEST_CERTIFY.
+func parseTPMCertifyInfo(b []byte) (*tpmCertifyInfo, error) {
+	r := newTPMReader(b)
+	magic := r.u32()
+	typ := r.u16()
+	r.tpm2b() // qualifiedSigner
+	info := &tpmCertifyInfo{
+		ExtraData: r.tpm2b(),
+	}
+	// TPMS_CLOCK_INFO
+	r.u64() // clock
+	r.u32() // resetCount
+	r.u32() // restartCount
+	r.u8()  // safe
+	r.u64() // firmwareVersion
+	// TPMS_CERTIFY_INFO
+	info.Name = r.tpm2b()
+	info.QualifiedName = r.tpm2b()
+	if err := r.done(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+
+	switch {
+	case magic != tpmGeneratedValue:
+		return nil, errors.New("tpm attestation was not generated by a tpm")
+	case typ != tpmSTAttestCertify:
+		return nil, errors.Errorf("unexpected tpm attestation type 0x%04x", typ)
+	}
+	return info, nil
+}
+
+// verifyTPMSignature verifies that the TPMT_SIGNATURE sig is a valid signature
+// of data using the given public key.
+func verifyTPMSignature(pub crypto.PublicKey, data, sig []byte) error {
+	r := newTPMReader(sig)
+	alg := r.u16()
+	h, err := tpmHash(r.u16())
+	if err != nil {
+		return err
+	}
+	hash := h.New()
+	hash.Write(data)
+	digest := hash.Sum(nil)
+
+	switch alg {
+	case tpmAlgRSASSA, tpmAlgRSAPSS:
+		s := r.tpm2b()
+		if err := r.done(); err != nil {
+			return errors.Wrap(err, "error parsing tpm signature")
+		}
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.Errorf("tpm signature algorithm 0x%04x does not match key type %T", alg, pub)
+		}
+		if alg == tpmAlgRSASSA {
+			err = rsa.VerifyPKCS1v15(key, h, digest, s)
+		} else {
+			err = rsa.VerifyPSS(key, h, digest, s, nil)
+		}
+		return errors.Wrap(err, "error verifying tpm signature")
+	case tpmAlgECDSA:
+		rb, sb := r.tpm2b(), r.tpm2b()
+		if err := r.done(); err != nil {
+			return errors.Wrap(err, "error parsing tpm signature")
+		}
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.Errorf("tpm signature algorithm 0x%04x does not match key type %T", alg, pub)
+		}
+		if !ecdsa.Verify(key, digest, new(big.Int).SetBytes(rb), new(big.Int).SetBytes(sb)) {
+			return errors.New("error verifying tpm signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported tpm signature algorithm 0x%04x", alg)
+	}
+}
@@ -0,0 +1,253 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func writeTPM2B(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint16(len(b)))
+	buf.Write(b)
+}
+
+func paddedBytes(b []byte, size int) []byte {
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+func encodeTPMECCPublic(key *ecdsa.PublicKey, attrs uint32) []byte {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	var curveID uint16
+	switch key.Curve {
+	case elliptic.P256():
+		curveID = tpmECCNistP256
+	case elliptic.P384():
+		curveID = tpmECCNistP384
+	case elliptic.P521():
+		curveID = tpmECCNistP521
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, tpmAlgECC)
+	binary.Write(buf, binary.BigEndian, tpmAlgSHA256)
+	binary.Write(buf, binary.BigEndian, attrs)
+	writeTPM2B(buf, nil)                              // authPolicy
+	binary.Write(buf, binary.BigEndian, tpmAlgNull)   // symmetric
+	binary.Write(buf, binary.BigEndian, tpmAlgECDSA)  // scheme
+	binary.Write(buf, binary.BigEndian, tpmAlgSHA256) // scheme hash
+	binary.Write(buf, binary.BigEndian, curveID)      // curveID
+	binary.Write(buf, binary.BigEndian, tpmAlgNull)   // kdf
+	writeTPM2B(buf, paddedBytes(key.X.Bytes(), size))
+	writeTPM2B(buf, paddedBytes(key.Y.Bytes(), size))
+	return buf.Bytes()
+}
+
+func encodeTPMRSAPublic(key *rsa.PublicKey, attrs uint32) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, tpmAlgRSA)
+	binary.Write(buf, binary.BigEndian, tpmAlgSHA256)
+	binary.Write(buf, binary.BigEndian, attrs)
+	writeTPM2B(buf, nil)                                      // authPolicy
+	binary.Write(buf, binary.BigEndian, tpmAlgNull)           // symmetric
+	binary.Write(buf, binary.BigEndian, tpmAlgNull)           // scheme
+	binary.Write(buf, binary.BigEndian, uint16(key.Size()*8)) // keyBits
+	binary.Write(buf, binary.BigEndian, uint32(0))            // default exponent
+	writeTPM2B(buf, key.N.Bytes())
+	return buf.Bytes()
+}
+
+func encodeTPMCertifyInfo(extraData, name []byte) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, tpmGeneratedValue)
+	binary.Write(buf, binary.BigEndian, tpmSTAttestCertify)
+	writeTPM2B(buf, []byte("qualified-signer"))
+	writeTPM2B(buf, extraData)
+	binary.Write(buf, binary.BigEndian, uint64(1000)) // clock
+	binary.Write(buf, binary.BigEndian, uint32(1))    // resetCount
+	binary.Write(buf, binary.BigEndian, uint32(2))    // restartCount
+	binary.Write(buf, binary.BigEndian, uint8(1))     // safe
+	binary.Write(buf, binary.BigEndian, uint64(3))    // firmwareVersion
+	writeTPM2B(buf, name)
+	writeTPM2B(buf, []byte("qualified-name"))
+	return buf.Bytes()
+}
+
+func signTPMECDSA(key *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, tpmAlgECDSA)
+	binary.Write(buf, binary.BigEndian, tpmAlgSHA256)
+	writeTPM2B(buf, r.Bytes())
+	writeTPM2B(buf, s.Bytes())
+	return buf.Bytes(), nil
+}
+
+func signTPMRSA(key *rsa.PrivateKey, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, tpmAlgRSASSA)
+	binary.Write(buf, binary.BigEndian, tpmAlgSHA256)
+	writeTPM2B(buf, sig)
+	return buf.Bytes(), nil
+}
+
+func Test_parseTPMPublic(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.FatalError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	ecPublic := encodeTPMECCPublic(&ecKey.PublicKey, tpmAttrFixedTPM|tpmAttrSign)
+	rsaPublic := encodeTPMRSAPublic(&rsaKey.PublicKey, tpmAttrFixedTPM)
+	unknownCurve := append([]byte{}, ecPublic...)
+	binary.BigEndian.PutUint16(unknownCurve[16:], 0x0010)
+	unknownType := append([]byte{}, rsaPublic...)
+	binary.BigEndian.PutUint16(unknownType, 0x0008)
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    *tpmPublic
+		wantErr bool
+	}{
+		{"ok ecc", ecPublic, &tpmPublic{Type: tpmAlgECC, NameAlg: tpmAlgSHA256, Attributes: tpmAttrFixedTPM | tpmAttrSign, Key: &ecKey.PublicKey, raw: ecPublic}, false},
+		{"ok rsa", rsaPublic, &tpmPublic{Type: tpmAlgRSA, NameAlg: tpmAlgSHA256, Attributes: tpmAttrFixedTPM, Key: &rsaKey.PublicKey, raw: rsaPublic}, false},
+		{"fail empty", nil, nil, true},
+		{"fail truncated", ecPublic[:len(ecPublic)-1], nil, true},
+		{"fail trailing data", append(append([]byte{}, rsaPublic...), 0), nil, true},
+		{"fail curve", unknownCurve, nil, true},
+		{"fail type", unknownType, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTPMPublic(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTPMPublic() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTPMPublic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tpmPublic_Name(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	b := encodeTPMECCPublic(&key.PublicKey, tpmAttrFixedTPM)
+	sum := sha256.Sum256(b)
+
+	tests := []struct {
+		name    string
+		pub     *tpmPublic
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", &tpmPublic{NameAlg: tpmAlgSHA256, raw: b}, append([]byte{0x00, 0x0B}, sum[:]...), false},
+		{"fail", &tpmPublic{NameAlg: tpmAlgNull, raw: b}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.pub.Name()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tpmPublic.Name() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tpmPublic.Name() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseTPMCertifyInfo(t *testing.T) {
+	ok := encodeTPMCertifyInfo([]byte("nonce"), []byte("name"))
+	badMagic := append([]byte{}, ok...)
+	badMagic[0] = 0
+	badType := append([]byte{}, ok...)
+	binary.BigEndian.PutUint16(badType[4:], 0x8018)
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    *tpmCertifyInfo
+		wantErr bool
+	}{
+		{"ok", ok, &tpmCertifyInfo{ExtraData: []byte("nonce"), Name: []byte("name"), QualifiedName: []byte("qualified-name")}, false},
+		{"fail empty", nil, nil, true},
+		{"fail truncated", ok[:len(ok)-2], nil, true},
+		{"fail magic", badMagic, nil, true},
+		{"fail type", badType, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTPMCertifyInfo(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTPMCertifyInfo() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTPMCertifyInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyTPMSignature(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	data := []byte("the-data")
+	ecSig, err := signTPMECDSA(ecKey, data)
+	assert.FatalError(t, err)
+	rsaSig, err := signTPMRSA(rsaKey, data)
+	assert.FatalError(t, err)
+
+	type args struct {
+		pub  crypto.PublicKey
+		data []byte
+		sig  []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok ecdsa", args{ecKey.Public(), data, ecSig}, false},
+		{"ok rsa", args{rsaKey.Public(), data, rsaSig}, false},
+		{"fail ecdsa data", args{ecKey.Public(), []byte("other"), ecSig}, true},
+		{"fail rsa data", args{rsaKey.Public(), []byte("other"), rsaSig}, true},
+		{"fail ecdsa key", args{rsaKey.Public(), data, ecSig}, true},
+		{"fail rsa key", args{ecKey.Public(), data, rsaSig}, true},
+		{"fail empty", args{ecKey.Public(), data, nil}, true},
+		{"fail algorithm", args{ecKey.Public(), data, []byte{0x00, 0x10, 0x00, 0x0B}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyTPMSignature(tt.args.pub, tt.args.data, tt.args.sig); (err != nil) != tt.wantErr {
+				t.Errorf("verifyTPMSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
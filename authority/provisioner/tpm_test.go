@@ -0,0 +1,689 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+func TestTPM_Getters(t *testing.T) {
+	p, _, err := generateTPM()
+	assert.FatalError(t, err)
+	id := "tpm/" + p.Name
+	if got := p.GetID(); got != id {
+		t.Errorf("TPM.GetID() = %v, want %v", got, id)
+	}
+	if got := p.GetName(); got != p.Name {
+		t.Errorf("TPM.GetName() = %v, want %v", got, p.Name)
+	}
+	if got := p.GetType(); got != TypeTPM {
+		t.Errorf("TPM.GetType() = %v, want %v", got, TypeTPM)
+	}
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("TPM.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+}
+
+func TestTPM_GetTokenID(t *testing.T) {
+	p, dev, err := generateTPM()
+	assert.FatalError(t, err)
+	tok, err := generateTPMToken("foo", p.Name, testAudiences.Sign[0], nil, time.Now(), dev)
+	assert.FatalError(t, err)
+
+	got, err := p.GetTokenID(tok)
+	assert.FatalError(t, err)
+	assert.Equals(t, "the-jti", got)
+
+	_, err = p.GetTokenID("foo")
+	assert.NotNil(t, err)
+}
+
+func TestTPM_Init(t *testing.T) {
+	p, _, err := generateTPM()
+	assert.FatalError(t, err)
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+
+	tests := map[string]struct {
+		p   *TPM
+		err error
+	}{
+		"fail/empty":      {&TPM{}, errors.New("provisioner type cannot be empty")},
+		"fail/empty-name": {&TPM{Type: "TPM"}, errors.New("provisioner name cannot be empty")},
+		"fail/empty-roots": {&TPM{Type: "TPM", Name: "foo"},
+			errors.New("provisioner manufacturer root(s) cannot be empty")},
+		"fail/no-valid-root-certs": {&TPM{Type: "TPM", Name: "foo", ManufacturerRoots: []byte("foo"), AttestationRoots: p.AttestationRoots},
+			errors.New("no x509 certificates found in manufacturerRoots attribute for provisioner foo")},
+		"fail/empty-attestation-roots": {&TPM{Type: "TPM", Name: "foo", ManufacturerRoots: p.ManufacturerRoots},
+			errors.New("provisioner attestation root(s) cannot be empty")},
+		"fail/no-valid-attestation-certs": {&TPM{Type: "TPM", Name: "foo", ManufacturerRoots: p.ManufacturerRoots, AttestationRoots: []byte("foo")},
+			errors.New("no x509 certificates found in attestationRoots attribute for provisioner foo")},
+		"fail/invalid-duration": {&TPM{Type: "TPM", Name: "foo", ManufacturerRoots: p.ManufacturerRoots, AttestationRoots: p.AttestationRoots, Claims: &Claims{DefaultTLSDur: &Duration{0}}},
+			errors.New("claims: DefaultTLSCertDuration must be greater than 0")},
+		"ok": {&TPM{Type: "TPM", Name: "foo", ManufacturerRoots: p.ManufacturerRoots, AttestationRoots: p.AttestationRoots}, nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.p.Init(config)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, config.Audiences.WithFragment(tc.p.GetID()), tc.p.audiences)
+					assert.Len(t, 1, tc.p.rootPool.Subjects())
+					assert.Len(t, 1, tc.p.attestationPool.Subjects())
+				}
+			}
+		})
+	}
+}
+
+func TestTPM_authorizeToken(t *testing.T) {
+	p, dev, err := generateTPM()
+	assert.FatalError(t, err)
+	other, otherDev, err := generateTPM()
+	assert.FatalError(t, err)
+	akPublic := encodeTPMECCPublic(&dev.ak.PublicKey, tpmAttrFixedTPM|tpmAttrSign)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	sum := sha256.Sum256(dev.ekCerts[0].RawSubjectPublicKeyInfo)
+	deviceID := "urn:ek:sha256:" + hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	aud := testAudiences.Sign[0]
+
+	type test struct {
+		token string
+		err   error
+	}
+	tests := map[string]func(*testing.T) test{
+		"fail/invalid-token": func(t *testing.T) test {
+			return test{
+				token: "foo",
+				err:   errors.New("tpm.authorizeToken; error parsing tpm token"),
+			}
+		},
+		"fail/no-attestation": func(t *testing.T) test {
+			tok, err := generateToken("foo", p.Name, aud, "", nil, now, jwk)
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken; tpm token must contain an attestation"),
+			}
+		},
+		"fail/untrusted-ek": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.EKCerts = [][]byte{otherDev.ekCerts[0].Raw}
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: error verifying endorsement key certificate"),
+			}
+		},
+		"fail/empty-ek": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.EKCerts = nil
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: endorsement key certificate cannot be empty"),
+			}
+		},
+		"fail/empty-ak-cert": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.AKCerts = nil
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: attestation key certificate cannot be empty"),
+			}
+		},
+		"fail/untrusted-ak-cert": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.AKCerts = [][]byte{otherDev.akCerts[0].Raw}
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: error verifying attestation key certificate"),
+			}
+		},
+		"fail/ak-cert-as-ek": func(t *testing.T) test {
+			// The attestation roots are not trusted for endorsement keys.
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.EKCerts = [][]byte{dev.akCerts[0].Raw}
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: error verifying endorsement key certificate"),
+			}
+		},
+		"fail/software-ak": func(t *testing.T) test {
+			// A public EK certificate and the AK certificate of the device
+			// do not allow to sign the attestation with another key.
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, &tpmTestDevice{
+				ekCerts: dev.ekCerts,
+				akCerts: dev.akCerts,
+				ak:      otherKey,
+				key:     dev.key,
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: attestation key certificate does not certify the attestation key"),
+			}
+		},
+		"fail/ak-cert-device": func(t *testing.T) test {
+			// The AK certificate of another device cannot be used with the
+			// public EK certificate of this one.
+			akCert, err := dev.akCertificate(dev.ak.Public(), otherDev.ekCerts[0])
+			assert.FatalError(t, err)
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.AKCerts = [][]byte{akCert.Raw}
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: attestation key certificate does not certify the endorsement key"),
+			}
+		},
+		"fail/unrestricted-ak": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.AKPublic = akPublic
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: attestation key is not a restricted signing key generated by the tpm"),
+			}
+		},
+		"fail/signature": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				sig, err := signTPMECDSA(otherKey, att.CertifyInfo)
+				assert.FatalError(t, err)
+				att.Signature = sig
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: error verifying tpm signature"),
+			}
+		},
+		"fail/nonce": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				pub, err := parseTPMPublic(att.Public)
+				assert.FatalError(t, err)
+				name, err := pub.Name()
+				assert.FatalError(t, err)
+				att.CertifyInfo = encodeTPMCertifyInfo([]byte("nonce"), name)
+				att.Signature, err = signTPMECDSA(dev.ak, att.CertifyInfo)
+				assert.FatalError(t, err)
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: tpm attestation is not bound to the token id"),
+			}
+		},
+		"fail/attested-key": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev, func(att *tpmAttestation) {
+				att.Public = encodeTPMECCPublic(&otherKey.PublicKey, tpmAttrFixedTPM|tpmAttrSign)
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken: tpm attestation does not certify the attested key"),
+			}
+		},
+		"fail/token-signature": func(t *testing.T) test {
+			// The token is signed with otherKey but the attestation certifies
+			// the device key.
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, &tpmTestDevice{
+				ekCerts: dev.ekCerts,
+				akCerts: dev.akCerts,
+				ak:      dev.ak,
+				key:     otherKey,
+			}, func(att *tpmAttestation) {
+				att.Public = encodeTPMECCPublic(&dev.key.PublicKey, tpmAttrFixedTPM|tpmAttrSign)
+				pub, err := parseTPMPublic(att.Public)
+				assert.FatalError(t, err)
+				name, err := pub.Name()
+				assert.FatalError(t, err)
+				nonce := sha256.Sum256([]byte("the-jti"))
+				att.CertifyInfo = encodeTPMCertifyInfo(nonce[:], name)
+				att.Signature, err = signTPMECDSA(dev.ak, att.CertifyInfo)
+				assert.FatalError(t, err)
+			})
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken; error parsing tpm claims"),
+			}
+		},
+		"fail/issuer": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", other.Name, aud, nil, now, dev)
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken; invalid tpm claims"),
+			}
+		},
+		"fail/expired": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now.Add(-time.Hour), dev)
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken; invalid tpm claims"),
+			}
+		},
+		"fail/audience": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, "https://foo.com", nil, now, dev)
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken; tpm token has invalid audience claim (aud)"),
+			}
+		},
+		"fail/subject": func(t *testing.T) test {
+			tok, err := generateTPMToken("", p.Name, aud, nil, now, dev)
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+				err:   errors.New("tpm.authorizeToken; tpm token subject cannot be empty"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, aud, nil, now, dev)
+			assert.FatalError(t, err)
+			return test{
+				token: tok,
+			}
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := tt(t)
+			if claims, err := p.authorizeToken(tc.token, testAudiences.Sign); err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, claims.Subject, "foo")
+					assert.Equals(t, claims.ekCert.Raw, dev.ekCerts[0].Raw)
+					assert.Equals(t, claims.deviceID.String(), deviceID)
+					assert.Equals(t, claims.key, &dev.key.PublicKey)
+				}
+			}
+		})
+	}
+}
+
+func TestTPM_AuthorizeSign(t *testing.T) {
+	p, dev, err := generateTPM()
+	assert.FatalError(t, err)
+	sum := sha256.Sum256(dev.ekCerts[0].RawSubjectPublicKeyInfo)
+	deviceID := "urn:ek:sha256:" + hex.EncodeToString(sum[:])
+
+	// With name constraints the token can set any subject and SANs.
+	constrained := *p
+	constrained.NameConstraints = &NameConstraints{PermittedDNSDomains: []string{"foo"}}
+
+	type test struct {
+		p       *TPM
+		token   string
+		code    int
+		err     error
+		subject string
+		sans    []string
+	}
+	tests := map[string]func(*testing.T) test{
+		"fail/invalid-token": func(t *testing.T) test {
+			return test{
+				p:     p,
+				token: "foo",
+				code:  http.StatusUnauthorized,
+				err:   errors.New("tpm.AuthorizeSign: tpm.authorizeToken; error parsing tpm token"),
+			}
+		},
+		"fail/subject": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, testAudiences.Sign[0], []string{deviceID}, time.Now(), dev)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("tpm.AuthorizeSign; tpm token subject must be the device identifier " + deviceID),
+			}
+		},
+		"fail/sans": func(t *testing.T) test {
+			tok, err := generateTPMToken(deviceID, p.Name, testAudiences.Sign[0], []string{deviceID, "foo"}, time.Now(), dev)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("tpm.AuthorizeSign; tpm token SAN foo is not allowed"),
+			}
+		},
+		"ok/empty-sans": func(t *testing.T) test {
+			tok, err := generateTPMToken(deviceID, p.Name, testAudiences.Sign[0], nil, time.Now(), dev)
+			assert.FatalError(t, err)
+			return test{
+				p:       p,
+				token:   tok,
+				subject: deviceID,
+				sans:    []string{deviceID},
+			}
+		},
+		"ok/device-sans": func(t *testing.T) test {
+			tok, err := generateTPMToken(deviceID, p.Name, testAudiences.Sign[0], []string{deviceID}, time.Now(), dev)
+			assert.FatalError(t, err)
+			return test{
+				p:       p,
+				token:   tok,
+				subject: deviceID,
+				sans:    []string{deviceID},
+			}
+		},
+		"ok/name-constraints": func(t *testing.T) test {
+			tok, err := generateTPMToken("foo", p.Name, testAudiences.Sign[0],
+				[]string{"127.0.0.1", "foo", "max@smallstep.com"}, time.Now(), dev)
+			assert.FatalError(t, err)
+			return test{
+				p:       &constrained,
+				token:   tok,
+				subject: "foo",
+				sans:    []string{"127.0.0.1", "foo", "max@smallstep.com"},
+			}
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := tt(t)
+			if opts, err := tc.p.AuthorizeSign(context.Background(), tc.token); err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					if assert.NotNil(t, opts) {
+						if tc.p.NameConstraints != nil {
+							assert.Equals(t, len(opts), 10)
+						} else {
+							assert.Equals(t, len(opts), 9)
+						}
+						for _, o := range opts {
+							switch v := o.(type) {
+							case *provisionerExtensionOption:
+								assert.Equals(t, v.Type, int(TypeTPM))
+								assert.Equals(t, v.Name, p.GetName())
+								assert.Equals(t, v.CredentialID, "")
+								assert.Len(t, 0, v.KeyValuePairs)
+							case profileDefaultDuration:
+								assert.Equals(t, time.Duration(v), p.claimer.DefaultTLSCertDuration())
+							case tpmDeviceIDModifier:
+								assert.Equals(t, v.deviceID.String(), deviceID)
+							case commonNameValidator:
+								assert.Equals(t, string(v), tc.subject)
+							case defaultPublicKeyValidator:
+							case tpmPublicKeyValidator:
+								assert.Equals(t, v.key, &dev.key.PublicKey)
+							case *AuthorizedIdentity:
+								assert.Equals(t, v.Subject, tc.subject)
+								assert.Equals(t, v.Credential, deviceID)
+							case defaultSANsValidator:
+								assert.Equals(t, []string(v), tc.sans)
+							case *validityValidator:
+								assert.Equals(t, v.min, p.claimer.MinTLSCertDuration())
+								assert.Equals(t, v.max, p.claimer.MaxTLSCertDuration())
+							case nameConstraintsValidator:
+								assert.Equals(t, v.NameConstraints, tc.p.NameConstraints)
+							default:
+								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTPM_AuthorizeSSHSign(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	p1, dev, err := generateTPM()
+	assert.FatalError(t, err)
+	sum := sha256.Sum256(dev.ekCerts[0].RawSubjectPublicKeyInfo)
+	deviceID := "urn:ek:sha256:" + hex.EncodeToString(sum[:])
+
+	p2, _, err := generateTPM()
+	assert.FatalError(t, err)
+	// disable sshCA
+	disable := false
+	p2.Claims = &Claims{EnableSSHCA: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	t1, err := generateTPMToken("foo.local", p1.Name, p1.audiences.SSHSign[0], nil, time.Now(), dev)
+	assert.FatalError(t, err)
+	t2, err := generateTPMToken("foo.local", p1.Name, p1.audiences.SSHSign[0], []string{"foo.local", "127.0.0.1"}, time.Now(), dev)
+	assert.FatalError(t, err)
+
+	// Tokens for the X.509 sign endpoint cannot be used to get an ssh
+	// certificate.
+	failAudience, err := generateTPMToken("foo.local", p1.Name, p1.audiences.Sign[0], nil, time.Now(), dev)
+	assert.FatalError(t, err)
+
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+
+	// A software AK with the public EK certificate of the device cannot get a
+	// host certificate with the device identifier, with or without the AK
+	// certificate of the device.
+	softwareAK, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	failSoftwareAK, err := generateTPMToken("foo.local", p1.Name, p1.audiences.SSHSign[0], nil, time.Now(), &tpmTestDevice{
+		ekCerts: dev.ekCerts,
+		ak:      softwareAK,
+		key:     other,
+	})
+	assert.FatalError(t, err)
+	failSoftwareAKCert, err := generateTPMToken("foo.local", p1.Name, p1.audiences.SSHSign[0], nil, time.Now(), &tpmTestDevice{
+		ekCerts: dev.ekCerts,
+		akCerts: dev.akCerts,
+		ak:      softwareAK,
+		key:     other,
+	})
+	assert.FatalError(t, err)
+
+	hostDuration := p1.claimer.DefaultHostSSHCertDuration()
+	expectedHostOptions := &SSHOptions{
+		CertType: "host", Principals: []string{"foo.local"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(hostDuration)),
+	}
+	expectedSANsOptions := &SSHOptions{
+		CertType: "host", Principals: []string{"foo.local", "127.0.0.1"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(hostDuration)),
+	}
+
+	type args struct {
+		token   string
+		sshOpts SSHOptions
+		key     interface{}
+	}
+	tests := []struct {
+		name        string
+		tpm         *TPM
+		args        args
+		expected    *SSHOptions
+		code        int
+		wantErr     bool
+		wantSignErr bool
+	}{
+		{"ok", p1, args{t1, SSHOptions{}, dev.key.Public()}, expectedHostOptions, http.StatusOK, false, false},
+		{"ok-type", p1, args{t1, SSHOptions{CertType: "host"}, dev.key.Public()}, expectedHostOptions, http.StatusOK, false, false},
+		{"ok-sans", p1, args{t2, SSHOptions{}, dev.key.Public()}, expectedSANsOptions, http.StatusOK, false, false},
+		{"ok-principals", p1, args{t2, SSHOptions{Principals: []string{"foo.local"}}, dev.key.Public()}, expectedHostOptions, http.StatusOK, false, false},
+		{"fail-key", p1, args{t1, SSHOptions{}, other.Public()}, nil, http.StatusOK, false, true},
+		{"fail-type", p1, args{t1, SSHOptions{CertType: "user"}, dev.key.Public()}, nil, http.StatusOK, false, true},
+		{"fail-principal", p1, args{t1, SSHOptions{Principals: []string{"smallstep.com"}}, dev.key.Public()}, nil, http.StatusOK, false, true},
+		{"fail-sshCA-disabled", p2, args{"foo", SSHOptions{}, dev.key.Public()}, nil, http.StatusUnauthorized, true, false},
+		{"fail-invalid-token", p1, args{"foo", SSHOptions{}, dev.key.Public()}, nil, http.StatusUnauthorized, true, false},
+		{"fail-sign-audience", p1, args{failAudience, SSHOptions{}, dev.key.Public()}, nil, http.StatusUnauthorized, true, false},
+		{"fail-software-ak", p1, args{failSoftwareAK, SSHOptions{}, other.Public()}, nil, http.StatusUnauthorized, true, false},
+		{"fail-software-ak-cert", p1, args{failSoftwareAKCert, SSHOptions{}, other.Public()}, nil, http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.tpm.AuthorizeSSHSign(context.Background(), tt.args.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPM.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+			} else if assert.NotNil(t, got) {
+				cert, err := signSSHCertificate(tt.args.key, tt.args.sshOpts, got, signer.Key.(crypto.Signer))
+				if (err != nil) != tt.wantSignErr {
+					t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				} else {
+					if tt.wantSignErr {
+						assert.Nil(t, cert)
+					} else {
+						assert.NoError(t, validateSSHCertificate(cert, tt.expected))
+						assert.Equals(t, cert.KeyId, deviceID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTPM_AuthorizeRenew(t *testing.T) {
+	p1, _, err := generateTPM()
+	assert.FatalError(t, err)
+	p2, _, err := generateTPM()
+	assert.FatalError(t, err)
+
+	// disable renewal
+	disable := true
+	p2.Claims = &Claims{DisableRenewal: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		prov    *TPM
+		code    int
+		wantErr bool
+	}{
+		{"ok", p1, http.StatusOK, false},
+		{"fail", p2, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.prov.AuthorizeRenew(context.Background(), nil); (err != nil) != tt.wantErr {
+				t.Errorf("TPM.AuthorizeRenew() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+			}
+		})
+	}
+}
+
+func Test_tpmDeviceIDModifier_Option(t *testing.T) {
+	deviceID, err := url.Parse("urn:ek:sha256:abcd")
+	assert.FatalError(t, err)
+	other, err := url.Parse("spiffe://foo/bar")
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name string
+		uris []*url.URL
+		want []string
+	}{
+		{"empty", nil, []string{"urn:ek:sha256:abcd"}},
+		{"append", []*url.URL{other}, []string{"spiffe://foo/bar", "urn:ek:sha256:abcd"}},
+		{"present", []*url.URL{deviceID}, []string{"urn:ek:sha256:abcd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{URIs: tt.uris}
+			prof := &x509util.Leaf{}
+			prof.SetSubject(cert)
+			assert.FatalError(t, tpmDeviceIDModifier{deviceID}.Option(Options{})(prof))
+			got := make([]string, len(cert.URIs))
+			for i, u := range cert.URIs {
+				got[i] = u.String()
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func Test_tpmPublicKeyValidator_Valid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		req     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"ok", &x509.CertificateRequest{PublicKey: key.Public()}, false},
+		{"fail", &x509.CertificateRequest{PublicKey: other.Public()}, true},
+		{"fail nil", &x509.CertificateRequest{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (tpmPublicKeyValidator{key.Public()}).Valid(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("tpmPublicKeyValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
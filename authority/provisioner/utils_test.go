@@ -2,17 +2,23 @@ package provisioner
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"time"
 
 	"github.com/pkg/errors"
@@ -614,7 +620,7 @@ func generateAzure() (*Azure, error) {
 		Audience: azureDefaultAudience,
 		Claims:   &globalProvisionerClaims,
 		claimer:  claimer,
-		config:   newAzureConfig(tenantID),
+		config:   newAzureConfig(tenantID, "", "", "", ""),
 		oidcConfig: openIDConfiguration{
 			Issuer:    "https://sts.windows.net/" + tenantID + "/",
 			JWKSetURI: "https://login.microsoftonline.com/common/discovery/keys",
@@ -688,6 +694,160 @@ func generateAzureWithServer() (*Azure, *httptest.Server, error) {
 	return az, srv, nil
 }
 
+// tpmTestDevice contains the keys and certificates of a simulated TPM.
+type tpmTestDevice struct {
+	ekCerts []*x509.Certificate
+	akCerts []*x509.Certificate
+	ak      *ecdsa.PrivateKey
+	key     *ecdsa.PrivateKey
+	ca      *x509.Certificate
+	caKey   *ecdsa.PrivateKey
+}
+
+// akCertificate returns an attestation key certificate for the given key and
+// endorsement key certificate, issued by the attestation CA of the device.
+func (d *tpmTestDevice) akCertificate(ak crypto.PublicKey, ekCert *x509.Certificate) (*x509.Certificate, error) {
+	deviceID, err := tpmDeviceID(ekCert)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		URIs:         []*url.URL{deviceID},
+	}, d.ca, ak, d.caKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func generateTPM() (*TPM, *tpmTestDevice, error) {
+	name, err := randutil.Alphanumeric(10)
+	if err != nil {
+		return nil, nil, err
+	}
+	claimer, err := NewClaimer(nil, globalProvisionerClaims)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TPM Manufacturer Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// EK certificates contain a critical SAN with the TPM manufacturer
+	// information as a directory name.
+	rdn, err := asn1.Marshal(pkix.Name{CommonName: "id:54504D00"}.ToRDNSequence())
+	if err != nil {
+		return nil, nil, err
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: rdn},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ekKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	ekDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		KeyUsage:        x509.KeyUsageKeyAgreement,
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Critical: true, Value: san}},
+	}, root, ekKey.Public(), rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ek, err := x509.ParseCertificate(ekDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TPM Attestation CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ak, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dev := &tpmTestDevice{
+		ekCerts: []*x509.Certificate{ek},
+		ak:      ak,
+		key:     key,
+		ca:      ca,
+		caKey:   caKey,
+	}
+	akCert, err := dev.akCertificate(ak.Public(), ek)
+	if err != nil {
+		return nil, nil, err
+	}
+	dev.akCerts = []*x509.Certificate{akCert}
+
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(root)
+	attestationPool := x509.NewCertPool()
+	attestationPool.AddCert(ca)
+	return &TPM{
+		Type:              "TPM",
+		Name:              name,
+		ManufacturerRoots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}),
+		AttestationRoots:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		Claims:            &globalProvisionerClaims,
+		claimer:           claimer,
+		audiences:         testAudiences,
+		rootPool:          rootPool,
+		attestationPool:   attestationPool,
+	}, dev, nil
+}
+
 func generateCollection(nJWK, nOIDC int) (*Collection, error) {
 	col := NewCollection(testAudiences)
 	for i := 0; i < nJWK; i++ {
@@ -772,6 +932,32 @@ func generateToken(sub, iss, aud string, email string, sans []string, iat time.T
 	return jose.Signed(sig).Claims(claims).CompactSerialize()
 }
 
+func generateTokenWithClaims(iss, aud string, extra map[string]interface{}, jwk *jose.JSONWebKey) (string, error) {
+	so := new(jose.SignerOptions)
+	so.WithType("JWT")
+	so.WithHeader("kid", jwk.KeyID)
+
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key}, so)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub":   "subject",
+		"iss":   iss,
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"email": "name@smallstep.com",
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
 func generateX5CSSHToken(jwk *jose.JSONWebKey, claims *x5cPayload, tokOpts ...tokOption) (string, error) {
 	so := new(jose.SignerOptions)
 	so.WithType("JWT")
@@ -794,7 +980,7 @@ func getK8sSAPayload() *k8sSAPayload {
 	return &k8sSAPayload{
 		Claims: jose.Claims{
 			Issuer:  k8sSAIssuer,
-			Subject: "foo",
+			Subject: "system:serviceaccount:ns-foo:san-foo",
 		},
 		Namespace:          "ns-foo",
 		SecretName:         "sn-foo",
@@ -803,6 +989,31 @@ func getK8sSAPayload() *k8sSAPayload {
 	}
 }
 
+func getK8sSAProjectedPayload(issuer, audience string) *k8sSAPayload {
+	now := time.Now()
+	return &k8sSAPayload{
+		Claims: jose.Claims{
+			Issuer:    issuer,
+			Subject:   "system:serviceaccount:ns-foo:san-foo",
+			Audience:  []string{audience},
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(time.Hour)),
+		},
+		Kubernetes: &k8sSAKubernetesClaims{
+			Namespace: "ns-foo",
+			ServiceAccount: k8sSAObjectReference{
+				Name: "san-foo",
+				UID:  "sauid-foo",
+			},
+			Pod: &k8sSAObjectReference{
+				Name: "pod-foo",
+				UID:  "poduid-foo",
+			},
+		},
+	}
+}
+
 func generateK8sSAToken(jwk *jose.JSONWebKey, claims *k8sSAPayload, tokOpts ...tokOption) (string, error) {
 	so := new(jose.SignerOptions)
 	so.WithHeader("kid", jwk.KeyID)
@@ -873,6 +1084,14 @@ func generateSSHToken(sub, iss, aud string, iat time.Time, sshOpts *SSHOptions,
 }
 
 func generateGCPToken(sub, iss, aud, instanceID, instanceName, projectID, zone string, iat time.Time, jwk *jose.JSONWebKey) (string, error) {
+	aud, err := generateSignAudience("https://ca.smallstep.com", aud)
+	if err != nil {
+		return "", err
+	}
+	return generateGCPTokenWithAudience(sub, iss, aud, instanceID, instanceName, projectID, zone, iat, jwk)
+}
+
+func generateGCPTokenWithAudience(sub, iss, aud, instanceID, instanceName, projectID, zone string, iat time.Time, jwk *jose.JSONWebKey) (string, error) {
 	sig, err := jose.NewSigner(
 		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
 		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
@@ -880,10 +1099,6 @@ func generateGCPToken(sub, iss, aud, instanceID, instanceName, projectID, zone s
 	if err != nil {
 		return "", err
 	}
-	aud, err = generateSignAudience("https://ca.smallstep.com", aud)
-	if err != nil {
-		return "", err
-	}
 	claims := gcpPayload{
 		Claims: jose.Claims{
 			Subject:   sub,
@@ -911,6 +1126,14 @@ func generateGCPToken(sub, iss, aud, instanceID, instanceName, projectID, zone s
 }
 
 func generateAWSToken(sub, iss, aud, accountID, instanceID, privateIP, region string, iat time.Time, key crypto.Signer) (string, error) {
+	aud, err := generateSignAudience("https://ca.smallstep.com", aud)
+	if err != nil {
+		return "", err
+	}
+	return generateAWSTokenWithAudience(sub, iss, aud, accountID, instanceID, privateIP, region, iat, key)
+}
+
+func generateAWSTokenWithAudience(sub, iss, aud, accountID, instanceID, privateIP, region string, iat time.Time, key crypto.Signer) (string, error) {
 	doc, err := json.MarshalIndent(awsInstanceIdentityDocument{
 		AccountID:        accountID,
 		Architecture:     "x86_64",
@@ -941,11 +1164,6 @@ func generateAWSToken(sub, iss, aud, accountID, instanceID, privateIP, region st
 		return "", err
 	}
 
-	aud, err = generateSignAudience("https://ca.smallstep.com", aud)
-	if err != nil {
-		return "", err
-	}
-
 	claims := awsPayload{
 		Claims: jose.Claims{
 			Subject:   sub,
@@ -965,7 +1183,7 @@ func generateAWSToken(sub, iss, aud, accountID, instanceID, privateIP, region st
 
 func generateAzureToken(sub, iss, aud, tenantID, subscriptionID, resourceGroup, virtualMachine string, iat time.Time, jwk *jose.JSONWebKey) (string, error) {
 	sig, err := jose.NewSigner(
-		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		jose.SigningKey{Algorithm: jose.SignatureAlgorithm(jwk.Algorithm), Key: jwk.Key},
 		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
 	)
 	if err != nil {
@@ -989,6 +1207,70 @@ func generateAzureToken(sub, iss, aud, tenantID, subscriptionID, resourceGroup,
 		TenantID:         tenantID,
 		Version:          "the-version",
 		XMSMirID:         fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s", subscriptionID, resourceGroup, virtualMachine),
+		VMID:             "the-vmid",
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+func generateTPMToken(sub, iss, aud string, sans []string, iat time.Time, dev *tpmTestDevice, modifiers ...func(*tpmAttestation)) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: dev.key},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	id := "the-jti"
+	akPublic := encodeTPMECCPublic(&dev.ak.PublicKey, tpmAttrFixedTPM|tpmAttrFixedParent|tpmAttrSensitiveDataOrigin|tpmAttrRestricted|tpmAttrSign)
+	public := encodeTPMECCPublic(&dev.key.PublicKey, tpmAttrFixedTPM|tpmAttrFixedParent|tpmAttrSensitiveDataOrigin|tpmAttrSign)
+	pub, err := parseTPMPublic(public)
+	if err != nil {
+		return "", err
+	}
+	name, err := pub.Name()
+	if err != nil {
+		return "", err
+	}
+	nonce := sha256.Sum256([]byte(id))
+	certifyInfo := encodeTPMCertifyInfo(nonce[:], name)
+	signature, err := signTPMECDSA(dev.ak, certifyInfo)
+	if err != nil {
+		return "", err
+	}
+
+	ekCerts := make([][]byte, len(dev.ekCerts))
+	for i, crt := range dev.ekCerts {
+		ekCerts[i] = crt.Raw
+	}
+	akCerts := make([][]byte, len(dev.akCerts))
+	for i, crt := range dev.akCerts {
+		akCerts[i] = crt.Raw
+	}
+	att := &tpmAttestation{
+		EKCerts:     ekCerts,
+		AKCerts:     akCerts,
+		AKPublic:    akPublic,
+		Public:      public,
+		CertifyInfo: certifyInfo,
+		Signature:   signature,
+	}
+	for _, fn := range modifiers {
+		fn(att)
+	}
+
+	claims := tpmPayload{
+		Claims: jose.Claims{
+			ID:        id,
+			Subject:   sub,
+			Issuer:    iss,
+			IssuedAt:  jose.NewNumericDate(iat),
+			NotBefore: jose.NewNumericDate(iat),
+			Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+		},
+		SANs:        sans,
+		Attestation: att,
 	}
 	return jose.Signed(sig).Claims(claims).CompactSerialize()
 }
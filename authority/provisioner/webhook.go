@@ -0,0 +1,307 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultWebhookTimeout is the time to wait for the webhook response if the
+// timeout is not configured.
+const defaultWebhookTimeout = 5 * time.Second
+
+// WebhookSignatureHeader is the header with the hex encoded HMAC-SHA256 of the
+// webhook request timestamp, a dot, and the request body, using the configured
+// secret as the key.
+const WebhookSignatureHeader = "X-Smallstep-Signature"
+
+// WebhookTimestampHeader is the header with the time the webhook request was
+// sent, in seconds since the Unix epoch. It is covered by the signature so a
+// captured request cannot be replayed after the tolerance of the webhook.
+const WebhookTimestampHeader = "X-Smallstep-Timestamp"
+
+// DefaultWebhookTolerance is the maximum difference between the timestamp of a
+// webhook request and the time it is verified if no tolerance is given to
+// VerifyWebhookRequest.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// maxWebhookResponseSize is the maximum number of bytes read from a webhook
+// response, a valid response is a small JSON document.
+const maxWebhookResponseSize = 64 * 1024
+
+// Webhook is the configuration of an external service that approves or denies
+// the X.509 and SSH certificate requests authorized by a provisioner.
+//
+// The CA will POST a JSON document with the provisioner, the authorized
+// identity, the token claims and the names in the certificate request to the
+// URL, and the certificate will only be signed if the service responds with a
+// 200 OK status and the JSON document {"allow": true}.
+//
+// If Secret is set, it must be a base64 encoded key, and the request will
+// include the WebhookTimestampHeader and WebhookSignatureHeader so the service
+// can verify it and reject stale requests, e.g. with VerifyWebhookRequest. The
+// response must then include a WebhookSignatureHeader too, see
+// SignWebhookResponse. The URL must use https unless a Secret is set.
+type Webhook struct {
+	URL     string    `json:"url"`
+	Secret  string    `json:"secret,omitempty"`
+	Timeout *Duration `json:"timeout,omitempty"`
+}
+
+// Validate validates the webhook configuration.
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	switch {
+	case w.URL == "":
+		return errors.New("webhook url cannot be empty")
+	case err != nil:
+		return errors.Wrapf(err, "error parsing webhook url %s", w.URL)
+	case u.Scheme == "http" && w.Secret == "":
+		return errors.Errorf("webhook url %s must use https, http is only allowed with a secret", w.URL)
+	case u.Scheme != "https" && u.Scheme != "http":
+		return errors.Errorf("webhook url %s must use https", w.URL)
+	case w.Timeout != nil && w.Timeout.Duration < 0:
+		return errors.New("webhook timeout cannot be negative")
+	}
+	if _, err := w.secret(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *Webhook) secret() ([]byte, error) {
+	if w.Secret == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(w.Secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding webhook secret")
+	}
+	return b, nil
+}
+
+func (w *Webhook) timeout() time.Duration {
+	if w.Timeout == nil || w.Timeout.Duration == 0 {
+		return defaultWebhookTimeout
+	}
+	return w.Timeout.Duration
+}
+
+// webhookRequest is the body sent to the webhook. The certificate type is
+// "x509" or "ssh", X.509 requests have the subject and the SANs of the
+// certificate request, and SSH requests the type, key id and principals of the
+// certificate.
+type webhookRequest struct {
+	ProvisionerName string              `json:"provisionerName"`
+	ProvisionerType string              `json:"provisionerType"`
+	CertificateType string              `json:"certificateType"`
+	Identity        *AuthorizedIdentity `json:"identity,omitempty"`
+	Claims          interface{}         `json:"claims,omitempty"`
+	CommonName      string              `json:"commonName,omitempty"`
+	DNSNames        []string            `json:"dnsNames,omitempty"`
+	IPAddresses     []string            `json:"ipAddresses,omitempty"`
+	EmailAddresses  []string            `json:"emailAddresses,omitempty"`
+	URIs            []string            `json:"uris,omitempty"`
+	SSHCertType     string              `json:"sshCertType,omitempty"`
+	KeyID           string              `json:"keyID,omitempty"`
+	Principals      []string            `json:"principals,omitempty"`
+}
+
+// webhookResponse is the body expected from the webhook.
+type webhookResponse struct {
+	Allow bool `json:"allow"`
+}
+
+// webhookValidator is a CertificateRequestValidator that sends the certificate
+// request and the identity that authorized it to a webhook.
+type webhookValidator struct {
+	webhook         *Webhook
+	provisionerName string
+	provisionerType Type
+	identity        *AuthorizedIdentity
+	claims          interface{}
+}
+
+func newWebhookValidator(w *Webhook, typ Type, name string, identity *AuthorizedIdentity, claims interface{}) *webhookValidator {
+	return &webhookValidator{
+		webhook:         w,
+		provisionerName: name,
+		provisionerType: typ,
+		identity:        identity,
+		claims:          claims,
+	}
+}
+
+// Valid sends the certificate request to the webhook and returns an error if
+// the webhook does not allow it.
+func (v *webhookValidator) Valid(req *x509.CertificateRequest) error {
+	wr := v.request("x509")
+	wr.CommonName = req.Subject.CommonName
+	wr.DNSNames = req.DNSNames
+	wr.EmailAddresses = req.EmailAddresses
+	for _, ip := range req.IPAddresses {
+		wr.IPAddresses = append(wr.IPAddresses, ip.String())
+	}
+	for _, u := range req.URIs {
+		wr.URIs = append(wr.URIs, u.String())
+	}
+	return v.send(wr)
+}
+
+func (v *webhookValidator) request(certificateType string) *webhookRequest {
+	return &webhookRequest{
+		ProvisionerName: v.provisionerName,
+		ProvisionerType: v.provisionerType.String(),
+		CertificateType: certificateType,
+		Identity:        v.identity,
+		Claims:          v.claims,
+	}
+}
+
+// send posts the request to the webhook and returns an error if the webhook
+// does not allow it.
+func (v *webhookValidator) send(wr *webhookRequest) error {
+	body, err := json.Marshal(wr)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling webhook request")
+	}
+	r, err := http.NewRequest("POST", v.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error creating webhook request")
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	secret, err := v.webhook.secret()
+	if err != nil {
+		return err
+	}
+	var signature string
+	if secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		signature = webhookSignature(secret, timestamp, body)
+		r.Header.Set(WebhookTimestampHeader, timestamp)
+		r.Header.Set(WebhookSignatureHeader, signature)
+	}
+
+	client := http.Client{
+		Timeout: v.webhook.timeout(),
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return errors.Wrapf(err, "error requesting webhook %s", v.webhook.URL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("webhook %s responded with status code %d", v.webhook.URL, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return errors.Wrapf(err, "error reading webhook %s response", v.webhook.URL)
+	}
+	// The response is bound to the request by signing it with the signature
+	// of the request.
+	if secret != nil {
+		got := resp.Header.Get(WebhookSignatureHeader)
+		if !hmac.Equal([]byte(got), []byte(webhookSignature(secret, signature, b))) {
+			return errors.Errorf("webhook %s response has an invalid signature", v.webhook.URL)
+		}
+	}
+	var wresp webhookResponse
+	if err := json.Unmarshal(b, &wresp); err != nil {
+		return errors.Wrapf(err, "error decoding webhook %s response", v.webhook.URL)
+	}
+	if !wresp.Allow {
+		return errors.Errorf("certificate request denied by webhook %s", v.webhook.URL)
+	}
+	return nil
+}
+
+// sshWebhookValidator is a SSHCertValidator that sends the SSH certificate and
+// the identity that authorized it to a webhook.
+type sshWebhookValidator struct {
+	*webhookValidator
+}
+
+func newSSHWebhookValidator(w *Webhook, typ Type, name string, identity *AuthorizedIdentity, claims interface{}) *sshWebhookValidator {
+	return &sshWebhookValidator{
+		webhookValidator: newWebhookValidator(w, typ, name, identity, claims),
+	}
+}
+
+// Valid sends the SSH certificate to the webhook and returns an error if the
+// webhook does not allow it.
+func (v *sshWebhookValidator) Valid(cert *ssh.Certificate, opts SSHOptions) error {
+	wr := v.request("ssh")
+	wr.KeyID = cert.KeyId
+	wr.Principals = cert.ValidPrincipals
+	switch cert.CertType {
+	case ssh.UserCert:
+		wr.SSHCertType = SSHUserCert
+	case ssh.HostCert:
+		wr.SSHCertType = SSHHostCert
+	}
+	return v.send(wr)
+}
+
+// webhookSignature returns the hex encoded HMAC-SHA256 of the timestamp, a dot,
+// and the body.
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookRequest verifies the signature of a request sent by a webhook
+// with the given secret and returns its body. It returns an error if the
+// signature is not valid or if the timestamp of the request differs from the
+// current time by more than the given tolerance, DefaultWebhookTolerance if it
+// is 0. The signature of an accepted request can be stored for the tolerance
+// to reject its replays.
+func VerifyWebhookRequest(r *http.Request, secret []byte, tolerance time.Duration) ([]byte, error) {
+	if tolerance == 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading webhook request")
+	}
+
+	timestamp := r.Header.Get(WebhookTimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("webhook request has an invalid %s header", WebhookTimestampHeader)
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return nil, errors.Errorf("webhook request timestamp is outside the tolerance of %s", tolerance)
+	}
+
+	signature := r.Header.Get(WebhookSignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(webhookSignature(secret, timestamp, body))) {
+		return nil, errors.New("webhook request has an invalid signature")
+	}
+	return body, nil
+}
+
+// SignWebhookResponse sets the WebhookSignatureHeader of a webhook response
+// with the given body to the hex encoded HMAC-SHA256 of the signature of the
+// request, a dot, and the body. It must be called before writing the body.
+func SignWebhookResponse(w http.ResponseWriter, r *http.Request, secret, body []byte) {
+	w.Header().Set(WebhookSignatureHeader, webhookSignature(secret, r.Header.Get(WebhookSignatureHeader), body))
+}
@@ -0,0 +1,319 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWebhook_Validate(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("the-secret"))
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		wantErr bool
+	}{
+		{"ok", &Webhook{URL: "https://webhook.smallstep.com/sign"}, false},
+		{"ok http with secret", &Webhook{URL: "http://127.0.0.1:8080", Secret: secret}, false},
+		{"ok secret and timeout", &Webhook{URL: "https://webhook.smallstep.com", Secret: secret, Timeout: &Duration{time.Second}}, false},
+		{"fail empty", &Webhook{}, true},
+		{"fail url", &Webhook{URL: "://webhook.smallstep.com"}, true},
+		{"fail scheme", &Webhook{URL: "ftp://webhook.smallstep.com"}, true},
+		{"fail http", &Webhook{URL: "http://127.0.0.1:8080"}, true},
+		{"fail timeout", &Webhook{URL: "https://webhook.smallstep.com", Timeout: &Duration{-time.Second}}, true},
+		{"fail secret", &Webhook{URL: "https://webhook.smallstep.com", Secret: "%%%"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.webhook.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Webhook.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhook_timeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout *Duration
+		want    time.Duration
+	}{
+		{"default", nil, defaultWebhookTimeout},
+		{"zero", &Duration{}, defaultWebhookTimeout},
+		{"ok", &Duration{time.Second}, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Webhook{Timeout: tt.timeout}
+			if got := w.timeout(); got != tt.want {
+				t.Errorf("Webhook.timeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_webhookValidator_Valid(t *testing.T) {
+	key := []byte("the-secret")
+	secret := base64.StdEncoding.EncodeToString(key)
+	identity := &AuthorizedIdentity{
+		Subject:       "subject",
+		TenantID:      "tenantID",
+		ResourceGroup: "resourceGroup",
+		Name:          "virtualMachine",
+		VMID:          "the-vmid",
+	}
+	claims := map[string]interface{}{"sub": "subject"}
+	req := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "virtualMachine"},
+		DNSNames:       []string{"virtualMachine"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses: []string{"vm@smallstep.com"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "smallstep.com", Path: "/vm"}},
+	}
+	expected := webhookRequest{
+		ProvisionerName: "azure",
+		ProvisionerType: "Azure",
+		CertificateType: "x509",
+		Identity:        identity,
+		Claims:          map[string]interface{}{"sub": "subject"},
+		CommonName:      "virtualMachine",
+		DNSNames:        []string{"virtualMachine"},
+		IPAddresses:     []string{"10.0.0.1"},
+		EmailAddresses:  []string{"vm@smallstep.com"},
+		URIs:            []string{"spiffe://smallstep.com/vm"},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var wr webhookRequest
+		if err := json.Unmarshal(body, &wr); err != nil || !reflect.DeepEqual(wr, expected) {
+			t.Errorf("unexpected webhook request %s", body)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/allow":
+			w.Write([]byte(`{"allow":true}`))
+		case "/deny":
+			w.Write([]byte(`{"allow":false}`))
+		case "/signed":
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if _, err := VerifyWebhookRequest(r, key, 0); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			SignWebhookResponse(w, r, key, []byte(`{"allow":true}`))
+			w.Write([]byte(`{"allow":true}`))
+		case "/bad-response-signature":
+			SignWebhookResponse(w, r, key, []byte(`{"allow":false}`))
+			w.Write([]byte(`{"allow":true}`))
+		case "/large":
+			w.Write([]byte(`{"allow":true,"padding":"`))
+			w.Write(bytes.Repeat([]byte("a"), maxWebhookResponseSize))
+			w.Write([]byte(`"}`))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"allow":true}`))
+		case "/bad-json":
+			w.Write([]byte(`{"allow":`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		wantErr bool
+	}{
+		{"ok", &Webhook{URL: srv.URL + "/allow"}, false},
+		{"ok signed", &Webhook{URL: srv.URL + "/signed", Secret: secret}, false},
+		{"fail deny", &Webhook{URL: srv.URL + "/deny"}, true},
+		{"fail signature", &Webhook{URL: srv.URL + "/signed", Secret: base64.StdEncoding.EncodeToString([]byte("bad-secret"))}, true},
+		{"fail no signature", &Webhook{URL: srv.URL + "/signed"}, true},
+		{"fail no response signature", &Webhook{URL: srv.URL + "/allow", Secret: secret}, true},
+		{"fail response signature", &Webhook{URL: srv.URL + "/bad-response-signature", Secret: secret}, true},
+		{"fail large response", &Webhook{URL: srv.URL + "/large"}, true},
+		{"fail timeout", &Webhook{URL: srv.URL + "/slow", Timeout: &Duration{50 * time.Millisecond}}, true},
+		{"fail not found", &Webhook{URL: srv.URL + "/not-found"}, true},
+		{"fail json", &Webhook{URL: srv.URL + "/bad-json"}, true},
+		{"fail connect", &Webhook{URL: "http://127.0.0.1:0"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newWebhookValidator(tt.webhook, TypeAzure, "azure", identity, claims)
+			if err := v.Valid(req); (err != nil) != tt.wantErr {
+				t.Errorf("webhookValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_sshWebhookValidator_Valid(t *testing.T) {
+	identity := &AuthorizedIdentity{Subject: "subject", Credential: "credential"}
+	claims := map[string]interface{}{"sub": "subject"}
+
+	var got webhookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = webhookRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/deny" {
+			w.Write([]byte(`{"allow":false}`))
+			return
+		}
+		w.Write([]byte(`{"allow":true}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		cert    *ssh.Certificate
+		want    webhookRequest
+		wantErr bool
+	}{
+		{"ok user", &Webhook{URL: srv.URL + "/allow"}, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "jane@smallstep.com",
+			ValidPrincipals: []string{"jane"},
+		}, webhookRequest{
+			ProvisionerName: "jwk",
+			ProvisionerType: "JWK",
+			CertificateType: "ssh",
+			Identity:        identity,
+			Claims:          map[string]interface{}{"sub": "subject"},
+			SSHCertType:     "user",
+			KeyID:           "jane@smallstep.com",
+			Principals:      []string{"jane"},
+		}, false},
+		{"ok host", &Webhook{URL: srv.URL + "/allow"}, &ssh.Certificate{
+			CertType:        ssh.HostCert,
+			KeyId:           "host",
+			ValidPrincipals: []string{"host.smallstep.com", "10.0.0.1"},
+		}, webhookRequest{
+			ProvisionerName: "jwk",
+			ProvisionerType: "JWK",
+			CertificateType: "ssh",
+			Identity:        identity,
+			Claims:          map[string]interface{}{"sub": "subject"},
+			SSHCertType:     "host",
+			KeyID:           "host",
+			Principals:      []string{"host.smallstep.com", "10.0.0.1"},
+		}, false},
+		{"fail deny", &Webhook{URL: srv.URL + "/deny"}, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "jane@smallstep.com",
+			ValidPrincipals: []string{"root"},
+		}, webhookRequest{
+			ProvisionerName: "jwk",
+			ProvisionerType: "JWK",
+			CertificateType: "ssh",
+			Identity:        identity,
+			Claims:          map[string]interface{}{"sub": "subject"},
+			SSHCertType:     "user",
+			KeyID:           "jane@smallstep.com",
+			Principals:      []string{"root"},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newSSHWebhookValidator(tt.webhook, TypeJWK, "jwk", identity, claims)
+			if err := v.Valid(tt.cert, SSHOptions{}); (err != nil) != tt.wantErr {
+				t.Fatalf("sshWebhookValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sshWebhookValidator.Valid() request = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookRequest(t *testing.T) {
+	key := []byte("the-secret")
+	body := []byte(`{"provisionerName":"azure"}`)
+	now := time.Now()
+
+	newRequest := func(ts time.Time, key, body []byte) *http.Request {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		r := httptest.NewRequest("POST", "/webhook", bytes.NewReader(body))
+		r.Header.Set(WebhookTimestampHeader, timestamp)
+		r.Header.Set(WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		req       *http.Request
+		tolerance time.Duration
+		wantErr   bool
+	}{
+		{"ok", newRequest(now, key, body), 0, false},
+		{"ok tolerance", newRequest(now.Add(-time.Hour), key, body), 2 * time.Hour, false},
+		{"fail stale", newRequest(now.Add(-DefaultWebhookTolerance-time.Minute), key, body), 0, true},
+		{"fail future", newRequest(now.Add(DefaultWebhookTolerance+time.Minute), key, body), 0, true},
+		{"fail secret", newRequest(now, []byte("bad-secret"), body), 0, true},
+		{"fail body", func() *http.Request {
+			r := newRequest(now, key, body)
+			r.Body = ioutil.NopCloser(strings.NewReader(`{"provisionerName":"aws"}`))
+			return r
+		}(), 0, true},
+		{"fail timestamp", func() *http.Request {
+			r := newRequest(now, key, body)
+			r.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix()+1, 10))
+			return r
+		}(), 0, true},
+		{"fail no timestamp", func() *http.Request {
+			r := newRequest(now, key, body)
+			r.Header.Del(WebhookTimestampHeader)
+			return r
+		}(), 0, true},
+		{"fail no signature", func() *http.Request {
+			r := newRequest(now, key, body)
+			r.Header.Del(WebhookSignatureHeader)
+			return r
+		}(), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyWebhookRequest(tt.req, key, tt.tolerance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyWebhookRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, body) {
+				t.Errorf("VerifyWebhookRequest() = %s, want %s", got, body)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
 )
 
@@ -22,15 +23,34 @@ type x5cPayload struct {
 
 // X5C is the default provisioner, an entity that can sign tokens necessary for
 // signature requests.
+//
+// If AllowedIntermediates is set, the certificate chain in the x5c header must
+// go through one of those intermediates, the Roots alone are not enough.
+//
+// If RequiredExtKeyUsages is set, the certificate used to sign the token must
+// have all those extended key usages, e.g. ["clientAuth"]. By default the
+// certificate must be valid for serverAuth.
 type X5C struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Roots     []byte  `json:"roots"`
-	Claims    *Claims `json:"claims,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	rootPool  *x509.CertPool
+	Type                 string           `json:"type"`
+	Name                 string           `json:"name"`
+	Roots                []byte           `json:"roots"`
+	AllowedIntermediates []byte           `json:"allowedIntermediates,omitempty"`
+	RequiredExtKeyUsages []string         `json:"requiredExtKeyUsages,omitempty"`
+	NameConstraints      *NameConstraints `json:"nameConstraints,omitempty"`
+	Webhook              *Webhook         `json:"webhook,omitempty"`
+	Claims               *Claims          `json:"claims,omitempty"`
+	claimer              *Claimer
+	audiences            Audiences
+	rootPool             *x509.CertPool
+	intermediates        []*x509.Certificate
+	extKeyUsages         []x509.ExtKeyUsage
+}
+
+func init() {
+	Register(TypeX5C.String(), func() Interface {
+		return &X5C{}
+	})
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -72,6 +92,13 @@ func (p *X5C) GetEncryptedKey() (string, string, bool) {
 	return "", "", false
 }
 
+// GetRateLimit returns the maximum number of certificates that the provisioner
+// can sign for the same subject in a window of time, or nil if there is no
+// limit.
+func (p *X5C) GetRateLimit() *RateLimit {
+	return p.claimer.RateLimit()
+}
+
 // Init initializes and validates the fields of a X5C type.
 func (p *X5C) Init(config Config) error {
 	switch {
@@ -83,21 +110,12 @@ func (p *X5C) Init(config Config) error {
 		return errors.New("provisioner root(s) cannot be empty")
 	}
 
+	roots, err := parseCertificates(p.Roots)
+	if err != nil {
+		return err
+	}
 	p.rootPool = x509.NewCertPool()
-
-	var (
-		block *pem.Block
-		rest  = p.Roots
-	)
-	for rest != nil {
-		block, rest = pem.Decode(rest)
-		if block == nil {
-			break
-		}
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			return errors.Wrap(err, "error parsing x509 certificate from PEM block")
-		}
+	for _, cert := range roots {
 		p.rootPool.AddCert(cert)
 	}
 
@@ -106,12 +124,37 @@ func (p *X5C) Init(config Config) error {
 		return errors.Errorf("no x509 certificates found in roots attribute for provisioner %s", p.GetName())
 	}
 
+	if len(p.AllowedIntermediates) > 0 {
+		if p.intermediates, err = parseCertificates(p.AllowedIntermediates); err != nil {
+			return err
+		}
+		if len(p.intermediates) == 0 {
+			return errors.Errorf("no x509 certificates found in allowedIntermediates attribute for provisioner %s", p.GetName())
+		}
+	}
+
+	if p.extKeyUsages, err = parseExtKeyUsages(p.RequiredExtKeyUsages); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
-	var err error
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
 
+	if p.NameConstraints != nil {
+		if err := p.NameConstraints.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the webhook if configured
+	if p.Webhook != nil {
+		if err := p.Webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
 }
@@ -125,19 +168,38 @@ func (p *X5C) authorizeToken(token string, audiences []string) (*x5cPayload, err
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "x5c.authorizeToken; error parsing x5c token")
 	}
 
-	verifiedChains, err := jwt.Headers[0].Certificates(x509.VerifyOptions{
+	opts := x509.VerifyOptions{
 		Roots: p.rootPool,
-	})
+	}
+	// The required extended key usages are validated below.
+	if len(p.extKeyUsages) > 0 {
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+	verifiedChains, err := jwt.Headers[0].Certificates(opts)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err,
 			"x5c.authorizeToken; error verifying x5c certificate chain in token")
 	}
+
+	if len(p.intermediates) > 0 {
+		if verifiedChains = p.allowedChains(verifiedChains); len(verifiedChains) == 0 {
+			return nil, errs.Unauthorized("x5c.authorizeToken; x5c certificate chain in token is not " +
+				"issued by an allowed intermediate")
+		}
+	}
 	leaf := verifiedChains[0][0]
 
 	if leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
 		return nil, errs.Unauthorized("x5c.authorizeToken; certificate used to sign x5c token cannot be used for digital signature")
 	}
 
+	for _, eku := range p.extKeyUsages {
+		if !hasExtKeyUsage(leaf, eku) {
+			return nil, errs.Unauthorized("x5c.authorizeToken; certificate used to sign x5c token is missing "+
+				"the required extended key usage %s", extKeyUsageName(eku))
+		}
+	}
+
 	// Using the leaf certificates key to validate the claims accomplishes two
 	// things:
 	//   1. Asserts that the private key used to sign the token corresponds
@@ -153,7 +215,7 @@ func (p *X5C) authorizeToken(token string, audiences []string) (*x5cPayload, err
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: p.Name,
 		Time:   time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "x5c.authorizeToken; invalid x5c claims")
 	}
 
@@ -172,6 +234,62 @@ func (p *X5C) authorizeToken(token string, audiences []string) (*x5cPayload, err
 	return &claims, nil
 }
 
+// allowedChains returns the chains that go through one of the allowed
+// intermediates.
+func (p *X5C) allowedChains(chains [][]*x509.Certificate) [][]*x509.Certificate {
+	var allowed [][]*x509.Certificate
+	for _, chain := range chains {
+		if p.hasAllowedIntermediate(chain) {
+			allowed = append(allowed, chain)
+		}
+	}
+	return allowed
+}
+
+func (p *X5C) hasAllowedIntermediate(chain []*x509.Certificate) bool {
+	// The first certificate is the leaf and the last one the root.
+	for i := 1; i < len(chain)-1; i++ {
+		for _, cert := range p.intermediates {
+			if chain[i].Equal(cert) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasExtKeyUsage returns true if the certificate has the given extended key
+// usage.
+func hasExtKeyUsage(cert *x509.Certificate, eku x509.ExtKeyUsage) bool {
+	for _, v := range cert.ExtKeyUsage {
+		if v == eku {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCertificates returns the certificates in the given PEM blocks.
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var (
+		block *pem.Block
+		rest  = b
+		certs []*x509.Certificate
+	)
+	for rest != nil {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing x509 certificate from PEM block")
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
 // AuthorizeRevoke returns an error if the provisioner does not have rights to
 // revoke the certificate with serial number in the `sub` property.
 func (p *X5C) AuthorizeRevoke(ctx context.Context, token string) error {
@@ -193,7 +311,12 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		claims.SANs = []string{claims.Subject}
 	}
 
-	return []SignOption{
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: x509util.Fingerprint(claims.chains[0][0]),
+	}
+	so := []SignOption{
+		identity,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeX5C, p.Name, ""),
 		profileLimitDuration{p.claimer.DefaultTLSCertDuration(),
@@ -201,9 +324,21 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		// validators
 		commonNameValidator(claims.Subject),
 		defaultSANsValidator(claims.SANs),
-		defaultPublicKeyValidator{},
+		newDefaultPublicKeyValidator(p.claimer),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+	}
+
+	// Restrict the SANs if name constraints are configured.
+	if p.NameConstraints != nil {
+		so = append(so, newNameConstraintsValidator(p.NameConstraints))
+	}
+
+	// Ask the webhook to approve the request if configured.
+	if p.Webhook != nil {
+		so = append(so, newWebhookValidator(p.Webhook, TypeX5C, p.Name, identity, claims))
+	}
+
+	return so, nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -230,7 +365,12 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	}
 
 	opts := claims.Step.SSH
+	identity := &AuthorizedIdentity{
+		Subject:    claims.Subject,
+		Credential: x509util.Fingerprint(claims.chains[0][0]),
+	}
 	signOptions := []SignOption{
+		identity,
 		// validates user's SSHOptions with the ones in the token
 		sshCertOptionsValidator(*opts),
 	}
@@ -258,6 +398,11 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Default to a user certificate with no principals if not set
 	signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
 
+	// Ask the webhook to approve the certificate if configured.
+	if p.Webhook != nil {
+		signOptions = append(signOptions, newSSHWebhookValidator(p.Webhook, TypeX5C, p.Name, identity, claims))
+	}
+
 	return append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
@@ -2,6 +2,8 @@ package provisioner
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"net/http"
 	"testing"
 	"time"
@@ -11,6 +13,7 @@ import (
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/randutil"
+	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
 )
 
@@ -82,6 +85,24 @@ func TestX5C_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail/no-valid-allowed-intermediates": func(t *testing.T) ProvisionerValidateTest {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.AllowedIntermediates = []byte("foo")
+			return ProvisionerValidateTest{
+				p:   p,
+				err: errors.Errorf("no x509 certificates found in allowedIntermediates attribute for provisioner %s", p.GetName()),
+			}
+		},
+		"fail/invalid-required-ext-key-usages": func(t *testing.T) ProvisionerValidateTest {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.RequiredExtKeyUsages = []string{"foo"}
+			return ProvisionerValidateTest{
+				p:   p,
+				err: errors.New("unsupported extended key usage 'foo'"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			p, err := generateX5C(nil)
 			assert.FatalError(t, err)
@@ -89,6 +110,26 @@ func TestX5C_Init(t *testing.T) {
 				p: p,
 			}
 		},
+		"ok/chain-policy": func(t *testing.T) ProvisionerValidateTest {
+			certs, err := pemutil.ReadCertificateBundle("./testdata/certs/x5c-leaf.crt")
+			assert.FatalError(t, err)
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.AllowedIntermediates = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[1].Raw})
+			p.RequiredExtKeyUsages = []string{"clientAuth"}
+			return ProvisionerValidateTest{
+				p: p,
+				extraValid: func(p *X5C) error {
+					if len(p.intermediates) != 1 || !p.intermediates[0].Equal(certs[1]) {
+						return errors.Errorf("unexpected intermediates: %v", p.intermediates)
+					}
+					if len(p.extKeyUsages) != 1 || p.extKeyUsages[0] != x509.ExtKeyUsageClientAuth {
+						return errors.Errorf("unexpected extended key usages: %v", p.extKeyUsages)
+					}
+					return nil
+				},
+			}
+		},
 		"ok/root-chain": func(t *testing.T) ProvisionerValidateTest {
 			p, err := generateX5C([]byte(`-----BEGIN CERTIFICATE-----
 MIIBtjCCAVygAwIBAgIQNr+f4IkABY2n4wx4sLOMrTAKBggqhkjOPQQDAjAUMRIw
@@ -366,6 +407,37 @@ lgsqsR63is+0YQ==
 				err:   errors.New("x5c.authorizeToken; x5c token subject cannot be empty"),
 			}
 		},
+		"fail/untrusted-chain": func(t *testing.T) test {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			// Only intermediates in the chain are considered.
+			p.intermediates = []*x509.Certificate{x5cCerts[0]}
+			tok, err := generateToken("foo", p.GetName(), testAudiences.Sign[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), x5cJWK,
+				withX5CHdr(x5cCerts))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("x5c.authorizeToken; x5c certificate chain in token is not issued by an allowed intermediate"),
+			}
+		},
+		"fail/missing-required-ext-key-usage": func(t *testing.T) test {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.extKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning}
+			tok, err := generateToken("foo", p.GetName(), testAudiences.Sign[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), x5cJWK,
+				withX5CHdr(x5cCerts))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("x5c.authorizeToken; certificate used to sign x5c token is missing the required extended key usage codeSigning"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, err := generateX5C(nil)
 			assert.FatalError(t, err)
@@ -378,6 +450,20 @@ lgsqsR63is+0YQ==
 				token: tok,
 			}
 		},
+		"ok/chain-policy": func(t *testing.T) test {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.intermediates = []*x509.Certificate{x5cCerts[1]}
+			p.extKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+			tok, err := generateToken("foo", p.GetName(), testAudiences.Sign[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), x5cJWK,
+				withX5CHdr(x5cCerts))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -463,7 +549,7 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					if assert.NotNil(t, opts) {
-						assert.Equals(t, len(opts), 6)
+						assert.Equals(t, len(opts), 7)
 						for _, o := range opts {
 							switch v := o.(type) {
 							case *provisionerExtensionOption:
@@ -479,6 +565,11 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 								assert.Equals(t, v.notAfter, claims.chains[0][0].NotAfter)
 							case commonNameValidator:
 								assert.Equals(t, string(v), "foo")
+							case *AuthorizedIdentity:
+								claims, err := tc.p.authorizeToken(tc.token, tc.p.audiences.Sign)
+								assert.FatalError(t, err)
+								assert.Equals(t, v.Subject, "foo")
+								assert.Equals(t, v.Credential, x509util.Fingerprint(claims.chains[0][0]))
 							case defaultPublicKeyValidator:
 							case defaultSANsValidator:
 								assert.Equals(t, []string(v), tc.sans)
@@ -780,15 +871,17 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 								*sshCertDefaultValidator:
 							case sshCertKeyIDValidator:
 								assert.Equals(t, string(v), "foo")
+							case *AuthorizedIdentity:
+								assert.Equals(t, v.Credential, x509util.Fingerprint(x5cCerts[0]))
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
 						if len(tc.claims.Step.SSH.CertType) > 0 {
-							assert.Equals(t, tot, 13)
+							assert.Equals(t, tot, 14)
 						} else {
-							assert.Equals(t, tot, 9)
+							assert.Equals(t, tot, 10)
 						}
 					}
 				}
@@ -2,10 +2,10 @@ package authority
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/x509"
 	"encoding/binary"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -205,6 +205,67 @@ func (a *Authority) GetSSHBastion(ctx context.Context, user string, hostname str
 
 // SignSSH creates a signed SSH certificate with the given public key and options.
 func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+	cert, err := a.signSSH(key, opts, signOpts...)
+	if err != nil {
+		a.refundRateLimit(signOpts)
+		return nil, err
+	}
+
+	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+		a.refundRateLimit(signOpts)
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error storing certificate in db")
+	}
+
+	a.auditSSHIssuance(ctx, cert, signOpts)
+	return cert, nil
+}
+
+// SSHBatchRequest is one of the certificates requested in SignSSHBatch.
+type SSHBatchRequest struct {
+	Key         ssh.PublicKey
+	Options     provisioner.SSHOptions
+	SignOptions []provisioner.SignOption
+}
+
+// SignSSHBatch creates a signed SSH certificate for each one of the given
+// requests, e.g. the host certificate of a new host and the user certificate of
+// its operator. The certificates are only stored and returned if all of them
+// are signed, if one request fails no certificate is returned.
+func (a *Authority) SignSSHBatch(ctx context.Context, reqs []SSHBatchRequest) (_ []*ssh.Certificate, err error) {
+	if len(reqs) == 0 {
+		return nil, errs.BadRequest("signSSHBatch: requests cannot be empty")
+	}
+	defer func() {
+		if err != nil {
+			for _, req := range reqs {
+				a.refundRateLimit(req.SignOptions)
+			}
+		}
+	}()
+
+	certs := make([]*ssh.Certificate, len(reqs))
+	for i, req := range reqs {
+		cert, err := a.signSSH(req.Key, req.Options, req.SignOptions...)
+		if err != nil {
+			return nil, errs.Wrapf(http.StatusInternalServerError, err, "signSSHBatch: error signing request %d", i)
+		}
+		certs[i] = cert
+	}
+
+	for _, cert := range certs {
+		if err := a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHBatch: error storing certificate in db")
+		}
+	}
+	for i, cert := range certs {
+		a.auditSSHIssuance(ctx, cert, reqs[i].SignOptions)
+	}
+
+	return certs, nil
+}
+
+// signSSH creates a signed SSH certificate without storing it in the db.
+func (a *Authority) signSSH(key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	var mods []provisioner.SSHCertModifier
 	var validators []provisioner.SSHCertValidator
 
@@ -227,6 +288,8 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 			if err := o.Valid(opts); err != nil {
 				return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
 			}
+		case *provisioner.AuthorizedIdentity, *provisioner.AuthorizedRequest:
+			// only used for auditing, it is not added to the certificate
 		default:
 			return nil, errs.InternalServer("signSSH: invalid extra option type %T", o)
 		}
@@ -238,7 +301,7 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 	}
 
 	var serial uint64
-	if err := binary.Read(rand.Reader, binary.BigEndian, &serial); err != nil {
+	if err := binary.Read(a.randReader(), binary.BigEndian, &serial); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error reading random number")
 	}
 
@@ -284,7 +347,7 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 	data = data[:len(data)-4]
 
 	// Sign the certificate
-	sig, err := signer.Sign(rand.Reader, data)
+	sig, err := signer.Sign(a.randReader(), data)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error signing certificate")
 	}
@@ -297,10 +360,6 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		}
 	}
 
-	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error storing certificate in db")
-	}
-
 	return cert, nil
 }
 
@@ -312,7 +371,7 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 	}
 
 	var serial uint64
-	if err := binary.Read(rand.Reader, binary.BigEndian, &serial); err != nil {
+	if err := binary.Read(a.randReader(), binary.BigEndian, &serial); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH: error reading random number")
 	}
 
@@ -362,7 +421,7 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 	data = data[:len(data)-4]
 
 	// Sign the certificate
-	sig, err := signer.Sign(rand.Reader, data)
+	sig, err := signer.Sign(a.randReader(), data)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH: error signing certificate")
 	}
@@ -372,6 +431,7 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH: error storing certificate in db")
 	}
 
+	a.auditSSHIssuance(ctx, cert, nil)
 	return cert, nil
 }
 
@@ -395,7 +455,7 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 	}
 
 	var serial uint64
-	if err := binary.Read(rand.Reader, binary.BigEndian, &serial); err != nil {
+	if err := binary.Read(a.randReader(), binary.BigEndian, &serial); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "rekeySSH; error reading random number")
 	}
 
@@ -445,7 +505,7 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 	data = data[:len(data)-4]
 
 	// Sign the certificate.
-	sig, err := signer.Sign(rand.Reader, data)
+	sig, err := signer.Sign(a.randReader(), data)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "rekeySSH; error signing certificate")
 	}
@@ -462,6 +522,7 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "rekeySSH; error storing certificate in db")
 	}
 
+	a.auditSSHIssuance(ctx, cert, signOpts)
 	return cert, nil
 }
 
@@ -504,7 +565,7 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 	}
 
 	var serial uint64
-	if err := binary.Read(rand.Reader, binary.BigEndian, &serial); err != nil {
+	if err := binary.Read(a.randReader(), binary.BigEndian, &serial); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHAddUser: error reading random number")
 	}
 
@@ -534,7 +595,7 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 	data = data[:len(data)-4]
 
 	// Sign the certificate
-	sig, err := signer.Sign(rand.Reader, data)
+	sig, err := signer.Sign(a.randReader(), data)
 	if err != nil {
 		return nil, err
 	}
@@ -544,6 +605,7 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHAddUser: error storing certificate in db")
 	}
 
+	a.auditSSHIssuance(ctx, cert, nil)
 	return cert, nil
 }
 
@@ -588,6 +650,41 @@ func (a *Authority) GetSSHHosts(ctx context.Context, cert *x509.Certificate) ([]
 	return hosts, nil
 }
 
+// GetSSHKRL returns the OpenSSH Key Revocation List with the revoked SSH
+// certificates in binary format. The serial numbers are revoked for all the
+// user and host CA keys.
+func (a *Authority) GetSSHKRL(ctx context.Context) ([]byte, error) {
+	if a.sshCAUserCertSignKey == nil && a.sshCAHostCertSignKey == nil {
+		return nil, errs.NotFound("getSSHKRL: ssh is not configured")
+	}
+
+	revocations, err := a.db.GetSSHRevocations()
+	if err != nil {
+		if err == db.ErrNotImplemented {
+			return nil, errs.Wrap(http.StatusNotImplemented, err,
+				"getSSHKRL: getSSHRevocations is not implemented")
+		}
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "getSSHKRL")
+	}
+
+	now := time.Now()
+	krl := sshutil.NewKRL(uint64(now.Unix()), "")
+	krl.GeneratedDate = now
+	keys := append(append([]ssh.PublicKey{}, a.sshCAUserCerts...), a.sshCAHostCerts...)
+	for _, rci := range revocations {
+		serial, err := strconv.ParseUint(rci.Serial, 10, 64)
+		if err != nil {
+			return nil, errs.Wrapf(http.StatusInternalServerError, err,
+				"getSSHKRL: error parsing serial number %s", rci.Serial)
+		}
+		for _, key := range keys {
+			krl.RevokeSerial(key, serial)
+		}
+	}
+
+	return krl.Marshal(), nil
+}
+
 func (a *Authority) getAddUserPrincipal() (cmd string) {
 	if a.config.SSH.AddUserPrincipal == "" {
 		return SSHAddUserPrincipal
@@ -173,6 +173,86 @@ func TestAuthority_SignSSH(t *testing.T) {
 	}
 }
 
+func TestAuthority_SignSSHBatch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pub, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	signKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	signer, err := ssh.NewSignerFromKey(signKey)
+	assert.FatalError(t, err)
+
+	hostReq := SSHBatchRequest{
+		Key:         pub,
+		Options:     provisioner.SSHOptions{CertType: "host", Principals: []string{"foo.test.com"}},
+		SignOptions: []provisioner.SignOption{},
+	}
+	userReq := SSHBatchRequest{
+		Key:         pub,
+		Options:     provisioner.SSHOptions{CertType: "user", Principals: []string{"operator"}},
+		SignOptions: []provisioner.SignOption{sshTestCertValidator("")},
+	}
+	failReq := SSHBatchRequest{
+		Key:         pub,
+		Options:     provisioner.SSHOptions{CertType: "user"},
+		SignOptions: []provisioner.SignOption{sshTestCertValidator("an error")},
+	}
+
+	type fields struct {
+		sshCAUserCertSignKey ssh.Signer
+		sshCAHostCertSignKey ssh.Signer
+		storeErr             error
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		reqs       []SSHBatchRequest
+		wantTypes  []uint32
+		wantStored int
+		wantErr    bool
+	}{
+		{"ok", fields{signer, signer, nil}, []SSHBatchRequest{hostReq, userReq}, []uint32{ssh.HostCert, ssh.UserCert}, 2, false},
+		{"ok one", fields{signer, signer, nil}, []SSHBatchRequest{userReq}, []uint32{ssh.UserCert}, 1, false},
+		{"fail empty", fields{signer, signer, nil}, nil, nil, 0, true},
+		{"fail validator", fields{signer, signer, nil}, []SSHBatchRequest{hostReq, failReq}, nil, 0, true},
+		{"fail no user key", fields{nil, signer, nil}, []SSHBatchRequest{hostReq, userReq}, nil, 0, true},
+		{"fail db", fields{signer, signer, errors.New("force")}, []SSHBatchRequest{hostReq, userReq}, nil, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored int
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MStoreSSHCertificate: func(cert *ssh.Certificate) error {
+					stored++
+					return tt.fields.storeErr
+				},
+			}))
+			a.sshCAUserCertSignKey = tt.fields.sshCAUserCertSignKey
+			a.sshCAHostCertSignKey = tt.fields.sshCAHostCertSignKey
+
+			got, err := a.SignSSHBatch(context.Background(), tt.reqs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authority.SignSSHBatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.wantStored, stored)
+			if err != nil {
+				assert.Nil(t, got)
+				return
+			}
+			if len(got) != len(tt.wantTypes) {
+				t.Fatalf("Authority.SignSSHBatch() len = %d, want %d", len(got), len(tt.wantTypes))
+			}
+			for i, cert := range got {
+				assert.Equals(t, tt.wantTypes[i], cert.CertType)
+				assert.Equals(t, tt.reqs[i].Options.Principals, cert.ValidPrincipals)
+				assert.NotNil(t, cert.Signature)
+			}
+		})
+	}
+}
+
 func TestAuthority_SignSSHAddUser(t *testing.T) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	assert.FatalError(t, err)
@@ -768,6 +848,117 @@ func TestAuthority_GetSSHHosts(t *testing.T) {
 	}
 }
 
+func TestAuthority_GetSSHKRL(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	user, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	userSigner, err := ssh.NewSignerFromSigner(key)
+	assert.FatalError(t, err)
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	host, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	hostSigner, err := ssh.NewSignerFromSigner(key)
+	assert.FatalError(t, err)
+
+	type test struct {
+		userSigner ssh.Signer
+		hostSigner ssh.Signer
+		db         db.AuthDB
+		cmp        func(got []byte)
+		err        error
+		code       int
+	}
+	tests := map[string]test{
+		"fail/ssh-not-configured": {
+			db:   &db.MockAuthDB{},
+			err:  errors.New("getSSHKRL: ssh is not configured"),
+			code: http.StatusNotFound,
+		},
+		"fail/db-not-implemented": {
+			userSigner: userSigner,
+			db: &db.MockAuthDB{
+				MGetSSHRevocations: func() ([]*db.RevokedCertificateInfo, error) {
+					return nil, db.ErrNotImplemented
+				},
+			},
+			err:  errors.New("getSSHKRL: getSSHRevocations is not implemented"),
+			code: http.StatusNotImplemented,
+		},
+		"fail/db-error": {
+			userSigner: userSigner,
+			db: &db.MockAuthDB{
+				MGetSSHRevocations: func() ([]*db.RevokedCertificateInfo, error) {
+					return nil, errors.New("force")
+				},
+			},
+			err:  errors.New("getSSHKRL: force"),
+			code: http.StatusInternalServerError,
+		},
+		"fail/bad-serial": {
+			userSigner: userSigner,
+			db: &db.MockAuthDB{
+				MGetSSHRevocations: func() ([]*db.RevokedCertificateInfo, error) {
+					return []*db.RevokedCertificateInfo{{Serial: "foo"}}, nil
+				},
+			},
+			err:  errors.New("getSSHKRL: error parsing serial number foo"),
+			code: http.StatusInternalServerError,
+		},
+		"ok": {
+			userSigner: userSigner,
+			hostSigner: hostSigner,
+			db: &db.MockAuthDB{
+				MGetSSHRevocations: func() ([]*db.RevokedCertificateInfo, error) {
+					return []*db.RevokedCertificateInfo{{Serial: "2"}, {Serial: "1"}}, nil
+				},
+			},
+			cmp: func(got []byte) {
+				krl := sshutil.NewKRL(0, "")
+				for _, k := range []ssh.PublicKey{user, host} {
+					krl.RevokeSerial(k, 1)
+					krl.RevokeSerial(k, 2)
+				}
+				want := krl.Marshal()
+				// Skip krl_version and generated_date
+				assert.Equals(t, want[:12], got[:12])
+				assert.Equals(t, want[28:], got[28:])
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t, WithDatabase(tc.db))
+			a.sshCAUserCertSignKey, a.sshCAUserCerts = nil, nil
+			a.sshCAHostCertSignKey, a.sshCAHostCerts = nil, nil
+			if tc.userSigner != nil {
+				a.sshCAUserCertSignKey = tc.userSigner
+				a.sshCAUserCerts = []ssh.PublicKey{tc.userSigner.PublicKey()}
+			}
+			if tc.hostSigner != nil {
+				a.sshCAHostCertSignKey = tc.hostSigner
+				a.sshCAHostCerts = []ssh.PublicKey{tc.hostSigner.PublicKey()}
+			}
+
+			got, err := a.GetSSHKRL(context.Background())
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					tc.cmp(got)
+				}
+			}
+		})
+	}
+}
+
 func TestAuthority_RekeySSH(t *testing.T) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	assert.FatalError(t, err)
@@ -3,11 +3,14 @@ package authority
 import (
 	"context"
 	"crypto"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"io"
+	"math/big"
 	"net/http"
 	"strings"
 	"time"
@@ -60,11 +63,63 @@ func withDefaultASN1DN(def *x509util.ASN1DN) x509util.WithOption {
 	}
 }
 
+// serialNumberLimit is the upper bound of the serial numbers, they have 128
+// random bits.
+var serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// withSerialNumber sets a random serial number read from the given reader.
+func withSerialNumber(r io.Reader) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		sn, err := rand.Int(r, serialNumberLimit)
+		if err != nil {
+			return errors.Wrap(err, "error generating serial number")
+		}
+		p.Subject().SerialNumber = sn
+		return nil
+	}
+}
+
+// randSigner is a crypto.Signer that always signs with its own source of
+// randomness, ignoring the one passed by x509.CreateCertificate.
+type randSigner struct {
+	crypto.Signer
+	rand io.Reader
+}
+
+// Sign implements crypto.Signer using the signer source of randomness.
+func (s *randSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.Signer.Sign(s.rand, digest, opts)
+}
+
+// randReader returns the source of randomness of the certificates, set with
+// WithRandReader, or crypto/rand.Reader.
+func (a *Authority) randReader() io.Reader {
+	if a.rand == nil {
+		return rand.Reader
+	}
+	return a.rand
+}
+
+// getX509Signer returns the signer of the X.509 certificates, using the source
+// of randomness set with WithRandReader if any.
+func (a *Authority) getX509Signer() crypto.Signer {
+	if a.rand == nil {
+		return a.x509Signer
+	}
+	return &randSigner{Signer: a.x509Signer, rand: a.rand}
+}
+
 // Sign creates a signed certificate from a certificate signing request.
-func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) (_ []*x509.Certificate, err error) {
+	defer func() {
+		if err != nil {
+			a.refundRateLimit(extraOpts)
+		}
+	}()
+
 	var (
 		opts            = []interface{}{errs.WithKeyVal("csr", csr), errs.WithKeyVal("signOptions", signOpts)}
-		mods            = []x509util.WithOption{withDefaultASN1DN(a.config.AuthorityConfig.Template)}
+		mods            = []x509util.WithOption{withDefaultASN1DN(a.config.AuthorityConfig.Template), withSerialNumber(a.randReader())}
 		certValidators  = []provisioner.CertificateValidator{}
 		forcedModifiers = []provisioner.CertificateEnforcer{provisioner.ExtraExtsEnforcer{}}
 	)
@@ -84,6 +139,8 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 			mods = append(mods, k.Option(signOpts))
 		case provisioner.CertificateEnforcer:
 			forcedModifiers = append(forcedModifiers, k)
+		case *provisioner.AuthorizedIdentity, *provisioner.AuthorizedRequest:
+			// only used for auditing, it is not added to the certificate
 		default:
 			return nil, errs.InternalServer("authority.Sign; invalid extra option type %T", append([]interface{}{k}, opts...)...)
 		}
@@ -93,7 +150,7 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign; invalid certificate request", opts...)
 	}
 
-	leaf, err := x509util.NewLeafProfileWithCSR(csr, a.x509Issuer, a.x509Signer, mods...)
+	leaf, err := x509util.NewLeafProfileWithCSR(csr, a.x509Issuer, a.getX509Signer(), mods...)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
 	}
@@ -131,6 +188,7 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		}
 	}
 
+	a.auditX509Issuance(context.Background(), serverCert, extraOpts)
 	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
 }
 
@@ -217,7 +275,7 @@ func (a *Authority) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x5
 		newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
 	}
 
-	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, a.x509Issuer, a.x509Signer)
+	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, a.x509Issuer, a.getX509Signer(), withSerialNumber(a.randReader()))
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Rekey", opts...)
 	}
@@ -239,6 +297,7 @@ func (a *Authority) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x5
 		}
 	}
 
+	a.auditX509Issuance(context.Background(), serverCert, nil)
 	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
 }
 
@@ -343,8 +402,8 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 
 // GetTLSCertificate creates a new leaf certificate to be used by the CA HTTPS server.
 func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
-	profile, err := x509util.NewLeafProfile("Step Online CA", a.x509Issuer, a.x509Signer,
-		x509util.WithHosts(strings.Join(a.config.DNSNames, ",")))
+	profile, err := x509util.NewLeafProfile("Step Online CA", a.x509Issuer, a.getX509Signer(),
+		x509util.WithHosts(strings.Join(a.config.DNSNames, ",")), withSerialNumber(a.randReader()))
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
 	}
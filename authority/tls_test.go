@@ -281,6 +281,19 @@ ZYtQ9Ot36qc=
 				notAfter:  signOpts.NotAfter.Time().Truncate(time.Second),
 			}
 		},
+		"ok with authorized identity": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			return &signTest{
+				auth: a,
+				csr:  csr,
+				extraOpts: append(extraOpts, &provisioner.AuthorizedIdentity{
+					Subject: "subject", TenantID: "tenant", ResourceGroup: "group",
+				}),
+				signOpts:  signOpts,
+				notBefore: signOpts.NotBefore.Time().Truncate(time.Second),
+				notAfter:  signOpts.NotAfter.Time().Truncate(time.Second),
+			}
+		},
 		"ok with enforced modifier": func(t *testing.T) *signTest {
 			bcExt := pkix.Extension{}
 			bcExt.Id = asn1.ObjectIdentifier{2, 5, 29, 19}
@@ -397,6 +410,56 @@ ZYtQ9Ot36qc=
 	}
 }
 
+func TestAuthority_Sign_issuance(t *testing.T) {
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	var events []*provisioner.IssuanceEvent
+	a := testAuthority(t, WithIssuanceFunc(func(ctx context.Context, event *provisioner.IssuanceEvent) {
+		events = append(events, event)
+	}))
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+	extraOpts, err := a.Authorize(ctx, token)
+	assert.FatalError(t, err)
+
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+	cert := certChain[0]
+	if assert.Len(t, 1, events) {
+		event := events[0]
+		assert.Equals(t, "step-cli:"+key.KeyID, event.ProvisionerID)
+		assert.Equals(t, "step-cli", event.ProvisionerName)
+		assert.Equals(t, "JWK", event.ProvisionerType)
+		assert.Equals(t, "smallstep test", event.TokenSubject)
+		assert.Equals(t, "x509", event.CertificateType)
+		assert.Equals(t, cert.SerialNumber.String(), event.SerialNumber)
+		assert.Equals(t, "smallstep test", event.Subject)
+		assert.Equals(t, []string{"test.smallstep.com"}, event.SANs)
+		assert.Equals(t, cert.NotBefore, event.NotBefore)
+		assert.Equals(t, cert.NotAfter, event.NotAfter)
+		assert.Equals(t, &provisioner.AuthorizedIdentity{Subject: "smallstep test", Credential: key.KeyID}, event.Identity)
+		assert.False(t, event.Time.IsZero())
+	}
+
+	// Renewals load the provisioner from the certificate.
+	events = nil
+	certChain, err = a.Renew(cert)
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, events) {
+		event := events[0]
+		assert.Equals(t, "step-cli:"+key.KeyID, event.ProvisionerID)
+		assert.Equals(t, "step-cli", event.ProvisionerName)
+		assert.Equals(t, "", event.TokenSubject)
+		assert.Equals(t, certChain[0].SerialNumber.String(), event.SerialNumber)
+		assert.Equals(t, []string{"test.smallstep.com"}, event.SANs)
+	}
+}
+
 func TestAuthority_Renew(t *testing.T) {
 	pub, _, err := keys.GenerateDefaultKeyPair()
 	assert.FatalError(t, err)
@@ -1003,6 +1003,31 @@ retry:
 	return &hosts, nil
 }
 
+// SSHKRL performs the GET /ssh/krl request to the CA and returns the OpenSSH
+// Key Revocation List in binary format.
+func (c *Client) SSHKRL() ([]byte, error) {
+	var retried bool
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/ssh/krl"})
+retry:
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "client GET %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	defer resp.Body.Close()
+	krl, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", u)
+	}
+	return krl, nil
+}
+
 // SSHBastion performs the POST /ssh/bastion request to the CA.
 func (c *Client) SSHBastion(req *api.SSHBastionRequest) (*api.SSHBastionResponse, error) {
 	var retried bool
@@ -0,0 +1,473 @@
+// Package awskmsinit implements the initialization of a PKI using AWS KMS.
+package awskmsinit
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cmd/internal/kmsinit"
+	"github.com/smallstep/certificates/fips"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/awskms"
+	"github.com/smallstep/certificates/kms/pkiutil"
+)
+
+// Config is the configuration used to initialize the PKI.
+type Config struct {
+	CredentialsFile    string
+	Region             string
+	SignatureAlgorithm apiv1.SignatureAlgorithm
+	// SSHSignatureAlgorithm is the algorithm of the SSH CA keys.
+	SSHSignatureAlgorithm apiv1.SignatureAlgorithm
+	// RootKeyType and IntermediateKeyType override the key type defined by
+	// the curve for the root and the intermediate keys.
+	RootKeyType         pkiutil.Intermediate
+	IntermediateKeyType pkiutil.Intermediate
+	SKIDMethod          pkiutil.SubjectKeyIDMethod
+	StoreCerts          bool
+	NoIntermediate      bool
+	Intermediates       pkiutil.Intermediates
+	SSH                 bool
+	Check               bool
+	Force               bool
+	NoWarmup            bool
+	CAConfigFile        string
+	// ReuseKeys reuses the existing keys with the same names instead of
+	// creating new ones, so a failed run can be repeated.
+	ReuseKeys bool
+	// AppendToChain, OldRoot and OldKey are used to cross-sign the new root
+	// certificate in AppendToChain with the old root and its key.
+	AppendToChain string
+	OldRoot       string
+	OldKey        string
+	// CrossRoot and CrossKey are used to cross-sign the intermediates with an
+	// external root and its key.
+	CrossRoot string
+	CrossKey  string
+	// Template customizes the root and intermediate certificates.
+	Template *pkiutil.TemplateFile
+	// FileMode is the permission of the written certificates and keys.
+	FileMode os.FileMode
+	// Format is the encoding of the written certificates.
+	Format pkiutil.CertificateFormat
+	// DER writes a copy in binary DER of the root and intermediate
+	// certificates.
+	DER bool
+	// SSHName identifies the SSH CA in the comments of its public keys.
+	SSHName string
+	// IntermediateExtensions are the extensions requested by the CSR in
+	// --intermediate-csr, added to the intermediate certificates.
+	IntermediateExtensions []pkix.Extension
+	// PolicyOIDs are the certificate policies of the intermediates, and of
+	// the root with RootPolicies.
+	PolicyOIDs   pkiutil.PolicyOIDs
+	RootPolicies bool
+	// NotBefore and NotAfter are the fixed validity of the root and
+	// intermediate certificates, by default they are valid from now and for
+	// ten years.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// RootKey is an existing key, or alias, used as the root key instead of
+	// creating a new one.
+	RootKey string
+	// KeyPolicy is the JSON key policy attached to the created keys.
+	KeyPolicy string
+	// FIPS only allows the algorithms approved by FIPS 186-4.
+	FIPS bool
+	// MultiRegion creates multi-region keys, replicated in the
+	// ReplicaRegions.
+	MultiRegion    bool
+	ReplicaRegions replicaRegions
+}
+
+// replicaRegions implements flag.Value to allow the definition of multiple
+// regions with the --replica-region flag.
+type replicaRegions []string
+
+// String implements flag.Value and returns the regions separated by commas.
+func (v *replicaRegions) String() string {
+	return strings.Join(*v, ",")
+}
+
+// Set implements flag.Value and adds the given region.
+func (v *replicaRegions) Set(s string) error {
+	if s == "" {
+		return errors.New("region cannot be empty")
+	}
+	*v = append(*v, s)
+	return nil
+}
+
+// KeyNames returns the names of the keys that will be created.
+func (c *Config) KeyNames() []string {
+	var names []string
+	if c.RootKey == "" {
+		names = append(names, "root")
+	}
+	if !c.NoIntermediate {
+		if len(c.Intermediates) == 0 {
+			names = append(names, "intermediate")
+		}
+		for _, in := range c.Intermediates {
+			names = append(names, pkiutil.IntermediateKeyName(in.Name))
+		}
+	}
+	if c.SSH {
+		names = append(names, "ssh-user-key", "ssh-host-key")
+	}
+	return names
+}
+
+// KeyRequest returns the request used to create the key with the given name.
+func (c *Config) KeyRequest(name string) *apiv1.CreateKeyRequest {
+	return pkiutil.KeyRequest(c.keyTemplate(), name)
+}
+
+// keyTemplate returns the options used to create all the keys.
+func (c *Config) keyTemplate() apiv1.CreateKeyRequest {
+	return apiv1.CreateKeyRequest{
+		SignatureAlgorithm: c.SignatureAlgorithm,
+		Idempotent:         c.ReuseKeys,
+		KeyPolicy:          c.KeyPolicy,
+		MultiRegion:        c.MultiRegion,
+		ReplicaRegions:     c.ReplicaRegions,
+	}
+}
+
+// Run initializes a PKI using AWS KMS. The name of the command is used in
+// the usage, and args are the command line arguments without it.
+func Run(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var c Config
+	var quiet bool
+	var curve, sshCurve, skidMethod, rootKeyType, intermediateKeyType, fileMode, format, templateFile, intermediateCSR, keyPolicy, notBefore, notAfter string
+	fs.StringVar(&c.CredentialsFile, "credentials-file", "", "Path to the `file` containing the AWS KMS credentials.")
+	fs.StringVar(&c.Region, "region", "", "AWS KMS region name.")
+	fs.StringVar(&curve, "curve", "P-256", "Elliptic curve to use for the keys, P-256, P-384 or P-521.")
+	fs.StringVar(&c.RootKey, "root-key", "", "Use the existing AWS KMS `key` as the root key instead of creating one, a key id, key ARN, alias name (alias/<name>) or alias ARN. Aliases are resolved by AWS KMS on every signature.")
+	fs.StringVar(&rootKeyType, "root-key-type", "", "Key type to use for the root key, P-256, P-384, P-521, RSA-2048, RSA-3072 or RSA-4096. Defaults to the curve.")
+	fs.StringVar(&intermediateKeyType, "intermediate-key-type", "", "Key type to use for the intermediate keys, P-256, P-384, P-521, RSA-2048, RSA-3072 or RSA-4096. Defaults to the curve.")
+	fs.StringVar(&skidMethod, "skid-method", string(pkiutil.RFC5280), "Method used to compute the subject key identifier, rfc5280 (SHA-1) or rfc7093 (truncated SHA-256).")
+	fs.BoolVar(&c.SSH, "ssh", false, "Create SSH keys.")
+	fs.StringVar(&sshCurve, "ssh-curve", "P-256", "Elliptic curve to use for the SSH keys, P-256, P-384 or P-521.")
+	fs.StringVar(&c.SSHName, "ssh-name", pkiutil.DefaultSSHName, "The `name` of the SSH CA written in the comments of its public keys, e.g. \"<name> SSH User CA\".")
+	fs.BoolVar(&c.StoreCerts, "store-certs", false, "Store the certificates in the KMS, if the KMS supports it.")
+	fs.Var(&c.Intermediates, "intermediate", "Create an intermediate with the format `name=<name>[,keytype=<type>]`, the key type is one of P-256, P-384, P-521, RSA-2048, RSA-3072 or RSA-4096. Use it multiple times to create multiple intermediates.")
+	fs.BoolVar(&c.NoIntermediate, "no-intermediate", false, "Create only the root certificate, leaf certificates will be signed directly by the root.")
+	fs.StringVar(&c.CAConfigFile, "write-ca-config", "", "Write a starter ca.json with the created certificates and keys to `file`.")
+	fs.BoolVar(&c.Check, "check", false, "Check that AWS KMS is reachable and the credentials are valid and exit.")
+	fs.BoolVar(&c.NoWarmup, "no-warmup", false, "Do not sign a throwaway digest to warm up the KMS before signing the certificates.")
+	fs.BoolVar(&c.Force, "force", false, "Create the keys even if keys with the same names already exist.")
+	fs.StringVar(&keyPolicy, "key-policy", "", "Path to a JSON key policy `file` attached to the created keys instead of the default key policy.")
+	fs.BoolVar(&c.ReuseKeys, "reuse-keys", false, "Reuse the existing keys with the same names instead of failing or creating new ones, so a failed run can be repeated.")
+	fs.StringVar(&c.AppendToChain, "append-to-chain", "", "Cross-sign the new root certificate in `file` with the old root and write it to cross_signed_root.crt and exit, requires --old-root and --old-key.")
+	fs.StringVar(&c.OldRoot, "old-root", "", "Path to the old root certificate `file` used with --append-to-chain.")
+	fs.StringVar(&c.OldKey, "old-key", "", "AWS KMS `key` URI of the old root key used with --append-to-chain.")
+	fs.StringVar(&c.CrossRoot, "cross-root", "", "Path to an external root certificate `file` used to cross-sign the intermediates, written to intermediate_ca_cross.crt, requires --cross-key.")
+	fs.StringVar(&c.CrossKey, "cross-key", "", "AWS KMS `key` URI of the external root key used with --cross-root.")
+	fs.StringVar(&templateFile, "template", "", "Path to a Go template `file` rendering a JSON certificate template used to customize the root and intermediate certificates.")
+	fs.StringVar(&notBefore, "not-before", "", "The `time` in RFC 3339 format when the root and intermediate certificates become valid, e.g. 2030-01-01T00:00:00Z. Defaults to now.")
+	fs.StringVar(&notAfter, "not-after", "", "The `time` in RFC 3339 format when the root and intermediate certificates expire, e.g. 2040-01-01T00:00:00Z. Defaults to ten years after --not-before.")
+	fs.StringVar(&intermediateCSR, "intermediate-csr", "", "Path to a certificate signing request `file` whose requested extensions are added to the intermediate certificates.")
+	fs.Var(&c.PolicyOIDs, "policy-oid", "Add the certificate policy `oid` in dotted notation to the intermediate certificates. Use it multiple times to add multiple policies.")
+	fs.BoolVar(&c.RootPolicies, "root-policies", false, "Add the --policy-oid policies to the root certificate too.")
+	fs.StringVar(&fileMode, "file-mode", "0600", "Octal permission `mode` of the written certificates and keys, e.g. 0640 to allow the group to read them.")
+	fs.StringVar(&format, "format", "pem", "The `format` of the written root and intermediate certificates, pem (.crt) or der (.cer).")
+	fs.BoolVar(&c.MultiRegion, "multi-region", false, "Create multi-region keys, so the same key material can be used in a failover region.")
+	fs.Var(&c.ReplicaRegions, "replica-region", "Replicate the multi-region keys in the AWS `region`, requires --multi-region. Use it multiple times to replicate the keys in multiple regions.")
+	fs.BoolVar(&c.FIPS, "fips", false, "Only allow the algorithms approved by FIPS 186-4 and enable the FIPS-only mode in the written ca.json.")
+	fs.BoolVar(&c.DER, "der", false, "Also write the root and intermediate certificates in binary DER to root_ca.der and intermediate_ca.der.")
+	fs.BoolVar(&quiet, "quiet", false, "Do not print the progress and the created keys and certificates, errors are still printed to stderr.")
+	fs.Usage = func() { kmsinit.Usage(fs, "") }
+	fs.Parse(args)
+
+	if c.NoIntermediate && len(c.Intermediates) > 0 {
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--intermediate`")
+		os.Exit(1)
+	}
+
+	if c.NoIntermediate && intermediateCSR != "" {
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--intermediate-csr`")
+		os.Exit(1)
+	}
+
+	if c.RootKey != "" && rootKeyType != "" {
+		fmt.Fprintln(os.Stderr, "flag `--root-key` is incompatible with flag `--root-key-type`")
+		os.Exit(1)
+	}
+
+	if c.RootPolicies && len(c.PolicyOIDs) == 0 {
+		fmt.Fprintln(os.Stderr, "flag `--root-policies` requires flag `--policy-oid`")
+		os.Exit(1)
+	}
+
+	if (c.AppendToChain != "" || c.OldRoot != "" || c.OldKey != "") && (c.AppendToChain == "" || c.OldRoot == "" || c.OldKey == "") {
+		fmt.Fprintln(os.Stderr, "flags `--append-to-chain`, `--old-root` and `--old-key` must be used together")
+		os.Exit(1)
+	}
+
+	if (c.CrossRoot != "" || c.CrossKey != "") && (c.CrossRoot == "" || c.CrossKey == "") {
+		fmt.Fprintln(os.Stderr, "flags `--cross-root` and `--cross-key` must be used together")
+		os.Exit(1)
+	}
+
+	if c.NoIntermediate && c.CrossRoot != "" {
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--cross-root`")
+		os.Exit(1)
+	}
+
+	if c.Force && c.ReuseKeys {
+		fmt.Fprintln(os.Stderr, "flag `--force` is incompatible with flag `--reuse-keys`")
+		os.Exit(1)
+	}
+
+	if len(c.ReplicaRegions) > 0 && !c.MultiRegion {
+		fmt.Fprintln(os.Stderr, "flag `--replica-region` requires flag `--multi-region`")
+		os.Exit(1)
+	}
+
+	skid, err := pkiutil.ParseSubjectKeyIDMethod(skidMethod)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--skid-method`; options are `rfc5280` or `rfc7093`\n", skidMethod)
+		os.Exit(1)
+	}
+	c.SKIDMethod = skid
+
+	if c.FileMode, err = pkiutil.ParseFileMode(fileMode); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--file-mode`; the owner must read and write, and the group and others can only read, e.g. `0600` or `0640`\n", fileMode)
+		os.Exit(1)
+	}
+
+	if c.Format, err = pkiutil.ParseCertificateFormat(format); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--format`; options are `pem` or `der`\n", format)
+		os.Exit(1)
+	}
+
+	if c.NotBefore, c.NotAfter, err = pkiutil.ParseValidity(notBefore, notAfter); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value for flags `--not-before` and `--not-after`: %v\n", err)
+		os.Exit(1)
+	}
+
+	if templateFile != "" {
+		if c.Template, err = pkiutil.ParseTemplateFile(templateFile); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if intermediateCSR != "" {
+		if c.IntermediateExtensions, err = pkiutil.ReadCSRExtensions(intermediateCSR); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if keyPolicy != "" {
+		if c.KeyPolicy, err = readKeyPolicy(keyPolicy); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	switch strings.ToUpper(curve) {
+	case "P-256":
+		c.SignatureAlgorithm = apiv1.ECDSAWithSHA256
+	case "P-384":
+		c.SignatureAlgorithm = apiv1.ECDSAWithSHA384
+	case "P-521":
+		c.SignatureAlgorithm = apiv1.ECDSAWithSHA512
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--curve`; options are `P-256`, `P-384` or `P-521`\n", curve)
+		os.Exit(1)
+	}
+
+	switch strings.ToUpper(sshCurve) {
+	case "P-256":
+		c.SSHSignatureAlgorithm = apiv1.ECDSAWithSHA256
+	case "P-384":
+		c.SSHSignatureAlgorithm = apiv1.ECDSAWithSHA384
+	case "P-521":
+		c.SSHSignatureAlgorithm = apiv1.ECDSAWithSHA512
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--ssh-curve`; options are `P-256`, `P-384` or `P-521`\n", sshCurve)
+		os.Exit(1)
+	}
+
+	if rootKeyType != "" {
+		if c.RootKeyType.SignatureAlgorithm, c.RootKeyType.Bits, err = pkiutil.ParseKeyType(rootKeyType); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--root-key-type`; options are `P-256`, `P-384`, `P-521`, `RSA-2048`, `RSA-3072` or `RSA-4096`\n", rootKeyType)
+			os.Exit(1)
+		}
+	}
+	if intermediateKeyType != "" {
+		if c.NoIntermediate {
+			fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--intermediate-key-type`")
+			os.Exit(1)
+		}
+		if c.IntermediateKeyType.SignatureAlgorithm, c.IntermediateKeyType.Bits, err = pkiutil.ParseKeyType(intermediateKeyType); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--intermediate-key-type`; options are `P-256`, `P-384`, `P-521`, `RSA-2048`, `RSA-3072` or `RSA-4096`\n", intermediateKeyType)
+			os.Exit(1)
+		}
+	}
+
+	if c.FIPS {
+		fips.Enable()
+	}
+	if err := pkiutil.CheckFIPSKeyTypes(append(pkiutil.Intermediates{
+		{SignatureAlgorithm: c.SignatureAlgorithm},
+		{SignatureAlgorithm: c.SSHSignatureAlgorithm},
+		c.RootKeyType,
+		c.IntermediateKeyType,
+	}, c.Intermediates...)...); err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	k, err := awskms.New(context.Background(), apiv1.Options{
+		Type:            string(apiv1.AmazonKMS),
+		Region:          c.Region,
+		CredentialsFile: c.CredentialsFile,
+	})
+	if err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	p := &pkiutil.Init{
+		KMS:        k,
+		FileMode:   c.FileMode,
+		Format:     c.Format,
+		DER:        c.DER,
+		StoreCerts: c.StoreCerts,
+		Quiet:      quiet,
+	}
+
+	if c.Check {
+		if err := k.CheckHealth(&apiv1.CheckHealthRequest{}); err != nil {
+			kmsinit.Fatal(err)
+		}
+		p.PrintSelected("AWS KMS", "OK")
+		return
+	}
+
+	if c.AppendToChain != "" {
+		if err := p.AppendToChain(c.AppendToChain, c.OldRoot, c.OldKey); err != nil {
+			kmsinit.Fatal(err)
+		}
+		return
+	}
+
+	if c.StoreCerts {
+		if _, ok := apiv1.KeyManager(k).(apiv1.CertificateManager); !ok {
+			fmt.Fprintln(os.Stderr, "flag `--store-certs` is not supported: awsKMS does not support storing certificates")
+			os.Exit(1)
+		}
+	}
+
+	// Check if the keys already exist, fail if they do
+	if !c.Force && !c.ReuseKeys {
+		if err := p.CheckKeys("", c.KeyNames()); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	ca := &pkiutil.CAConfig{
+		FIPS: fips.Enabled(),
+		KMS: &apiv1.Options{
+			Type:            string(apiv1.AmazonKMS),
+			Region:          c.Region,
+			CredentialsFile: c.CredentialsFile,
+		},
+	}
+
+	p.PrintLine("Creating X.509 PKI ...")
+	if err := createX509(p, c, ca); err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	if c.SSH {
+		p.PrintLine()
+		p.PrintLine("Creating SSH Keys ...")
+		user, host := c.KeyRequest("ssh-user-key"), c.KeyRequest("ssh-host-key")
+		user.SignatureAlgorithm, host.SignatureAlgorithm = c.SSHSignatureAlgorithm, c.SSHSignatureAlgorithm
+		if err := p.CreateSSHKeys(c.SSHName, user, host, ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if c.CAConfigFile != "" {
+		p.PrintLine()
+		if err := p.WriteCAConfig(c.CAConfigFile, ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+}
+
+// readKeyPolicy reads the key policy in the given file and checks that it is a
+// well-formed JSON document.
+func readKeyPolicy(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading %s", filename)
+	}
+	if !json.Valid(b) {
+		return "", errors.Errorf("error reading %s: the key policy is not a valid JSON document", filename)
+	}
+	return string(b), nil
+}
+
+func createX509(p *pkiutil.Init, c Config, ca *pkiutil.CAConfig) error {
+	// The key type of the root and intermediates can be different, the
+	// signature algorithm of the certificates is defined by the root key.
+	root := c.keyTemplate()
+	if c.RootKeyType.SignatureAlgorithm != apiv1.UnspecifiedSignAlgorithm {
+		root.SignatureAlgorithm, root.Bits = c.RootKeyType.SignatureAlgorithm, c.RootKeyType.Bits
+	}
+	opts := pkiutil.PKIOptions{
+		RootKey:                pkiutil.KeyRequest(root, "root"),
+		SKIDMethod:             c.SKIDMethod,
+		Template:               c.Template,
+		NoWarmup:               c.NoWarmup,
+		IntermediateExtensions: c.IntermediateExtensions,
+		PolicyOIDs:             c.PolicyOIDs,
+		RootPolicies:           c.RootPolicies,
+		ExistingRootKey:        c.RootKey,
+		NotBefore:              c.NotBefore,
+		NotAfter:               c.NotAfter,
+	}
+	if !c.NoIntermediate {
+		intermediates := c.Intermediates
+		if len(intermediates) == 0 {
+			intermediates = pkiutil.Intermediates{{}}
+		}
+		template := c.keyTemplate()
+		if c.IntermediateKeyType.SignatureAlgorithm != apiv1.UnspecifiedSignAlgorithm {
+			template.SignatureAlgorithm, template.Bits = c.IntermediateKeyType.SignatureAlgorithm, c.IntermediateKeyType.Bits
+		}
+		for _, in := range intermediates {
+			opts.Intermediates = append(opts.Intermediates, pkiutil.PKIIntermediate{
+				Name: in.Name,
+				Key:  pkiutil.IntermediateKeyRequest(template, in),
+			})
+		}
+	}
+
+	// Load the external root before creating any key.
+	var cross *pkiutil.CrossSigner
+	if c.CrossRoot != "" {
+		var err error
+		if cross, err = p.LoadCrossSigner(c.CrossRoot, c.CrossKey); err != nil {
+			return err
+		}
+	}
+
+	pki, err := pkiutil.CreatePKI(p.KMS, opts)
+	if err != nil {
+		return err
+	}
+
+	return p.WritePKI(pki, cross, ca)
+}
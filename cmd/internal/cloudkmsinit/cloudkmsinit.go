@@ -0,0 +1,572 @@
+// Package cloudkmsinit implements the initialization of a PKI using Cloud KMS.
+package cloudkmsinit
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/smallstep/certificates/cmd/internal/kmsinit"
+	"github.com/smallstep/certificates/fips"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkiutil"
+	"github.com/smallstep/cli/ui"
+)
+
+// Config is the configuration used to initialize the PKI.
+type Config struct {
+	CredentialsFile    string
+	Project            string
+	Location           string
+	Ring               string
+	ProtectionLevel    apiv1.ProtectionLevel
+	SignatureAlgorithm apiv1.SignatureAlgorithm
+	// SSHSignatureAlgorithm is the algorithm of the SSH CA keys.
+	SSHSignatureAlgorithm apiv1.SignatureAlgorithm
+	SKIDMethod            pkiutil.SubjectKeyIDMethod
+	StoreCerts            bool
+	NoIntermediate        bool
+	Intermediates         pkiutil.Intermediates
+	CSRFile               string
+	SSH                   bool
+	List                  bool
+	CreateRing            bool
+	Check                 bool
+	Force                 bool
+	NoWarmup              bool
+	CAConfigFile          string
+	// ReuseKeys reuses the existing keys with the same names instead of
+	// creating new versions of them, so a failed run can be repeated.
+	ReuseKeys bool
+	// AppendToChain, OldRoot and OldKey are used to cross-sign the new root
+	// certificate in AppendToChain with the old root and its key.
+	AppendToChain string
+	OldRoot       string
+	OldKey        string
+	// CrossRoot and CrossKey are used to cross-sign the intermediates with an
+	// external root and its key.
+	CrossRoot string
+	CrossKey  string
+	// Rotate, Root and RootKey are used to create new versions of the
+	// intermediate keys and re-issue their certificates with the root in Root
+	// and its key.
+	Rotate  bool
+	Root    string
+	RootKey string
+	// Template customizes the root and intermediate certificates.
+	Template *pkiutil.TemplateFile
+	// FileMode is the permission of the written certificates and keys.
+	FileMode os.FileMode
+	// Format is the encoding of the written certificates.
+	Format pkiutil.CertificateFormat
+	// DER writes a copy in binary DER of the root and intermediate
+	// certificates.
+	DER bool
+	// SSHName identifies the SSH CA in the comments of its public keys.
+	SSHName string
+	// IntermediateExtensions are the extensions requested by the CSR in
+	// --intermediate-csr, added to the intermediate certificates.
+	IntermediateExtensions []pkix.Extension
+	// PolicyOIDs are the certificate policies of the intermediates, and of
+	// the root with RootPolicies.
+	PolicyOIDs   pkiutil.PolicyOIDs
+	RootPolicies bool
+	// NotBefore and NotAfter are the fixed validity of the root and
+	// intermediate certificates, by default they are valid from now and for
+	// ten years.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// CredentialsSecret is the Secret Manager secret with the Cloud KMS
+	// credentials.
+	CredentialsSecret string
+	// FIPS only allows the algorithms approved by FIPS 186-4.
+	FIPS bool
+}
+
+// Parent returns the name of the key ring where the keys will be created.
+func (c *Config) Parent() string {
+	return "projects/" + c.Project + "/locations/" + c.Location + "/keyRings/" + c.Ring
+}
+
+// KeyNames returns the names of the keys that will be created.
+func (c *Config) KeyNames() []string {
+	parent := c.Parent() + "/cryptoKeys"
+
+	var names []string
+	switch {
+	case c.CSRFile != "":
+		names = append(names, parent+"/intermediate")
+	case c.NoIntermediate:
+		names = append(names, parent+"/root")
+	case len(c.Intermediates) == 0:
+		names = append(names, parent+"/root", parent+"/intermediate")
+	default:
+		names = append(names, parent+"/root")
+		for _, in := range c.Intermediates {
+			names = append(names, parent+"/"+pkiutil.IntermediateKeyName(in.Name))
+		}
+	}
+	if c.SSH {
+		names = append(names, parent+"/ssh-user-key", parent+"/ssh-host-key")
+	}
+	return names
+}
+
+// KeyRequest returns the request used to create the key with the given name
+// in the key ring.
+func (c *Config) KeyRequest(name string) *apiv1.CreateKeyRequest {
+	return pkiutil.KeyRequest(c.keyTemplate(), name)
+}
+
+// keyTemplate returns the options used to create all the keys, the name is
+// the prefix of the keys in the key ring.
+func (c *Config) keyTemplate() apiv1.CreateKeyRequest {
+	return apiv1.CreateKeyRequest{
+		Name:               c.Parent() + "/cryptoKeys/",
+		SignatureAlgorithm: c.SignatureAlgorithm,
+		ProtectionLevel:    c.ProtectionLevel,
+		Idempotent:         c.ReuseKeys,
+	}
+}
+
+// PKIOptions returns the options used to create or rotate the intermediates,
+// without intermediates with NoIntermediate.
+func (c *Config) PKIOptions() pkiutil.PKIOptions {
+	opts := pkiutil.PKIOptions{
+		SKIDMethod:             c.SKIDMethod,
+		Template:               c.Template,
+		NoWarmup:               c.NoWarmup,
+		IntermediateExtensions: c.IntermediateExtensions,
+		PolicyOIDs:             c.PolicyOIDs,
+		RootPolicies:           c.RootPolicies,
+		NotBefore:              c.NotBefore,
+		NotAfter:               c.NotAfter,
+	}
+	if c.NoIntermediate {
+		return opts
+	}
+	intermediates := c.Intermediates
+	if len(intermediates) == 0 {
+		intermediates = pkiutil.Intermediates{{}}
+	}
+	for _, in := range intermediates {
+		opts.Intermediates = append(opts.Intermediates, pkiutil.PKIIntermediate{
+			Name: in.Name,
+			Key:  pkiutil.IntermediateKeyRequest(c.keyTemplate(), in),
+		})
+	}
+	return opts
+}
+
+// Run initializes a PKI using Google's Cloud KMS. The name of the command is used in
+// the usage, and args are the command line arguments without it.
+func Run(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var c Config
+	var quiet bool
+	var protectionLevelName, curve, sshCurve, skidMethod, fileMode, format, templateFile, intermediateCSR, notBefore, notAfter string
+	fs.StringVar(&c.CredentialsFile, "credentials-file", "", "Path to the `file` containing the Google's Cloud KMS credentials.")
+	fs.StringVar(&c.CredentialsSecret, "credentials-secret", "", "Google's Cloud KMS credentials stored in the Secret Manager `secret`, projects/<project>/secrets/<secret>[/versions/<version>]. The secret is read using --credentials-file or the default credentials.")
+	fs.StringVar(&c.Project, "project", "", "Google Cloud Project ID.")
+	fs.StringVar(&c.Location, "location", "global", "Cloud KMS location name.")
+	fs.StringVar(&c.Ring, "ring", "pki", "Cloud KMS ring name.")
+	fs.StringVar(&protectionLevelName, "protection-level", "SOFTWARE", "Protection level to use, SOFTWARE or HSM.")
+	fs.StringVar(&curve, "curve", "P-256", "Elliptic curve to use for the keys, P-256 or P-384.")
+	fs.StringVar(&skidMethod, "skid-method", string(pkiutil.RFC5280), "Method used to compute the subject key identifier, rfc5280 (SHA-1) or rfc7093 (truncated SHA-256).")
+	fs.StringVar(&c.CSRFile, "csr-out", "", "Create only the intermediate key and write its certificate signing request to `file`.")
+	fs.BoolVar(&c.SSH, "ssh", false, "Create SSH keys.")
+	fs.StringVar(&sshCurve, "ssh-curve", "P-256", "Elliptic curve to use for the SSH keys, P-256 or P-384.")
+	fs.StringVar(&c.SSHName, "ssh-name", pkiutil.DefaultSSHName, "The `name` of the SSH CA written in the comments of its public keys, e.g. \"<name> SSH User CA\".")
+	fs.BoolVar(&c.StoreCerts, "store-certs", false, "Store the certificates in the KMS, if the KMS supports it.")
+	fs.Var(&c.Intermediates, "intermediate", "Create an intermediate with the format `name=<name>[,keytype=<type>]`, the key type is one of P-256, P-384, P-521, RSA-2048, RSA-3072 or RSA-4096. Use it multiple times to create multiple intermediates.")
+	fs.BoolVar(&c.NoIntermediate, "no-intermediate", false, "Create only the root certificate, leaf certificates will be signed directly by the root.")
+	fs.BoolVar(&c.List, "list", false, "List the available key rings and keys and exit.")
+	fs.StringVar(&c.CAConfigFile, "write-ca-config", "", "Write a starter ca.json with the created certificates and keys to `file`.")
+	fs.BoolVar(&c.Check, "check", false, "Check that Cloud KMS is reachable and the credentials can access the key ring and exit.")
+	fs.BoolVar(&c.NoWarmup, "no-warmup", false, "Do not sign a throwaway digest to warm up the KMS before signing the certificates.")
+	fs.BoolVar(&c.Force, "force", false, "Create new versions of the keys if keys with the same names already exist.")
+	fs.BoolVar(&c.ReuseKeys, "reuse-keys", false, "Reuse the existing keys with the same names instead of failing or creating new versions, so a failed run can be repeated.")
+	fs.BoolVar(&c.CreateRing, "create-ring", false, "Create the key ring if it does not exist. The location must be a valid Cloud KMS location.")
+	fs.StringVar(&c.AppendToChain, "append-to-chain", "", "Cross-sign the new root certificate in `file` with the old root and write it to cross_signed_root.crt and exit, requires --old-root and --old-key.")
+	fs.StringVar(&c.OldRoot, "old-root", "", "Path to the old root certificate `file` used with --append-to-chain.")
+	fs.StringVar(&c.OldKey, "old-key", "", "Cloud KMS `key` version name or URI of the old root key used with --append-to-chain.")
+	fs.StringVar(&c.CrossRoot, "cross-root", "", "Path to an external root certificate `file` used to cross-sign the intermediates, written to intermediate_ca_cross.crt, requires --cross-key.")
+	fs.StringVar(&c.CrossKey, "cross-key", "", "Cloud KMS `key` version name or URI of the external root key used with --cross-root.")
+	fs.BoolVar(&c.Rotate, "rotate", false, "Create new versions of the intermediate keys and re-issue their certificates with the root, requires --root and --root-key.")
+	fs.StringVar(&c.Root, "root", "", "Path to the root certificate `file` used with --rotate.")
+	fs.StringVar(&c.RootKey, "root-key", "", "Cloud KMS `key` version name or URI of the root key used with --rotate.")
+	fs.StringVar(&templateFile, "template", "", "Path to a Go template `file` rendering a JSON certificate template used to customize the root and intermediate certificates.")
+	fs.StringVar(&notBefore, "not-before", "", "The `time` in RFC 3339 format when the root and intermediate certificates become valid, e.g. 2030-01-01T00:00:00Z. Defaults to now.")
+	fs.StringVar(&notAfter, "not-after", "", "The `time` in RFC 3339 format when the root and intermediate certificates expire, e.g. 2040-01-01T00:00:00Z. Defaults to ten years after --not-before.")
+	fs.StringVar(&intermediateCSR, "intermediate-csr", "", "Path to a certificate signing request `file` whose requested extensions are added to the intermediate certificates.")
+	fs.Var(&c.PolicyOIDs, "policy-oid", "Add the certificate policy `oid` in dotted notation to the intermediate certificates. Use it multiple times to add multiple policies.")
+	fs.BoolVar(&c.RootPolicies, "root-policies", false, "Add the --policy-oid policies to the root certificate too.")
+	fs.StringVar(&fileMode, "file-mode", "0600", "Octal permission `mode` of the written certificates and keys, e.g. 0640 to allow the group to read them.")
+	fs.StringVar(&format, "format", "pem", "The `format` of the written root and intermediate certificates, pem (.crt) or der (.cer).")
+	fs.BoolVar(&c.FIPS, "fips", false, "Only allow the algorithms approved by FIPS 186-4 and enable the FIPS-only mode in the written ca.json.")
+	fs.BoolVar(&c.DER, "der", false, "Also write the root and intermediate certificates in binary DER to root_ca.der and intermediate_ca.der.")
+	fs.BoolVar(&quiet, "quiet", false, "Do not print the progress and the created keys and certificates, errors are still printed to stderr.")
+	fs.Usage = func() { kmsinit.Usage(fs, "--project <name>") }
+	fs.Parse(args)
+
+	switch {
+	case c.Project == "":
+		fs.Usage()
+	case c.Location == "":
+		fmt.Fprintln(os.Stderr, "flag `--location` is required")
+		os.Exit(1)
+	case c.Ring == "":
+		fmt.Fprintln(os.Stderr, "flag `--ring` is required")
+		os.Exit(1)
+	case protectionLevelName == "":
+		fmt.Fprintln(os.Stderr, "flag `--protection-level` is required")
+		os.Exit(1)
+	case c.NoIntermediate && c.CSRFile != "":
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--csr-out`")
+		os.Exit(1)
+	case c.NoIntermediate && len(c.Intermediates) > 0:
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--intermediate`")
+		os.Exit(1)
+	case c.CSRFile != "" && len(c.Intermediates) > 0:
+		fmt.Fprintln(os.Stderr, "flag `--csr-out` is incompatible with flag `--intermediate`")
+		os.Exit(1)
+	case c.CSRFile != "" && c.CAConfigFile != "":
+		fmt.Fprintln(os.Stderr, "flag `--csr-out` is incompatible with flag `--write-ca-config`")
+		os.Exit(1)
+	case c.CSRFile != "" && templateFile != "":
+		fmt.Fprintln(os.Stderr, "flag `--csr-out` is incompatible with flag `--template`")
+		os.Exit(1)
+	case c.CSRFile != "" && intermediateCSR != "":
+		fmt.Fprintln(os.Stderr, "flag `--csr-out` is incompatible with flag `--intermediate-csr`")
+		os.Exit(1)
+	case c.NoIntermediate && intermediateCSR != "":
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--intermediate-csr`")
+		os.Exit(1)
+	case c.CSRFile != "" && len(c.PolicyOIDs) > 0:
+		fmt.Fprintln(os.Stderr, "flag `--csr-out` is incompatible with flag `--policy-oid`")
+		os.Exit(1)
+	case c.RootPolicies && len(c.PolicyOIDs) == 0:
+		fmt.Fprintln(os.Stderr, "flag `--root-policies` requires flag `--policy-oid`")
+		os.Exit(1)
+	case (c.AppendToChain != "" || c.OldRoot != "" || c.OldKey != "") && (c.AppendToChain == "" || c.OldRoot == "" || c.OldKey == ""):
+		fmt.Fprintln(os.Stderr, "flags `--append-to-chain`, `--old-root` and `--old-key` must be used together")
+		os.Exit(1)
+	case (c.CrossRoot != "" || c.CrossKey != "") && (c.CrossRoot == "" || c.CrossKey == ""):
+		fmt.Fprintln(os.Stderr, "flags `--cross-root` and `--cross-key` must be used together")
+		os.Exit(1)
+	case c.NoIntermediate && c.CrossRoot != "":
+		fmt.Fprintln(os.Stderr, "flag `--no-intermediate` is incompatible with flag `--cross-root`")
+		os.Exit(1)
+	case c.CSRFile != "" && c.CrossRoot != "":
+		fmt.Fprintln(os.Stderr, "flag `--csr-out` is incompatible with flag `--cross-root`")
+		os.Exit(1)
+	case c.Rotate && c.CrossRoot != "":
+		fmt.Fprintln(os.Stderr, "flag `--rotate` is incompatible with flag `--cross-root`")
+		os.Exit(1)
+	case (c.Rotate || c.Root != "" || c.RootKey != "") && (!c.Rotate || c.Root == "" || c.RootKey == ""):
+		fmt.Fprintln(os.Stderr, "flags `--rotate`, `--root` and `--root-key` must be used together")
+		os.Exit(1)
+	case c.Rotate && c.NoIntermediate:
+		fmt.Fprintln(os.Stderr, "flag `--rotate` is incompatible with flag `--no-intermediate`")
+		os.Exit(1)
+	case c.Rotate && c.CSRFile != "":
+		fmt.Fprintln(os.Stderr, "flag `--rotate` is incompatible with flag `--csr-out`")
+		os.Exit(1)
+	case c.Rotate && c.SSH:
+		fmt.Fprintln(os.Stderr, "flag `--rotate` is incompatible with flag `--ssh`")
+		os.Exit(1)
+	case c.Rotate && c.RootPolicies:
+		fmt.Fprintln(os.Stderr, "flag `--rotate` is incompatible with flag `--root-policies`")
+		os.Exit(1)
+	case c.Rotate && c.ReuseKeys:
+		fmt.Fprintln(os.Stderr, "flag `--rotate` is incompatible with flag `--reuse-keys`")
+		os.Exit(1)
+	case c.Force && c.ReuseKeys:
+		fmt.Fprintln(os.Stderr, "flag `--force` is incompatible with flag `--reuse-keys`")
+		os.Exit(1)
+	}
+
+	for _, in := range c.Intermediates {
+		if in.SignatureAlgorithm == apiv1.ECDSAWithSHA512 {
+			fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--intermediate`; Cloud KMS does not support the keytype `P-521`\n", in.Name)
+			os.Exit(1)
+		}
+	}
+
+	skid, err := pkiutil.ParseSubjectKeyIDMethod(skidMethod)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--skid-method`; options are `rfc5280` or `rfc7093`\n", skidMethod)
+		os.Exit(1)
+	}
+	c.SKIDMethod = skid
+
+	if c.FileMode, err = pkiutil.ParseFileMode(fileMode); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--file-mode`; the owner must read and write, and the group and others can only read, e.g. `0600` or `0640`\n", fileMode)
+		os.Exit(1)
+	}
+
+	if c.Format, err = pkiutil.ParseCertificateFormat(format); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--format`; options are `pem` or `der`\n", format)
+		os.Exit(1)
+	}
+
+	if c.NotBefore, c.NotAfter, err = pkiutil.ParseValidity(notBefore, notAfter); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value for flags `--not-before` and `--not-after`: %v\n", err)
+		os.Exit(1)
+	}
+
+	if templateFile != "" {
+		if c.Template, err = pkiutil.ParseTemplateFile(templateFile); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if intermediateCSR != "" {
+		if c.IntermediateExtensions, err = pkiutil.ReadCSRExtensions(intermediateCSR); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	switch strings.ToUpper(protectionLevelName) {
+	case "SOFTWARE":
+		c.ProtectionLevel = apiv1.Software
+	case "HSM":
+		c.ProtectionLevel = apiv1.HSM
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--protection-level`; options are `SOFTWARE` or `HSM`\n", protectionLevelName)
+		os.Exit(1)
+	}
+
+	// Cloud KMS does not support ECDSA on the NIST P-521 curve.
+	switch strings.ToUpper(curve) {
+	case "P-256":
+		c.SignatureAlgorithm = apiv1.ECDSAWithSHA256
+	case "P-384":
+		c.SignatureAlgorithm = apiv1.ECDSAWithSHA384
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--curve`; options are `P-256` or `P-384`\n", curve)
+		os.Exit(1)
+	}
+
+	switch strings.ToUpper(sshCurve) {
+	case "P-256":
+		c.SSHSignatureAlgorithm = apiv1.ECDSAWithSHA256
+	case "P-384":
+		c.SSHSignatureAlgorithm = apiv1.ECDSAWithSHA384
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--ssh-curve`; options are `P-256` or `P-384`\n", sshCurve)
+		os.Exit(1)
+	}
+
+	if c.FIPS {
+		fips.Enable()
+	}
+	if err := pkiutil.CheckFIPSKeyTypes(append(pkiutil.Intermediates{
+		{SignatureAlgorithm: c.SignatureAlgorithm},
+		{SignatureAlgorithm: c.SSHSignatureAlgorithm},
+	}, c.Intermediates...)...); err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	k, err := cloudkms.New(context.Background(), apiv1.Options{
+		Type:              string(apiv1.CloudKMS),
+		CredentialsFile:   c.CredentialsFile,
+		CredentialsSecret: c.CredentialsSecret,
+	})
+	if err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	if c.StoreCerts {
+		if _, ok := apiv1.KeyManager(k).(apiv1.CertificateManager); !ok {
+			fmt.Fprintln(os.Stderr, "flag `--store-certs` is not supported: cloudKMS does not support storing certificates")
+			os.Exit(1)
+		}
+	}
+
+	p := &pkiutil.Init{
+		KMS:        k,
+		FileMode:   c.FileMode,
+		Format:     c.Format,
+		DER:        c.DER,
+		StoreCerts: c.StoreCerts,
+		Quiet:      quiet,
+	}
+
+	if c.Check {
+		if err := k.CheckHealth(&apiv1.CheckHealthRequest{Name: c.Parent()}); err != nil {
+			kmsinit.Fatal(err)
+		}
+		p.PrintSelected("Cloud KMS", "OK")
+		p.PrintSelected("Key Ring", c.Parent())
+		return
+	}
+
+	if c.List {
+		if err := listKeys(k, c); err != nil {
+			kmsinit.Fatal(err)
+		}
+		return
+	}
+
+	if c.AppendToChain != "" {
+		if err := p.AppendToChain(c.AppendToChain, c.OldRoot, c.OldKey); err != nil {
+			kmsinit.Fatal(err)
+		}
+		return
+	}
+
+	// Fail early with an actionable message if the location does not offer
+	// the protection level, instead of failing when the keys are created.
+	if err := k.CheckProtectionLevel("projects/"+c.Project+"/locations/"+c.Location, c.ProtectionLevel); err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	if c.CreateRing {
+		if err := k.CreateKeyRing(c.Parent()); err != nil {
+			kmsinit.Fatal(err)
+		}
+		p.PrintSelected("Key Ring", c.Parent())
+		p.PrintLine()
+	}
+
+	// Check if the keys already exist, fail if they do. Rotated keys must
+	// already exist.
+	if !c.Force && !c.Rotate && !c.ReuseKeys {
+		if err := p.CheckKeys(c.Parent(), c.KeyNames()); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	ca := &pkiutil.CAConfig{
+		FIPS: fips.Enabled(),
+		KMS: &apiv1.Options{
+			Type:              string(apiv1.CloudKMS),
+			CredentialsFile:   c.CredentialsFile,
+			CredentialsSecret: c.CredentialsSecret,
+		},
+	}
+
+	switch {
+	case c.CSRFile != "":
+		p.PrintLine("Creating Intermediate CSR ...")
+		if err := p.CreateIntermediateCSR(c.KeyRequest("intermediate"), c.CSRFile, c.NoWarmup); err != nil {
+			kmsinit.Fatal(err)
+		}
+	case c.Rotate:
+		p.PrintLine("Rotating Intermediate Keys ...")
+		if err := p.RotateIntermediates(c.Root, c.RootKey, c.PKIOptions(), ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	default:
+		p.PrintLine("Creating PKI ...")
+		if err := createPKI(p, c, ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if c.SSH {
+		p.PrintLine()
+		p.PrintLine("Creating SSH Keys ...")
+		user, host := c.KeyRequest("ssh-user-key"), c.KeyRequest("ssh-host-key")
+		user.SignatureAlgorithm, host.SignatureAlgorithm = c.SSHSignatureAlgorithm, c.SSHSignatureAlgorithm
+		host.ProtectionLevel = apiv1.Software
+		if err := p.CreateSSHKeys(c.SSHName, user, host, ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if c.CAConfigFile != "" {
+		p.PrintLine()
+		if err := p.WriteCAConfig(c.CAConfigFile, ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+}
+
+// listKeys prints the key rings available in the location and the keys in the
+// given key ring.
+func listKeys(k *cloudkms.CloudKMS, c Config) error {
+	parent := "projects/" + c.Project + "/locations/" + c.Location
+
+	rings, err := k.ListKeyRings(parent)
+	if err != nil {
+		return err
+	}
+
+	ui.Printf("Key rings in %s:\n", parent)
+	if len(rings) == 0 {
+		ui.Println("  (none)")
+	}
+	var found bool
+	for _, name := range rings {
+		if name == c.Parent() {
+			found = true
+		}
+		ui.Printf("  %s\n", name)
+	}
+
+	ui.Println()
+	if !found {
+		ui.Printf("Key ring %s does not exist in %s.\n", c.Ring, parent)
+		return nil
+	}
+
+	resp, err := k.ListKeys(&apiv1.ListKeysRequest{
+		Parent: c.Parent(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ui.Printf("Keys in %s:\n", c.Parent())
+	if len(resp.Keys) == 0 {
+		ui.Println("  (none)")
+	}
+	for _, key := range resp.Keys {
+		ui.Printf("  %s\n", key.Name)
+	}
+
+	return nil
+}
+
+// createPKI creates concurrently the root key and the intermediate keys, the
+// key generation can be slow, specially with the HSM protection level, and
+// signs the certificates once all of them are available. If the creation of
+// any key fails, the keys already created are destroyed, unless the keys are
+// reused.
+func createPKI(p *pkiutil.Init, c Config, ca *pkiutil.CAConfig) error {
+	opts := c.PKIOptions()
+
+	// Load the external root before creating any key.
+	var cross *pkiutil.CrossSigner
+	if c.CrossRoot != "" {
+		var err error
+		if cross, err = p.LoadCrossSigner(c.CrossRoot, c.CrossKey); err != nil {
+			return err
+		}
+	}
+
+	reqs := []*apiv1.CreateKeyRequest{c.KeyRequest("root")}
+	for _, in := range opts.Intermediates {
+		reqs = append(reqs, in.Key)
+	}
+	// Reused keys can belong to a previous run, and new ones will be reused
+	// by the next one.
+	keys, err := p.CreateKeys(reqs, !c.ReuseKeys)
+	if err != nil {
+		return err
+	}
+
+	pki, err := pkiutil.SignPKI(p.KMS, keys, opts)
+	if err != nil {
+		return err
+	}
+
+	return p.WritePKI(pki, cross, ca)
+}
@@ -0,0 +1,123 @@
+// Package kmsinit contains the helpers shared by the commands that initialize
+// a PKI using a KMS.
+package kmsinit
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/pkiutil"
+)
+
+// Fatal prints the error and exits with the code of the error, see
+// pkiutil.ExitCode.
+func Fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(pkiutil.ExitCode(err))
+}
+
+// Usage prints the usage of the command with the given flag set and exits.
+// The synopsis is printed after the name of the flag set, the command is its
+// first word.
+func Usage(fs *flag.FlagSet, synopsis string) {
+	if synopsis != "" {
+		synopsis = " " + synopsis
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s%s\n", fs.Name(), synopsis)
+	fmt.Fprintf(os.Stderr, `
+The %s command initializes a public key infrastructure (PKI)
+to be used by step-ca.
+
+This tool is experimental and in the future it will be integrated in step cli.
+
+OPTIONS
+`, strings.Fields(fs.Name())[0])
+	fmt.Fprintln(os.Stderr)
+	fs.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `
+COPYRIGHT
+
+  (c) 2018-2020 Smallstep Labs, Inc.`)
+	os.Exit(1)
+}
+
+// detectFlags are the flags that select a KMS when the flag --kms is not set.
+var detectFlags = []struct {
+	name string
+	kms  apiv1.Type
+}{
+	{"project", apiv1.CloudKMS},
+	{"region", apiv1.AmazonKMS},
+	{"pin-file", apiv1.YubiKey},
+}
+
+// KMSType returns the KMS selected in the command line arguments, and the
+// arguments without the flag --kms. If the flag is not set, --project selects
+// Cloud KMS, --region AWS KMS and --pin-file a YubiKey, otherwise the KMS is
+// detected in the environment using the given function, e.g. os.Getenv.
+func KMSType(args []string, getenv func(string) string) (apiv1.Type, []string, error) {
+	var rest []string
+	var kmsType string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := splitFlag(args[i])
+		if name != "kms" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return apiv1.DefaultKMS, nil, errors.New("flag `--kms` needs an argument")
+			}
+			i++
+			value = args[i]
+		}
+		kmsType = value
+	}
+
+	if kmsType != "" {
+		t, err := pkiutil.ParseKMSType(kmsType)
+		if err != nil {
+			return apiv1.DefaultKMS, nil, errors.Errorf("invalid value `%s` for flag `--kms`; options are `cloudkms`, `awskms` or `yubikey`", kmsType)
+		}
+		return t, rest, nil
+	}
+
+	for _, f := range detectFlags {
+		for _, arg := range rest {
+			if arg == "--" {
+				break
+			}
+			if name, _, _ := splitFlag(arg); name == f.name {
+				return f.kms, rest, nil
+			}
+		}
+	}
+
+	t, err := pkiutil.DetectKMSType(getenv)
+	if err != nil {
+		return apiv1.DefaultKMS, nil, errors.Errorf("%v; use the flag `--kms`", err)
+	}
+	return t, rest, nil
+}
+
+// splitFlag returns the name and value of a flag in the formats accepted by
+// the flag package, -name, --name, -name=value or --name=value. The name is
+// empty if the argument is not a flag.
+func splitFlag(arg string) (name, value string, hasValue bool) {
+	if len(arg) < 2 || arg[0] != '-' {
+		return "", "", false
+	}
+	name = strings.TrimPrefix(arg[1:], "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		return name[:i], name[i+1:], true
+	}
+	return name, "", false
+}
@@ -0,0 +1,627 @@
+// Package yubikeyinit implements the initialization of a PKI using YubiKey.
+package yubikeyinit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cmd/internal/kmsinit"
+	"github.com/smallstep/certificates/fips"
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/pkiutil"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+	"golang.org/x/crypto/ed25519"
+
+	// Enable yubikey.
+	_ "github.com/smallstep/certificates/kms/yubikey"
+)
+
+// Config is the configuration used to initialize the PKI.
+type Config struct {
+	RootOnly   bool
+	RootSlot   string
+	CrtSlot    string
+	RootFile   string
+	KeyFile    string
+	Pin        string
+	Force      bool
+	Curve      string
+	SKIDMethod pkiutil.SubjectKeyIDMethod
+	P12Out     string
+	KeyFormat  string
+	// Algorithm is the algorithm of the intermediate key created in software
+	// with RootOnly, ecdsa with the configured curve or ed25519.
+	Algorithm string
+	// PasswordFile is the path to the file with the password used to encrypt
+	// the intermediate key created with RootOnly, Password is its content.
+	PasswordFile string
+	Password     []byte
+	// PinFile is the path to the file with the YubiKey PIN, if it is not set
+	// the PIN will be prompted.
+	PinFile string
+	// NoIntermediate creates only the root certificate, unlike RootOnly that
+	// creates the intermediate key in a file.
+	NoIntermediate bool
+	// Check only verifies that the YubiKey is connected and responding.
+	Check bool
+	// CAConfigFile is the path where a starter ca.json will be written.
+	CAConfigFile string
+	// AppendToChain is the path to a new root certificate that will be
+	// cross-signed with the old root in OldRoot and the key in the OldKey
+	// slot.
+	AppendToChain string
+	OldRoot       string
+	OldKey        string
+	// CrossRoot is the path to an external root certificate used to
+	// cross-sign the intermediate with the key in the CrossKey slot.
+	CrossRoot string
+	CrossKey  string
+	// Template customizes the root and intermediate certificates.
+	Template *pkiutil.TemplateFile
+	// FileMode is the permission of the written certificates and keys.
+	FileMode os.FileMode
+	// Format is the encoding of the written certificates.
+	Format pkiutil.CertificateFormat
+	// DER writes a copy in binary DER of the root and intermediate
+	// certificates.
+	DER bool
+	// TouchPolicy and PINPolicy are the policies of the keys created in the
+	// YubiKey.
+	TouchPolicy apiv1.TouchPolicy
+	PINPolicy   apiv1.PINPolicy
+	// IntermediateExtensions are the extensions requested by the CSR in
+	// --intermediate-csr, added to the intermediate certificates.
+	IntermediateExtensions []pkix.Extension
+	// PolicyOIDs are the certificate policies of the intermediates, and of
+	// the root with RootPolicies.
+	PolicyOIDs   pkiutil.PolicyOIDs
+	RootPolicies bool
+	// NotBefore and NotAfter are the fixed validity of the root and
+	// intermediate certificates, by default they are valid from now and for
+	// ten years.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// FIPS only allows the algorithms approved by FIPS 186-4.
+	FIPS bool
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case c.RootFile != "" && c.KeyFile == "":
+		return errors.New("flag `--root` requires flag `--key`")
+	case c.KeyFile != "" && c.RootFile == "":
+		return errors.New("flag `--key` requires flag `--root`")
+	case c.RootOnly && c.RootFile != "":
+		return errors.New("flag `--root-only` is incompatible with flag `--root`")
+	case c.NoIntermediate && c.RootOnly:
+		return errors.New("flag `--no-intermediate` is incompatible with flag `--root-only`")
+	case c.NoIntermediate && c.RootFile != "":
+		return errors.New("flag `--no-intermediate` is incompatible with flag `--root`")
+	case c.RootPolicies && len(c.PolicyOIDs) == 0:
+		return errors.New("flag `--root-policies` requires flag `--policy-oid`")
+	case c.P12Out != "" && !c.RootOnly:
+		return errors.New("flag `--p12-out` requires flag `--root-only`")
+	case c.P12Out != "" && c.FIPS:
+		// PKCS#12 files are encrypted with algorithms not approved by FIPS.
+		return errors.New("flag `--p12-out` is incompatible with flag `--fips`")
+	case c.PasswordFile != "" && !c.RootOnly:
+		return errors.New("flag `--password-file` requires flag `--root-only`")
+	case c.KeyFormat != "" && !c.RootOnly:
+		return errors.New("flag `--key-format` requires flag `--root-only`")
+	case c.KeyFormat != "" && c.KeyFormat != "pkcs8" && c.KeyFormat != "sec1":
+		return errors.Errorf("invalid value `%s` for flag `--key-format`; options are `pkcs8` or `sec1`", c.KeyFormat)
+	case c.Algorithm != "" && !c.RootOnly:
+		return errors.New("flag `--algorithm` requires flag `--root-only`")
+	case c.Algorithm != "" && c.Algorithm != "ecdsa" && c.Algorithm != "ed25519":
+		return errors.Errorf("invalid value `%s` for flag `--algorithm`; options are `ecdsa` or `ed25519`", c.Algorithm)
+	case c.Algorithm == "ed25519" && c.KeyFormat == "sec1":
+		return errors.New("flag `--key-format` with value `sec1` requires an ECDSA key; options are `pkcs8`")
+	case (c.AppendToChain != "" || c.OldRoot != "" || c.OldKey != "") && (c.AppendToChain == "" || c.OldRoot == "" || c.OldKey == ""):
+		return errors.New("flags `--append-to-chain`, `--old-root` and `--old-key` must be used together")
+	case (c.CrossRoot != "" || c.CrossKey != "") && (c.CrossRoot == "" || c.CrossKey == ""):
+		return errors.New("flags `--cross-root` and `--cross-key` must be used together")
+	case c.NoIntermediate && c.CrossRoot != "":
+		return errors.New("flag `--no-intermediate` is incompatible with flag `--cross-root`")
+	case c.RootSlot == c.CrtSlot:
+		return errors.New("flag `--root-slot` and flag `--crt-slot` cannot be the same")
+	case c.RootFile == "" && c.RootSlot == "":
+		return errors.New("one of flag `--root` or `--root-slot` is required")
+	case c.Curve != "P-256" && c.Curve != "P-384":
+		return errors.Errorf("invalid value `%s` for flag `--curve`; options are `P-256` or `P-384`", c.Curve)
+	case c.SKIDMethod != pkiutil.RFC5280 && c.SKIDMethod != pkiutil.RFC7093:
+		return errors.Errorf("invalid value `%s` for flag `--skid-method`; options are `rfc5280` or `rfc7093`", c.SKIDMethod)
+	default:
+		if err := apiv1.CheckFIPSAlgorithm(c.SignatureAlgorithm(), 0); err != nil {
+			return err
+		}
+		if c.Algorithm == "ed25519" {
+			if err := apiv1.CheckFIPSAlgorithm(apiv1.PureEd25519, 0); err != nil {
+				return errors.Wrap(err, "invalid value `ed25519` for flag `--algorithm`")
+			}
+		}
+		if c.RootFile != "" {
+			c.RootSlot = ""
+		}
+		if c.RootOnly || c.NoIntermediate {
+			c.CrtSlot = ""
+		}
+		return nil
+	}
+}
+
+// SignatureAlgorithm returns the signature algorithm for the configured curve.
+func (c *Config) SignatureAlgorithm() apiv1.SignatureAlgorithm {
+	if c.Curve == "P-384" {
+		return apiv1.ECDSAWithSHA384
+	}
+	return apiv1.ECDSAWithSHA256
+}
+
+// EllipticCurve returns the elliptic curve for the configured curve.
+func (c *Config) EllipticCurve() elliptic.Curve {
+	if c.Curve == "P-384" {
+		return elliptic.P384()
+	}
+	return elliptic.P256()
+}
+
+// Run initializes a PKI using a YubiKey. The name of the command is used in
+// the usage, and args are the command line arguments without it.
+func Run(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var c Config
+	var quiet bool
+	var fileMode, format, touchPolicy, pinPolicy, templateFile, intermediateCSR, notBefore, notAfter string
+	fs.BoolVar(&c.RootOnly, "root-only", false, "Slot only the root certificate and sign and intermediate.")
+	fs.StringVar(&c.RootSlot, "root-slot", "9a", "Slot to store the root certificate.")
+	fs.StringVar(&c.CrtSlot, "crt-slot", "9c", "Slot to store the intermediate certificate.")
+	fs.StringVar(&c.RootFile, "root", "", "Path to the root certificate to use.")
+	fs.StringVar(&c.KeyFile, "key", "", "Path to the root key to use.")
+	fs.StringVar(&c.PinFile, "pin-file", "", "Path to the `file` containing the YubiKey PIN. It will be prompted if it is not set.")
+	fs.BoolVar(&c.Force, "force", false, "Force the delete of previous keys and certificates.")
+	fs.StringVar(&c.Curve, "curve", "P-256", "Elliptic curve to use for the keys, P-256 or P-384.")
+	fs.BoolVar(&c.NoIntermediate, "no-intermediate", false, "Create only the root certificate, leaf certificates will be signed directly by the root.")
+	fs.StringVar(&c.P12Out, "p12-out", "", "Path to write the intermediate key and certificate chain as a PKCS#12 file, requires --root-only and is incompatible with --fips.")
+	fs.StringVar(&c.KeyFormat, "key-format", "", "The `format` of the intermediate key written with --root-only, pkcs8 or sec1 (ECDSA). Defaults to sec1 for ECDSA and pkcs8 for Ed25519 keys.")
+	fs.StringVar(&c.Algorithm, "algorithm", "", "The `algorithm` of the intermediate key created in software with --root-only, ecdsa or ed25519. Defaults to ecdsa with the --curve.")
+	fs.StringVar(&c.PasswordFile, "password-file", "", "Path to the `file` containing the password to encrypt the intermediate key, requires --root-only. It will be prompted if it is not set.")
+	fs.StringVar((*string)(&c.SKIDMethod), "skid-method", string(pkiutil.RFC5280), "Method used to compute the subject key identifier, rfc5280 (SHA-1) or rfc7093 (truncated SHA-256).")
+	fs.StringVar(&c.CAConfigFile, "write-ca-config", "", "Write a starter ca.json with the created certificates and keys to `file`.")
+	fs.BoolVar(&c.Check, "check", false, "Check that the YubiKey is connected and responding and exit.")
+	fs.StringVar(&c.AppendToChain, "append-to-chain", "", "Cross-sign the new root certificate in `file` with the old root and write it to cross_signed_root.crt and exit, requires --old-root and --old-key.")
+	fs.StringVar(&c.OldRoot, "old-root", "", "Path to the old root certificate `file` used with --append-to-chain.")
+	fs.StringVar(&c.OldKey, "old-key", "", "Slot or URI of the old root `key` used with --append-to-chain, e.g. 9a.")
+	fs.StringVar(&c.CrossRoot, "cross-root", "", "Path to an external root certificate `file` used to cross-sign the intermediate, written to intermediate_ca_cross.crt, requires --cross-key.")
+	fs.StringVar(&c.CrossKey, "cross-key", "", "Slot or URI of the external root `key` used with --cross-root, e.g. 82.")
+	fs.StringVar(&templateFile, "template", "", "Path to a Go template `file` rendering a JSON certificate template used to customize the root and intermediate certificates.")
+	fs.StringVar(&notBefore, "not-before", "", "The `time` in RFC 3339 format when the root and intermediate certificates become valid, e.g. 2030-01-01T00:00:00Z. Defaults to now.")
+	fs.StringVar(&notAfter, "not-after", "", "The `time` in RFC 3339 format when the root and intermediate certificates expire, e.g. 2040-01-01T00:00:00Z. Defaults to ten years after --not-before.")
+	fs.StringVar(&intermediateCSR, "intermediate-csr", "", "Path to a certificate signing request `file` whose requested extensions are added to the intermediate certificates.")
+	fs.Var(&c.PolicyOIDs, "policy-oid", "Add the certificate policy `oid` in dotted notation to the intermediate certificates. Use it multiple times to add multiple policies.")
+	fs.BoolVar(&c.RootPolicies, "root-policies", false, "Add the --policy-oid policies to the root certificate too.")
+	fs.StringVar(&fileMode, "file-mode", "0600", "Octal permission `mode` of the written certificates and keys, e.g. 0640 to allow the group to read them.")
+	fs.StringVar(&format, "format", "pem", "The `format` of the written root and intermediate certificates, pem (.crt) or der (.cer).")
+	fs.BoolVar(&c.FIPS, "fips", false, "Only allow the algorithms approved by FIPS 186-4 and enable the FIPS-only mode in the written ca.json.")
+	fs.BoolVar(&c.DER, "der", false, "Also write the root and intermediate certificates in binary DER to root_ca.der and intermediate_ca.der.")
+	fs.StringVar(&touchPolicy, "touch-policy", "", "The touch `policy` of the keys created in the YubiKey, never, always or cached. Defaults to never.")
+	fs.StringVar(&pinPolicy, "pin-policy", "", "The PIN `policy` of the keys created in the YubiKey, never, once or always. Defaults to always.")
+	fs.BoolVar(&quiet, "quiet", false, "Do not print the progress and the created keys and certificates, errors are still printed to stderr.")
+	fs.Usage = func() { kmsinit.Usage(fs, "") }
+	fs.Parse(args)
+
+	if c.FIPS {
+		fips.Enable()
+	}
+	if err := c.Validate(); err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	mode, err := pkiutil.ParseFileMode(fileMode)
+	if err != nil {
+		kmsinit.Fatal(errors.Errorf("invalid value `%s` for flag `--file-mode`; the owner must read and write, and the group and others can only read, e.g. `0600` or `0640`", fileMode))
+	}
+	c.FileMode = mode
+
+	if c.Format, err = pkiutil.ParseCertificateFormat(format); err != nil {
+		kmsinit.Fatal(errors.Errorf("invalid value `%s` for flag `--format`; options are `pem` or `der`", format))
+	}
+
+	if c.NotBefore, c.NotAfter, err = pkiutil.ParseValidity(notBefore, notAfter); err != nil {
+		kmsinit.Fatal(errors.Wrap(err, "invalid value for flags `--not-before` and `--not-after`"))
+	}
+
+	if templateFile != "" {
+		if c.Template, err = pkiutil.ParseTemplateFile(templateFile); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if intermediateCSR != "" && (c.NoIntermediate || c.RootOnly) {
+		kmsinit.Fatal(errors.New("flag `--intermediate-csr` requires an intermediate; it is incompatible with flags `--no-intermediate` and `--root-only`"))
+	}
+
+	if intermediateCSR != "" {
+		if c.IntermediateExtensions, err = pkiutil.ReadCSRExtensions(intermediateCSR); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	if c.TouchPolicy, err = pkiutil.ParseTouchPolicy(touchPolicy); err != nil {
+		kmsinit.Fatal(errors.Errorf("invalid value `%s` for flag `--touch-policy`; options are `never`, `always` or `cached`", touchPolicy))
+	}
+	if c.PINPolicy, err = pkiutil.ParsePINPolicy(pinPolicy); err != nil {
+		kmsinit.Fatal(errors.Errorf("invalid value `%s` for flag `--pin-policy`; options are `never`, `once` or `always`", pinPolicy))
+	}
+
+	// Read the password before using the YubiKey.
+	if c.PasswordFile != "" {
+		pass, err := readPasswordFile(c.PasswordFile)
+		if err != nil {
+			kmsinit.Fatal(err)
+		}
+		c.Password = pass
+	}
+
+	// The check does not require the PIN.
+	if c.Check {
+		if err := checkHealth(); err != nil {
+			kmsinit.Fatal(err)
+		}
+		if !quiet {
+			ui.PrintSelected("YubiKey", "OK")
+		}
+		return
+	}
+
+	if c.PinFile != "" {
+		pin, err := readPasswordFile(c.PinFile)
+		if err != nil {
+			kmsinit.Fatal(err)
+		}
+		c.Pin = string(pin)
+	} else {
+		pin, err := ui.PromptPassword("What is the YubiKey PIN?")
+		if err != nil {
+			kmsinit.Fatal(err)
+		}
+		c.Pin = string(pin)
+	}
+
+	k, err := kms.New(context.Background(), apiv1.Options{
+		Type: string(apiv1.YubiKey),
+		Pin:  c.Pin,
+	})
+	if err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	// The certificates are stored in the slots of their keys.
+	p := &pkiutil.Init{
+		KMS:        k,
+		FileMode:   c.FileMode,
+		Format:     c.Format,
+		DER:        c.DER,
+		StoreCerts: true,
+		Quiet:      quiet,
+	}
+
+	if c.AppendToChain != "" {
+		err := p.AppendToChain(c.AppendToChain, c.OldRoot, c.OldKey)
+		_ = k.Close()
+		if err != nil {
+			kmsinit.Fatal(err)
+		}
+		return
+	}
+
+	// Check if the slots are empty, fail if they are not. With --force the
+	// stored certificates are removed, the keys are replaced when the new ones
+	// are generated.
+	if !c.Force {
+		switch {
+		case c.RootSlot != "":
+			checkSlot(k, c.RootSlot)
+		case c.CrtSlot != "":
+			checkSlot(k, c.CrtSlot)
+		}
+	} else {
+		for _, slot := range []string{c.RootSlot, c.CrtSlot} {
+			if slot == "" {
+				continue
+			}
+			if err := pkiutil.DeleteCertificate(k, slot); err != nil {
+				kmsinit.Fatal(err)
+			}
+		}
+	}
+
+	ca := &pkiutil.CAConfig{
+		FIPS: fips.Enabled(),
+		KMS: &apiv1.Options{
+			Type: string(apiv1.YubiKey),
+		},
+	}
+
+	p.PrintLine("Creating PKI ...")
+	if err := createPKI(p, c, ca); err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	// The YubiKey PIN is not written, and it must be added to the kms options.
+	if c.CAConfigFile != "" {
+		p.PrintLine()
+		if err := p.WriteCAConfig(c.CAConfigFile, ca); err != nil {
+			kmsinit.Fatal(err)
+		}
+	}
+
+	defer func() {
+		_ = k.Close()
+	}()
+}
+
+// checkHealth opens the YubiKey and verifies that it is responding.
+func checkHealth() error {
+	k, err := kms.New(context.Background(), apiv1.Options{
+		Type: string(apiv1.YubiKey),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = k.Close()
+	}()
+
+	hc, ok := k.(kms.HealthChecker)
+	if !ok {
+		return errors.New("yubikey does not support health checks")
+	}
+	return hc.CheckHealth(&apiv1.CheckHealthRequest{})
+}
+
+// readPasswordFile reads the password in the given file, the trailing new lines
+// are removed. It fails if the password is empty.
+func readPasswordFile(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	b = bytes.TrimRight(b, "\r\n")
+	if len(b) == 0 {
+		return nil, errors.Errorf("error reading %s: password cannot be empty", filename)
+	}
+	return b, nil
+}
+
+func checkSlot(k kms.KeyManager, slot string) {
+	if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: slot,
+	}); err == nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Your YubiKey already has a key in the slot %s.\n", slot)
+		fmt.Fprintln(os.Stderr, "   If you want to delete it and start fresh, use `--force`.")
+		os.Exit(1)
+	}
+}
+
+func createPKI(p *pkiutil.Init, c Config, ca *pkiutil.CAConfig) error {
+	var err error
+	k := p.KMS
+
+	opts := pkiutil.PKIOptions{
+		RootCommonName:         "YubiKey Smallstep Root",
+		SKIDMethod:             c.SKIDMethod,
+		Template:               c.Template,
+		IntermediateExtensions: c.IntermediateExtensions,
+		PolicyOIDs:             c.PolicyOIDs,
+		RootPolicies:           c.RootPolicies,
+		NotBefore:              c.NotBefore,
+		NotAfter:               c.NotAfter,
+	}
+	if !c.NoIntermediate {
+		opts.Intermediates = []pkiutil.PKIIntermediate{
+			{CommonName: "YubiKey Smallstep Intermediate"},
+		}
+	}
+
+	if c.TouchPolicy == apiv1.TouchPolicyAlways || c.TouchPolicy == apiv1.TouchPolicyCached {
+		p.PrintLine("Touch the YubiKey when it blinks to sign the certificates.")
+	}
+
+	// Load the external root before creating any key.
+	var cross *pkiutil.CrossSigner
+	if c.CrossRoot != "" {
+		if cross, err = p.LoadCrossSigner(c.CrossRoot, c.CrossKey); err != nil {
+			return err
+		}
+	}
+
+	// Root Certificate
+	var signer crypto.Signer
+	var root *x509.Certificate
+	if c.RootFile != "" && c.KeyFile != "" {
+		root, err = pemutil.ReadCertificate(c.RootFile)
+		if err != nil {
+			return err
+		}
+
+		key, err := pemutil.Read(c.KeyFile)
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		if signer, ok = key.(crypto.Signer); !ok {
+			return errors.Errorf("key type '%T' does not implement a signer", key)
+		}
+		if err := fips.CheckPublicKey(signer.Public()); err != nil {
+			return errors.Wrapf(err, "error reading %s", c.KeyFile)
+		}
+		ca.Root = c.RootFile
+	} else {
+		resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+			Name:               c.RootSlot,
+			SignatureAlgorithm: c.SignatureAlgorithm(),
+			TouchPolicy:        c.TouchPolicy,
+			PINPolicy:          c.PINPolicy,
+		})
+		if err != nil {
+			return err
+		}
+
+		signer, err = k.CreateSigner(&resp.CreateSignerRequest)
+		if err != nil {
+			return err
+		}
+
+		cert, err := pkiutil.CreateRootCertificate(resp.PublicKey, signer, opts)
+		if err != nil {
+			return err
+		}
+
+		keyURI, err := pkiutil.CreatedKeyURI(k, resp)
+		if err != nil {
+			return err
+		}
+
+		// The root is the certificate read back from the slot.
+		pc := &pkiutil.PKICertificate{Certificate: cert, Key: resp, KeyURI: keyURI}
+		if err := p.WriteRoot(pc, ca); err != nil {
+			return err
+		}
+		root = pc.Certificate
+
+		if c.NoIntermediate {
+			ca.Crt, ca.Key = ca.Root, keyURI
+			p.PrintNoIntermediateWarning()
+			return nil
+		}
+	}
+
+	// Intermediate Certificate
+	in := &pkiutil.PKICertificate{}
+	var publicKey crypto.PublicKey
+	var priv crypto.Signer
+	var pass []byte
+	if c.RootOnly {
+		// The intermediate key is created in software, it can be an Ed25519
+		// key even if the YubiKey does not support them.
+		if c.Algorithm == "ed25519" {
+			_, priv, err = ed25519.GenerateKey(rand.Reader)
+		} else {
+			priv, err = ecdsa.GenerateKey(c.EllipticCurve(), rand.Reader)
+		}
+		if err != nil {
+			return errors.Wrap(err, "error creating intermediate key")
+		}
+
+		if pass = c.Password; pass == nil {
+			pass, err = ui.PromptPasswordGenerate("What do you want your password to be? [leave empty and we'll generate one]",
+				ui.WithRichPrompt())
+			if err != nil {
+				return err
+			}
+		}
+
+		opts, err := keyFormatOptions(priv, c.KeyFormat)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, pemutil.WithPassword(pass), pemutil.ToFile("intermediate_ca_key", c.FileMode))
+		if _, err = pemutil.Serialize(priv, opts...); err != nil {
+			return err
+		}
+
+		publicKey = priv.Public()
+		in.KeyURI = "intermediate_ca_key"
+	} else {
+		resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+			Name:               c.CrtSlot,
+			SignatureAlgorithm: c.SignatureAlgorithm(),
+			TouchPolicy:        c.TouchPolicy,
+			PINPolicy:          c.PINPolicy,
+		})
+		if err != nil {
+			return err
+		}
+		publicKey = resp.PublicKey
+		in.Key = resp
+		if in.KeyURI, err = pkiutil.CreatedKeyURI(k, resp); err != nil {
+			return err
+		}
+	}
+
+	if in.Certificate, err = pkiutil.CreateIntermediateCertificate(publicKey, opts.Intermediates[0], root, signer, opts); err != nil {
+		return err
+	}
+
+	// The intermediate is the certificate read back from the slot, unless the
+	// key is in a file.
+	if err := p.WriteIntermediate(in, ca); err != nil {
+		return err
+	}
+
+	if c.RootOnly {
+		keyFile, err := filepath.Abs("intermediate_ca_key")
+		if err != nil {
+			return errors.Wrap(err, "error getting intermediate key path")
+		}
+		// The intermediate key is a file, the ca.json does not need a KMS.
+		ca.Key, ca.KMS = keyFile, nil
+	}
+
+	// The cross-signed intermediate shares the key of the intermediate.
+	if cross != nil {
+		if err := p.WriteCrossSignedIntermediate(in, cross); err != nil {
+			return err
+		}
+	}
+
+	// The PKCS#12 file uses the same password as the intermediate key.
+	if c.P12Out != "" {
+		b, err := pkiutil.EncodePKCS12(rand.Reader, priv, in.Certificate, []*x509.Certificate{root}, string(pass))
+		if err != nil {
+			return err
+		}
+		if err = utils.WriteFile(c.P12Out, b, c.FileMode); err != nil {
+			return err
+		}
+		p.PrintSelected("Intermediate PKCS#12", c.P12Out)
+	}
+
+	return nil
+}
+
+// keyFormatOptions returns the pemutil options used to serialize the given key
+// in the given format. An empty format will use the default one, SEC 1 for
+// ECDSA keys and PKCS#8 for Ed25519 keys.
+func keyFormatOptions(key crypto.PrivateKey, format string) ([]pemutil.Options, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case "pkcs8":
+		return []pemutil.Options{pemutil.WithPKCS8(true)}, nil
+	case "sec1":
+		if _, ok := key.(*ecdsa.PrivateKey); !ok {
+			return nil, errors.Errorf("key format `%s` is not compatible with %T", format, key)
+		}
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unsupported key format `%s`", format)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/pkiutil"
+	"github.com/smallstep/cli/ui"
+
+	// Enable the supported KMS.
+	_ "github.com/smallstep/certificates/kms/awskms"
+	_ "github.com/smallstep/certificates/kms/cloudkms"
+	_ "github.com/smallstep/certificates/kms/yubikey"
+)
+
+// Config is the configuration used to connect to the KMS.
+type Config struct {
+	KMS               string
+	CredentialsFile   string
+	CredentialsSecret string
+	Region            string
+	Profile           string
+	JSON              bool
+}
+
+func main() {
+	var c Config
+	flag.StringVar(&c.KMS, "kms", string(apiv1.SoftKMS), "The `type` of KMS to use, softkms, cloudkms, awskms or yubikey.")
+	flag.StringVar(&c.CredentialsFile, "credentials-file", "", "Path to the `file` containing the Cloud KMS or AWS KMS credentials.")
+	flag.StringVar(&c.CredentialsSecret, "credentials-secret", "", "Secret Manager `name` of the secret containing the Cloud KMS credentials.")
+	flag.StringVar(&c.Region, "region", "", "AWS KMS region name.")
+	flag.StringVar(&c.Profile, "profile", "", "AWS KMS profile name.")
+	flag.BoolVar(&c.JSON, "json", false, "Print the capabilities in JSON format.")
+	flag.Usage = usage
+	flag.Parse()
+
+	k, err := kms.New(context.Background(), apiv1.Options{
+		Type:              c.KMS,
+		CredentialsFile:   c.CredentialsFile,
+		CredentialsSecret: c.CredentialsSecret,
+		Region:            c.Region,
+		Profile:           c.Profile,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	caps := pkiutil.Capabilities(apiv1.Type(c.KMS), k)
+	_ = k.Close()
+
+	if c.JSON {
+		b, err := json.MarshalIndent(caps, "", "  ")
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	ui.PrintSelected("KMS", caps.Type)
+	for _, alg := range caps.SignatureAlgorithms {
+		if bits := caps.FormatBits(alg); bits != "" {
+			alg += " (" + bits + ")"
+		}
+		ui.PrintSelected("Signature Algorithm", alg)
+	}
+	ui.PrintSelected("Protection Levels", formatList(caps.ProtectionLevels))
+	ui.PrintSelected("Interfaces", formatList(caps.Interfaces()))
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(pkiutil.ExitCode(err))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: step-kms-capabilities")
+	fmt.Fprintln(os.Stderr, `
+The step-kms-capabilities command prints the signature algorithms, RSA key
+sizes and protection levels supported by a KMS, and the optional features it
+implements, e.g. storing certificates or listing keys.
+
+This tool is experimental and in the future it will be integrated in step cli.
+
+OPTIONS`)
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `
+COPYRIGHT
+
+  (c) 2018-2020 Smallstep Labs, Inc.`)
+	os.Exit(1)
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cmd/internal/awskmsinit"
+	"github.com/smallstep/certificates/cmd/internal/cloudkmsinit"
+	"github.com/smallstep/certificates/cmd/internal/kmsinit"
+	"github.com/smallstep/certificates/cmd/internal/yubikeyinit"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// step-kms-init initializes a PKI using the KMS selected with the flag --kms,
+// the rest of the flags are the ones of step-cloudkms-init, step-awskms-init
+// or step-yubikey-init.
+func main() {
+	t, args, err := kmsinit.KMSType(os.Args[1:], os.Getenv)
+	if err != nil {
+		kmsinit.Fatal(err)
+	}
+
+	switch t {
+	case apiv1.CloudKMS:
+		cloudkmsinit.Run("step-kms-init --kms cloudkms", args)
+	case apiv1.AmazonKMS:
+		awskmsinit.Run("step-kms-init --kms awskms", args)
+	case apiv1.YubiKey:
+		yubikeyinit.Run("step-kms-init --kms yubikey", args)
+	default:
+		kmsinit.Fatal(errors.Errorf("step-kms-init does not support %s", t))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/pkiutil"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+
+	// Enable the supported KMS.
+	_ "github.com/smallstep/certificates/kms/awskms"
+	_ "github.com/smallstep/certificates/kms/cloudkms"
+	_ "github.com/smallstep/certificates/kms/yubikey"
+)
+
+// Config is the configuration used to sign a certificate signing request.
+type Config struct {
+	KMS             string
+	CredentialsFile string
+	Region          string
+	Profile         string
+	Pin             string
+	PasswordFile    string
+	Key             string
+	KeyVersion      string
+	CSRFile         string
+	IssuerFile      string
+	OutFile         string
+	Validity        time.Duration
+	CA              bool
+	SKIDMethod      pkiutil.SubjectKeyIDMethod
+}
+
+// Validate checks the flags in the configuration.
+func (c *Config) Validate() error {
+	switch {
+	case c.Key == "":
+		return errors.New("flag `--key` is required")
+	case c.CSRFile == "":
+		return errors.New("flag `--csr` is required")
+	case c.IssuerFile == "":
+		return errors.New("flag `--issuer` is required")
+	case c.Validity <= 0:
+		return errors.Errorf("invalid value `%s` for flag `--validity`; it must be a positive duration", c.Validity)
+	case c.SKIDMethod != pkiutil.RFC5280 && c.SKIDMethod != pkiutil.RFC7093:
+		return errors.Errorf("invalid value `%s` for flag `--skid-method`; options are `rfc5280` or `rfc7093`", c.SKIDMethod)
+	default:
+		return nil
+	}
+}
+
+func main() {
+	var c Config
+	flag.StringVar(&c.KMS, "kms", string(apiv1.SoftKMS), "The `type` of KMS to use, softkms, cloudkms, awskms or yubikey.")
+	flag.StringVar(&c.CredentialsFile, "credentials-file", "", "Path to the `file` containing the Cloud KMS or AWS KMS credentials.")
+	flag.StringVar(&c.Region, "region", "", "AWS KMS region name.")
+	flag.StringVar(&c.Profile, "profile", "", "AWS KMS profile name.")
+	flag.StringVar(&c.Pin, "pin", "", "The YubiKey PIN, it will be prompted if it is not set.")
+	flag.StringVar(&c.PasswordFile, "password-file", "", "Path to the `file` containing the password to decrypt the key when using softkms.")
+	flag.StringVar(&c.Key, "key", "", "The `name` of the signing key in the KMS, e.g. awskms:key-id=..., a Cloud KMS resource name, or yubikey:slot-id=9c.")
+	flag.StringVar(&c.KeyVersion, "key-version", "", "The `version` of the Cloud KMS signing key, if not set the version in the key name is used.")
+	flag.StringVar(&c.CSRFile, "csr", "", "Path to the certificate signing request to sign.")
+	flag.StringVar(&c.IssuerFile, "issuer", "", "Path to the issuer certificate, its public key must match the signing key.")
+	flag.StringVar(&c.OutFile, "out", "", "Path to write the signed certificate, by default it is written to the standard output.")
+	flag.DurationVar(&c.Validity, "validity", 24*time.Hour, "The validity of the certificate, e.g. 24h or 87600h.")
+	flag.BoolVar(&c.CA, "ca", false, "Sign an intermediate certificate instead of a leaf certificate.")
+	flag.StringVar((*string)(&c.SKIDMethod), "skid-method", string(pkiutil.RFC5280), "Method used to compute the subject key identifier, rfc5280 (SHA-1) or rfc7093 (truncated SHA-256).")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := c.Validate(); err != nil {
+		fatal(err)
+	}
+
+	if apiv1.Type(strings.ToLower(c.KMS)) == apiv1.YubiKey && c.Pin == "" {
+		pin, err := ui.PromptPassword("What is the YubiKey PIN?")
+		if err != nil {
+			fatal(err)
+		}
+		c.Pin = string(pin)
+	}
+
+	k, err := kms.New(context.Background(), apiv1.Options{
+		Type:            c.KMS,
+		CredentialsFile: c.CredentialsFile,
+		Region:          c.Region,
+		Profile:         c.Profile,
+		Pin:             c.Pin,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	err = sign(k, c)
+	_ = k.Close()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(pkiutil.ExitCode(err))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: step-kms-sign --key <name> --csr <file> --issuer <file>")
+	fmt.Fprintln(os.Stderr, `
+The step-kms-sign command signs a certificate signing request with a key
+stored in a KMS, without running a certificate authority.
+
+This tool is experimental and in the future it will be integrated in step cli.
+
+OPTIONS`)
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `
+COPYRIGHT
+
+  (c) 2018-2020 Smallstep Labs, Inc.`)
+	os.Exit(1)
+}
+
+// readCertificateRequest reads a PEM or DER encoded certificate signing request.
+func readCertificateRequest(filename string) (*x509.CertificateRequest, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, errors.Errorf("error decoding %s: unexpected PEM block %s", filename, block.Type)
+		}
+		b = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", filename)
+	}
+	return csr, nil
+}
+
+// sign signs the certificate signing request with the configured KMS key.
+func sign(k kms.KeyManager, c Config) error {
+	csr, err := readCertificateRequest(c.CSRFile)
+	if err != nil {
+		return err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return errors.Wrap(err, "error validating certificate signing request")
+	}
+
+	issuer, err := pemutil.ReadCertificate(c.IssuerFile)
+	if err != nil {
+		return err
+	}
+	if !issuer.IsCA {
+		return errors.Errorf("certificate %s is not a certificate authority", c.IssuerFile)
+	}
+
+	req := &apiv1.CreateSignerRequest{
+		SigningKey: c.Key,
+		KeyVersion: c.KeyVersion,
+	}
+	if c.PasswordFile != "" {
+		b, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", c.PasswordFile)
+		}
+		req.Password = bytes.TrimRight(b, "\r\n")
+	}
+	signer, err := k.CreateSigner(req)
+	if err != nil {
+		return err
+	}
+
+	// Fail early if the key does not belong to the issuer.
+	issuerKey, err := x509.MarshalPKIXPublicKey(issuer.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling issuer public key")
+	}
+	signerKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return errors.Wrap(err, "error marshaling signer public key")
+	}
+	if !bytes.Equal(issuerKey, signerKey) {
+		return errors.Errorf("key %s does not match the public key of %s", c.Key, c.IssuerFile)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(c.Validity)
+	if notAfter.After(issuer.NotAfter) {
+		return errors.Errorf("flag `--validity` exceeds the validity of the issuer, it expires at %s", issuer.NotAfter.Format(time.RFC3339))
+	}
+
+	serialNumber, err := pkiutil.SerialNumber(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	subjectKeyID, err := pkiutil.SubjectKeyID(csr.PublicKey, c.SKIDMethod)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		NotBefore:      now,
+		NotAfter:       notAfter,
+		Issuer:         issuer.Subject,
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+		SerialNumber:   serialNumber,
+		SubjectKeyId:   subjectKeyID,
+	}
+	if c.CA {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		template.BasicConstraintsValid = true
+		template.MaxPathLen = 0
+		template.MaxPathLenZero = true
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+			template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, template, issuer, csr.PublicKey, signer)
+	if err != nil {
+		return errors.Wrap(err, "error signing certificate")
+	}
+
+	crt := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: b,
+	})
+	if c.OutFile == "" {
+		_, err = os.Stdout.Write(crt)
+		return err
+	}
+	if err := utils.WriteFile(c.OutFile, crt, 0600); err != nil {
+		return err
+	}
+	ui.PrintSelected("Certificate", c.OutFile)
+	return nil
+}
@@ -47,6 +47,7 @@ type AuthDB interface {
 	IsSSHRevoked(sn string) (bool, error)
 	Revoke(rci *RevokedCertificateInfo) error
 	RevokeSSH(rci *RevokedCertificateInfo) error
+	GetSSHRevocations() ([]*RevokedCertificateInfo, error)
 	StoreCertificate(crt *x509.Certificate) error
 	UseToken(id, tok string) (bool, error)
 	IsSSHHost(name string) (bool, error)
@@ -269,6 +270,24 @@ func (db *DB) GetSSHHostPrincipals() ([]string, error) {
 	return principals, nil
 }
 
+// GetSSHRevocations returns the information of all the revoked SSH
+// certificates.
+func (db *DB) GetSSHRevocations() ([]*RevokedCertificateInfo, error) {
+	entries, err := db.List(revokedSSHCertsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing revoked SSH certificates")
+	}
+	var revocations []*RevokedCertificateInfo
+	for _, e := range entries {
+		rci := new(RevokedCertificateInfo)
+		if err := json.Unmarshal(e.Value, rci); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling revoked SSH certificate %s", e.Key)
+		}
+		revocations = append(revocations, rci)
+	}
+	return revocations, nil
+}
+
 // Shutdown sends a shutdown message to the database.
 func (db *DB) Shutdown() error {
 	if db.isUp {
@@ -288,6 +307,7 @@ type MockAuthDB struct {
 	MIsSSHRevoked         func(string) (bool, error)
 	MRevoke               func(rci *RevokedCertificateInfo) error
 	MRevokeSSH            func(rci *RevokedCertificateInfo) error
+	MGetSSHRevocations    func() ([]*RevokedCertificateInfo, error)
 	MStoreCertificate     func(crt *x509.Certificate) error
 	MUseToken             func(id, tok string) (bool, error)
 	MIsSSHHost            func(principal string) (bool, error)
@@ -339,6 +359,17 @@ func (m *MockAuthDB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	return m.Err
 }
 
+// GetSSHRevocations mock.
+func (m *MockAuthDB) GetSSHRevocations() ([]*RevokedCertificateInfo, error) {
+	if m.MGetSSHRevocations != nil {
+		return m.MGetSSHRevocations()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]*RevokedCertificateInfo), m.Err
+}
+
 // StoreCertificate mock.
 func (m *MockAuthDB) StoreCertificate(crt *x509.Certificate) error {
 	if m.MStoreCertificate != nil {
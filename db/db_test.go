@@ -158,3 +158,63 @@ func TestUseToken(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSSHRevocations(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []*RevokedCertificateInfo
+		err  error
+	}{
+		"fail/force-List-error": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			err: errors.New("error listing revoked SSH certificates: force"),
+		},
+		"fail/unmarshal-error": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return []*database.Entry{{Key: []byte("1"), Value: []byte("not json")}}, nil
+				},
+			}, true},
+			err: errors.New("error unmarshaling revoked SSH certificate 1"),
+		},
+		"ok/empty": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, nil
+				},
+			}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					assert.Equals(t, revokedSSHCertsTable, bucket)
+					return []*database.Entry{
+						{Key: []byte("1"), Value: []byte(`{"Serial":"1","ReasonCode":1}`)},
+						{Key: []byte("2"), Value: []byte(`{"Serial":"2","Reason":"foo"}`)},
+					}, nil
+				},
+			}, true},
+			want: []*RevokedCertificateInfo{
+				{Serial: "1", ReasonCode: 1},
+				{Serial: "2", Reason: "foo"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetSSHRevocations()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
@@ -79,6 +79,11 @@ func (s *SimpleDB) StoreSSHCertificate(crt *ssh.Certificate) error {
 	return ErrNotImplemented
 }
 
+// GetSSHRevocations returns a "NotImplemented" error.
+func (s *SimpleDB) GetSSHRevocations() ([]*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
 // GetSSHHostPrincipals returns a "NotImplemented" error.
 func (s *SimpleDB) GetSSHHostPrincipals() ([]string, error) {
 	return nil, ErrNotImplemented
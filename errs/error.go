@@ -169,6 +169,8 @@ func StatusCodeError(code int, e error, opts ...Option) error {
 		return UnauthorizedErr(e, opts...)
 	case http.StatusForbidden:
 		return ForbiddenErr(e, opts...)
+	case http.StatusTooManyRequests:
+		return TooManyRequestsErr(e, opts...)
 	case http.StatusInternalServerError:
 		return InternalServerErr(e, opts...)
 	case http.StatusNotImplemented:
@@ -188,6 +190,8 @@ var (
 	ForbiddenDefaultMsg = "The request was forbidden by the certificate authority. " + seeLogs
 	// NotFoundDefaultMsg 404 default msg
 	NotFoundDefaultMsg = "The requested resource could not be found. " + seeLogs
+	// TooManyRequestsDefaultMsg 429 default msg
+	TooManyRequestsDefaultMsg = "The request was rejected because too many requests were made. " + seeLogs
 	// InternalServerErrorDefaultMsg 500 default msg
 	InternalServerErrorDefaultMsg = "The certificate authority encountered an Internal Server Error. " + seeLogs
 	// NotImplementedDefaultMsg 501 default msg
@@ -326,6 +330,18 @@ func NotFoundErr(err error, opts ...Option) error {
 	return NewErr(http.StatusNotFound, err, opts...)
 }
 
+// TooManyRequests creates a 429 error with the given format and arguments.
+func TooManyRequests(format string, args ...interface{}) error {
+	args = append(args, withDefaultMessage(TooManyRequestsDefaultMsg))
+	return Errorf(http.StatusTooManyRequests, format, args...)
+}
+
+// TooManyRequestsErr returns an 429 error with the given error.
+func TooManyRequestsErr(err error, opts ...Option) error {
+	opts = append(opts, withDefaultMessage(TooManyRequestsDefaultMsg))
+	return NewErr(http.StatusTooManyRequests, err, opts...)
+}
+
 // UnexpectedErr will be used when the certificate authority makes an outgoing
 // request and receives an unhandled status code.
 func UnexpectedErr(code int, err error, opts ...Option) error {
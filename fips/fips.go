@@ -0,0 +1,101 @@
+// Package fips implements the FIPS-only mode. When it is enabled, the KMS
+// backends, the provisioners and the init tools only allow the algorithms
+// approved by FIPS 186-4: RSA keys of at least 2048 bits, ECDSA keys on the
+// NIST P-256, P-384 and P-521 curves, and SHA-2 digests. Ed25519 is rejected.
+//
+// The mode is enabled at runtime with Enable, or always enabled in binaries
+// built with the fips build tag.
+package fips
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// MinRSAKeySize is the minimum size in bits of the RSA keys allowed in FIPS
+// mode.
+const MinRSAKeySize = 2048
+
+var enabled uint32
+
+// Enable enables the FIPS-only mode for the whole process.
+func Enable() {
+	atomic.StoreUint32(&enabled, 1)
+}
+
+// Disable disables the FIPS-only mode enabled with Enable. It does nothing in
+// binaries built with the fips build tag.
+func Disable() {
+	atomic.StoreUint32(&enabled, 0)
+}
+
+// Enabled returns true if the FIPS-only mode is enabled.
+func Enabled() bool {
+	return forced || atomic.LoadUint32(&enabled) == 1
+}
+
+// ErrNotPermitted is the error returned when an algorithm or key is not
+// allowed in FIPS mode.
+type ErrNotPermitted struct {
+	Algorithm string
+}
+
+func (e ErrNotPermitted) Error() string {
+	return e.Algorithm + " is not permitted in FIPS mode"
+}
+
+// StatusCode implements the errs.StatusCoder interface.
+func (e ErrNotPermitted) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// CheckPublicKey returns an ErrNotPermitted error if FIPS mode is enabled and
+// the given public key is not an RSA key of at least MinRSAKeySize bits or an
+// ECDSA key on the P-256, P-384 or P-521 curves.
+func CheckPublicKey(pub crypto.PublicKey) error {
+	if !Enabled() {
+		return nil
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < MinRSAKeySize {
+			return ErrNotPermitted{Algorithm: fmt.Sprintf("RSA-%d", k.N.BitLen())}
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		switch name := k.Curve.Params().Name; name {
+		case "P-256", "P-384", "P-521":
+			return nil
+		default:
+			return ErrNotPermitted{Algorithm: "ECDSA " + name}
+		}
+	case ed25519.PublicKey:
+		return ErrNotPermitted{Algorithm: "Ed25519"}
+	default:
+		return ErrNotPermitted{Algorithm: fmt.Sprintf("public key of type %T", pub)}
+	}
+}
+
+// CheckSignatureAlgorithm returns an ErrNotPermitted error if FIPS mode is
+// enabled and the given signature algorithm does not use RSA or ECDSA with a
+// SHA-2 digest.
+func CheckSignatureAlgorithm(alg x509.SignatureAlgorithm) error {
+	if !Enabled() {
+		return nil
+	}
+	switch alg {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return nil
+	default:
+		return ErrNotPermitted{Algorithm: alg.String()}
+	}
+}
@@ -0,0 +1,6 @@
+// +build !fips
+
+package fips
+
+// forced is true in binaries built with the fips build tag.
+const forced = false
@@ -0,0 +1,125 @@
+package fips
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestEnabled(t *testing.T) {
+	if forced {
+		t.Skip("FIPS mode is always enabled with the fips build tag")
+	}
+	if Enabled() {
+		t.Error("Enabled() = true, want false")
+	}
+	Enable()
+	if !Enabled() {
+		t.Error("Enabled() = false, want true")
+	}
+	Disable()
+	if Enabled() {
+		t.Error("Enabled() = true, want false")
+	}
+}
+
+func TestCheckPublicKey(t *testing.T) {
+	Enable()
+	defer Disable()
+
+	mustECDSA := func(c elliptic.Curve) crypto.PublicKey {
+		key, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key.Public()
+	}
+	mustRSA := func(bits int) crypto.PublicKey {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key.Public()
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pub     crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok P-256", mustECDSA(elliptic.P256()), false},
+		{"ok P-384", mustECDSA(elliptic.P384()), false},
+		{"ok P-521", mustECDSA(elliptic.P521()), false},
+		{"ok RSA-2048", mustRSA(2048), false},
+		{"fail P-224", mustECDSA(elliptic.P224()), true},
+		{"fail RSA-1024", mustRSA(1024), true},
+		{"fail Ed25519", edPub, true},
+		{"fail unknown", []byte("foo"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPublicKey(tt.pub)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := err.(ErrNotPermitted); tt.wantErr && !ok {
+				t.Errorf("CheckPublicKey() error = %T, want ErrNotPermitted", err)
+			}
+		})
+	}
+}
+
+func TestCheckPublicKey_disabled(t *testing.T) {
+	if forced {
+		t.Skip("FIPS mode is always enabled with the fips build tag")
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPublicKey(edPub); err != nil {
+		t.Errorf("CheckPublicKey() error = %v, want nil", err)
+	}
+}
+
+func TestCheckSignatureAlgorithm(t *testing.T) {
+	Enable()
+	defer Disable()
+
+	tests := []struct {
+		name    string
+		alg     x509.SignatureAlgorithm
+		wantErr bool
+	}{
+		{"ok SHA256WithRSA", x509.SHA256WithRSA, false},
+		{"ok SHA512WithRSAPSS", x509.SHA512WithRSAPSS, false},
+		{"ok ECDSAWithSHA384", x509.ECDSAWithSHA384, false},
+		{"fail SHA1WithRSA", x509.SHA1WithRSA, true},
+		{"fail MD5WithRSA", x509.MD5WithRSA, true},
+		{"fail ECDSAWithSHA1", x509.ECDSAWithSHA1, true},
+		{"fail PureEd25519", x509.PureEd25519, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckSignatureAlgorithm(tt.alg); (err != nil) != tt.wantErr {
+				t.Errorf("CheckSignatureAlgorithm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErrNotPermitted_Error(t *testing.T) {
+	if got := (ErrNotPermitted{Algorithm: "Ed25519"}).Error(); got != "Ed25519 is not permitted in FIPS mode" {
+		t.Errorf("ErrNotPermitted.Error() = %v, want Ed25519 is not permitted in FIPS mode", got)
+	}
+}
@@ -0,0 +1,82 @@
+package apiv1
+
+import (
+	"net/http"
+)
+
+// ErrPermissionDenied is the error returned when the credentials used by a KMS
+// are missing, invalid, or not allowed to perform an operation.
+type ErrPermissionDenied struct {
+	Message string
+}
+
+func (e ErrPermissionDenied) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "permission denied"
+}
+
+// StatusCode implements the errs.StatusCoder interface.
+func (e ErrPermissionDenied) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// ErrAlreadyExists is the error returned when a key or a key ring cannot be
+// created because it already exists.
+type ErrAlreadyExists struct {
+	Message string
+}
+
+func (e ErrAlreadyExists) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "key already exists"
+}
+
+// StatusCode implements the errs.StatusCoder interface.
+func (e ErrAlreadyExists) StatusCode() int {
+	return http.StatusConflict
+}
+
+// ErrNotFound is the error returned when a key, key version or key ring does
+// not exist.
+type ErrNotFound struct {
+	Message string
+}
+
+func (e ErrNotFound) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "key not found"
+}
+
+// StatusCode implements the errs.StatusCoder interface.
+func (e ErrNotFound) StatusCode() int {
+	return http.StatusNotFound
+}
+
+// ErrUnsupportedAlgorithm is the error returned when a KMS does not support
+// the requested signature algorithm or key size.
+type ErrUnsupportedAlgorithm struct {
+	Message string
+}
+
+func (e ErrUnsupportedAlgorithm) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "unsupported signature algorithm"
+}
+
+// StatusCode implements the errs.StatusCoder interface.
+func (e ErrUnsupportedAlgorithm) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// StatusCode implements the errs.StatusCoder interface.
+func (e ErrNotImplemented) StatusCode() int {
+	return http.StatusNotImplemented
+}
@@ -0,0 +1,39 @@
+package apiv1
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		want           string
+		wantStatusCode int
+	}{
+		{"permission denied", ErrPermissionDenied{}, "permission denied", http.StatusForbidden},
+		{"permission denied custom", ErrPermissionDenied{"access denied"}, "access denied", http.StatusForbidden},
+		{"already exists", ErrAlreadyExists{}, "key already exists", http.StatusConflict},
+		{"already exists custom", ErrAlreadyExists{"alias exists"}, "alias exists", http.StatusConflict},
+		{"not found", ErrNotFound{}, "key not found", http.StatusNotFound},
+		{"not found custom", ErrNotFound{"key ring not found"}, "key ring not found", http.StatusNotFound},
+		{"unsupported algorithm", ErrUnsupportedAlgorithm{}, "unsupported signature algorithm", http.StatusBadRequest},
+		{"unsupported algorithm custom", ErrUnsupportedAlgorithm{"P-521 is not supported"}, "P-521 is not supported", http.StatusBadRequest},
+		{"not implemented", ErrNotImplemented{}, "not implemented", http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %v, want %v", got, tt.want)
+			}
+			sc, ok := tt.err.(interface{ StatusCode() int })
+			if !ok {
+				t.Fatalf("%T does not implement StatusCode()", tt.err)
+			}
+			if got := sc.StatusCode(); got != tt.wantStatusCode {
+				t.Errorf("StatusCode() = %v, want %v", got, tt.wantStatusCode)
+			}
+		})
+	}
+}
@@ -16,11 +16,59 @@ type KeyManager interface {
 	Close() error
 }
 
-// CertificateManager is the interface implemented by the KMS that can load and
-// store x509.Certificates.
+// CertificateManager is the interface implemented by the KMS that can load,
+// store and delete x509.Certificates.
 type CertificateManager interface {
-	LoadCerticate(req *LoadCertificateRequest) (*x509.Certificate, error)
+	LoadCertificate(req *LoadCertificateRequest) (*x509.Certificate, error)
 	StoreCertificate(req *StoreCertificateRequest) error
+	DeleteCertificate(req *DeleteCertificateRequest) error
+}
+
+// KeyURIFormatter is the interface implemented by the KMS that can render the
+// name of a key as a URI that can be used directly in the ca.json.
+type KeyURIFormatter interface {
+	KeyURI(name string) (string, error)
+}
+
+// HealthChecker is the interface implemented by the KMS that can verify that
+// the backend is reachable and that the credentials used have enough
+// permissions, without creating or modifying any key.
+type HealthChecker interface {
+	CheckHealth(req *CheckHealthRequest) error
+}
+
+// KeyLister is the interface implemented by the KMS that can list the keys
+// previously created, it is used to detect existing keys before creating new
+// ones.
+type KeyLister interface {
+	ListKeys(req *ListKeysRequest) (*ListKeysResponse, error)
+}
+
+// KeyRotator is the interface implemented by the KMS that can create a new
+// version of an existing key, the key name does not change but the new version
+// has a new public key.
+type KeyRotator interface {
+	RotateKey(req *RotateKeyRequest) (*CreateKeyResponse, error)
+}
+
+// CapabilitiesReporter is the interface implemented by the KMS that can report
+// the signature algorithms, RSA key sizes and protection levels that can be
+// used to create keys.
+type CapabilitiesReporter interface {
+	Capabilities() *Capabilities
+}
+
+// Encrypter is the interface implemented by the KMS that can encrypt small
+// secrets, e.g. the data keys used in envelope encryption, with a symmetric key
+// stored in the KMS.
+type Encrypter interface {
+	Encrypt(req *EncryptRequest) (*EncryptResponse, error)
+}
+
+// Decrypter is the interface implemented by the KMS that can decrypt the
+// secrets previously encrypted by an Encrypter.
+type Decrypter interface {
+	Decrypt(req *DecryptRequest) (*DecryptResponse, error)
 }
 
 // ErrNotImplemented
@@ -60,6 +108,10 @@ type Options struct {
 	// Path to the credentials file used in CloudKMS and AmazonKMS.
 	CredentialsFile string `json:"credentialsFile"`
 
+	// Name of the Secret Manager secret with the credentials used in CloudKMS,
+	// projects/<project>/secrets/<secret>[/versions/<version>].
+	CredentialsSecret string `json:"credentialsSecret"`
+
 	// Path to the module used with PKCS11 KMS.
 	Module string `json:"module"`
 
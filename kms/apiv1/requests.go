@@ -4,6 +4,8 @@ import (
 	"crypto"
 	"crypto/x509"
 	"fmt"
+
+	"github.com/smallstep/certificates/fips"
 )
 
 // ProtectionLevel specifies on some KMS how cryptographic operations are
@@ -33,6 +35,67 @@ func (p ProtectionLevel) String() string {
 	}
 }
 
+// TouchPolicy specifies on some devices when a physical touch is required to
+// use a key.
+type TouchPolicy int
+
+const (
+	// Touch policy not specified, the device default is used.
+	UnspecifiedTouchPolicy TouchPolicy = iota
+	// A touch is never required.
+	TouchPolicyNever
+	// A touch is required for every operation.
+	TouchPolicyAlways
+	// A touch is cached for 15 seconds.
+	TouchPolicyCached
+)
+
+// String returns a string representation of p.
+func (p TouchPolicy) String() string {
+	switch p {
+	case UnspecifiedTouchPolicy:
+		return "unspecified"
+	case TouchPolicyNever:
+		return "never"
+	case TouchPolicyAlways:
+		return "always"
+	case TouchPolicyCached:
+		return "cached"
+	default:
+		return fmt.Sprintf("unknown(%d)", p)
+	}
+}
+
+// PINPolicy specifies on some devices when the PIN is required to use a key.
+type PINPolicy int
+
+const (
+	// PIN policy not specified, the device default is used.
+	UnspecifiedPINPolicy PINPolicy = iota
+	// The PIN is never required.
+	PINPolicyNever
+	// The PIN is required once per session.
+	PINPolicyOnce
+	// The PIN is required for every operation.
+	PINPolicyAlways
+)
+
+// String returns a string representation of p.
+func (p PINPolicy) String() string {
+	switch p {
+	case UnspecifiedPINPolicy:
+		return "unspecified"
+	case PINPolicyNever:
+		return "never"
+	case PINPolicyOnce:
+		return "once"
+	case PINPolicyAlways:
+		return "always"
+	default:
+		return fmt.Sprintf("unknown(%d)", p)
+	}
+}
+
 // SignatureAlgorithm used for cryptographic signing.
 type SignatureAlgorithm int
 
@@ -91,6 +154,27 @@ func (s SignatureAlgorithm) String() string {
 	}
 }
 
+// CheckFIPSAlgorithm returns a fips.ErrNotPermitted error if the FIPS-only mode
+// is enabled and the given signature algorithm and RSA bits are not approved.
+// An unspecified algorithm uses the default of the KMS, an ECDSA key, and RSA
+// keys without bits use the default size, at least 2048 bits.
+func CheckFIPSAlgorithm(alg SignatureAlgorithm, bits int) error {
+	if !fips.Enabled() {
+		return nil
+	}
+	switch alg {
+	case UnspecifiedSignAlgorithm, ECDSAWithSHA256, ECDSAWithSHA384, ECDSAWithSHA512:
+		return nil
+	case SHA256WithRSA, SHA384WithRSA, SHA512WithRSA, SHA256WithRSAPSS, SHA384WithRSAPSS, SHA512WithRSAPSS:
+		if bits != 0 && bits < fips.MinRSAKeySize {
+			return fips.ErrNotPermitted{Algorithm: fmt.Sprintf("%s with %d bits", alg, bits)}
+		}
+		return nil
+	default:
+		return fips.ErrNotPermitted{Algorithm: alg.String()}
+	}
+}
+
 // GetPublicKeyRequest is the parameter used in the kms.GetPublicKey method.
 type GetPublicKeyRequest struct {
 	Name string
@@ -105,6 +189,30 @@ type CreateKeyRequest struct {
 	// ProtectionLevel specifies how cryptographic operations are performed.
 	// Used by: cloudkms
 	ProtectionLevel ProtectionLevel
+
+	// TouchPolicy and PINPolicy specify when a physical touch or the PIN are
+	// required to use the key.
+	// Used by: yubikey
+	TouchPolicy TouchPolicy
+	PINPolicy   PINPolicy
+
+	// Idempotent makes CreateKey return the existing key if a key with the
+	// same name already exists, instead of failing or creating a new one, so
+	// a failed initialization can be repeated without orphan keys.
+	// Used by: cloudkms, awskms
+	Idempotent bool
+
+	// KeyPolicy is a JSON policy document attached to the key at creation,
+	// it is not applied to an existing key with Idempotent.
+	// Used by: awskms
+	KeyPolicy string
+
+	// MultiRegion creates a multi-region key, and ReplicaRegions are the
+	// regions where the key is replicated, so the same key material can be
+	// used in a failover region. ReplicaRegions requires MultiRegion.
+	// Used by: awskms
+	MultiRegion    bool
+	ReplicaRegions []string
 }
 
 // CreateKeyResponse is the response value of the kms.CreateKey method.
@@ -113,12 +221,25 @@ type CreateKeyResponse struct {
 	PublicKey           crypto.PublicKey
 	PrivateKey          crypto.PrivateKey
 	CreateSignerRequest CreateSignerRequest
+
+	// KeyURI is the canonical URI of the created key, as it should be used in
+	// the ca.json. It is empty if the KMS does not have one.
+	// Used by: cloudkms, awskms, yubikey
+	KeyURI string
+
+	// ReplicaKeyURIs are the URIs of the replicas of a multi-region key, each
+	// one with the region of the replica.
+	// Used by: awskms
+	ReplicaKeyURIs []string
 }
 
 // CreateSignerRequest is the parameter used in the kms.CreateSigner method.
+// KeyVersion is an optional version of the signing key, it allows to sign with
+// a specific version in a KMS with versioned keys like Cloud KMS.
 type CreateSignerRequest struct {
 	Signer        crypto.Signer
 	SigningKey    string
+	KeyVersion    string
 	SigningKeyPEM []byte
 	TokenLabel    string
 	PublicKey     string
@@ -138,3 +259,83 @@ type StoreCertificateRequest struct {
 	Name        string
 	Certificate *x509.Certificate
 }
+
+// DeleteCertificateRequest is the parameter used in the DeleteCertificate
+// method of a CertificateManager.
+type DeleteCertificateRequest struct {
+	Name string
+}
+
+// Capabilities is the value returned by the Capabilities method of a
+// CapabilitiesReporter. Bits contains the RSA key sizes supported by each
+// signature algorithm; an RSA algorithm without an entry accepts any size.
+type Capabilities struct {
+	SignatureAlgorithms []SignatureAlgorithm
+	Bits                map[SignatureAlgorithm][]int
+	ProtectionLevels    []ProtectionLevel
+}
+
+// CheckHealthRequest is the parameter used in the CheckHealth method of a
+// HealthChecker. Name is an optional resource used by the check, e.g. the key
+// ring in Cloud KMS.
+type CheckHealthRequest struct {
+	Name string
+}
+
+// ListKeysRequest is the parameter used in the ListKeys method of a KeyLister.
+// Parent is the resource that contains the keys, e.g. the key ring in Cloud
+// KMS, it is not used in AWS KMS.
+type ListKeysRequest struct {
+	Parent string
+}
+
+// ListKeysResponse is the response of the ListKeys method of a KeyLister.
+type ListKeysResponse struct {
+	Keys []KeyInfo
+}
+
+// RotateKeyRequest is the parameter used in the RotateKey method of a
+// KeyRotator. Name is the key to rotate, e.g. the crypto key in Cloud KMS.
+type RotateKeyRequest struct {
+	Name string
+}
+
+// KeyInfo describes a key returned by a KeyLister. Name is the name used to
+// create the key in the CreateKeyRequest, and Key is the name of the key in
+// the KMS.
+type KeyInfo struct {
+	Name string
+	Key  string
+}
+
+// EncryptRequest is the parameter used in the Encrypt method of an Encrypter.
+// Name is the symmetric key used to encrypt the plaintext. AdditionalData is
+// optional, and the same value must be used to decrypt the ciphertext.
+// The maximum size of the plaintext depends on the KMS, 64KiB in Cloud KMS
+// and 4KiB in AWS KMS.
+type EncryptRequest struct {
+	Name           string
+	Plaintext      []byte
+	AdditionalData []byte
+}
+
+// EncryptResponse is the response of the Encrypt method of an Encrypter. Name
+// is the key, or the key version, used to encrypt the plaintext.
+type EncryptResponse struct {
+	Name       string
+	Ciphertext []byte
+}
+
+// DecryptRequest is the parameter used in the Decrypt method of a Decrypter.
+// Name is the symmetric key used to encrypt the ciphertext, and AdditionalData
+// must match the value used in the EncryptRequest.
+type DecryptRequest struct {
+	Name           string
+	Ciphertext     []byte
+	AdditionalData []byte
+}
+
+// DecryptResponse is the response of the Decrypt method of a Decrypter.
+type DecryptResponse struct {
+	Plaintext []byte
+}
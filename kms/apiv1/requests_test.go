@@ -1,6 +1,10 @@
 package apiv1
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/smallstep/certificates/fips"
+)
 
 func TestProtectionLevel_String(t *testing.T) {
 	tests := []struct {
@@ -22,6 +26,48 @@ func TestProtectionLevel_String(t *testing.T) {
 	}
 }
 
+func TestTouchPolicy_String(t *testing.T) {
+	tests := []struct {
+		name string
+		p    TouchPolicy
+		want string
+	}{
+		{"unspecified", UnspecifiedTouchPolicy, "unspecified"},
+		{"never", TouchPolicyNever, "never"},
+		{"always", TouchPolicyAlways, "always"},
+		{"cached", TouchPolicyCached, "cached"},
+		{"unknown", TouchPolicy(100), "unknown(100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("TouchPolicy.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPINPolicy_String(t *testing.T) {
+	tests := []struct {
+		name string
+		p    PINPolicy
+		want string
+	}{
+		{"unspecified", UnspecifiedPINPolicy, "unspecified"},
+		{"never", PINPolicyNever, "never"},
+		{"once", PINPolicyOnce, "once"},
+		{"always", PINPolicyAlways, "always"},
+		{"unknown", PINPolicy(100), "unknown(100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("PINPolicy.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestSignatureAlgorithm_String(t *testing.T) {
 	tests := []struct {
 		name string
@@ -49,3 +95,43 @@ func TestSignatureAlgorithm_String(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckFIPSAlgorithm(t *testing.T) {
+	type args struct {
+		alg  SignatureAlgorithm
+		bits int
+	}
+	tests := []struct {
+		name    string
+		enable  bool
+		args    args
+		wantErr bool
+	}{
+		{"disabled", false, args{PureEd25519, 0}, false},
+		{"unspecified", true, args{UnspecifiedSignAlgorithm, 0}, false},
+		{"ecdsa", true, args{ECDSAWithSHA384, 0}, false},
+		{"rsa", true, args{SHA256WithRSA, 0}, false},
+		{"rsa2048", true, args{SHA256WithRSAPSS, 2048}, false},
+		{"fail rsa1024", true, args{SHA256WithRSA, 1024}, true},
+		{"fail ed25519", true, args{PureEd25519, 0}, true},
+		{"fail unknown", true, args{SignatureAlgorithm(100), 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.enable && fips.Enabled() {
+				t.Skip("FIPS mode is always enabled")
+			}
+			if tt.enable {
+				fips.Enable()
+				defer fips.Disable()
+			}
+			err := CheckFIPSAlgorithm(tt.args.alg, tt.args.bits)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckFIPSAlgorithm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := err.(fips.ErrNotPermitted); tt.wantErr && !ok {
+				t.Errorf("CheckFIPSAlgorithm() error = %T, want fips.ErrNotPermitted", err)
+			}
+		})
+	}
+}
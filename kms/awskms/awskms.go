@@ -3,11 +3,16 @@ package awskms
 import (
 	"context"
 	"crypto"
+	"encoding/json"
+	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
@@ -21,6 +26,11 @@ import (
 type KMS struct {
 	session *session.Session
 	service KeyManagementClient
+
+	// regional are the clients of the regions other than the session one,
+	// used with the replicas of multi-region keys.
+	mu       sync.Mutex
+	regional map[string]KeyManagementClient
 }
 
 // KeyManagementClient defines the methods on KeyManagementClient that this
@@ -30,6 +40,12 @@ type KeyManagementClient interface {
 	CreateKeyWithContext(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error)
 	CreateAliasWithContext(ctx aws.Context, input *kms.CreateAliasInput, opts ...request.Option) (*kms.CreateAliasOutput, error)
 	SignWithContext(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error)
+	ListKeysWithContext(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error)
+	ListAliasesWithContext(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error)
+	EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+	DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error)
+	ReplicateKeyWithContext(ctx aws.Context, input *kms.ReplicateKeyInput, opts ...request.Option) (*kms.ReplicateKeyOutput, error)
 }
 
 // customerMasterKeySpecMapping is a mapping between the step signature algorithm,
@@ -97,7 +113,8 @@ func init() {
 	})
 }
 
-// GetPublicKey returns a public key from KMS.
+// GetPublicKey returns a public key from KMS. The key is requested in the
+// region of the key name, if it has one, see CreateSigner.
 func (k *KMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
 	if req.Name == "" {
 		return nil, errors.New("getPublicKey 'name' cannot be empty")
@@ -106,32 +123,59 @@ func (k *KMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, er
 	if err != nil {
 		return nil, err
 	}
+	client, err := k.keyClient(req.Name)
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := defaultContext()
 	defer cancel()
 
-	resp, err := k.service.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+	resp, err := client.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
 		KeyId: &keyID,
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "awskms GetPublicKeyWithContext failed")
+		return nil, wrapError(err, "awskms GetPublicKeyWithContext failed")
 	}
 
 	return pemutil.ParseDER(resp.PublicKey)
 }
 
 // CreateKey generates a new key in KMS and returns the public key version
-// of it.
+// of it. With MultiRegion the key is a multi-region key, it is replicated in
+// the ReplicaRegions and the URIs of its replicas are returned in the
+// ReplicaKeyURIs of the response.
 func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	if req.Name == "" {
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
 	}
+	if err := apiv1.CheckFIPSAlgorithm(req.SignatureAlgorithm, req.Bits); err != nil {
+		return nil, err
+	}
 
 	keySpec, err := getCustomerMasterKeySpecMapping(req.SignatureAlgorithm, req.Bits)
 	if err != nil {
 		return nil, err
 	}
 
+	if req.KeyPolicy != "" && !json.Valid([]byte(req.KeyPolicy)) {
+		return nil, errors.New("createKeyRequest 'keyPolicy' is not a valid JSON document")
+	}
+
+	if len(req.ReplicaRegions) > 0 && !req.MultiRegion {
+		return nil, errors.New("createKeyRequest 'replicaRegions' requires 'multiRegion'")
+	}
+
+	if req.Idempotent {
+		keyID, err := k.findKey(req.Name, keySpec)
+		if err != nil {
+			return nil, err
+		}
+		if keyID != "" {
+			return k.createKeyResponse(keyID, req)
+		}
+	}
+
 	tag := new(kms.Tag)
 	tag.SetTagKey("name")
 	tag.SetTagValue(req.Name)
@@ -142,21 +186,34 @@ func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse,
 		Tags:                  []*kms.Tag{tag},
 	}
 	input.SetKeyUsage(kms.KeyUsageTypeSignVerify)
+	if req.KeyPolicy != "" {
+		input.SetPolicy(req.KeyPolicy)
+	}
+	if req.MultiRegion {
+		input.SetMultiRegion(true)
+	}
 
 	ctx, cancel := defaultContext()
 	defer cancel()
 
 	resp, err := k.service.CreateKeyWithContext(ctx, input)
 	if err != nil {
-		return nil, errors.Wrap(err, "awskms CreateKeyWithContext failed")
+		return nil, wrapError(err, "awskms CreateKeyWithContext failed")
 	}
 	if err := k.createKeyAlias(*resp.KeyMetadata.KeyId, req.Name); err != nil {
 		return nil, err
 	}
 
+	return k.createKeyResponse(*resp.KeyMetadata.KeyId, req)
+}
+
+// createKeyResponse returns the CreateKeyResponse for the given key id. If the
+// request is for a multi-region key, the key is replicated in the requested
+// regions.
+func (k *KMS) createKeyResponse(keyID string, req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	// Create uri for key
 	name := uri.New("awskms", url.Values{
-		"key-id": []string{*resp.KeyMetadata.KeyId},
+		"key-id": []string{keyID},
 	}).String()
 
 	publicKey, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
@@ -166,6 +223,18 @@ func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse,
 		return nil, err
 	}
 
+	keyURI, err := k.KeyURI(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var replicaKeyURIs []string
+	if req.MultiRegion {
+		if replicaKeyURIs, err = k.replicateKey(keyID, req); err != nil {
+			return nil, err
+		}
+	}
+
 	// Names uses Amazon Resource Name
 	// https://docs.aws.amazon.com/general/latest/gr/aws-arns-and-namespaces.html
 	return &apiv1.CreateKeyResponse{
@@ -174,9 +243,119 @@ func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse,
 		CreateSignerRequest: apiv1.CreateSignerRequest{
 			SigningKey: name,
 		},
+		KeyURI:         keyURI,
+		ReplicaKeyURIs: replicaKeyURIs,
 	}, nil
 }
 
+// replicateKey replicates the multi-region key with the given id in the
+// regions of the request that do not have a replica yet, and returns the URIs
+// of all the replicas of the key sorted by region. It returns an
+// ErrAlreadyExists error if the key is not a multi-region key.
+func (k *KMS) replicateKey(keyID string, req *apiv1.CreateKeyRequest) ([]string, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.service.DescribeKeyWithContext(ctx, &kms.DescribeKeyInput{
+		KeyId: &keyID,
+	})
+	if err != nil {
+		return nil, wrapError(err, "awskms DescribeKeyWithContext failed")
+	}
+	if !aws.BoolValue(resp.KeyMetadata.MultiRegion) {
+		return nil, apiv1.ErrAlreadyExists{
+			Message: fmt.Sprintf("awskms key %s already exists and it is not a multi-region key", req.Name),
+		}
+	}
+
+	replicas := make(map[string]string)
+	if mrc := resp.KeyMetadata.MultiRegionConfiguration; mrc != nil {
+		for _, r := range mrc.ReplicaKeys {
+			replicas[aws.StringValue(r.Region)] = aws.StringValue(r.Arn)
+		}
+	}
+
+	for _, region := range req.ReplicaRegions {
+		if _, ok := replicas[region]; ok {
+			continue
+		}
+
+		tag := new(kms.Tag)
+		tag.SetTagKey("name")
+		tag.SetTagValue(req.Name)
+
+		input := &kms.ReplicateKeyInput{
+			KeyId:         &keyID,
+			ReplicaRegion: aws.String(region),
+			Description:   &req.Name,
+			Tags:          []*kms.Tag{tag},
+		}
+		if req.KeyPolicy != "" {
+			input.SetPolicy(req.KeyPolicy)
+		}
+
+		out, err := k.service.ReplicateKeyWithContext(ctx, input)
+		if err != nil {
+			return nil, wrapError(err, "awskms ReplicateKeyWithContext failed")
+		}
+		replicas[region] = aws.StringValue(out.ReplicaKeyMetadata.Arn)
+	}
+
+	regions := make([]string, 0, len(replicas))
+	for region := range replicas {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	uris := make([]string, len(regions))
+	for i, region := range regions {
+		uris[i] = uri.New("awskms", url.Values{
+			"key-id": []string{replicas[region]},
+			"region": []string{region},
+		}).String()
+	}
+	return uris, nil
+}
+
+// findKey returns the id of the key created by CreateKey with the given name,
+// or an empty string if there is none. It returns an ErrAlreadyExists error if
+// the key does not have the given key spec.
+func (k *KMS) findKey(name, keySpec string) (string, error) {
+	input := new(kms.ListAliasesInput)
+	for {
+		resp, err := k.listAliases(input)
+		if err != nil {
+			return "", err
+		}
+		for _, alias := range resp.Aliases {
+			if n, ok := aliasName(alias); !ok || n != name {
+				continue
+			}
+			keyID := aws.StringValue(alias.TargetKeyId)
+
+			ctx, cancel := defaultContext()
+			defer cancel()
+
+			key, err := k.service.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+				KeyId: &keyID,
+			})
+			if err != nil {
+				return "", wrapError(err, "awskms GetPublicKeyWithContext failed")
+			}
+			if spec := aws.StringValue(key.CustomerMasterKeySpec); spec != keySpec {
+				return "", apiv1.ErrAlreadyExists{
+					Message: fmt.Sprintf("awskms key %s already exists with key spec %s, %s was requested", name, spec, keySpec),
+				}
+			}
+			return keyID, nil
+		}
+		if !aws.BoolValue(resp.Truncated) {
+			return "", nil
+		}
+		input.Marker = resp.NextMarker
+	}
+}
+
 func (k *KMS) createKeyAlias(keyID, alias string) error {
 	alias = "alias/" + alias + "-" + keyID[:8]
 
@@ -188,17 +367,199 @@ func (k *KMS) createKeyAlias(keyID, alias string) error {
 		TargetKeyId: &keyID,
 	})
 	if err != nil {
-		return errors.Wrap(err, "awskms CreateAliasWithContext failed")
+		return wrapError(err, "awskms CreateAliasWithContext failed")
 	}
 	return nil
 }
 
 // CreateSigner creates a new crypto.Signer with a previously configured key.
+// If the signing key has a region, in the region parameter of the uri or in
+// the key ARN, the signer uses that region instead of the one in the session,
+// so a CA can use the replica of a multi-region key in a failover region, e.g.
+// awskms:key-id=mrk-1234abcd12ab34cd56ef1234567890ab;region=us-west-2.
 func (k *KMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	if req.SigningKey == "" {
 		return nil, errors.New("createSigner 'signingKey' cannot be empty")
 	}
-	return NewSigner(k.service, req.SigningKey)
+	client, err := k.keyClient(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(client, req.SigningKey)
+}
+
+// keyClient returns the client for the region of the given key name, see
+// keyRegion.
+func (k *KMS) keyClient(name string) (KeyManagementClient, error) {
+	region, err := keyRegion(name)
+	if err != nil {
+		return nil, err
+	}
+	return k.client(region), nil
+}
+
+// client returns the client for the given region. It returns the session
+// client if the region is empty or it is the region of the session.
+func (k *KMS) client(region string) KeyManagementClient {
+	if region == "" {
+		return k.service
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if c, ok := k.regional[region]; ok {
+		return c
+	}
+	if k.session == nil || region == aws.StringValue(k.session.Config.Region) {
+		return k.service
+	}
+	if k.regional == nil {
+		k.regional = make(map[string]KeyManagementClient)
+	}
+	c := kms.New(k.session, aws.NewConfig().WithRegion(region))
+	k.regional[region] = c
+	return c
+}
+
+// KeyURI returns the given key name as an awskms URI, including the region of
+// the key, if it has one, or the region configured in the session, e.g.
+// awskms:key-id=KEY_ID;region=us-east-1.
+func (k *KMS) KeyURI(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("key name cannot be empty")
+	}
+	keyID, err := parseKeyID(name)
+	if err != nil {
+		return "", err
+	}
+	values := url.Values{
+		"key-id": []string{keyID},
+	}
+	region, err := keyRegion(name)
+	if err != nil {
+		return "", err
+	}
+	if region != "" {
+		values.Set("region", region)
+	} else if k.session != nil && aws.StringValue(k.session.Config.Region) != "" {
+		values.Set("region", aws.StringValue(k.session.Config.Region))
+	}
+	return uri.New("awskms", values).String(), nil
+}
+
+// CheckHealth verifies that AWS KMS is reachable and that the configured
+// credentials are valid, it lists at most one key and it does not create or
+// modify any key. The request name is not used.
+func (k *KMS) CheckHealth(req *apiv1.CheckHealthRequest) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	_, err := k.service.ListKeysWithContext(ctx, &kms.ListKeysInput{
+		Limit: aws.Int64(1),
+	})
+	if err == nil {
+		return nil
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDeniedException":
+			return apiv1.ErrPermissionDenied{Message: "awskms health check failed: insufficient permissions, kms:ListKeys is required: " + err.Error()}
+		case "UnrecognizedClientException", "InvalidClientTokenId", "ExpiredTokenException", "NoCredentialProviders":
+			return apiv1.ErrPermissionDenied{Message: "awskms health check failed: invalid or missing credentials: " + err.Error()}
+		}
+	}
+	return errors.Wrap(err, "awskms health check failed: AWS KMS is not reachable")
+}
+
+// ListKeys returns the keys created by CreateKey. The keys are found using the
+// aliases created with them, `alias/<name>-<key-id-prefix>`, and all the pages
+// of aliases are requested. The request parent is not used.
+func (k *KMS) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	var keys []apiv1.KeyInfo
+	input := new(kms.ListAliasesInput)
+	for {
+		resp, err := k.listAliases(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, alias := range resp.Aliases {
+			if name, ok := aliasName(alias); ok {
+				keys = append(keys, apiv1.KeyInfo{
+					Name: name,
+					Key: uri.New("awskms", url.Values{
+						"key-id": []string{aws.StringValue(alias.TargetKeyId)},
+					}).String(),
+				})
+			}
+		}
+		if !aws.BoolValue(resp.Truncated) {
+			break
+		}
+		input.Marker = resp.NextMarker
+	}
+
+	return &apiv1.ListKeysResponse{
+		Keys: keys,
+	}, nil
+}
+
+func (k *KMS) listAliases(input *kms.ListAliasesInput) (*kms.ListAliasesOutput, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.service.ListAliasesWithContext(ctx, input)
+	if err != nil {
+		return nil, wrapError(err, "awskms ListAliasesWithContext failed")
+	}
+	return resp, nil
+}
+
+// aliasName returns the name used to create the key of an alias created by
+// createKeyAlias. It returns false if the alias does not follow that format.
+func aliasName(alias *kms.AliasListEntry) (string, bool) {
+	keyID := aws.StringValue(alias.TargetKeyId)
+	if len(keyID) < 8 {
+		return "", false
+	}
+	name := aws.StringValue(alias.AliasName)
+	suffix := "-" + keyID[:8]
+	if !strings.HasPrefix(name, "alias/") || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "alias/"), suffix)
+	if name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// Capabilities implements apiv1.CapabilitiesReporter and returns the signature
+// algorithms, RSA key sizes and protection levels supported by AWS KMS.
+func (k *KMS) Capabilities() *apiv1.Capabilities {
+	caps := &apiv1.Capabilities{
+		Bits:             make(map[apiv1.SignatureAlgorithm][]int),
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.HSM},
+	}
+	for alg, v := range customerMasterKeySpecMapping {
+		if alg == apiv1.UnspecifiedSignAlgorithm {
+			continue
+		}
+		caps.SignatureAlgorithms = append(caps.SignatureAlgorithms, alg)
+		if m, ok := v.(map[int]string); ok {
+			for bits := range m {
+				if bits > 0 {
+					caps.Bits[alg] = append(caps.Bits[alg], bits)
+				}
+			}
+			sort.Ints(caps.Bits[alg])
+		}
+	}
+	sort.Slice(caps.SignatureAlgorithms, func(i, j int) bool {
+		return caps.SignatureAlgorithms[i] < caps.SignatureAlgorithms[j]
+	})
+	return caps
 }
 
 // Close closes the connection of the KMS client.
@@ -210,26 +571,75 @@ func defaultContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), 15*time.Second)
 }
 
-// parseKeyID extracts the key-id from an uri.
+// parseKeyID extracts the key-id from an uri. The key-id can be a key id, a
+// key ARN, an alias name, e.g. alias/step-root, or an alias ARN. Aliases are
+// case sensitive and they keep their case, key ids and key ARNs are lowercased.
 func parseKeyID(name string) (string, error) {
-	name = strings.ToLower(name)
-	if strings.HasPrefix(name, "awskms:") || strings.HasPrefix(name, "aws:") {
+	keyID := name
+	if lower := strings.ToLower(name); strings.HasPrefix(lower, "awskms:") || strings.HasPrefix(lower, "aws:") {
 		u, err := uri.Parse(name)
 		if err != nil {
 			return "", err
 		}
-		if k := u.Get("key-id"); k != "" {
-			return k, nil
+		if keyID = u.Get("key-id"); keyID == "" {
+			return "", errors.Errorf("failed to get key-id from %s", name)
+		}
+	}
+	if isAlias(keyID) {
+		return keyID, nil
+	}
+	return strings.ToLower(keyID), nil
+}
+
+// keyRegion returns the region of a key, the region parameter of an uri or the
+// region of a key ARN. It returns an empty string if the key does not have a
+// region, and an error if the uri cannot be parsed.
+func keyRegion(name string) (string, error) {
+	keyID := name
+	if lower := strings.ToLower(name); strings.HasPrefix(lower, "awskms:") || strings.HasPrefix(lower, "aws:") {
+		u, err := uri.Parse(name)
+		if err != nil {
+			return "", err
+		}
+		if region := u.Get("region"); region != "" {
+			return region, nil
+		}
+		keyID = u.Get("key-id")
+	}
+	// arn:partition:kms:region:account-id:resource
+	if parts := strings.SplitN(keyID, ":", 6); len(parts) == 6 && strings.EqualFold(parts[0], "arn") {
+		return parts[3], nil
+	}
+	return "", nil
+}
+
+// isAlias returns true if the given key id is an alias name or an alias ARN.
+// AWS KMS resolves an alias to the key it points to on every request.
+func isAlias(keyID string) bool {
+	return strings.HasPrefix(keyID, "alias/") ||
+		(strings.HasPrefix(strings.ToLower(keyID), "arn:") && strings.Contains(keyID, ":alias/"))
+}
+
+// wrapError converts the AWS errors with a matching apiv1 error type, and wraps
+// the rest with the given message.
+func wrapError(err error, msg string) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDeniedException", "UnrecognizedClientException":
+			return apiv1.ErrPermissionDenied{Message: msg + ": " + err.Error()}
+		case "AlreadyExistsException":
+			return apiv1.ErrAlreadyExists{Message: msg + ": " + err.Error()}
+		case "NotFoundException":
+			return apiv1.ErrNotFound{Message: msg + ": " + err.Error()}
 		}
-		return "", errors.Errorf("failed to get key-id from %s", name)
 	}
-	return name, nil
+	return errors.Wrap(err, msg)
 }
 
 func getCustomerMasterKeySpecMapping(alg apiv1.SignatureAlgorithm, bits int) (string, error) {
 	v, ok := customerMasterKeySpecMapping[alg]
 	if !ok {
-		return "", errors.Errorf("awskms does not support signature algorithm '%s'", alg)
+		return "", apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("awskms does not support signature algorithm '%s'", alg)}
 	}
 
 	switch v := v.(type) {
@@ -238,7 +648,7 @@ func getCustomerMasterKeySpecMapping(alg apiv1.SignatureAlgorithm, bits int) (st
 	case map[int]string:
 		s, ok := v[bits]
 		if !ok {
-			return "", errors.Errorf("awskms does not support signature algorithm '%s' with '%d' bits", alg, bits)
+			return "", apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("awskms does not support signature algorithm '%s' with '%d' bits", alg, bits)}
 		}
 		return s, nil
 	default:
@@ -7,12 +7,15 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
 	"github.com/smallstep/cli/crypto/pemutil"
 )
@@ -181,6 +184,7 @@ func TestKMS_CreateKey(t *testing.T) {
 			CreateSignerRequest: apiv1.CreateSignerRequest{
 				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
 			},
+			KeyURI: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
 		}, false},
 		{"ok rsa", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
 			Name:               "root",
@@ -192,8 +196,181 @@ func TestKMS_CreateKey(t *testing.T) {
 			CreateSignerRequest: apiv1.CreateSignerRequest{
 				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
 			},
+			KeyURI: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}, false},
+		{"ok idempotent", fields{nil, &MockClient{
+			getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+				resp, err := okClient.getPublicKeyWithContext(ctx, input, opts...)
+				if err != nil {
+					return nil, err
+				}
+				resp.CustomerMasterKeySpec = aws.String(kms.CustomerMasterKeySpecEccNistP256)
+				return resp, nil
+			},
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+			listAliasesWithContext: okClient.listAliasesWithContext,
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Idempotent:         true,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PublicKey: key,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			},
+			KeyURI: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}, false},
+		{"ok idempotent new key", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "intermediate",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Idempotent:         true,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PublicKey: key,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			},
+			KeyURI: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}, false},
+		{"fail idempotent key spec", fields{nil, &MockClient{
+			getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+				resp, err := okClient.getPublicKeyWithContext(ctx, input, opts...)
+				if err != nil {
+					return nil, err
+				}
+				resp.CustomerMasterKeySpec = aws.String(kms.CustomerMasterKeySpecRsa2048)
+				return resp, nil
+			},
+			listAliasesWithContext: okClient.listAliasesWithContext,
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Idempotent:         true,
+		}}, nil, true},
+		{"fail idempotent listAliases", fields{nil, &MockClient{
+			listAliasesWithContext: func(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Idempotent:         true,
+		}}, nil, true},
+		{"ok with key policy", fields{nil, &MockClient{
+			getPublicKeyWithContext: okClient.getPublicKeyWithContext,
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				if aws.StringValue(input.Policy) != `{"Version":"2012-10-17","Statement":[]}` {
+					return nil, fmt.Errorf("unexpected policy %q", aws.StringValue(input.Policy))
+				}
+				return okClient.createKeyWithContext(ctx, input, opts...)
+			},
+			createAliasWithContext: okClient.createAliasWithContext,
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			KeyPolicy:          `{"Version":"2012-10-17","Statement":[]}`,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PublicKey: key,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			},
+			KeyURI: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}, false},
+		{"ok multi-region", fields{nil, &MockClient{
+			getPublicKeyWithContext: okClient.getPublicKeyWithContext,
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				if !aws.BoolValue(input.MultiRegion) {
+					return nil, fmt.Errorf("unexpected single-region key")
+				}
+				return okClient.createKeyWithContext(ctx, input, opts...)
+			},
+			createAliasWithContext: okClient.createAliasWithContext,
+			describeKeyWithContext: func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+				resp, err := okClient.describeKeyWithContext(ctx, input, opts...)
+				if err != nil {
+					return nil, err
+				}
+				resp.KeyMetadata.MultiRegionConfiguration = &kms.MultiRegionConfiguration{
+					ReplicaKeys: []*kms.MultiRegionKey{
+						{Arn: aws.String("arn:aws:kms:us-west-2:111122223333:key/" + keyID), Region: aws.String("us-west-2")},
+					},
+				}
+				return resp, nil
+			},
+			replicateKeyWithContext: func(ctx aws.Context, input *kms.ReplicateKeyInput, opts ...request.Option) (*kms.ReplicateKeyOutput, error) {
+				if aws.StringValue(input.ReplicaRegion) == "us-west-2" {
+					return nil, fmt.Errorf("unexpected replication to us-west-2")
+				}
+				return okClient.replicateKeyWithContext(ctx, input, opts...)
+			},
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			MultiRegion:        true,
+			ReplicaRegions:     []string{"us-west-2", "eu-west-1"},
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PublicKey: key,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			},
+			KeyURI: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			ReplicaKeyURIs: []string{
+				"awskms:key-id=arn%3Aaws%3Akms%3Aeu-west-1%3A111122223333%3Akey%2Fbe468355-ca7a-40d9-a28b-8ae1c4c7f936;region=eu-west-1",
+				"awskms:key-id=arn%3Aaws%3Akms%3Aus-west-2%3A111122223333%3Akey%2Fbe468355-ca7a-40d9-a28b-8ae1c4c7f936;region=us-west-2",
+			},
 		}, false},
 		{"fail empty", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{}}, nil, true},
+		{"fail replica regions", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			ReplicaRegions:     []string{"us-west-2"},
+		}}, nil, true},
+		{"fail idempotent multi-region", fields{nil, &MockClient{
+			getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+				resp, err := okClient.getPublicKeyWithContext(ctx, input, opts...)
+				if err != nil {
+					return nil, err
+				}
+				resp.CustomerMasterKeySpec = aws.String(kms.CustomerMasterKeySpecEccNistP256)
+				return resp, nil
+			},
+			listAliasesWithContext: okClient.listAliasesWithContext,
+			describeKeyWithContext: func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+				md := new(kms.KeyMetadata)
+				md.SetKeyId(keyID)
+				md.SetMultiRegion(false)
+				return &kms.DescribeKeyOutput{KeyMetadata: md}, nil
+			},
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Idempotent:         true,
+			MultiRegion:        true,
+		}}, nil, true},
+		{"fail replicateKey", fields{nil, &MockClient{
+			getPublicKeyWithContext: okClient.getPublicKeyWithContext,
+			createKeyWithContext:    okClient.createKeyWithContext,
+			createAliasWithContext:  okClient.createAliasWithContext,
+			describeKeyWithContext:  okClient.describeKeyWithContext,
+			replicateKeyWithContext: func(ctx aws.Context, input *kms.ReplicateKeyInput, opts ...request.Option) (*kms.ReplicateKeyOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			MultiRegion:        true,
+			ReplicaRegions:     []string{"us-west-2"},
+		}}, nil, true},
+		{"fail key policy", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			KeyPolicy:          `{"Version":`,
+		}}, nil, true},
 		{"fail unsupported alg", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
 			Name:               "root",
 			SignatureAlgorithm: apiv1.PureEd25519,
@@ -254,14 +431,16 @@ func TestKMS_CreateKey(t *testing.T) {
 
 func TestKMS_CreateSigner(t *testing.T) {
 	client := getOKClient()
+	replica := getOKClient()
 	key, err := pemutil.ParseKey([]byte(publicKey))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	type fields struct {
-		session *session.Session
-		service KeyManagementClient
+		session  *session.Session
+		service  KeyManagementClient
+		regional map[string]KeyManagementClient
 	}
 	type args struct {
 		req *apiv1.CreateSignerRequest
@@ -273,21 +452,39 @@ func TestKMS_CreateSigner(t *testing.T) {
 		want    crypto.Signer
 		wantErr bool
 	}{
-		{"ok", fields{nil, client}, args{&apiv1.CreateSignerRequest{
+		{"ok", fields{nil, client, nil}, args{&apiv1.CreateSignerRequest{
 			SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
 		}}, &Signer{
 			service:   client,
 			keyID:     "be468355-ca7a-40d9-a28b-8ae1c4c7f936",
 			publicKey: key,
 		}, false},
-		{"fail empty", fields{nil, client}, args{&apiv1.CreateSignerRequest{}}, nil, true},
-		{"fail preload", fields{nil, client}, args{&apiv1.CreateSignerRequest{}}, nil, true},
+		{"ok replica", fields{nil, client, map[string]KeyManagementClient{"us-west-2": replica}}, args{&apiv1.CreateSignerRequest{
+			SigningKey: "awskms:key-id=mrk-be468355ca7a40d9a28b8ae1c4c7f936;region=us-west-2",
+		}}, &Signer{
+			service:   replica,
+			keyID:     "mrk-be468355ca7a40d9a28b8ae1c4c7f936",
+			publicKey: key,
+		}, false},
+		{"ok replica arn", fields{nil, client, map[string]KeyManagementClient{"us-west-2": replica}}, args{&apiv1.CreateSignerRequest{
+			SigningKey: "awskms:key-id=arn:aws:kms:us-west-2:111122223333:key/mrk-be468355ca7a40d9a28b8ae1c4c7f936",
+		}}, &Signer{
+			service:   replica,
+			keyID:     "arn:aws:kms:us-west-2:111122223333:key/mrk-be468355ca7a40d9a28b8ae1c4c7f936",
+			publicKey: key,
+		}, false},
+		{"fail empty", fields{nil, client, nil}, args{&apiv1.CreateSignerRequest{}}, nil, true},
+		{"fail parse", fields{nil, client, nil}, args{&apiv1.CreateSignerRequest{
+			SigningKey: "awskms:key-id=mrk-be468355ca7a40d9a28b8ae1c4c7f936;region=%ZZ",
+		}}, nil, true},
+		{"fail preload", fields{nil, client, nil}, args{&apiv1.CreateSignerRequest{}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			k := &KMS{
-				session: tt.fields.session,
-				service: tt.fields.service,
+				session:  tt.fields.session,
+				service:  tt.fields.service,
+				regional: tt.fields.regional,
 			}
 			got, err := k.CreateSigner(tt.args.req)
 			if (err != nil) != tt.wantErr {
@@ -301,6 +498,165 @@ func TestKMS_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestKMS_KeyURI(t *testing.T) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{Region: aws.String("us-east-1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		session *session.Session
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		keyName string
+		want    string
+		wantErr bool
+	}{
+		{"ok", fields{sess}, "be468355-ca7a-40d9-a28b-8ae1c4c7f936", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936;region=us-east-1", false},
+		{"ok uri", fields{sess}, "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936;region=us-east-1", false},
+		{"ok no region", fields{nil}, "be468355-ca7a-40d9-a28b-8ae1c4c7f936", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"ok alias", fields{sess}, "alias/step-root", "awskms:key-id=alias%2Fstep-root;region=us-east-1", false},
+		{"ok replica", fields{sess}, "awskms:key-id=mrk-be468355ca7a40d9a28b8ae1c4c7f936;region=us-west-2", "awskms:key-id=mrk-be468355ca7a40d9a28b8ae1c4c7f936;region=us-west-2", false},
+		{"ok replica arn", fields{sess}, "arn:aws:kms:us-west-2:111122223333:key/mrk-be468355ca7a40d9a28b8ae1c4c7f936", "awskms:key-id=arn%3Aaws%3Akms%3Aus-west-2%3A111122223333%3Akey%2Fmrk-be468355ca7a40d9a28b8ae1c4c7f936;region=us-west-2", false},
+		{"fail empty", fields{sess}, "", "", true},
+		{"fail parse", fields{sess}, "awskms:key-id=%ZZ", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				session: tt.fields.session,
+			}
+			got, err := k.KeyURI(tt.keyName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.KeyURI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("KMS.KeyURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_CheckHealth(t *testing.T) {
+	failClient := func(err error) *MockClient {
+		return &MockClient{
+			listKeysWithContext: func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+				return nil, err
+			},
+		}
+	}
+
+	type fields struct {
+		service KeyManagementClient
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		wantErr bool
+	}{
+		{"ok", fields{getOKClient()}, false},
+		{"fail access denied", fields{failClient(awserr.New("AccessDeniedException", "not authorized", nil))}, true},
+		{"fail credentials", fields{failClient(awserr.New("UnrecognizedClientException", "invalid token", nil))}, true},
+		{"fail unreachable", fields{failClient(fmt.Errorf("an error"))}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				service: tt.fields.service,
+			}
+			if err := k.CheckHealth(&apiv1.CheckHealthRequest{}); (err != nil) != tt.wantErr {
+				t.Errorf("KMS.CheckHealth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKMS_ListKeys(t *testing.T) {
+	pagedClient := &MockClient{
+		listAliasesWithContext: func(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+			if input.Marker == nil {
+				return &kms.ListAliasesOutput{
+					Aliases: []*kms.AliasListEntry{
+						{AliasName: aws.String("alias/root-" + keyID[:8]), TargetKeyId: aws.String(keyID)},
+						{AliasName: aws.String("alias/foo"), TargetKeyId: aws.String(keyID)},
+					},
+					NextMarker: aws.String("page-2"),
+					Truncated:  aws.Bool(true),
+				}, nil
+			}
+			return &kms.ListAliasesOutput{
+				Aliases: []*kms.AliasListEntry{
+					{AliasName: aws.String("alias/intermediate-" + keyID[:8]), TargetKeyId: aws.String(keyID)},
+				},
+			}, nil
+		},
+	}
+	failClient := &MockClient{
+		listAliasesWithContext: func(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	keyURI := "awskms:key-id=" + keyID
+	type fields struct {
+		service KeyManagementClient
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		want    *apiv1.ListKeysResponse
+		wantErr bool
+	}{
+		{"ok", fields{getOKClient()}, &apiv1.ListKeysResponse{
+			Keys: []apiv1.KeyInfo{{Name: "root", Key: keyURI}},
+		}, false},
+		{"ok paginated", fields{pagedClient}, &apiv1.ListKeysResponse{
+			Keys: []apiv1.KeyInfo{{Name: "root", Key: keyURI}, {Name: "intermediate", Key: keyURI}},
+		}, false},
+		{"fail", fields{failClient}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				service: tt.fields.service,
+			}
+			got, err := k.ListKeys(&apiv1.ListKeysRequest{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.ListKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_Capabilities(t *testing.T) {
+	want := &apiv1.Capabilities{
+		SignatureAlgorithms: []apiv1.SignatureAlgorithm{
+			apiv1.SHA256WithRSA, apiv1.SHA512WithRSA, apiv1.SHA256WithRSAPSS, apiv1.SHA512WithRSAPSS,
+			apiv1.ECDSAWithSHA256, apiv1.ECDSAWithSHA384, apiv1.ECDSAWithSHA512,
+		},
+		Bits: map[apiv1.SignatureAlgorithm][]int{
+			apiv1.SHA256WithRSA:    {2048, 3072, 4096},
+			apiv1.SHA512WithRSA:    {4096},
+			apiv1.SHA256WithRSAPSS: {2048, 3072, 4096},
+			apiv1.SHA512WithRSAPSS: {4096},
+		},
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.HSM},
+	}
+	k := &KMS{}
+	if got := k.Capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("KMS.Capabilities() = %v, want %v", got, want)
+	}
+}
+
 func TestKMS_Close(t *testing.T) {
 	type fields struct {
 		session *session.Session
@@ -339,6 +695,10 @@ func Test_parseKeyID(t *testing.T) {
 		{"ok uri", args{"awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936"}, "be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
 		{"ok key id", args{"be468355-ca7a-40d9-a28b-8ae1c4c7f936"}, "be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
 		{"ok arn", args{"arn:aws:kms:us-east-1:123456789:key/be468355-ca7a-40d9-a28b-8ae1c4c7f936"}, "arn:aws:kms:us-east-1:123456789:key/be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"ok uppercase", args{"AWSKMS:key-id=BE468355-CA7A-40D9-A28B-8AE1C4C7F936"}, "be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"ok alias", args{"alias/Step-Root"}, "alias/Step-Root", false},
+		{"ok alias uri", args{"awskms:key-id=alias%2FStep-Root"}, "alias/Step-Root", false},
+		{"ok alias arn", args{"arn:aws:kms:us-east-1:123456789:alias/Step-Root"}, "arn:aws:kms:us-east-1:123456789:alias/Step-Root", false},
 		{"fail parse", args{"awskms:key-id=%ZZ"}, "", true},
 		{"fail empty key", args{"awskms:key-id="}, "", true},
 		{"fail missing", args{"awskms:foo=bar"}, "", true},
@@ -356,3 +716,59 @@ func Test_parseKeyID(t *testing.T) {
 		})
 	}
 }
+
+func Test_keyRegion(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyName string
+		want    string
+		wantErr bool
+	}{
+		{"uri", "awskms:key-id=mrk-be468355ca7a40d9a28b8ae1c4c7f936;region=us-west-2", "us-west-2", false},
+		{"uri arn", "awskms:key-id=arn%3Aaws%3Akms%3Aus-west-2%3A111122223333%3Akey%2Fmrk-be468355ca7a40d9a28b8ae1c4c7f936", "us-west-2", false},
+		{"arn", "arn:aws:kms:eu-west-1:111122223333:key/mrk-be468355ca7a40d9a28b8ae1c4c7f936", "eu-west-1", false},
+		{"alias arn", "arn:aws:kms:eu-west-1:111122223333:alias/step-root", "eu-west-1", false},
+		{"key id", "be468355-ca7a-40d9-a28b-8ae1c4c7f936", "", false},
+		{"uri key id", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936", "", false},
+		{"alias", "alias/step-root", "", false},
+		{"fail parse", "awskms:key-id=%ZZ", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyRegion(tt.keyName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("keyRegion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("keyRegion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_wrapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want interface{}
+	}{
+		{"access denied", awserr.New("AccessDeniedException", "not authorized", nil), apiv1.ErrPermissionDenied{}},
+		{"credentials", awserr.New("UnrecognizedClientException", "invalid token", nil), apiv1.ErrPermissionDenied{}},
+		{"already exists", awserr.New("AlreadyExistsException", "alias exists", nil), apiv1.ErrAlreadyExists{}},
+		{"not found", awserr.New("NotFoundException", "key not found", nil), apiv1.ErrNotFound{}},
+		{"other", awserr.New("KMSInternalException", "internal error", nil), awserr.New("KMSInternalException", "internal error", nil)},
+		{"not aws", fmt.Errorf("an error"), fmt.Errorf("an error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapError(tt.err, "awskms Test failed")
+			if got, want := fmt.Sprintf("%T", errors.Cause(err)), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("wrapError() cause = %v, want %v", got, want)
+			}
+			if !strings.HasPrefix(err.Error(), "awskms Test failed: ") {
+				t.Errorf("wrapError() error = %v, want prefix 'awskms Test failed: '", err)
+			}
+		})
+	}
+}
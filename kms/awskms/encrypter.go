@@ -0,0 +1,98 @@
+package awskms
+
+import (
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// encryptionContextKey is the key of the encryption context used to bind the
+// additional data of a request to the ciphertext.
+const encryptionContextKey = "additional-data"
+
+// Encrypt implements apiv1.Encrypter and encrypts the plaintext with a
+// symmetric key. The key name can be a key id, a key ARN, an alias or an
+// awskms URI. The additional data is sent base64 encoded in the encryption
+// context. The key is used in the region of the key name, if it has one, see
+// CreateSigner.
+func (k *KMS) Encrypt(req *apiv1.EncryptRequest) (*apiv1.EncryptResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("encryptRequest 'name' cannot be empty")
+	}
+	if len(req.Plaintext) == 0 {
+		return nil, errors.New("encryptRequest 'plaintext' cannot be empty")
+	}
+	keyID, err := parseKeyID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	client, err := k.keyClient(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:             &keyID,
+		Plaintext:         req.Plaintext,
+		EncryptionContext: encryptionContext(req.AdditionalData),
+	})
+	if err != nil {
+		return nil, wrapError(err, "awskms EncryptWithContext failed")
+	}
+
+	return &apiv1.EncryptResponse{
+		Name:       aws.StringValue(resp.KeyId),
+		Ciphertext: resp.CiphertextBlob,
+	}, nil
+}
+
+// Decrypt implements apiv1.Decrypter and decrypts a ciphertext encrypted with
+// Encrypt. AWS KMS verifies that the ciphertext was encrypted with the key in
+// the request. Like Encrypt, the key is used in the region of the key name.
+func (k *KMS) Decrypt(req *apiv1.DecryptRequest) (*apiv1.DecryptResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	}
+	if len(req.Ciphertext) == 0 {
+		return nil, errors.New("decryptRequest 'ciphertext' cannot be empty")
+	}
+	keyID, err := parseKeyID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	client, err := k.keyClient(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:             &keyID,
+		CiphertextBlob:    req.Ciphertext,
+		EncryptionContext: encryptionContext(req.AdditionalData),
+	})
+	if err != nil {
+		return nil, wrapError(err, "awskms DecryptWithContext failed")
+	}
+
+	return &apiv1.DecryptResponse{
+		Plaintext: resp.Plaintext,
+	}, nil
+}
+
+func encryptionContext(data []byte) map[string]*string {
+	if len(data) == 0 {
+		return nil
+	}
+	return map[string]*string{
+		encryptionContextKey: aws.String(base64.StdEncoding.EncodeToString(data)),
+	}
+}
@@ -0,0 +1,139 @@
+package awskms
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestKMS_Encrypt(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-1:123456789:key/" + keyID
+	okClient := &MockClient{
+		encryptWithContext: func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+			if aws.StringValue(input.KeyId) != keyID {
+				return nil, fmt.Errorf("unexpected key id %s", aws.StringValue(input.KeyId))
+			}
+			ciphertext := []byte("ciphertext:")
+			if v, ok := input.EncryptionContext[encryptionContextKey]; ok {
+				ciphertext = append(ciphertext, aws.StringValue(v)+":"...)
+			}
+			return &kms.EncryptOutput{
+				KeyId:          aws.String(keyARN),
+				CiphertextBlob: append(ciphertext, input.Plaintext...),
+			}, nil
+		},
+	}
+	failClient := &MockClient{
+		encryptWithContext: func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	type fields struct {
+		service  KeyManagementClient
+		regional map[string]KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.EncryptResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient, nil}, args{&apiv1.EncryptRequest{Name: keyID, Plaintext: []byte("secret")}}, &apiv1.EncryptResponse{Name: keyARN, Ciphertext: []byte("ciphertext:secret")}, false},
+		{"ok with uri and additional data", fields{okClient, nil}, args{&apiv1.EncryptRequest{Name: "awskms:key-id=" + keyID, Plaintext: []byte("secret"), AdditionalData: []byte("aad")}}, &apiv1.EncryptResponse{Name: keyARN, Ciphertext: []byte("ciphertext:YWFk:secret")}, false},
+		{"ok replica", fields{failClient, map[string]KeyManagementClient{"us-west-2": okClient}}, args{&apiv1.EncryptRequest{Name: "awskms:key-id=" + keyID + ";region=us-west-2", Plaintext: []byte("secret")}}, &apiv1.EncryptResponse{Name: keyARN, Ciphertext: []byte("ciphertext:secret")}, false},
+		{"fail empty name", fields{okClient, nil}, args{&apiv1.EncryptRequest{Plaintext: []byte("secret")}}, nil, true},
+		{"fail empty plaintext", fields{okClient, nil}, args{&apiv1.EncryptRequest{Name: keyID}}, nil, true},
+		{"fail parse", fields{okClient, nil}, args{&apiv1.EncryptRequest{Name: "awskms:key-id=", Plaintext: []byte("secret")}}, nil, true},
+		{"fail parse region", fields{okClient, nil}, args{&apiv1.EncryptRequest{Name: "awskms:key-id=" + keyID + ";region=%ZZ", Plaintext: []byte("secret")}}, nil, true},
+		{"fail encrypt", fields{failClient, nil}, args{&apiv1.EncryptRequest{Name: keyID, Plaintext: []byte("secret")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				service:  tt.fields.service,
+				regional: tt.fields.regional,
+			}
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.Encrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_Decrypt(t *testing.T) {
+	okClient := &MockClient{
+		decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+			if aws.StringValue(input.KeyId) != keyID {
+				return nil, fmt.Errorf("unexpected key id %s", aws.StringValue(input.KeyId))
+			}
+			if v := input.EncryptionContext[encryptionContextKey]; aws.StringValue(v) != "YWFk" {
+				return nil, fmt.Errorf("unexpected encryption context %v", input.EncryptionContext)
+			}
+			return &kms.DecryptOutput{
+				KeyId:     aws.String(keyID),
+				Plaintext: []byte("secret"),
+			}, nil
+		},
+	}
+	failClient := &MockClient{
+		decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	type fields struct {
+		service  KeyManagementClient
+		regional map[string]KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.DecryptResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient, nil}, args{&apiv1.DecryptRequest{Name: keyID, Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, &apiv1.DecryptResponse{Plaintext: []byte("secret")}, false},
+		{"ok with uri", fields{okClient, nil}, args{&apiv1.DecryptRequest{Name: "awskms:key-id=" + keyID, Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, &apiv1.DecryptResponse{Plaintext: []byte("secret")}, false},
+		{"ok replica", fields{failClient, map[string]KeyManagementClient{"us-west-2": okClient}}, args{&apiv1.DecryptRequest{Name: "awskms:key-id=" + keyID + ";region=us-west-2", Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, &apiv1.DecryptResponse{Plaintext: []byte("secret")}, false},
+		{"fail empty name", fields{okClient, nil}, args{&apiv1.DecryptRequest{Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail empty ciphertext", fields{okClient, nil}, args{&apiv1.DecryptRequest{Name: keyID}}, nil, true},
+		{"fail parse", fields{okClient, nil}, args{&apiv1.DecryptRequest{Name: "awskms:key-id=", Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail parse region", fields{okClient, nil}, args{&apiv1.DecryptRequest{Name: "awskms:key-id=" + keyID + ";region=%ZZ", Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, nil, true},
+		{"fail additional data", fields{okClient, nil}, args{&apiv1.DecryptRequest{Name: keyID, Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail decrypt", fields{failClient, nil}, args{&apiv1.DecryptRequest{Name: keyID, Ciphertext: []byte("ciphertext")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				service:  tt.fields.service,
+				regional: tt.fields.regional,
+			}
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
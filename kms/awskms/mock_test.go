@@ -13,6 +13,12 @@ type MockClient struct {
 	createKeyWithContext    func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error)
 	createAliasWithContext  func(ctx aws.Context, input *kms.CreateAliasInput, opts ...request.Option) (*kms.CreateAliasOutput, error)
 	signWithContext         func(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error)
+	listKeysWithContext     func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error)
+	listAliasesWithContext  func(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error)
+	encryptWithContext      func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	decryptWithContext      func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+	describeKeyWithContext  func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error)
+	replicateKeyWithContext func(ctx aws.Context, input *kms.ReplicateKeyInput, opts ...request.Option) (*kms.ReplicateKeyOutput, error)
 }
 
 func (m *MockClient) GetPublicKeyWithContext(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
@@ -31,6 +37,30 @@ func (m *MockClient) SignWithContext(ctx aws.Context, input *kms.SignInput, opts
 	return m.signWithContext(ctx, input, opts...)
 }
 
+func (m *MockClient) ListKeysWithContext(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+	return m.listKeysWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) ListAliasesWithContext(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+	return m.listAliasesWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	return m.encryptWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	return m.decryptWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+	return m.describeKeyWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) ReplicateKeyWithContext(ctx aws.Context, input *kms.ReplicateKeyInput, opts ...request.Option) (*kms.ReplicateKeyOutput, error) {
+	return m.replicateKeyWithContext(ctx, input, opts...)
+}
+
 const (
 	publicKey = `-----BEGIN PUBLIC KEY-----
 MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE8XWlIWkOThxNjGbZLYUgRHmsvCrW
@@ -68,5 +98,33 @@ func getOKClient() *MockClient {
 				Signature: signature,
 			}, nil
 		},
+		listKeysWithContext: func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+			return &kms.ListKeysOutput{
+				Keys: []*kms.KeyListEntry{{KeyId: aws.String(keyID)}},
+			}, nil
+		},
+		listAliasesWithContext: func(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+			return &kms.ListAliasesOutput{
+				Aliases: []*kms.AliasListEntry{
+					{AliasName: aws.String("alias/aws/acm")},
+					{AliasName: aws.String("alias/root-" + keyID[:8]), TargetKeyId: aws.String(keyID)},
+				},
+			}, nil
+		},
+		describeKeyWithContext: func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+			md := new(kms.KeyMetadata)
+			md.SetKeyId(aws.StringValue(input.KeyId))
+			md.SetMultiRegion(true)
+			return &kms.DescribeKeyOutput{
+				KeyMetadata: md,
+			}, nil
+		},
+		replicateKeyWithContext: func(ctx aws.Context, input *kms.ReplicateKeyInput, opts ...request.Option) (*kms.ReplicateKeyOutput, error) {
+			md := new(kms.KeyMetadata)
+			md.SetArn("arn:aws:kms:" + aws.StringValue(input.ReplicaRegion) + ":111122223333:key/" + aws.StringValue(input.KeyId))
+			return &kms.ReplicateKeyOutput{
+				ReplicaKeyMetadata: md,
+			}, nil
+		},
 	}
 }
@@ -18,7 +18,10 @@ type Signer struct {
 	publicKey crypto.PublicKey
 }
 
-// NewSigner creates a new signer using a key in the AWS KMS.
+// NewSigner creates a new signer using a key in the AWS KMS. The signing key
+// can be an alias, the alias is resolved by AWS KMS every time a digest is
+// signed. The public key is loaded when the signer is created, so a signer must
+// be created again after re-pointing the alias.
 func NewSigner(svc KeyManagementClient, signingKey string) (*Signer, error) {
 	keyID, err := parseKeyID(signingKey)
 	if err != nil {
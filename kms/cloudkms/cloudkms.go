@@ -3,22 +3,32 @@ package cloudkms
 import (
 	"context"
 	"crypto"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	cloudkms "cloud.google.com/go/kms/apiv1"
+	"github.com/golang/protobuf/ptypes"
 	gax "github.com/googleapis/gax-go/v2"
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
 	"github.com/smallstep/cli/crypto/pemutil"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
 )
 
+// Scheme is the scheme used in the Cloud KMS URIs.
+const Scheme = "cloudkms"
+
 const pendingGenerationRetries = 10
 
 // protectionLevelMapping maps step protection levels with cloud kms ones.
@@ -69,27 +79,54 @@ type KeyManagementClient interface {
 	GetKeyRing(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateKeyRing(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	ListKeyRings(context.Context, *kmspb.ListKeyRingsRequest, ...gax.CallOption) *cloudkms.KeyRingIterator
+	ListCryptoKeys(context.Context, *kmspb.ListCryptoKeysRequest, ...gax.CallOption) *cloudkms.CryptoKeyIterator
+	ListCryptoKeyVersions(context.Context, *kmspb.ListCryptoKeyVersionsRequest, ...gax.CallOption) *cloudkms.CryptoKeyVersionIterator
+	DestroyCryptoKeyVersion(context.Context, *kmspb.DestroyCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	Encrypt(context.Context, *kmspb.EncryptRequest, ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(context.Context, *kmspb.DecryptRequest, ...gax.CallOption) (*kmspb.DecryptResponse, error)
+}
+
+// LocationsClient is the interface used to get the metadata of the Cloud KMS
+// locations, it is implemented by the gRPC client of the locations service.
+type LocationsClient interface {
+	GetLocation(ctx context.Context, in *locationpb.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error)
 }
 
 // CloudKMS implements a KMS using Google's Cloud apiv1.
 type CloudKMS struct {
-	client KeyManagementClient
+	client    KeyManagementClient
+	locations LocationsClient
+	signers   signerCache
 }
 
-// New creates a new CloudKMS configured with a new client.
+// New creates a new CloudKMS configured with a new client. The client uses the
+// credentials in the Secret Manager secret CredentialsSecret, the credentials
+// file CredentialsFile, or the default credentials.
 func New(ctx context.Context, opts apiv1.Options) (*CloudKMS, error) {
 	var cloudOpts []option.ClientOption
 	if opts.CredentialsFile != "" {
 		cloudOpts = append(cloudOpts, option.WithCredentialsFile(opts.CredentialsFile))
 	}
 
+	// The credentials in Secret Manager are read with the credentials file or
+	// the default credentials, and they replace them in the Cloud KMS client.
+	if opts.CredentialsSecret != "" {
+		b, err := readCredentialsSecret(ctx, opts.CredentialsSecret, cloudOpts...)
+		if err != nil {
+			return nil, err
+		}
+		cloudOpts = []option.ClientOption{option.WithCredentialsJSON(b)}
+	}
+
 	client, err := cloudkms.NewKeyManagementClient(ctx, cloudOpts...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &CloudKMS{
-		client: client,
+		client:    client,
+		locations: locationpb.NewLocationsClient(client.Connection()),
 	}, nil
 }
 
@@ -106,6 +143,33 @@ func NewCloudKMS(client KeyManagementClient) *CloudKMS {
 	}
 }
 
+// Capabilities implements apiv1.CapabilitiesReporter and returns the signature
+// algorithms, RSA key sizes and protection levels supported by Cloud KMS.
+func (k *CloudKMS) Capabilities() *apiv1.Capabilities {
+	caps := &apiv1.Capabilities{
+		Bits:             make(map[apiv1.SignatureAlgorithm][]int),
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.Software, apiv1.HSM},
+	}
+	for alg, v := range signatureAlgorithmMapping {
+		if alg == apiv1.UnspecifiedSignAlgorithm {
+			continue
+		}
+		caps.SignatureAlgorithms = append(caps.SignatureAlgorithms, alg)
+		if m, ok := v.(map[int]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm); ok {
+			for bits := range m {
+				if bits > 0 {
+					caps.Bits[alg] = append(caps.Bits[alg], bits)
+				}
+			}
+			sort.Ints(caps.Bits[alg])
+		}
+	}
+	sort.Slice(caps.SignatureAlgorithms, func(i, j int) bool {
+		return caps.SignatureAlgorithms[i] < caps.SignatureAlgorithms[j]
+	})
+	return caps
+}
+
 // Close closes the connection of the Cloud KMS client.
 func (k *CloudKMS) Close() error {
 	if err := k.client.Close(); err != nil {
@@ -114,14 +178,28 @@ func (k *CloudKMS) Close() error {
 	return nil
 }
 
-// CreateSigner returns a new cloudkms signer configured with the given signing
-// key name.
+// CreateSigner returns a cloudkms signer configured with the given signing key
+// name. If the request has a key version, the signer will use exactly that
+// version of the key. The public key is fetched when the signer is created.
+// Signers are cached by key name, so repeated calls for the same key return
+// the same signer, until the key is rotated or destroyed.
 func (k *CloudKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	if req.SigningKey == "" {
 		return nil, errors.New("signing key cannot be empty")
 	}
 
-	return NewSigner(k.client, req.SigningKey), nil
+	signingKey, err := keyVersionName(resourceName(req.SigningKey), req.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := k.signers.GetOrCreate(signingKey, func() (*Signer, error) {
+		return NewSigner(k.client, signingKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
 }
 
 // CreateKey creates in Google's Cloud KMS a new asymmetric key for signing.
@@ -129,6 +207,9 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 	if req.Name == "" {
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
 	}
+	if err := apiv1.CheckFIPSAlgorithm(req.SignatureAlgorithm, req.Bits); err != nil {
+		return nil, err
+	}
 
 	protectionLevel, ok := protectionLevelMapping[req.ProtectionLevel]
 	if !ok {
@@ -138,14 +219,14 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 	var signatureAlgorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
 	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
 	if !ok {
-		return nil, errors.Errorf("cloudKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+		return nil, apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("cloudKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)}
 	}
 	switch v := v.(type) {
 	case kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm:
 		signatureAlgorithm = v
 	case map[int]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm:
 		if signatureAlgorithm, ok = v[req.Bits]; !ok {
-			return nil, errors.Errorf("cloudKMS does not support signature algorithm '%s' with '%d' bits", req.SignatureAlgorithm, req.Bits)
+			return nil, apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("cloudKMS does not support signature algorithm '%s' with '%d' bits", req.SignatureAlgorithm, req.Bits)}
 		}
 	default:
 		return nil, errors.Errorf("unexpected error: this should not happen")
@@ -155,7 +236,8 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 
 	// Split `projects/PROJECT_ID/locations/global/keyRings/RING_ID/cryptoKeys/KEY_ID`
 	// to `projects/PROJECT_ID/locations/global/keyRings/RING_ID` and `KEY_ID`.
-	keyRing, keyID := Parent(req.Name)
+	name := resourceName(req.Name)
+	keyRing, keyID := Parent(name)
 	if err := k.createKeyRingIfNeeded(keyRing); err != nil {
 		return nil, err
 	}
@@ -175,32 +257,43 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 			},
 		},
 	})
+	var reused bool
 	if err != nil {
 		if status.Code(err) != codes.AlreadyExists {
-			return nil, errors.Wrap(err, "cloudKMS CreateCryptoKey failed")
+			return nil, wrapError(err, "cloudKMS CreateCryptoKey failed")
 		}
-		// Create a new version if the key already exists.
-		//
-		// Note that it will have the same purpose, protection level and
-		// algorithm than as previous one.
-		req := &kmspb.CreateCryptoKeyVersionRequest{
-			Parent: req.Name,
-			CryptoKeyVersion: &kmspb.CryptoKeyVersion{
-				State: kmspb.CryptoKeyVersion_ENABLED,
-			},
-		}
-		response, err := k.client.CreateCryptoKeyVersion(ctx, req)
-		if err != nil {
-			return nil, errors.Wrap(err, "cloudKMS CreateCryptoKeyVersion failed")
+		if req.Idempotent {
+			// Reuse the newest enabled version of the existing key with the
+			// requested algorithm.
+			if crytoKeyName, err = k.lastKeyVersion(name, signatureAlgorithm); err != nil {
+				return nil, err
+			}
+			reused = true
+		} else {
+			// Create a new version if the key already exists.
+			//
+			// Note that it will have the same purpose, protection level and
+			// algorithm than as previous one.
+			req := &kmspb.CreateCryptoKeyVersionRequest{
+				Parent: name,
+				CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+					State: kmspb.CryptoKeyVersion_ENABLED,
+				},
+			}
+			response, err := k.client.CreateCryptoKeyVersion(ctx, req)
+			if err != nil {
+				return nil, wrapError(err, "cloudKMS CreateCryptoKeyVersion failed")
+			}
+			k.signers.Invalidate(name)
+			crytoKeyName = response.Name
 		}
-		crytoKeyName = response.Name
 	} else {
 		crytoKeyName = response.Name + "/cryptoKeyVersions/1"
 	}
 
 	// Sleep deterministically to avoid retries because of PENDING_GENERATING.
 	// One second is often enough.
-	if protectionLevel == kmspb.ProtectionLevel_HSM {
+	if protectionLevel == kmspb.ProtectionLevel_HSM && !reused {
 		time.Sleep(1 * time.Second)
 	}
 
@@ -209,7 +302,12 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 		Name: crytoKeyName,
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "cloudKMS GetPublicKey failed")
+		return nil, wrapError(err, "cloudKMS GetPublicKey failed")
+	}
+
+	keyURI, err := k.KeyURI(crytoKeyName)
+	if err != nil {
+		return nil, err
 	}
 
 	return &apiv1.CreateKeyResponse{
@@ -217,10 +315,101 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 		PublicKey: pk,
 		CreateSignerRequest: apiv1.CreateSignerRequest{
 			SigningKey: crytoKeyName,
+			KeyVersion: KeyVersion(crytoKeyName),
 		},
+		KeyURI: keyURI,
 	}, nil
 }
 
+// RotateKey creates a new version of the given crypto key and returns it, the
+// new version has the same purpose, protection level and algorithm than the
+// previous ones. Cloud KMS asymmetric keys do not have a primary version, so
+// the new version must be used explicitly, e.g. with the key URI in the
+// response. If the name includes a version, the version is ignored. Key names
+// follow the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) RotateKey(req *apiv1.RotateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	name := resourceName(req.Name)
+	if name == "" {
+		return nil, errors.New("rotateKeyRequest 'name' cannot be empty")
+	}
+	if i := strings.Index(name, "/cryptoKeyVersions/"); i > 0 {
+		name = name[:i]
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := k.client.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+		Parent: name,
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+			State: kmspb.CryptoKeyVersion_ENABLED,
+		},
+	})
+	if err != nil {
+		return nil, wrapError(err, "cloudKMS CreateCryptoKeyVersion failed")
+	}
+	k.signers.Invalidate(name)
+
+	// The new version can be pending generation, GetPublicKey retries until it
+	// is available.
+	pk, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: response.Name,
+	})
+	if err != nil {
+		return nil, wrapError(err, "cloudKMS GetPublicKey failed")
+	}
+
+	keyURI, err := k.KeyURI(response.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      response.Name,
+		PublicKey: pk,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: response.Name,
+			KeyVersion: KeyVersion(response.Name),
+		},
+		KeyURI: keyURI,
+	}, nil
+}
+
+// CreateKeyRing creates the given key ring if it does not exist yet. Key ring
+// names follow the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})
+//
+// Locations are defined by Google Cloud and cannot be created.
+func (k *CloudKMS) CreateKeyRing(name string) error {
+	if name == "" {
+		return errors.New("createKeyRing 'name' cannot be empty")
+	}
+	return k.createKeyRingIfNeeded(name)
+}
+
+// DestroyKey schedules the destruction of the given key version. Cloud KMS
+// does not allow to delete crypto keys, but the material of a destroyed version
+// cannot be used to sign anymore. Key versions follow the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})/cryptoKeyVersions/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) DestroyKey(name string) error {
+	if name == "" {
+		return errors.New("destroyKey 'name' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	name = resourceName(name)
+	if _, err := k.client.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{
+		Name: name,
+	}); err != nil {
+		return wrapError(err, "cloudKMS DestroyCryptoKeyVersion failed")
+	}
+	k.signers.Invalidate(name)
+	return nil
+}
+
 func (k *CloudKMS) createKeyRingIfNeeded(name string) error {
 	ctx, cancel := defaultContext()
 	defer cancel()
@@ -238,12 +427,117 @@ func (k *CloudKMS) createKeyRingIfNeeded(name string) error {
 		KeyRingId: child,
 	})
 	if err != nil && status.Code(err) != codes.AlreadyExists {
-		return errors.Wrap(err, "cloudKMS CreateKeyRing failed")
+		return wrapError(err, "cloudKMS CreateKeyRing failed")
 	}
 
 	return nil
 }
 
+// CheckProtectionLevel returns an error if the given location does not support
+// the given protection level. Software keys are available in all locations,
+// and the HSM availability is read from the location metadata. If the CloudKMS
+// was created without a locations client the check is skipped. The location
+// follows the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) CheckProtectionLevel(location string, level apiv1.ProtectionLevel) error {
+	if location == "" {
+		return errors.New("checkProtectionLevel 'location' cannot be empty")
+	}
+	if level != apiv1.HSM || k.locations == nil {
+		return nil
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	loc, err := k.locations.GetLocation(ctx, &locationpb.GetLocationRequest{
+		Name: location,
+	})
+	if err != nil {
+		return wrapError(err, "cloudKMS GetLocation failed")
+	}
+
+	var md kmspb.LocationMetadata
+	if loc.Metadata != nil {
+		if err := ptypes.UnmarshalAny(loc.Metadata, &md); err != nil {
+			return errors.Wrap(err, "cloudKMS GetLocation failed: error parsing location metadata")
+		}
+	}
+	if !md.HsmAvailable {
+		id := location[strings.LastIndex(location, "/")+1:]
+		return errors.Errorf("location %s does not support HSM protection level; use a regional location", id)
+	}
+
+	return nil
+}
+
+// ListKeyRings returns the names of the key rings available in the given
+// location. The location follows the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) ListKeyRings(location string) ([]string, error) {
+	if location == "" {
+		return nil, errors.New("listKeyRings 'location' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var names []string
+	it := k.client.ListKeyRings(ctx, &kmspb.ListKeyRingsRequest{
+		Parent: location,
+	})
+	for {
+		ring, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, wrapError(err, "cloudKMS ListKeyRings failed")
+		}
+		names = append(names, ring.Name)
+	}
+
+	return names, nil
+}
+
+// ListKeys returns the crypto keys available in the key ring defined by the
+// request parent, the iterator requests all the pages of keys. If the key ring
+// does not exist it returns an empty list. The key ring follows the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	if req.Parent == "" {
+		return nil, errors.New("listKeysRequest 'parent' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var keys []apiv1.KeyInfo
+	it := k.client.ListCryptoKeys(ctx, &kmspb.ListCryptoKeysRequest{
+		Parent: resourceName(req.Parent),
+	})
+	for {
+		key, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return &apiv1.ListKeysResponse{}, nil
+			}
+			return nil, wrapError(err, "cloudKMS ListCryptoKeys failed")
+		}
+		keys = append(keys, apiv1.KeyInfo{
+			Name: key.Name,
+			Key:  key.Name,
+		})
+	}
+
+	return &apiv1.ListKeysResponse{
+		Keys: keys,
+	}, nil
+}
+
 // GetPublicKey gets from Google's Cloud KMS a public key by name. Key names
 // follow the pattern:
 //   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})/cryptoKeyVersions/([a-zA-Z0-9_-]{1,63})
@@ -252,9 +546,9 @@ func (k *CloudKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKe
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
 	}
 
-	response, err := k.getPublicKeyWithRetries(req.Name, pendingGenerationRetries)
+	response, err := k.getPublicKeyWithRetries(resourceName(req.Name), pendingGenerationRetries)
 	if err != nil {
-		return nil, errors.Wrap(err, "cloudKMS GetPublicKey failed")
+		return nil, wrapError(err, "cloudKMS GetPublicKey failed")
 	}
 
 	pk, err := pemutil.ParseKey([]byte(response.Pem))
@@ -265,6 +559,92 @@ func (k *CloudKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKe
 	return pk, nil
 }
 
+// KeyURI returns the given key name as a URI with the cloudkms scheme, e.g.
+//   cloudkms:projects/PROJECT_ID/locations/global/keyRings/RING_ID/cryptoKeys/KEY_ID/cryptoKeyVersions/1
+// The URI can be used as a key name in this KMS.
+func (k *CloudKMS) KeyURI(name string) (string, error) {
+	name = resourceName(name)
+	if name == "" {
+		return "", errors.New("key name cannot be empty")
+	}
+	return Scheme + ":" + name, nil
+}
+
+// CheckHealth verifies that Cloud KMS is reachable and that the configured
+// credentials can access the key ring in the request name, it does not create
+// or modify any resource. Key ring names follow the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) CheckHealth(req *apiv1.CheckHealthRequest) error {
+	name := resourceName(req.Name)
+	if name == "" {
+		return errors.New("checkHealthRequest 'name' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	_, err := k.client.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{
+		Name: name,
+	})
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.PermissionDenied:
+		return wrapError(err, "cloudKMS health check failed: insufficient permissions, cloudkms.keyRings.get is required")
+	case codes.Unauthenticated:
+		return wrapError(err, "cloudKMS health check failed: invalid or missing credentials")
+	case codes.NotFound:
+		return wrapError(err, fmt.Sprintf("cloudKMS health check failed: key ring %s does not exist", name))
+	default:
+		return errors.Wrap(err, "cloudKMS health check failed: Cloud KMS is not reachable")
+	}
+}
+
+// lastKeyVersion returns the name of the newest enabled version of the given
+// crypto key with the given algorithm. It returns an ErrAlreadyExists error if
+// the crypto key does not have an enabled version with that algorithm.
+func (k *CloudKMS) lastKeyVersion(name string, alg kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (string, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var (
+		last        string
+		lastVersion int
+	)
+	it := k.client.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+		Parent: name,
+	})
+	for {
+		v, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return "", wrapError(err, "cloudKMS ListCryptoKeyVersions failed")
+		}
+		if v.State != kmspb.CryptoKeyVersion_ENABLED {
+			continue
+		}
+		if alg != kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED && v.Algorithm != alg {
+			continue
+		}
+		n, err := strconv.Atoi(KeyVersion(v.Name))
+		if err != nil {
+			continue
+		}
+		if n > lastVersion {
+			last, lastVersion = v.Name, n
+		}
+	}
+
+	if last == "" {
+		return "", apiv1.ErrAlreadyExists{
+			Message: fmt.Sprintf("cloudKMS key %s already exists without an enabled version with algorithm %s", name, alg),
+		}
+	}
+	return last, nil
+}
+
 // getPublicKeyWithRetries retries the request if the error is
 // FailedPrecondition, caused because the key is in the PENDING_GENERATION
 // status.
@@ -301,6 +681,61 @@ func Parent(name string) (string, string) {
 	return a, b
 }
 
+// KeyVersion returns the version of the given crypto key version name, or an
+// empty string if the name does not have a version. Crypto key version names
+// follow the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})/cryptoKeyVersions/([a-zA-Z0-9_-]{1,63})
+func KeyVersion(name string) string {
+	a, b := parent(resourceName(name))
+	if _, c := parent(a); c != "cryptoKeyVersions" {
+		return ""
+	}
+	return b
+}
+
+// keyVersionName returns the crypto key version name of the given key with the
+// given version. If the version is empty the name is returned as is, and it
+// fails if the name already has a different version.
+func keyVersionName(name, version string) (string, error) {
+	if version == "" {
+		return name, nil
+	}
+	if _, err := strconv.ParseUint(version, 10, 64); err != nil || version == "0" {
+		return "", errors.Errorf("invalid key version '%s'", version)
+	}
+	if v := KeyVersion(name); v != "" {
+		if v != version {
+			return "", errors.Errorf("key version '%s' does not match the version of %s", version, name)
+		}
+		return name, nil
+	}
+	return name + "/cryptoKeyVersions/" + version, nil
+}
+
+// resourceName returns the Cloud KMS resource name of the given key name,
+// removing the optional cloudkms scheme.
+func resourceName(name string) string {
+	if len(name) > len(Scheme) && strings.EqualFold(name[:len(Scheme)+1], Scheme+":") {
+		return name[len(Scheme)+1:]
+	}
+	return name
+}
+
+// wrapError converts the gRPC errors with a matching apiv1 error type, and
+// wraps the rest with the given message.
+func wrapError(err error, msg string) error {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return apiv1.ErrPermissionDenied{Message: msg + ": " + err.Error()}
+	case codes.AlreadyExists:
+		return apiv1.ErrAlreadyExists{Message: msg + ": " + err.Error()}
+	case codes.NotFound:
+		return apiv1.ErrNotFound{Message: msg + ": " + err.Error()}
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
 func parent(name string) (string, string) {
 	i := strings.LastIndex(name, "/")
 	switch i {
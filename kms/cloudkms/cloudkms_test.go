@@ -7,12 +7,17 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/ptypes"
 	gax "github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
 	"github.com/smallstep/cli/crypto/pemutil"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -63,6 +68,7 @@ func TestNew(t *testing.T) {
 	}{
 		{"fail authentication", true, args{context.Background(), apiv1.Options{}}, nil, true},
 		{"fail credentials", false, args{context.Background(), apiv1.Options{CredentialsFile: "testdata/missing"}}, nil, true},
+		{"fail credentials secret", false, args{context.Background(), apiv1.Options{CredentialsSecret: "secrets/missing"}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -91,7 +97,7 @@ func TestNewCloudKMS(t *testing.T) {
 		args args
 		want *CloudKMS
 	}{
-		{"ok", args{&MockClient{}}, &CloudKMS{&MockClient{}}},
+		{"ok", args{&MockClient{}}, &CloudKMS{client: &MockClient{}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -126,8 +132,69 @@ func TestCloudKMS_Close(t *testing.T) {
 	}
 }
 
+func TestCloudKMS_Capabilities(t *testing.T) {
+	want := &apiv1.Capabilities{
+		SignatureAlgorithms: []apiv1.SignatureAlgorithm{
+			apiv1.SHA256WithRSA, apiv1.SHA512WithRSA, apiv1.SHA256WithRSAPSS, apiv1.SHA512WithRSAPSS,
+			apiv1.ECDSAWithSHA256, apiv1.ECDSAWithSHA384,
+		},
+		Bits: map[apiv1.SignatureAlgorithm][]int{
+			apiv1.SHA256WithRSA:    {2048, 3072, 4096},
+			apiv1.SHA512WithRSA:    {4096},
+			apiv1.SHA256WithRSAPSS: {2048, 3072, 4096},
+			apiv1.SHA512WithRSAPSS: {4096},
+		},
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.Software, apiv1.HSM},
+	}
+	k := &CloudKMS{}
+	if got := k.Capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CloudKMS.Capabilities() = %v, want %v", got, want)
+	}
+}
+
+func TestKeyVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1", "1"},
+		{"cloudkms:projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/12", "12"},
+		{"projects/p/locations/l/keyRings/k/cryptoKeys/c", ""},
+		{"projects/p/locations/l/keyRings/k", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KeyVersion(tt.name); got != tt.want {
+				t.Errorf("KeyVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestCloudKMS_CreateSigner(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+
+	pemBytes, err := ioutil.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	okClient := &MockClient{
+		getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+			return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+		},
+	}
+	failClient := &MockClient{
+		getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
 	type fields struct {
 		client KeyManagementClient
 	}
@@ -141,8 +208,14 @@ func TestCloudKMS_CreateSigner(t *testing.T) {
 		want    crypto.Signer
 		wantErr bool
 	}{
-		{"ok", fields{&MockClient{}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, &Signer{client: &MockClient{}, signingKey: keyName}, false},
-		{"fail", fields{&MockClient{}}, args{&apiv1.CreateSignerRequest{SigningKey: ""}}, nil, true},
+		{"ok", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, &Signer{client: okClient, signingKey: keyName, publicKey: pk}, false},
+		{"ok with version", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "1"}}, &Signer{client: okClient, signingKey: keyName, publicKey: pk}, false},
+		{"ok add version", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: "cloudkms:projects/p/locations/l/keyRings/k/cryptoKeys/c", KeyVersion: "2"}}, &Signer{client: okClient, signingKey: "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/2", publicKey: pk}, false},
+		{"fail", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: ""}}, nil, true},
+		{"fail version mismatch", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "2"}}, nil, true},
+		{"fail invalid version", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "latest"}}, nil, true},
+		{"fail zero version", fields{okClient}, args{&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "0"}}, nil, true},
+		{"fail get public key", fields{failClient}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -161,6 +234,87 @@ func TestCloudKMS_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestCloudKMS_CreateSigner_cache(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	versionName := keyName + "/cryptoKeyVersions/1"
+
+	pemBytes, err := ioutil.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	k := &CloudKMS{
+		client: &MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				calls++
+				if calls == 1 {
+					return nil, fmt.Errorf("an error")
+				}
+				return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+			},
+			createCryptoKeyVersion: func(_ context.Context, req *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				return &kmspb.CryptoKeyVersion{Name: req.Parent + "/cryptoKeyVersions/2"}, nil
+			},
+			destroyCryptoKeyVersion: func(_ context.Context, req *kmspb.DestroyCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				return &kmspb.CryptoKeyVersion{Name: req.Name}, nil
+			},
+		},
+	}
+
+	createSigner := func(req *apiv1.CreateSignerRequest) crypto.Signer {
+		t.Helper()
+		s, err := k.CreateSigner(req)
+		if err != nil {
+			t.Fatalf("CloudKMS.CreateSigner() error = %v", err)
+		}
+		return s
+	}
+
+	// Errors are not cached.
+	if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: versionName}); err == nil {
+		t.Fatal("CloudKMS.CreateSigner() error = nil, wantErr true")
+	}
+
+	// The same key version returns the same signer, with any name format.
+	s1 := createSigner(&apiv1.CreateSignerRequest{SigningKey: versionName})
+	if s := createSigner(&apiv1.CreateSignerRequest{SigningKey: "cloudkms:" + versionName}); s != s1 {
+		t.Errorf("CloudKMS.CreateSigner() = %p, want %p", s, s1)
+	}
+	if s := createSigner(&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "1"}); s != s1 {
+		t.Errorf("CloudKMS.CreateSigner() = %p, want %p", s, s1)
+	}
+	if !reflect.DeepEqual(s1.Public(), createSigner(&apiv1.CreateSignerRequest{SigningKey: versionName}).Public()) {
+		t.Error("CloudKMS.CreateSigner() returned signers with different public keys")
+	}
+
+	// Other versions use other signers.
+	s2 := createSigner(&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "2"})
+	if s2 == s1 {
+		t.Error("CloudKMS.CreateSigner() returned the same signer for different versions")
+	}
+
+	// Rotating the key invalidates the signers of all the versions.
+	if _, err := k.RotateKey(&apiv1.RotateKeyRequest{Name: keyName}); err != nil {
+		t.Fatalf("CloudKMS.RotateKey() error = %v", err)
+	}
+	s3 := createSigner(&apiv1.CreateSignerRequest{SigningKey: versionName})
+	if s3 == s1 {
+		t.Error("CloudKMS.CreateSigner() returned a signer cached before RotateKey")
+	}
+	if s := createSigner(&apiv1.CreateSignerRequest{SigningKey: keyName, KeyVersion: "2"}); s == s2 {
+		t.Error("CloudKMS.CreateSigner() returned a signer cached before RotateKey")
+	}
+
+	// Destroying a version invalidates its signer.
+	if err := k.DestroyKey(versionName); err != nil {
+		t.Fatalf("CloudKMS.DestroyKey() error = %v", err)
+	}
+	if s := createSigner(&apiv1.CreateSignerRequest{SigningKey: versionName}); s == s3 {
+		t.Error("CloudKMS.CreateSigner() returned a signer cached before DestroyKey")
+	}
+}
+
 func TestCloudKMS_CreateKey(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
 	testError := fmt.Errorf("an error")
@@ -175,6 +329,20 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Only the newest enabled version with the requested algorithm can be
+	// reused.
+	versions := []*kmspb.CryptoKeyVersion{
+		{Name: keyName + "/cryptoKeyVersions/1", State: kmspb.CryptoKeyVersion_ENABLED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+		{Name: keyName + "/cryptoKeyVersions/2", State: kmspb.CryptoKeyVersion_DESTROYED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+		{Name: keyName + "/cryptoKeyVersions/3", State: kmspb.CryptoKeyVersion_ENABLED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+		{Name: keyName + "/cryptoKeyVersions/4", State: kmspb.CryptoKeyVersion_ENABLED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384},
+		{Name: keyName + "/cryptoKeyVersions/5", State: kmspb.CryptoKeyVersion_DISABLED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+	}
+	otherVersions := []*kmspb.CryptoKeyVersion{
+		{Name: keyName + "/cryptoKeyVersions/1", State: kmspb.CryptoKeyVersion_ENABLED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384},
+		{Name: keyName + "/cryptoKeyVersions/2", State: kmspb.CryptoKeyVersion_DISABLED, Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+	}
+
 	var retries int
 	type fields struct {
 		client KeyManagementClient
@@ -202,7 +370,7 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 				},
 			}},
 			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256}},
-			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1", KeyVersion: "1"}, KeyURI: "cloudkms:" + keyName + "/cryptoKeyVersions/1"}, false},
 		{"ok new key ring", fields{
 			&MockClient{
 				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
@@ -219,7 +387,7 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 				},
 			}},
 			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 3072}},
-			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1", KeyVersion: "1"}, KeyURI: "cloudkms:" + keyName + "/cryptoKeyVersions/1"}, false},
 		{"ok new key version", fields{
 			&MockClient{
 				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
@@ -236,7 +404,25 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 				},
 			}},
 			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256}},
-			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/2", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/2"}}, false},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/2", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/2", KeyVersion: "2"}, KeyURI: "cloudkms:" + keyName + "/cryptoKeyVersions/2"}, false},
+		{"ok idempotent", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, _ *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					return nil, alreadyExists
+				},
+				listCryptoKeyVersions: listCryptoKeyVersions(t, versions, nil),
+				getPublicKey: func(_ context.Context, req *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+					if req.Name != keyName+"/cryptoKeyVersions/3" {
+						return nil, testError
+					}
+					return &kmspb.PublicKey{Pem: string(pemBytes), Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256}, nil
+				},
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256, Idempotent: true}},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/3", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/3", KeyVersion: "3"}, KeyURI: "cloudkms:" + keyName + "/cryptoKeyVersions/3"}, false},
 		{"ok with retries", fields{
 			&MockClient{
 				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
@@ -254,7 +440,7 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 				},
 			}},
 			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256}},
-			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1", KeyVersion: "1"}, KeyURI: "cloudkms:" + keyName + "/cryptoKeyVersions/1"}, false},
 		{"fail name", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{}}, nil, true},
 		{"fail protection level", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.ProtectionLevel(100)}}, nil, true},
 		{"fail signature algorithm", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}}, nil, true},
@@ -296,6 +482,45 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 			}},
 			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256}},
 			nil, true},
+		{"fail idempotent algorithm", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, _ *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					return nil, alreadyExists
+				},
+				listCryptoKeyVersions: listCryptoKeyVersions(t, otherVersions, nil),
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256, Idempotent: true}},
+			nil, true},
+		{"fail idempotent list versions", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, _ *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					return nil, alreadyExists
+				},
+				listCryptoKeyVersions: listCryptoKeyVersions(t, nil, status.Error(codes.PermissionDenied, "permission denied")),
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256, Idempotent: true}},
+			nil, true},
+		{"fail idempotent get public key", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, _ *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					return nil, alreadyExists
+				},
+				listCryptoKeyVersions: listCryptoKeyVersions(t, versions, nil),
+				getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+					return nil, testError
+				},
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256, Idempotent: true}},
+			nil, true},
 		{"fail get public key", fields{
 			&MockClient{
 				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
@@ -328,6 +553,77 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 	}
 }
 
+func TestCloudKMS_CreateKeyRing(t *testing.T) {
+	ringName := "projects/p/locations/l/keyRings/k"
+	testError := fmt.Errorf("an error")
+	alreadyExists := status.Error(codes.AlreadyExists, "already exists")
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		name string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+			}},
+			args{ringName}, false},
+		{"ok new key ring", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return nil, testError
+				},
+				createKeyRing: func(_ context.Context, req *kmspb.CreateKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					if req.Parent != "projects/p/locations/l" || req.KeyRingId != "k" {
+						return nil, fmt.Errorf("unexpected request %v", req)
+					}
+					return &kmspb.KeyRing{}, nil
+				},
+			}},
+			args{ringName}, false},
+		{"ok already exists", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return nil, testError
+				},
+				createKeyRing: func(_ context.Context, _ *kmspb.CreateKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return nil, alreadyExists
+				},
+			}},
+			args{ringName}, false},
+		{"fail name", fields{&MockClient{}}, args{""}, true},
+		{"fail create key ring", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return nil, testError
+				},
+				createKeyRing: func(_ context.Context, _ *kmspb.CreateKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return nil, testError
+				},
+			}},
+			args{ringName}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			if err := k.CreateKeyRing(tt.args.name); (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.CreateKeyRing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestCloudKMS_GetPublicKey(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
 	testError := fmt.Errorf("an error")
@@ -373,6 +669,16 @@ func TestCloudKMS_GetPublicKey(t *testing.T) {
 				},
 			}},
 			args{&apiv1.GetPublicKeyRequest{Name: keyName}}, pk, false},
+		{"ok uri", fields{
+			&MockClient{
+				getPublicKey: func(_ context.Context, req *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+					if req.Name != keyName {
+						return nil, testError
+					}
+					return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+				},
+			}},
+			args{&apiv1.GetPublicKeyRequest{Name: "cloudkms:" + keyName}}, pk, false},
 		{"fail name", fields{&MockClient{}}, args{&apiv1.GetPublicKeyRequest{}}, nil, true},
 		{"fail get public key", fields{
 			&MockClient{
@@ -405,3 +711,282 @@ func TestCloudKMS_GetPublicKey(t *testing.T) {
 		})
 	}
 }
+
+func TestCloudKMS_KeyURI(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+	tests := []struct {
+		name    string
+		keyName string
+		want    string
+		wantErr bool
+	}{
+		{"ok", keyName, "cloudkms:" + keyName, false},
+		{"ok uri", "cloudkms:" + keyName, "cloudkms:" + keyName, false},
+		{"ok uppercase", "CLOUDKMS:" + keyName, "cloudkms:" + keyName, false},
+		{"fail empty", "", "", true},
+		{"fail empty uri", "cloudkms:", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{}
+			got, err := k.KeyURI(tt.keyName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.KeyURI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CloudKMS.KeyURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_CheckProtectionLevel(t *testing.T) {
+	location := "projects/p/locations/global"
+	mustMetadata := func(hsm bool) *locationpb.Location {
+		md, err := ptypes.MarshalAny(&kmspb.LocationMetadata{HsmAvailable: hsm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &locationpb.Location{Name: location, Metadata: md}
+	}
+	getLocation := func(loc *locationpb.Location, err error) *MockLocationsClient {
+		return &MockLocationsClient{
+			getLocation: func(_ context.Context, req *locationpb.GetLocationRequest, _ ...grpc.CallOption) (*locationpb.Location, error) {
+				if req.Name != location {
+					return nil, fmt.Errorf("unexpected location %s", req.Name)
+				}
+				return loc, err
+			},
+		}
+	}
+
+	type fields struct {
+		locations LocationsClient
+	}
+	type args struct {
+		location string
+		level    apiv1.ProtectionLevel
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok hsm", fields{getLocation(mustMetadata(true), nil)}, args{location, apiv1.HSM}, false},
+		{"ok software", fields{getLocation(mustMetadata(false), nil)}, args{location, apiv1.Software}, false},
+		{"ok no locations client", fields{nil}, args{location, apiv1.HSM}, false},
+		{"fail empty", fields{getLocation(mustMetadata(true), nil)}, args{"", apiv1.HSM}, true},
+		{"fail hsm", fields{getLocation(mustMetadata(false), nil)}, args{location, apiv1.HSM}, true},
+		{"fail no metadata", fields{getLocation(&locationpb.Location{Name: location}, nil)}, args{location, apiv1.HSM}, true},
+		{"fail get location", fields{getLocation(nil, status.Error(codes.PermissionDenied, "permission denied"))}, args{location, apiv1.HSM}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				locations: tt.fields.locations,
+			}
+			if err := k.CheckProtectionLevel(tt.args.location, tt.args.level); (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.CheckProtectionLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_CheckHealth(t *testing.T) {
+	keyRing := "projects/p/locations/l/keyRings/k"
+	okClient := &MockClient{
+		getKeyRing: func(_ context.Context, req *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+			return &kmspb.KeyRing{Name: req.Name}, nil
+		},
+	}
+	failClient := func(err error) *MockClient {
+		return &MockClient{
+			getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+				return nil, err
+			},
+		}
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.CheckHealthRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, args{&apiv1.CheckHealthRequest{Name: keyRing}}, false},
+		{"ok uri", fields{okClient}, args{&apiv1.CheckHealthRequest{Name: "cloudkms:" + keyRing}}, false},
+		{"fail empty", fields{okClient}, args{&apiv1.CheckHealthRequest{}}, true},
+		{"fail permission denied", fields{failClient(status.Error(codes.PermissionDenied, "permission denied"))}, args{&apiv1.CheckHealthRequest{Name: keyRing}}, true},
+		{"fail unauthenticated", fields{failClient(status.Error(codes.Unauthenticated, "unauthenticated"))}, args{&apiv1.CheckHealthRequest{Name: keyRing}}, true},
+		{"fail not found", fields{failClient(status.Error(codes.NotFound, "not found"))}, args{&apiv1.CheckHealthRequest{Name: keyRing}}, true},
+		{"fail unavailable", fields{failClient(status.Error(codes.Unavailable, "unavailable"))}, args{&apiv1.CheckHealthRequest{Name: keyRing}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			if err := k.CheckHealth(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.CheckHealth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_wrapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want interface{}
+	}{
+		{"permission denied", status.Error(codes.PermissionDenied, "permission denied"), apiv1.ErrPermissionDenied{}},
+		{"unauthenticated", status.Error(codes.Unauthenticated, "unauthenticated"), apiv1.ErrPermissionDenied{}},
+		{"already exists", status.Error(codes.AlreadyExists, "already exists"), apiv1.ErrAlreadyExists{}},
+		{"not found", status.Error(codes.NotFound, "not found"), apiv1.ErrNotFound{}},
+		{"other", status.Error(codes.Unavailable, "unavailable"), status.Error(codes.Unavailable, "unavailable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapError(tt.err, "cloudKMS Test failed")
+			if got, want := fmt.Sprintf("%T", errors.Cause(err)), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("wrapError() cause = %v, want %v", got, want)
+			}
+			if !strings.HasPrefix(err.Error(), "cloudKMS Test failed: ") {
+				t.Errorf("wrapError() error = %v, want prefix 'cloudKMS Test failed: '", err)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_DestroyKey(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+	okClient := &MockClient{
+		destroyCryptoKeyVersion: func(_ context.Context, req *kmspb.DestroyCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+			if req.Name != keyName {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			return &kmspb.CryptoKeyVersion{Name: req.Name, State: kmspb.CryptoKeyVersion_DESTROY_SCHEDULED}, nil
+		},
+	}
+	failClient := &MockClient{
+		destroyCryptoKeyVersion: func(_ context.Context, _ *kmspb.DestroyCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		keyName string
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, keyName, false},
+		{"ok uri", fields{okClient}, "cloudkms:" + keyName, false},
+		{"fail empty", fields{okClient}, "", true},
+		{"fail destroy", fields{failClient}, keyName, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			if err := k.DestroyKey(tt.keyName); (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.DestroyKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_RotateKey(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	versionName := keyName + "/cryptoKeyVersions/2"
+
+	pemBytes, err := ioutil.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	okClient := &MockClient{
+		createCryptoKeyVersion: func(_ context.Context, req *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+			if req.Parent != keyName {
+				return nil, fmt.Errorf("unexpected parent %s", req.Parent)
+			}
+			return &kmspb.CryptoKeyVersion{Name: versionName}, nil
+		},
+		getPublicKey: func(_ context.Context, req *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+			if req.Name != versionName {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+		},
+	}
+	failCreateClient := &MockClient{
+		createCryptoKeyVersion: func(_ context.Context, _ *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+	failGetClient := &MockClient{
+		createCryptoKeyVersion: okClient.createCryptoKeyVersion,
+		getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	want := &apiv1.CreateKeyResponse{
+		Name:      versionName,
+		PublicKey: pk,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: versionName,
+			KeyVersion: "2",
+		},
+		KeyURI: "cloudkms:" + versionName,
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		req     *apiv1.RotateKeyRequest
+		want    *apiv1.CreateKeyResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, &apiv1.RotateKeyRequest{Name: keyName}, want, false},
+		{"ok uri", fields{okClient}, &apiv1.RotateKeyRequest{Name: "cloudkms:" + keyName}, want, false},
+		{"ok version", fields{okClient}, &apiv1.RotateKeyRequest{Name: keyName + "/cryptoKeyVersions/1"}, want, false},
+		{"fail empty", fields{okClient}, &apiv1.RotateKeyRequest{}, nil, true},
+		{"fail create version", fields{failCreateClient}, &apiv1.RotateKeyRequest{Name: keyName}, nil, true},
+		{"fail get public key", fields{failGetClient}, &apiv1.RotateKeyRequest{Name: keyName}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.RotateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.RotateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.RotateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package cloudkms
+
+import (
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+// Encrypt implements apiv1.Encrypter and encrypts the plaintext with a
+// symmetric crypto key. The primary version of the key is used if the name
+// does not include a version. Crypto key names follow the pattern:
+//   projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) Encrypt(req *apiv1.EncryptRequest) (*apiv1.EncryptResponse, error) {
+	name := resourceName(req.Name)
+	switch {
+	case name == "":
+		return nil, errors.New("encryptRequest 'name' cannot be empty")
+	case len(req.Plaintext) == 0:
+		return nil, errors.New("encryptRequest 'plaintext' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:                        name,
+		Plaintext:                   req.Plaintext,
+		AdditionalAuthenticatedData: req.AdditionalData,
+	})
+	if err != nil {
+		return nil, wrapError(err, "cloudKMS Encrypt failed")
+	}
+
+	return &apiv1.EncryptResponse{
+		Name:       resp.Name,
+		Ciphertext: resp.Ciphertext,
+	}, nil
+}
+
+// Decrypt implements apiv1.Decrypter and decrypts a ciphertext encrypted with
+// Encrypt. Cloud KMS finds the key version in the ciphertext, so the version
+// is removed from the name if present.
+func (k *CloudKMS) Decrypt(req *apiv1.DecryptRequest) (*apiv1.DecryptResponse, error) {
+	name := resourceName(req.Name)
+	switch {
+	case name == "":
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	case len(req.Ciphertext) == 0:
+		return nil, errors.New("decryptRequest 'ciphertext' cannot be empty")
+	}
+	if KeyVersion(name) != "" {
+		name, _ = Parent(name)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                        name,
+		Ciphertext:                  req.Ciphertext,
+		AdditionalAuthenticatedData: req.AdditionalData,
+	})
+	if err != nil {
+		return nil, wrapError(err, "cloudKMS Decrypt failed")
+	}
+
+	return &apiv1.DecryptResponse{
+		Plaintext: resp.Plaintext,
+	}, nil
+}
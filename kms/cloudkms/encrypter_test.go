@@ -0,0 +1,128 @@
+package cloudkms
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/smallstep/certificates/kms/apiv1"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+func TestCloudKMS_Encrypt(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	versionName := keyName + "/cryptoKeyVersions/1"
+	okClient := &MockClient{
+		encrypt: func(_ context.Context, req *kmspb.EncryptRequest, _ ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+			if req.Name != keyName && req.Name != versionName {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			return &kmspb.EncryptResponse{
+				Name:       versionName,
+				Ciphertext: append(append([]byte("ciphertext:"), req.AdditionalAuthenticatedData...), req.Plaintext...),
+			}, nil
+		},
+	}
+	failClient := &MockClient{
+		encrypt: func(_ context.Context, _ *kmspb.EncryptRequest, _ ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.EncryptResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, args{&apiv1.EncryptRequest{Name: keyName, Plaintext: []byte("secret")}}, &apiv1.EncryptResponse{Name: versionName, Ciphertext: []byte("ciphertext:secret")}, false},
+		{"ok with version", fields{okClient}, args{&apiv1.EncryptRequest{Name: versionName, Plaintext: []byte("secret")}}, &apiv1.EncryptResponse{Name: versionName, Ciphertext: []byte("ciphertext:secret")}, false},
+		{"ok with uri and aad", fields{okClient}, args{&apiv1.EncryptRequest{Name: "cloudkms:" + keyName, Plaintext: []byte("secret"), AdditionalData: []byte("aad:")}}, &apiv1.EncryptResponse{Name: versionName, Ciphertext: []byte("ciphertext:aad:secret")}, false},
+		{"fail empty name", fields{okClient}, args{&apiv1.EncryptRequest{Plaintext: []byte("secret")}}, nil, true},
+		{"fail empty plaintext", fields{okClient}, args{&apiv1.EncryptRequest{Name: keyName}}, nil, true},
+		{"fail encrypt", fields{failClient}, args{&apiv1.EncryptRequest{Name: keyName, Plaintext: []byte("secret")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.Encrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_Decrypt(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	okClient := &MockClient{
+		decrypt: func(_ context.Context, req *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+			if req.Name != keyName {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			if string(req.AdditionalAuthenticatedData) != "aad" {
+				return nil, fmt.Errorf("unexpected additional data %s", req.AdditionalAuthenticatedData)
+			}
+			return &kmspb.DecryptResponse{
+				Plaintext: []byte("secret"),
+			}, nil
+		},
+	}
+	failClient := &MockClient{
+		decrypt: func(_ context.Context, _ *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.DecryptResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, &apiv1.DecryptResponse{Plaintext: []byte("secret")}, false},
+		{"ok with version", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName + "/cryptoKeyVersions/1", Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, &apiv1.DecryptResponse{Plaintext: []byte("secret")}, false},
+		{"ok with uri", fields{okClient}, args{&apiv1.DecryptRequest{Name: "cloudkms:" + keyName, Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, &apiv1.DecryptResponse{Plaintext: []byte("secret")}, false},
+		{"fail empty name", fields{okClient}, args{&apiv1.DecryptRequest{Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail empty ciphertext", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName}}, nil, true},
+		{"fail additional data", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail decrypt", fields{failClient}, args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,19 +2,32 @@ package cloudkms
 
 import (
 	"context"
+	"net"
+	"testing"
 
+	cloudkms "cloud.google.com/go/kms/apiv1"
 	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1beta1"
+	"google.golang.org/grpc"
 )
 
 type MockClient struct {
-	close                  func() error
-	getPublicKey           func(context.Context, *kmspb.GetPublicKeyRequest, ...gax.CallOption) (*kmspb.PublicKey, error)
-	asymmetricSign         func(context.Context, *kmspb.AsymmetricSignRequest, ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error)
-	createCryptoKey        func(context.Context, *kmspb.CreateCryptoKeyRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
-	getKeyRing             func(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
-	createKeyRing          func(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
-	createCryptoKeyVersion func(context.Context, *kmspb.CreateCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	close                   func() error
+	getPublicKey            func(context.Context, *kmspb.GetPublicKeyRequest, ...gax.CallOption) (*kmspb.PublicKey, error)
+	asymmetricSign          func(context.Context, *kmspb.AsymmetricSignRequest, ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error)
+	createCryptoKey         func(context.Context, *kmspb.CreateCryptoKeyRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
+	getKeyRing              func(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
+	createKeyRing           func(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
+	createCryptoKeyVersion  func(context.Context, *kmspb.CreateCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	listKeyRings            func(context.Context, *kmspb.ListKeyRingsRequest, ...gax.CallOption) *cloudkms.KeyRingIterator
+	listCryptoKeys          func(context.Context, *kmspb.ListCryptoKeysRequest, ...gax.CallOption) *cloudkms.CryptoKeyIterator
+	listCryptoKeyVersions   func(context.Context, *kmspb.ListCryptoKeyVersionsRequest, ...gax.CallOption) *cloudkms.CryptoKeyVersionIterator
+	destroyCryptoKeyVersion func(context.Context, *kmspb.DestroyCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	encrypt                 func(context.Context, *kmspb.EncryptRequest, ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	decrypt                 func(context.Context, *kmspb.DecryptRequest, ...gax.CallOption) (*kmspb.DecryptResponse, error)
 }
 
 func (m *MockClient) Close() error {
@@ -44,3 +57,92 @@ func (m *MockClient) CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRing
 func (m *MockClient) CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
 	return m.createCryptoKeyVersion(ctx, req, opts...)
 }
+
+func (m *MockClient) ListKeyRings(ctx context.Context, req *kmspb.ListKeyRingsRequest, opts ...gax.CallOption) *cloudkms.KeyRingIterator {
+	return m.listKeyRings(ctx, req, opts...)
+}
+
+func (m *MockClient) ListCryptoKeys(ctx context.Context, req *kmspb.ListCryptoKeysRequest, opts ...gax.CallOption) *cloudkms.CryptoKeyIterator {
+	return m.listCryptoKeys(ctx, req, opts...)
+}
+
+func (m *MockClient) ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest, opts ...gax.CallOption) *cloudkms.CryptoKeyVersionIterator {
+	return m.listCryptoKeyVersions(ctx, req, opts...)
+}
+
+func (m *MockClient) DestroyCryptoKeyVersion(ctx context.Context, req *kmspb.DestroyCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	return m.destroyCryptoKeyVersion(ctx, req, opts...)
+}
+
+func (m *MockClient) Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+	return m.encrypt(ctx, req, opts...)
+}
+
+func (m *MockClient) Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+	return m.decrypt(ctx, req, opts...)
+}
+
+// versionsServer is a Cloud KMS gRPC server that only lists crypto key
+// versions. The iterators of the Cloud KMS client cannot be created without a
+// server.
+type versionsServer struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	versions []*kmspb.CryptoKeyVersion
+	err      error
+}
+
+func (s *versionsServer) ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest) (*kmspb.ListCryptoKeyVersionsResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &kmspb.ListCryptoKeyVersionsResponse{
+		CryptoKeyVersions: s.versions,
+		TotalSize:         int32(len(s.versions)),
+	}, nil
+}
+
+// listCryptoKeyVersions returns a function for MockClient.listCryptoKeyVersions
+// that lists the given versions, or fails with the given error.
+func listCryptoKeyVersions(t *testing.T, versions []*kmspb.CryptoKeyVersion, err error) func(context.Context, *kmspb.ListCryptoKeyVersionsRequest, ...gax.CallOption) *cloudkms.CryptoKeyVersionIterator {
+	t.Helper()
+	lis, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	srv := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(srv, &versionsServer{versions: versions, err: err})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, e := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if e != nil {
+		t.Fatal(e)
+	}
+	client, e := cloudkms.NewKeyManagementClient(context.Background(), option.WithGRPCConn(conn))
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client.ListCryptoKeyVersions
+}
+
+type MockSecretManagerClient struct {
+	close               func() error
+	accessSecretVersion func(context.Context, *secretspb.AccessSecretVersionRequest, ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error)
+}
+
+func (m *MockSecretManagerClient) Close() error {
+	return m.close()
+}
+
+func (m *MockSecretManagerClient) AccessSecretVersion(ctx context.Context, req *secretspb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error) {
+	return m.accessSecretVersion(ctx, req, opts...)
+}
+
+type MockLocationsClient struct {
+	getLocation func(context.Context, *locationpb.GetLocationRequest, ...grpc.CallOption) (*locationpb.Location, error)
+}
+
+func (m *MockLocationsClient) GetLocation(ctx context.Context, req *locationpb.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error) {
+	return m.getLocation(ctx, req, opts...)
+}
@@ -0,0 +1,60 @@
+package cloudkms
+
+import (
+	"context"
+	"regexp"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1beta1"
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1beta1"
+)
+
+// secretNameRegexp matches the name of a Secret Manager secret, with an
+// optional version.
+var secretNameRegexp = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+(/versions/[^/]+)?$`)
+
+// SecretManagerClient defines the methods on the Secret Manager client that
+// this package will use to read the credentials. This interface will be used
+// for unit testing.
+type SecretManagerClient interface {
+	Close() error
+	AccessSecretVersion(context.Context, *secretspb.AccessSecretVersionRequest, ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error)
+}
+
+var newSecretManagerClient = func(ctx context.Context, opts ...option.ClientOption) (SecretManagerClient, error) {
+	return secretmanager.NewClient(ctx, opts...)
+}
+
+// readCredentialsSecret returns the credentials stored in the given Secret
+// Manager secret. The name has the format
+// projects/<project>/secrets/<secret>/versions/<version>, if the version is
+// not present the latest one is used. The secret is read with the given
+// client options.
+func readCredentialsSecret(ctx context.Context, name string, opts ...option.ClientOption) ([]byte, error) {
+	m := secretNameRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return nil, errors.Errorf("credentials secret '%s' is not valid, it must have the format projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+	if m[1] == "" {
+		name += "/versions/latest"
+	}
+
+	client, err := newSecretManagerClient(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating secret manager client")
+	}
+	defer client.Close()
+
+	resp, err := client.AccessSecretVersion(ctx, &secretspb.AccessSecretVersionRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading credentials secret %s", name)
+	}
+	if resp.Payload == nil || len(resp.Payload.Data) == 0 {
+		return nil, errors.Errorf("credentials secret %s is empty", name)
+	}
+	return resp.Payload.Data, nil
+}
@@ -0,0 +1,82 @@
+package cloudkms
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1beta1"
+)
+
+func Test_readCredentialsSecret(t *testing.T) {
+	tmp := newSecretManagerClient
+	defer func() {
+		newSecretManagerClient = tmp
+	}()
+
+	credentials := []byte(`{"type": "service_account"}`)
+	access := func(want string, resp *secretspb.AccessSecretVersionResponse, err error) func(context.Context, *secretspb.AccessSecretVersionRequest, ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error) {
+		return func(_ context.Context, req *secretspb.AccessSecretVersionRequest, _ ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error) {
+			if req.Name != want {
+				return nil, fmt.Errorf("unexpected secret name %s, want %s", req.Name, want)
+			}
+			return resp, err
+		}
+	}
+	payload := &secretspb.AccessSecretVersionResponse{
+		Payload: &secretspb.SecretPayload{Data: credentials},
+	}
+
+	type args struct {
+		name string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		client    SecretManagerClient
+		clientErr error
+		want      []byte
+		wantErr   bool
+	}{
+		{"ok", args{"projects/p/secrets/s/versions/3"}, &MockSecretManagerClient{
+			close:               func() error { return nil },
+			accessSecretVersion: access("projects/p/secrets/s/versions/3", payload, nil),
+		}, nil, credentials, false},
+		{"ok latest", args{"projects/p/secrets/s"}, &MockSecretManagerClient{
+			close:               func() error { return nil },
+			accessSecretVersion: access("projects/p/secrets/s/versions/latest", payload, nil),
+		}, nil, credentials, false},
+		{"fail name", args{"projects/p/secrets"}, nil, nil, nil, true},
+		{"fail name version", args{"projects/p/secrets/s/versions/"}, nil, nil, nil, true},
+		{"fail client", args{"projects/p/secrets/s"}, nil, fmt.Errorf("an error"), nil, true},
+		{"fail access", args{"projects/p/secrets/s"}, &MockSecretManagerClient{
+			close:               func() error { return nil },
+			accessSecretVersion: access("projects/p/secrets/s/versions/latest", nil, fmt.Errorf("an error")),
+		}, nil, nil, true},
+		{"fail empty", args{"projects/p/secrets/s"}, &MockSecretManagerClient{
+			close:               func() error { return nil },
+			accessSecretVersion: access("projects/p/secrets/s/versions/latest", &secretspb.AccessSecretVersionResponse{}, nil),
+		}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newSecretManagerClient = func(ctx context.Context, opts ...option.ClientOption) (SecretManagerClient, error) {
+				if tt.clientErr != nil {
+					return nil, tt.clientErr
+				}
+				return tt.client, nil
+			}
+			got, err := readCredentialsSecret(context.Background(), tt.args.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readCredentialsSecret() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCredentialsSecret() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package cloudkms
 import (
 	"crypto"
 	"io"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/cli/crypto/pemutil"
@@ -13,33 +15,42 @@ import (
 type Signer struct {
 	client     KeyManagementClient
 	signingKey string
+	publicKey  crypto.PublicKey
 }
 
-func NewSigner(c KeyManagementClient, signingKey string) *Signer {
-	return &Signer{
+// NewSigner creates a new signer using a crypto key version in Google's Cloud
+// KMS. The public key is loaded when the signer is created.
+func NewSigner(c KeyManagementClient, signingKey string) (*Signer, error) {
+	// Make sure that the key exists.
+	signer := &Signer{
 		client:     c,
 		signingKey: signingKey,
 	}
+	if err := signer.preloadKey(signingKey); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
 }
 
-// Public returns the public key of this signer or an error.
-func (s *Signer) Public() crypto.PublicKey {
+func (s *Signer) preloadKey(signingKey string) error {
 	ctx, cancel := defaultContext()
 	defer cancel()
 
 	response, err := s.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
-		Name: s.signingKey,
+		Name: signingKey,
 	})
 	if err != nil {
 		return errors.Wrap(err, "cloudKMS GetPublicKey failed")
 	}
 
-	pk, err := pemutil.ParseKey([]byte(response.Pem))
-	if err != nil {
-		return err
-	}
+	s.publicKey, err = pemutil.ParseKey([]byte(response.Pem))
+	return err
+}
 
-	return pk
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
 }
 
 // Sign signs digest with the private key stored in Google's Cloud KMS.
@@ -76,3 +87,43 @@ func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]
 
 	return response.Signature, nil
 }
+
+// signerCache caches the signers created by CloudKMS.CreateSigner by crypto key
+// version name, so repeated calls for the same key return the same signer and
+// do not fetch the public key again.
+type signerCache struct {
+	sync.Mutex
+	signers map[string]*Signer
+}
+
+// GetOrCreate returns the cached signer for the given name, or creates and
+// caches a new one using fn. Errors are not cached.
+func (c *signerCache) GetOrCreate(name string, fn func() (*Signer, error)) (*Signer, error) {
+	c.Lock()
+	defer c.Unlock()
+	if s, ok := c.signers[name]; ok {
+		return s, nil
+	}
+	s, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	if c.signers == nil {
+		c.signers = make(map[string]*Signer)
+	}
+	c.signers[name] = s
+	return s, nil
+}
+
+// Invalidate removes the cached signers for the given crypto key or crypto key
+// version name. A crypto key name removes the signers of all its versions.
+func (c *signerCache) Invalidate(name string) {
+	c.Lock()
+	defer c.Unlock()
+	prefix := name + "/cryptoKeyVersions/"
+	for k := range c.signers {
+		if k == name || strings.HasPrefix(k, prefix) {
+			delete(c.signers, k)
+		}
+	}
+}
@@ -16,27 +16,6 @@ import (
 )
 
 func Test_newSigner(t *testing.T) {
-	type args struct {
-		c          KeyManagementClient
-		signingKey string
-	}
-	tests := []struct {
-		name string
-		args args
-		want *Signer
-	}{
-		{"ok", args{&MockClient{}, "signingKey"}, &Signer{client: &MockClient{}, signingKey: "signingKey"}},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := NewSigner(tt.args.c, tt.args.signingKey); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("newSigner() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_signer_Public(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
 	testError := fmt.Errorf("an error")
 
@@ -49,51 +28,64 @@ func Test_signer_Public(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	type fields struct {
-		client     KeyManagementClient
+	okClient := &MockClient{
+		getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+			return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+		},
+	}
+
+	type args struct {
+		c          KeyManagementClient
 		signingKey string
 	}
 	tests := []struct {
 		name    string
-		fields  fields
-		want    crypto.PublicKey
+		args    args
+		want    *Signer
 		wantErr bool
 	}{
-		{"ok", fields{&MockClient{
+		{"ok", args{okClient, keyName}, &Signer{client: okClient, signingKey: keyName, publicKey: pk}, false},
+		{"fail get public key", args{&MockClient{
 			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
-				return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+				return nil, testError
 			},
-		}, keyName}, pk, false},
-		{"fail get public key", fields{&MockClient{
+		}, keyName}, nil, true},
+		{"fail parse pem", args{&MockClient{
 			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
-				return nil, testError
+				return &kmspb.PublicKey{Pem: string("bad pem")}, nil
 			},
 		}, keyName}, nil, true},
-		{"fail parse pem", fields{
-			&MockClient{
-				getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
-					return &kmspb.PublicKey{Pem: string("bad pem")}, nil
-				},
-			}, keyName}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Signer{
-				client:     tt.fields.client,
-				signingKey: tt.fields.signingKey,
-			}
-			got := s.Public()
-			if _, ok := got.(error); ok != tt.wantErr {
-				t.Errorf("signer.Public() error = %v, wantErr %v", got, tt.wantErr)
+			got, err := NewSigner(tt.args.c, tt.args.signingKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSigner() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("signer.Public() = %v, want %v", got, tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewSigner() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func Test_signer_Public(t *testing.T) {
+	pemBytes, err := ioutil.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Signer{publicKey: pk}
+	if got := s.Public(); !reflect.DeepEqual(got, pk) {
+		t.Errorf("signer.Public() = %v, want %v", got, pk)
+	}
+}
+
 func Test_signer_Sign(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
 	okClient := &MockClient{
@@ -18,6 +18,22 @@ type KeyManager = apiv1.KeyManager
 // store x509.Certificates.
 type CertificateManager = apiv1.CertificateManager
 
+// HealthChecker is the interface implemented by the KMS that can verify that
+// the backend is reachable.
+type HealthChecker = apiv1.HealthChecker
+
+// KeyLister is the interface implemented by the KMS that can list the keys
+// previously created.
+type KeyLister = apiv1.KeyLister
+
+// Encrypter is the interface implemented by the KMS that can encrypt small
+// secrets with a symmetric key.
+type Encrypter = apiv1.Encrypter
+
+// Decrypter is the interface implemented by the KMS that can decrypt the
+// secrets encrypted by an Encrypter.
+type Decrypter = apiv1.Decrypter
+
 // New initializes a new KMS from the given type.
 func New(ctx context.Context, opts apiv1.Options) (KeyManager, error) {
 	if err := opts.Validate(); err != nil {
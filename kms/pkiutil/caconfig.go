@@ -0,0 +1,124 @@
+package pkiutil
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/tlsutil"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+)
+
+// DefaultProvisionerName is the name of the JWK provisioner added to the
+// ca.json generated with GenerateCAConfig.
+const DefaultProvisionerName = "admin"
+
+// CAConfig contains the values used to generate a starter ca.json for a PKI
+// initialized in a KMS. Root, Crt and Key are required. Root and Crt are
+// paths to the certificates, and Key is the name of the key, usually a KMS
+// URI, used to sign certificates.
+type CAConfig struct {
+	Root        string
+	Crt         string
+	Key         string
+	KMS         *apiv1.Options
+	SSHHostKey  string
+	SSHUserKey  string
+	Provisioner string
+	// FIPS enables the FIPS-only mode in the CA.
+	FIPS bool
+}
+
+// GenerateCAConfig returns a starter ca.json for the given PKI. The
+// configuration uses the default address, database and TLS options of
+// `step ca init`, and it contains a JWK provisioner with a new key encrypted
+// with the given password. Paths to files are converted to absolute paths.
+func GenerateCAConfig(c CAConfig, password []byte) (*authority.Config, error) {
+	switch {
+	case c.Root == "":
+		return nil, errors.New("root cannot be empty")
+	case c.Crt == "":
+		return nil, errors.New("crt cannot be empty")
+	case c.Key == "":
+		return nil, errors.New("key cannot be empty")
+	case c.Provisioner == "":
+		c.Provisioner = DefaultProvisionerName
+	}
+
+	pub, priv, err := jose.GenerateDefaultKeyPair(password)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := priv.CompactSerialize()
+	if err != nil {
+		return nil, errors.Wrap(err, "error serializing private key")
+	}
+
+	prov := &provisioner.JWK{
+		Name:         c.Provisioner,
+		Type:         "JWK",
+		Key:          pub,
+		EncryptedKey: encryptedKey,
+	}
+
+	config := &authority.Config{
+		Root:             []string{absPath(c.Root)},
+		FederatedRoots:   []string{},
+		IntermediateCert: absPath(c.Crt),
+		IntermediateKey:  c.Key,
+		Address:          ":443",
+		DNSNames:         []string{"localhost"},
+		KMS:              c.KMS,
+		Logger:           []byte(`{"format": "text"}`),
+		DB: &db.Config{
+			Type:       "badger",
+			DataSource: absPath("db"),
+		},
+		AuthorityConfig: &authority.AuthConfig{
+			DisableIssuedAtCheck: false,
+			Provisioners:         provisioner.List{prov},
+		},
+		TLS: &tlsutil.TLSOptions{
+			MinVersion:    x509util.DefaultTLSMinVersion,
+			MaxVersion:    x509util.DefaultTLSMaxVersion,
+			Renegotiation: x509util.DefaultTLSRenegotiation,
+			CipherSuites:  x509util.DefaultTLSCipherSuites,
+		},
+		FIPS: c.FIPS,
+	}
+	if c.SSHHostKey != "" || c.SSHUserKey != "" {
+		enableSSHCA := true
+		config.SSH = &authority.SSHConfig{
+			HostKey: c.SSHHostKey,
+			UserKey: c.SSHUserKey,
+		}
+		prov.Claims = &provisioner.Claims{
+			EnableSSHCA: &enableSSHCA,
+		}
+	}
+
+	return config, nil
+}
+
+// WriteCAConfig generates a starter ca.json using GenerateCAConfig and writes
+// it to the given filename.
+func WriteCAConfig(filename string, c CAConfig, password []byte) error {
+	config, err := GenerateCAConfig(c, password)
+	if err != nil {
+		return err
+	}
+	return config.Save(filename)
+}
+
+// absPath returns the absolute representation of the given path, or the path
+// itself if it cannot be resolved.
+func absPath(name string) string {
+	if p, err := filepath.Abs(name); err == nil {
+		return p
+	}
+	return name
+}
@@ -0,0 +1,110 @@
+package pkiutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestGenerateCAConfig(t *testing.T) {
+	kmsOptions := &apiv1.Options{Type: "awskms", Region: "us-east-1"}
+	tests := []struct {
+		name    string
+		c       CAConfig
+		wantSSH bool
+		wantErr bool
+	}{
+		{"ok", CAConfig{Root: "root_ca.crt", Crt: "intermediate_ca.crt", Key: "awskms:key-id=1234", KMS: kmsOptions}, false, false},
+		{"ok ssh", CAConfig{Root: "root_ca.crt", Crt: "intermediate_ca.crt", Key: "awskms:key-id=1234", KMS: kmsOptions, SSHHostKey: "awskms:key-id=host", SSHUserKey: "awskms:key-id=user"}, true, false},
+		{"ok provisioner", CAConfig{Root: "root_ca.crt", Crt: "intermediate_ca.crt", Key: "awskms:key-id=1234", Provisioner: "ops"}, false, false},
+		{"fail root", CAConfig{Crt: "intermediate_ca.crt", Key: "awskms:key-id=1234"}, false, true},
+		{"fail crt", CAConfig{Root: "root_ca.crt", Key: "awskms:key-id=1234"}, false, true},
+		{"fail key", CAConfig{Root: "root_ca.crt", Crt: "intermediate_ca.crt"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GenerateCAConfig(tt.c, []byte("password"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GenerateCAConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if err := got.Validate(); err != nil {
+				t.Errorf("GenerateCAConfig() config is not valid: %v", err)
+			}
+			if len(got.Root) != 1 || !filepath.IsAbs(got.Root[0]) {
+				t.Errorf("GenerateCAConfig() root = %v, want an absolute path", got.Root)
+			}
+			if !filepath.IsAbs(got.IntermediateCert) {
+				t.Errorf("GenerateCAConfig() crt = %v, want an absolute path", got.IntermediateCert)
+			}
+			if got.IntermediateKey != tt.c.Key {
+				t.Errorf("GenerateCAConfig() key = %v, want %v", got.IntermediateKey, tt.c.Key)
+			}
+			if got.KMS != tt.c.KMS {
+				t.Errorf("GenerateCAConfig() kms = %v, want %v", got.KMS, tt.c.KMS)
+			}
+			if (got.SSH != nil) != tt.wantSSH {
+				t.Errorf("GenerateCAConfig() ssh = %v, wantSSH %v", got.SSH, tt.wantSSH)
+			}
+
+			provisioners := got.AuthorityConfig.Provisioners
+			if len(provisioners) != 1 {
+				t.Fatalf("GenerateCAConfig() provisioners = %v, want 1 provisioner", provisioners)
+			}
+			jwk, ok := provisioners[0].(*provisioner.JWK)
+			if !ok {
+				t.Fatalf("GenerateCAConfig() provisioner type = %T, want *provisioner.JWK", provisioners[0])
+			}
+			wantName := tt.c.Provisioner
+			if wantName == "" {
+				wantName = DefaultProvisionerName
+			}
+			if jwk.Name != wantName || jwk.Key == nil || jwk.EncryptedKey == "" {
+				t.Errorf("GenerateCAConfig() provisioner = %v, want a JWK provisioner named %s", jwk, wantName)
+			}
+		})
+	}
+}
+
+func TestWriteCAConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkiutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "ca.json")
+	c := CAConfig{
+		Root: "root_ca.crt",
+		Crt:  "intermediate_ca.crt",
+		Key:  "cloudkms:projects/p/locations/global/keyRings/pki/cryptoKeys/intermediate/cryptoKeyVersions/1",
+		KMS:  &apiv1.Options{Type: "cloudkms"},
+	}
+	if err := WriteCAConfig(filename, c, []byte("password")); err != nil {
+		t.Fatalf("WriteCAConfig() error = %v", err)
+	}
+
+	config, err := authority.LoadConfiguration(filename)
+	if err != nil {
+		t.Fatalf("authority.LoadConfiguration() error = %v", err)
+	}
+	if config.IntermediateKey != c.Key {
+		t.Errorf("WriteCAConfig() key = %v, want %v", config.IntermediateKey, c.Key)
+	}
+	if config.KMS == nil || config.KMS.Type != "cloudkms" {
+		t.Errorf("WriteCAConfig() kms = %v, want cloudkms", config.KMS)
+	}
+
+	if err := WriteCAConfig(filepath.Join(dir, "missing", "ca.json"), c, []byte("password")); err == nil {
+		t.Error("WriteCAConfig() error = nil, want an error")
+	}
+}
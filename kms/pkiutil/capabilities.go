@@ -0,0 +1,96 @@
+package pkiutil
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// KMSCapabilities describes the features supported by a KMS. The algorithms,
+// key sizes and protection levels are only set if the KMS implements the
+// CapabilitiesReporter interface, the rest of the fields report the optional
+// interfaces implemented by the KMS.
+type KMSCapabilities struct {
+	Type                string           `json:"type"`
+	SignatureAlgorithms []string         `json:"signatureAlgorithms,omitempty"`
+	Bits                map[string][]int `json:"bits,omitempty"`
+	ProtectionLevels    []string         `json:"protectionLevels,omitempty"`
+	CertificateManager  bool             `json:"certificateManager"`
+	KeyURIFormatter     bool             `json:"keyURIFormatter"`
+	HealthChecker       bool             `json:"healthChecker"`
+	KeyLister           bool             `json:"keyLister"`
+	KeyRotator          bool             `json:"keyRotator"`
+	Encrypter           bool             `json:"encrypter"`
+	Decrypter           bool             `json:"decrypter"`
+}
+
+// Capabilities returns the capabilities of the given KMS of the given type.
+func Capabilities(t apiv1.Type, k apiv1.KeyManager) *KMSCapabilities {
+	caps := &KMSCapabilities{
+		Type: strings.ToLower(string(t)),
+	}
+	if t == apiv1.DefaultKMS {
+		caps.Type = string(apiv1.SoftKMS)
+	}
+
+	if cr, ok := k.(apiv1.CapabilitiesReporter); ok {
+		c := cr.Capabilities()
+		for _, alg := range c.SignatureAlgorithms {
+			caps.SignatureAlgorithms = append(caps.SignatureAlgorithms, alg.String())
+			if bits := c.Bits[alg]; len(bits) > 0 {
+				if caps.Bits == nil {
+					caps.Bits = make(map[string][]int)
+				}
+				caps.Bits[alg.String()] = bits
+			}
+		}
+		for _, level := range c.ProtectionLevels {
+			caps.ProtectionLevels = append(caps.ProtectionLevels, level.String())
+		}
+	}
+
+	_, caps.CertificateManager = k.(apiv1.CertificateManager)
+	_, caps.KeyURIFormatter = k.(apiv1.KeyURIFormatter)
+	_, caps.HealthChecker = k.(apiv1.HealthChecker)
+	_, caps.KeyLister = k.(apiv1.KeyLister)
+	_, caps.KeyRotator = k.(apiv1.KeyRotator)
+	_, caps.Encrypter = k.(apiv1.Encrypter)
+	_, caps.Decrypter = k.(apiv1.Decrypter)
+	return caps
+}
+
+// Interfaces returns the names of the optional interfaces implemented by the
+// KMS.
+func (c *KMSCapabilities) Interfaces() []string {
+	var names []string
+	for _, v := range []struct {
+		name string
+		ok   bool
+	}{
+		{"CertificateManager", c.CertificateManager},
+		{"KeyURIFormatter", c.KeyURIFormatter},
+		{"HealthChecker", c.HealthChecker},
+		{"KeyLister", c.KeyLister},
+		{"KeyRotator", c.KeyRotator},
+		{"Encrypter", c.Encrypter},
+		{"Decrypter", c.Decrypter},
+	} {
+		if v.ok {
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+// FormatBits returns the RSA key sizes supported by the given signature
+// algorithm as a comma separated list, or an empty string if there are no
+// restrictions.
+func (c *KMSCapabilities) FormatBits(alg string) string {
+	bits := c.Bits[alg]
+	s := make([]string, len(bits))
+	for i, b := range bits {
+		s[i] = strconv.Itoa(b)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,81 @@
+package pkiutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+type fakeCapabilitiesReporter struct {
+	fakeCertificateManager
+}
+
+func (fakeCapabilitiesReporter) Capabilities() *apiv1.Capabilities {
+	return &apiv1.Capabilities{
+		SignatureAlgorithms: []apiv1.SignatureAlgorithm{apiv1.SHA256WithRSA, apiv1.ECDSAWithSHA256},
+		Bits: map[apiv1.SignatureAlgorithm][]int{
+			apiv1.SHA256WithRSA: {2048, 4096},
+		},
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.Software, apiv1.HSM},
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	type args struct {
+		t apiv1.Type
+		k apiv1.KeyManager
+	}
+	tests := []struct {
+		name string
+		args args
+		want *KMSCapabilities
+	}{
+		{"ok", args{"CloudKMS", &fakeCapabilitiesReporter{}}, &KMSCapabilities{
+			Type:                "cloudkms",
+			SignatureAlgorithms: []string{"SHA256-RSA", "ECDSA-SHA256"},
+			Bits:                map[string][]int{"SHA256-RSA": {2048, 4096}},
+			ProtectionLevels:    []string{"software", "hsm"},
+			CertificateManager:  true,
+		}},
+		{"ok key manager", args{apiv1.AmazonKMS, fakeKeyManager{}}, &KMSCapabilities{
+			Type: "awskms",
+		}},
+		{"ok key uri formatter", args{apiv1.YubiKey, fakeKeyURIFormatter{}}, &KMSCapabilities{
+			Type:            "yubikey",
+			KeyURIFormatter: true,
+		}},
+		{"ok default", args{apiv1.DefaultKMS, &fakeKeyLister{}}, &KMSCapabilities{
+			Type:      "softkms",
+			KeyLister: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Capabilities(tt.args.t, tt.args.k); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Capabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMSCapabilities_Interfaces(t *testing.T) {
+	c := &KMSCapabilities{CertificateManager: true, HealthChecker: true, KeyRotator: true, Decrypter: true}
+	want := []string{"CertificateManager", "HealthChecker", "KeyRotator", "Decrypter"}
+	if got := c.Interfaces(); !reflect.DeepEqual(got, want) {
+		t.Errorf("KMSCapabilities.Interfaces() = %v, want %v", got, want)
+	}
+	if got := (&KMSCapabilities{}).Interfaces(); got != nil {
+		t.Errorf("KMSCapabilities.Interfaces() = %v, want nil", got)
+	}
+}
+
+func TestKMSCapabilities_FormatBits(t *testing.T) {
+	c := &KMSCapabilities{Bits: map[string][]int{"SHA256-RSA": {2048, 3072}}}
+	if got := c.FormatBits("SHA256-RSA"); got != "2048, 3072" {
+		t.Errorf("KMSCapabilities.FormatBits() = %s, want 2048, 3072", got)
+	}
+	if got := c.FormatBits("SHA256-RSAPSS"); got != "" {
+		t.Errorf("KMSCapabilities.FormatBits() = %s, want empty", got)
+	}
+}
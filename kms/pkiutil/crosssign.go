@@ -0,0 +1,138 @@
+package pkiutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CrossSignedRootFile is the file name used by the init tools to write the
+// cross-signed root certificate.
+const CrossSignedRootFile = "cross_signed_root.crt"
+
+// CrossSignedSuffix is appended to the base file name of the intermediates to
+// write the certificates cross-signed by an external root, e.g.
+// intermediate_ca_cross.crt.
+const CrossSignedSuffix = "_cross"
+
+// CrossSign returns a certificate with the subject, public key and constraints
+// of newRoot, issued by oldRoot and signed with the oldRoot key. Clients that
+// only trust oldRoot can verify the certificates issued by newRoot using the
+// cross-signed certificate as an intermediate.
+//
+// The cross-signed certificate is valid from now until the earliest expiration
+// of both roots.
+func CrossSign(newRoot, oldRoot *x509.Certificate, signer crypto.Signer) (*x509.Certificate, error) {
+	switch {
+	case newRoot == nil:
+		return nil, errors.New("new root certificate cannot be nil")
+	case oldRoot == nil:
+		return nil, errors.New("old root certificate cannot be nil")
+	case signer == nil:
+		return nil, errors.New("old root signer cannot be nil")
+	case !newRoot.IsCA:
+		return nil, errors.New("new root certificate is not a CA certificate")
+	case !oldRoot.IsCA:
+		return nil, errors.New("old root certificate is not a CA certificate")
+	}
+
+	serialNumber, err := SerialNumber(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := newRoot.NotAfter
+	if oldRoot.NotAfter.Before(notAfter) {
+		notAfter = oldRoot.NotAfter
+	}
+
+	template := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		KeyUsage:              newRoot.KeyUsage,
+		BasicConstraintsValid: true,
+		MaxPathLen:            newRoot.MaxPathLen,
+		MaxPathLenZero:        newRoot.MaxPathLenZero,
+		Subject:               newRoot.Subject,
+		SerialNumber:          serialNumber,
+		SubjectKeyId:          newRoot.SubjectKeyId,
+		AuthorityKeyId:        oldRoot.SubjectKeyId,
+	}
+
+	return createCrossSigned(template, oldRoot, newRoot.PublicKey, signer, "old root")
+}
+
+// CrossSignIntermediate returns a certificate with the subject, public key,
+// constraints, extended key usages and policies of intermediate, issued by root
+// and signed with the root key. Both intermediates share the key and the
+// subject key identifier, so the certificates issued by the intermediate
+// verify with the chain of the original root and with the chain of root.
+//
+// The cross-signed certificate is valid from now until the earliest expiration
+// of the intermediate and root.
+func CrossSignIntermediate(intermediate, root *x509.Certificate, signer crypto.Signer) (*x509.Certificate, error) {
+	switch {
+	case intermediate == nil:
+		return nil, errors.New("intermediate certificate cannot be nil")
+	case root == nil:
+		return nil, errors.New("cross root certificate cannot be nil")
+	case signer == nil:
+		return nil, errors.New("cross root signer cannot be nil")
+	case !intermediate.IsCA:
+		return nil, errors.New("intermediate certificate is not a CA certificate")
+	case !root.IsCA:
+		return nil, errors.New("cross root certificate is not a CA certificate")
+	}
+
+	serialNumber, err := SerialNumber(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := intermediate.NotAfter
+	if root.NotAfter.Before(notAfter) {
+		notAfter = root.NotAfter
+	}
+
+	template := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		KeyUsage:              intermediate.KeyUsage,
+		ExtKeyUsage:           intermediate.ExtKeyUsage,
+		UnknownExtKeyUsage:    intermediate.UnknownExtKeyUsage,
+		PolicyIdentifiers:     intermediate.PolicyIdentifiers,
+		BasicConstraintsValid: true,
+		MaxPathLen:            intermediate.MaxPathLen,
+		MaxPathLenZero:        intermediate.MaxPathLenZero,
+		Subject:               intermediate.Subject,
+		SerialNumber:          serialNumber,
+		SubjectKeyId:          intermediate.SubjectKeyId,
+		AuthorityKeyId:        root.SubjectKeyId,
+	}
+
+	return createCrossSigned(template, root, intermediate.PublicKey, signer, "cross root")
+}
+
+// createCrossSigned creates the certificate in template issued by issuer and
+// checks that the signer is the key of the issuer.
+func createCrossSigned(template, issuer *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer, issuerName string) (*x509.Certificate, error) {
+	b, err := x509.CreateCertificate(rand.Reader, template, issuer, pub, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cross-signed certificate")
+	}
+
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing cross-signed certificate")
+	}
+	if err := cert.CheckSignatureFrom(issuer); err != nil {
+		return nil, errors.Wrapf(err, "error verifying cross-signed certificate: the signer does not match the %s", issuerName)
+	}
+
+	return cert, nil
+}
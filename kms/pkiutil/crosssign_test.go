@@ -0,0 +1,247 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func mustRoot(t *testing.T, cn string, notAfter time.Time) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skid, err := SubjectKeyID(key.Public(), RFC5280)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLen:            1,
+		Issuer:                pkix.Name{CommonName: cn},
+		Subject:               pkix.Name{CommonName: cn},
+		SerialNumber:          big.NewInt(1),
+		SubjectKeyId:          skid,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestCrossSign(t *testing.T) {
+	now := time.Now()
+	oldRoot, oldKey := mustRoot(t, "Old Root", now.Add(24*time.Hour))
+	newRoot, newKey := mustRoot(t, "New Root", now.Add(48*time.Hour))
+	_, otherKey := mustRoot(t, "Other Root", now.Add(48*time.Hour))
+
+	// A leaf signed by the new root.
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		DNSNames:     []string{"leaf"},
+		SerialNumber: big.NewInt(2),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, newRoot, leafKey.Public(), newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafCert := &x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}}
+
+	type args struct {
+		newRoot *x509.Certificate
+		oldRoot *x509.Certificate
+		signer  crypto.Signer
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{newRoot, oldRoot, oldKey}, false},
+		{"fail new root nil", args{nil, oldRoot, oldKey}, true},
+		{"fail old root nil", args{newRoot, nil, oldKey}, true},
+		{"fail signer nil", args{newRoot, oldRoot, nil}, true},
+		{"fail new root not ca", args{leafCert, oldRoot, oldKey}, true},
+		{"fail old root not ca", args{newRoot, leafCert, oldKey}, true},
+		{"fail signer", args{newRoot, oldRoot, otherKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CrossSign(tt.args.newRoot, tt.args.oldRoot, tt.args.signer)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CrossSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Subject.String() != newRoot.Subject.String() {
+				t.Errorf("CrossSign() subject = %v, want %v", got.Subject, newRoot.Subject)
+			}
+			if got.Issuer.String() != oldRoot.Subject.String() {
+				t.Errorf("CrossSign() issuer = %v, want %v", got.Issuer, oldRoot.Subject)
+			}
+			if !got.NotAfter.Equal(oldRoot.NotAfter) {
+				t.Errorf("CrossSign() notAfter = %v, want %v", got.NotAfter, oldRoot.NotAfter)
+			}
+
+			// The leaf verifies with the old root using the cross-signed
+			// certificate as an intermediate.
+			roots := x509.NewCertPool()
+			roots.AddCert(oldRoot)
+			intermediates := x509.NewCertPool()
+			intermediates.AddCert(got)
+			if _, err := leaf.Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+			}); err != nil {
+				t.Errorf("leaf.Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCrossSignIntermediate(t *testing.T) {
+	now := time.Now()
+	root, rootKey := mustRoot(t, "Root", now.Add(48*time.Hour))
+	externalRoot, externalKey := mustRoot(t, "External Root", now.Add(24*time.Hour))
+	_, otherKey := mustRoot(t, "Other Root", now.Add(48*time.Hour))
+
+	// The intermediate signed by the root.
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skid, err := SubjectKeyID(intermediateKey.Public(), RFC5280)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(36 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		Subject:               pkix.Name{CommonName: "Intermediate"},
+		SerialNumber:          big.NewInt(2),
+		SubjectKeyId:          skid,
+	}, root, intermediateKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A leaf signed by the intermediate.
+	b, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		DNSNames:     []string{"leaf"},
+		SerialNumber: big.NewInt(3),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, intermediate, intermediateKey.Public(), intermediateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafCert := &x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}}
+
+	type args struct {
+		intermediate *x509.Certificate
+		root         *x509.Certificate
+		signer       crypto.Signer
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{intermediate, externalRoot, externalKey}, false},
+		{"fail intermediate nil", args{nil, externalRoot, externalKey}, true},
+		{"fail root nil", args{intermediate, nil, externalKey}, true},
+		{"fail signer nil", args{intermediate, externalRoot, nil}, true},
+		{"fail intermediate not ca", args{leafCert, externalRoot, externalKey}, true},
+		{"fail root not ca", args{intermediate, leafCert, externalKey}, true},
+		{"fail signer", args{intermediate, externalRoot, otherKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CrossSignIntermediate(tt.args.intermediate, tt.args.root, tt.args.signer)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CrossSignIntermediate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Subject.String() != intermediate.Subject.String() {
+				t.Errorf("CrossSignIntermediate() subject = %v, want %v", got.Subject, intermediate.Subject)
+			}
+			if got.Issuer.String() != externalRoot.Subject.String() {
+				t.Errorf("CrossSignIntermediate() issuer = %v, want %v", got.Issuer, externalRoot.Subject)
+			}
+			if !bytes.Equal(got.SubjectKeyId, intermediate.SubjectKeyId) {
+				t.Errorf("CrossSignIntermediate() subjectKeyId = %x, want %x", got.SubjectKeyId, intermediate.SubjectKeyId)
+			}
+			if !bytes.Equal(got.AuthorityKeyId, externalRoot.SubjectKeyId) {
+				t.Errorf("CrossSignIntermediate() authorityKeyId = %x, want %x", got.AuthorityKeyId, externalRoot.SubjectKeyId)
+			}
+			if !got.NotAfter.Equal(externalRoot.NotAfter) {
+				t.Errorf("CrossSignIntermediate() notAfter = %v, want %v", got.NotAfter, externalRoot.NotAfter)
+			}
+
+			// The leaf verifies with both roots.
+			for _, r := range []struct {
+				root, intermediate *x509.Certificate
+			}{{root, intermediate}, {externalRoot, got}} {
+				roots := x509.NewCertPool()
+				roots.AddCert(r.root)
+				intermediates := x509.NewCertPool()
+				intermediates.AddCert(r.intermediate)
+				if _, err := leaf.Verify(x509.VerifyOptions{
+					Roots:         roots,
+					Intermediates: intermediates,
+				}); err != nil {
+					t.Errorf("leaf.Verify() with %s error = %v", r.root.Subject.CommonName, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package pkiutil
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	oidExtensionSubjectKeyID     = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidExtensionKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionAuthorityKeyID   = asn1.ObjectIdentifier{2, 5, 29, 35}
+)
+
+// keyUsageCertSignBit is the position of keyCertSign in the key usage bit
+// string, see RFC 5280, section 4.2.1.3.
+const keyUsageCertSignBit = 5
+
+// ReadCSRExtensions reads the certificate signing request in the given PEM or
+// DER file and returns the extensions it requests, see ParseCSRExtensions.
+func ReadCSRExtensions(filename string) ([]pkix.Extension, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	exts, err := ParseCSRExtensions(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", filename)
+	}
+	return exts, nil
+}
+
+// ParseCSRExtensions parses the given PEM or DER certificate signing request,
+// checks its signature, and returns the extensions it requests so they can be
+// added to an intermediate certificate. Extensions that would break the PKI
+// are rejected: a basic constraints extension that is not a CA, a key usage
+// without certificate signing, and the subject and authority key identifiers,
+// that are always computed when the certificate is signed.
+func ParseCSRExtensions(b []byte) ([]pkix.Extension, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, errors.Errorf("unexpected PEM block type %s, expected CERTIFICATE REQUEST", block.Type)
+		}
+		b = block.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate request")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.Wrap(err, "error validating certificate request signature")
+	}
+
+	for _, ext := range csr.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionBasicConstraints):
+			var bc struct {
+				IsCA       bool `asn1:"optional"`
+				MaxPathLen int  `asn1:"optional,default:-1"`
+			}
+			if rest, err := asn1.Unmarshal(ext.Value, &bc); err != nil || len(rest) > 0 {
+				return nil, errors.New("intermediate CSR extension basicConstraints is not valid")
+			}
+			if !bc.IsCA {
+				return nil, errors.New("intermediate CSR extension basicConstraints is not allowed: the intermediate must be a CA")
+			}
+		case ext.Id.Equal(oidExtensionKeyUsage):
+			var ku asn1.BitString
+			if rest, err := asn1.Unmarshal(ext.Value, &ku); err != nil || len(rest) > 0 {
+				return nil, errors.New("intermediate CSR extension keyUsage is not valid")
+			}
+			if ku.At(keyUsageCertSignBit) == 0 {
+				return nil, errors.New("intermediate CSR extension keyUsage is not allowed: the intermediate must be able to sign certificates")
+			}
+		case ext.Id.Equal(oidExtensionSubjectKeyID):
+			return nil, errors.New("intermediate CSR extension subjectKeyIdentifier is not allowed: it is computed when the certificate is signed")
+		case ext.Id.Equal(oidExtensionAuthorityKeyID):
+			return nil, errors.New("intermediate CSR extension authorityKeyIdentifier is not allowed: it is computed when the certificate is signed")
+		}
+	}
+
+	return csr.Extensions, nil
+}
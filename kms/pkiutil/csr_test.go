@@ -0,0 +1,117 @@
+package pkiutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mustCSR(t *testing.T, exts ...pkix.Extension) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "Test Intermediate"},
+		ExtraExtensions: exts,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustExtension(t *testing.T, oid asn1.ObjectIdentifier, v interface{}) pkix.Extension {
+	t.Helper()
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oid, Value: b}
+}
+
+func TestParseCSRExtensions(t *testing.T) {
+	type basicConstraints struct {
+		IsCA       bool `asn1:"optional"`
+		MaxPathLen int  `asn1:"optional,default:-1"`
+	}
+
+	policy := mustExtension(t, asn1.ObjectIdentifier{2, 5, 29, 32}, []asn1.ObjectIdentifier{{1, 2, 3, 4}})
+	custom := mustExtension(t, asn1.ObjectIdentifier{1, 2, 3, 4, 5}, "custom")
+	ca := mustExtension(t, oidExtensionBasicConstraints, basicConstraints{IsCA: true, MaxPathLen: 0})
+	notCA := mustExtension(t, oidExtensionBasicConstraints, basicConstraints{MaxPathLen: -1})
+	certSign := mustExtension(t, oidExtensionKeyUsage, asn1.BitString{Bytes: []byte{0x06}, BitLength: 7})
+	digitalSignature := mustExtension(t, oidExtensionKeyUsage, asn1.BitString{Bytes: []byte{0x80}, BitLength: 1})
+	skid := mustExtension(t, oidExtensionSubjectKeyID, []byte{1, 2, 3, 4})
+	akid := mustExtension(t, oidExtensionAuthorityKeyID, []byte{1, 2, 3, 4})
+
+	der := mustCSR(t, policy, custom)
+	badSignature := append([]byte(nil), der...)
+	badSignature[len(badSignature)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    []pkix.Extension
+		wantErr bool
+	}{
+		{"ok der", der, []pkix.Extension{policy, custom}, false},
+		{"ok pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), []pkix.Extension{policy, custom}, false},
+		{"ok no extensions", mustCSR(t), nil, false},
+		{"ok ca", mustCSR(t, ca, certSign), []pkix.Extension{ca, certSign}, false},
+		{"fail pem type", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil, true},
+		{"fail parse", []byte("not a csr"), nil, true},
+		{"fail signature", badSignature, nil, true},
+		{"fail not ca", mustCSR(t, notCA), nil, true},
+		{"fail key usage", mustCSR(t, digitalSignature), nil, true},
+		{"fail subject key id", mustCSR(t, skid), nil, true},
+		{"fail authority key id", mustCSR(t, akid), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCSRExtensions(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCSRExtensions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("ParseCSRExtensions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSRExtensions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkiutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	custom := mustExtension(t, asn1.ObjectIdentifier{1, 2, 3, 4, 5}, "custom")
+	filename := filepath.Join(dir, "intermediate.csr")
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: mustCSR(t, custom)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadCSRExtensions(filename)
+	if err != nil {
+		t.Fatalf("ReadCSRExtensions() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []pkix.Extension{custom}) {
+		t.Errorf("ReadCSRExtensions() = %v, want %v", got, []pkix.Extension{custom})
+	}
+	if _, err := ReadCSRExtensions(filepath.Join(dir, "missing.csr")); err == nil {
+		t.Error("ReadCSRExtensions() error = nil, want error")
+	}
+}
@@ -0,0 +1,53 @@
+package pkiutil
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// Environment variables used to detect the KMS available.
+var (
+	cloudKMSEnvironment  = []string{"GOOGLE_APPLICATION_CREDENTIALS", "GOOGLE_CLOUD_PROJECT"}
+	amazonKMSEnvironment = []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_ACCESS_KEY_ID"}
+)
+
+// ParseKMSType returns the KMS type with the given name, one of cloudkms,
+// awskms, yubikey or pkcs11.
+func ParseKMSType(name string) (apiv1.Type, error) {
+	switch t := apiv1.Type(strings.ToLower(strings.TrimSpace(name))); t {
+	case apiv1.CloudKMS, apiv1.AmazonKMS, apiv1.YubiKey, apiv1.PKCS11:
+		return t, nil
+	default:
+		return apiv1.DefaultKMS, errors.Errorf("unsupported kms type '%s'", name)
+	}
+}
+
+// DetectKMSType returns the KMS type configured in the environment, using the
+// given function to get the environment variables, e.g. os.Getenv. Cloud KMS
+// is detected using the Google application credentials or project, and AWS KMS
+// using the AWS region, profile or access key. A YubiKey cannot be detected.
+// It returns an error if none or both of them are configured.
+func DetectKMSType(getenv func(string) string) (apiv1.Type, error) {
+	isSet := func(names []string) bool {
+		for _, name := range names {
+			if getenv(name) != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	cloudKMS, amazonKMS := isSet(cloudKMSEnvironment), isSet(amazonKMSEnvironment)
+	switch {
+	case cloudKMS && amazonKMS:
+		return apiv1.DefaultKMS, errors.New("error detecting kms: both Cloud KMS and AWS KMS are configured in the environment")
+	case cloudKMS:
+		return apiv1.CloudKMS, nil
+	case amazonKMS:
+		return apiv1.AmazonKMS, nil
+	default:
+		return apiv1.DefaultKMS, errors.New("error detecting kms: neither Cloud KMS nor AWS KMS are configured in the environment")
+	}
+}
@@ -0,0 +1,73 @@
+package pkiutil
+
+import (
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestParseKMSType(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    apiv1.Type
+		wantErr bool
+	}{
+		{"cloudkms", apiv1.CloudKMS, false},
+		{"awskms", apiv1.AmazonKMS, false},
+		{"YubiKey", apiv1.YubiKey, false},
+		{" pkcs11 ", apiv1.PKCS11, false},
+		{"", apiv1.DefaultKMS, true},
+		{"softkms", apiv1.DefaultKMS, true},
+		{"foo", apiv1.DefaultKMS, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKMSType(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKMSType() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseKMSType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectKMSType(t *testing.T) {
+	env := func(kv ...string) func(string) string {
+		m := make(map[string]string)
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i]] = kv[i+1]
+		}
+		return func(key string) string {
+			return m[key]
+		}
+	}
+
+	tests := []struct {
+		name    string
+		getenv  func(string) string
+		want    apiv1.Type
+		wantErr bool
+	}{
+		{"cloudkms credentials", env("GOOGLE_APPLICATION_CREDENTIALS", "/path/to/credentials.json"), apiv1.CloudKMS, false},
+		{"cloudkms project", env("GOOGLE_CLOUD_PROJECT", "project"), apiv1.CloudKMS, false},
+		{"awskms region", env("AWS_REGION", "us-east-1"), apiv1.AmazonKMS, false},
+		{"awskms profile", env("AWS_PROFILE", "default", "HOME", "/root"), apiv1.AmazonKMS, false},
+		{"fail both", env("GOOGLE_CLOUD_PROJECT", "project", "AWS_REGION", "us-east-1"), apiv1.DefaultKMS, true},
+		{"fail none", env("HOME", "/root"), apiv1.DefaultKMS, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectKMSType(tt.getenv)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DetectKMSType() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("DetectKMSType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,568 @@
+package pkiutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+)
+
+// Names of the files with the public keys of the SSH CA written by
+// CreateSSHKeys.
+const (
+	SSHUserPublicKeyFile = "ssh_user_ca_key.pub"
+	SSHHostPublicKeyFile = "ssh_host_ca_key.pub"
+)
+
+// Init implements the steps shared by the init tools once the flags have been
+// parsed: it writes the created certificates and prints the URIs of their
+// keys, cross-signs the certificates with other roots, creates the SSH CA keys
+// and writes a starter ca.json.
+type Init struct {
+	// KMS is the key manager where the keys are created.
+	KMS apiv1.KeyManager
+	// Dir is the directory where the certificates are written, it defaults to
+	// the current directory.
+	Dir string
+	// FileMode is the permission of the written certificates and keys.
+	FileMode os.FileMode
+	// Format is the encoding of the written certificates.
+	Format CertificateFormat
+	// DER writes a copy in binary DER of the root and intermediate
+	// certificates.
+	DER bool
+	// StoreCerts stores the certificates in the KMS with their keys, the KMS
+	// must implement the CertificateManager interface.
+	StoreCerts bool
+	// Quiet disables the output of the progress and the created keys and
+	// certificates, warnings and errors are still printed to stderr.
+	Quiet bool
+}
+
+// PrintSelected prints the given name and value unless Quiet is set.
+func (i *Init) PrintSelected(name, value string) {
+	if !i.Quiet {
+		ui.PrintSelected(name, value)
+	}
+}
+
+// PrintLine prints the given values unless Quiet is set.
+func (i *Init) PrintLine(a ...interface{}) {
+	if !i.Quiet {
+		ui.Println(a...)
+	}
+}
+
+// PrintNoIntermediateWarning prints to stderr how to use a PKI without
+// intermediates.
+func (i *Init) PrintNoIntermediateWarning() {
+	i.PrintLine()
+	fmt.Fprintln(os.Stderr, "⚠️  No intermediate certificate has been created, the root key will sign the")
+	fmt.Fprintln(os.Stderr, "   leaf certificates directly. Configure the root certificate and key as the")
+	fmt.Fprintln(os.Stderr, "   `crt` and `key` in the ca.json. The root key must always be available to")
+	fmt.Fprintln(os.Stderr, "   the CA, and if it is compromised the whole PKI must be replaced.")
+}
+
+// WriteCertificate writes the given certificate in the configured format to
+// the file with the given base name, and a copy in binary DER if DER is set.
+// The files are printed with the given label, and the name of the first file
+// is returned.
+func (i *Init) WriteCertificate(label, base string, cert *x509.Certificate) (string, error) {
+	base = filepath.Join(i.Dir, base)
+	filename := i.Format.Filename(base)
+	if err := utils.WriteFile(filename, i.Format.Encode(cert.Raw), i.FileMode); err != nil {
+		return "", err
+	}
+	i.PrintSelected(label+" Certificate", filename)
+
+	if i.DER {
+		derFile := DERFilename(base)
+		if err := utils.WriteFile(derFile, cert.Raw, i.FileMode); err != nil {
+			return "", err
+		}
+		i.PrintSelected(label+" Certificate (DER)", derFile)
+	}
+
+	return filename, nil
+}
+
+// WritePKI writes the root and intermediate certificates of the given PKI and
+// sets them in the given CAConfig, the first intermediate is the one used in
+// the ca.json, or the root if the PKI does not have intermediates. If cross is
+// not nil the intermediates are also cross-signed with it.
+func (i *Init) WritePKI(pki *PKI, cross *CrossSigner, ca *CAConfig) error {
+	if err := i.WriteRoot(pki.Root, ca); err != nil {
+		return err
+	}
+
+	if len(pki.Intermediates) == 0 {
+		ca.Crt, ca.Key = ca.Root, pki.Root.KeyURI
+		i.PrintNoIntermediateWarning()
+		return nil
+	}
+
+	for _, in := range pki.Intermediates {
+		if err := i.WriteIntermediate(in, ca); err != nil {
+			return err
+		}
+		if cross != nil {
+			if err := i.WriteCrossSignedIntermediate(in, cross); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteRoot prints the root key, writes the root certificate to root_ca.crt,
+// or root_ca.cer, and sets it in the given CAConfig.
+func (i *Init) WriteRoot(root *PKICertificate, ca *CAConfig) error {
+	if err := i.storeCertificate("Root", root); err != nil {
+		return err
+	}
+	i.printKey("Root Key", root.KeyURI, root.Key)
+
+	filename, err := i.WriteCertificate("Root", "root_ca", root.Certificate)
+	if err != nil {
+		return err
+	}
+	ca.Root = filename
+	return nil
+}
+
+// WriteIntermediate prints the key of the given intermediate and writes its
+// certificate to intermediate_ca.crt, or intermediate_ca_<name>.crt if it has
+// a name. The first intermediate written is the one set in the given
+// CAConfig.
+func (i *Init) WriteIntermediate(in *PKICertificate, ca *CAConfig) error {
+	label, base := intermediateFile(in.Name)
+	if err := i.storeCertificate(label, in); err != nil {
+		return err
+	}
+	i.printKey(label+" Key", in.KeyURI, in.Key)
+
+	filename, err := i.WriteCertificate(label, base, in.Certificate)
+	if err != nil {
+		return err
+	}
+	if ca.Crt == "" {
+		ca.Crt, ca.Key = filename, in.KeyURI
+	}
+	return nil
+}
+
+// WriteCrossSignedIntermediate cross-signs the given intermediate with the
+// external root and writes it to intermediate_ca_cross.crt, or
+// intermediate_ca_<name>_cross.crt if it has a name.
+func (i *Init) WriteCrossSignedIntermediate(in *PKICertificate, cross *CrossSigner) error {
+	label, base := intermediateFile(in.Name)
+
+	cert, err := CrossSignIntermediate(in.Certificate, cross.Root, cross.Signer)
+	if err != nil {
+		return err
+	}
+
+	_, err = i.WriteCertificate("Cross-Signed "+label, base+CrossSignedSuffix, cert)
+	return err
+}
+
+// intermediateFile returns the label and the base file name of the
+// intermediate with the given name.
+func intermediateFile(name string) (string, string) {
+	if name == "" {
+		return "Intermediate", "intermediate_ca"
+	}
+	return "Intermediate " + name, "intermediate_ca_" + name
+}
+
+// printKey prints the URI of a key with the given label, its version in a KMS
+// with versioned keys like Cloud KMS, so it can be recorded, and the replicas
+// of a multi-region key in AWS KMS.
+func (i *Init) printKey(label, keyURI string, resp *apiv1.CreateKeyResponse) {
+	i.PrintSelected(label, keyURI)
+	if resp == nil {
+		return
+	}
+	if v := resp.CreateSignerRequest.KeyVersion; v != "" {
+		i.PrintSelected(label+" Version", v)
+	}
+	for _, replica := range resp.ReplicaKeyURIs {
+		i.PrintSelected(label+" Replica", replica)
+	}
+}
+
+// storeCertificate stores the certificate with its key if StoreCerts is set,
+// and reads it back to verify that the KMS stored it. The serial number and
+// fingerprint printed, and the certificate written afterwards, are the ones of
+// the certificate read back.
+func (i *Init) storeCertificate(label string, c *PKICertificate) error {
+	if !i.StoreCerts || c.Key == nil {
+		return nil
+	}
+	if err := StoreCertificate(i.KMS, c.Key.Name, c.Certificate); err != nil {
+		return err
+	}
+	stored, err := VerifyStoredCertificate(i.KMS, c.Key.Name, c.Certificate)
+	if err != nil {
+		return err
+	}
+	keyURI, err := KeyURI(i.KMS, c.Key.Name)
+	if err != nil {
+		return err
+	}
+	i.PrintSelected(label+" Certificate Stored", keyURI)
+	i.PrintSelected(label+" Certificate Serial", stored.SerialNumber.String())
+	i.PrintSelected(label+" Certificate Fingerprint", x509util.Fingerprint(stored))
+	c.Certificate = stored
+	return nil
+}
+
+// CrossSigner is an external root certificate and its key, used to cross-sign
+// the intermediates of a PKI.
+type CrossSigner struct {
+	Root   *x509.Certificate
+	Signer crypto.Signer
+}
+
+// LoadCrossSigner reads the root certificate in the given file and creates a
+// signer with the given key in the KMS. It is used before creating any key, so
+// a wrong root or key does not leave unused keys.
+func (i *Init) LoadCrossSigner(rootFile, key string) (*CrossSigner, error) {
+	root, err := pemutil.ReadCertificate(rootFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := i.KMS.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CrossSigner{Root: root, Signer: signer}, nil
+}
+
+// AppendToChain signs the new root certificate in newRootFile with the old
+// root in oldRootFile and its key, and writes it to CrossSignedRootFile in PEM.
+// Clients trusting only the old root can use the cross-signed certificate to
+// verify the chains of the new one.
+func (i *Init) AppendToChain(newRootFile, oldRootFile, oldKey string) error {
+	newRoot, err := pemutil.ReadCertificate(newRootFile)
+	if err != nil {
+		return err
+	}
+	cross, err := i.LoadCrossSigner(oldRootFile, oldKey)
+	if err != nil {
+		return err
+	}
+
+	cert, err := CrossSign(newRoot, cross.Root, cross.Signer)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(i.Dir, CrossSignedRootFile)
+	if err = utils.WriteFile(filename, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	}), i.FileMode); err != nil {
+		return err
+	}
+
+	i.PrintSelected("Cross-Signed Root Certificate", filename)
+	return nil
+}
+
+// KeyRequest returns a copy of the given request with the given name appended
+// to its name. The init tools use a single request with the options of all the
+// keys, and a name with the prefix of the keys, e.g. the key ring in Cloud KMS.
+func KeyRequest(template apiv1.CreateKeyRequest, name string) *apiv1.CreateKeyRequest {
+	template.Name += name
+	return &template
+}
+
+// IntermediateKeyName returns the name of the key of the intermediate with the
+// given name, "intermediate" for the default intermediate, or
+// "intermediate-<name>".
+func IntermediateKeyName(name string) string {
+	if name == "" {
+		return "intermediate"
+	}
+	return "intermediate-" + name
+}
+
+// IntermediateKeyRequest returns the request used to create the key of the
+// given intermediate, see KeyRequest. The key type of the intermediate, if it
+// is set, overrides the one in the given request.
+func IntermediateKeyRequest(template apiv1.CreateKeyRequest, in Intermediate) *apiv1.CreateKeyRequest {
+	if in.SignatureAlgorithm != apiv1.UnspecifiedSignAlgorithm {
+		template.SignatureAlgorithm, template.Bits = in.SignatureAlgorithm, in.Bits
+	}
+	return KeyRequest(template, IntermediateKeyName(in.Name))
+}
+
+// CheckFIPSKeyTypes returns an error if the FIPS-only mode is enabled and any
+// of the given key types is not approved. Key types without a signature
+// algorithm are ignored.
+func CheckFIPSKeyTypes(keyTypes ...Intermediate) error {
+	for _, kt := range keyTypes {
+		if kt.SignatureAlgorithm == apiv1.UnspecifiedSignAlgorithm {
+			continue
+		}
+		if err := apiv1.CheckFIPSAlgorithm(kt.SignatureAlgorithm, kt.Bits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckKeys returns an error listing the keys in the given parent with one of
+// the given names, see ExistingKeys, so the keys of a previous run are not
+// replaced by accident.
+func (i *Init) CheckKeys(parent string, names []string) error {
+	keys, err := ExistingKeys(i.KMS, parent, names)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	msg := "⚠️  Your KMS already has keys with the names used by this tool:\n"
+	for _, key := range keys {
+		if key.Key == "" || key.Key == key.Name {
+			msg += "   " + key.Name + "\n"
+		} else {
+			msg += "   " + key.Name + ": " + key.Key + "\n"
+		}
+	}
+	msg += "   If you want to create them anyway, use `--force`, or use `--reuse-keys`\n"
+	msg += "   to reuse them, e.g. to repeat a failed run."
+	return errors.New(msg)
+}
+
+// keyDestroyer is the interface implemented by the KMS that can destroy the
+// keys created, like Cloud KMS.
+type keyDestroyer interface {
+	DestroyKey(name string) error
+}
+
+// CreateKeys creates concurrently the keys with the given requests, the key
+// generation can be slow, specially in an HSM. The responses are in the same
+// order as the requests. If the creation of any key fails and destroy is set,
+// the keys already created are destroyed if the KMS supports it.
+func (i *Init) CreateKeys(reqs []*apiv1.CreateKeyRequest, destroy bool) ([]*apiv1.CreateKeyResponse, error) {
+	var wg sync.WaitGroup
+	resps := make([]*apiv1.CreateKeyResponse, len(reqs))
+	errs := make([]error, len(reqs))
+	for n, req := range reqs {
+		wg.Add(1)
+		go func(n int, req *apiv1.CreateKeyRequest) {
+			defer wg.Done()
+			resps[n], errs[n] = i.KMS.CreateKey(req)
+		}(n, req)
+	}
+	wg.Wait()
+
+	for n, err := range errs {
+		if err != nil {
+			if destroy {
+				i.destroyKeys(resps)
+			}
+			return nil, errors.Wrapf(err, "error creating key %s", reqs[n].Name)
+		}
+	}
+	return resps, nil
+}
+
+// destroyKeys destroys the given keys, it is used to not leave unused keys if
+// the PKI cannot be created.
+func (i *Init) destroyKeys(resps []*apiv1.CreateKeyResponse) {
+	kd, ok := i.KMS.(keyDestroyer)
+	if !ok {
+		return
+	}
+	for _, resp := range resps {
+		if resp == nil {
+			continue
+		}
+		if err := kd.DestroyKey(resp.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Key %s could not be destroyed: %v\n", resp.Name, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Key %s has been scheduled for destruction.\n", resp.Name)
+	}
+}
+
+// RotateIntermediates creates new versions of the keys of the intermediates
+// in the options, using the KeyRotator interface, and re-issues their
+// certificates with the root in rootFile and its key. The names of the keys do
+// not change, but the ca.json must use the new key versions.
+func (i *Init) RotateIntermediates(rootFile, rootKey string, opts PKIOptions, ca *CAConfig) error {
+	kr, ok := i.KMS.(apiv1.KeyRotator)
+	if !ok {
+		return errors.Errorf("%T does not support rotating keys", i.KMS)
+	}
+
+	root, err := pemutil.ReadCertificate(rootFile)
+	if err != nil {
+		return err
+	}
+	signer, err := i.KMS.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: rootKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Prime the connection and credentials before the first real signature.
+	if !opts.NoWarmup {
+		if err := Warmup(signer); err != nil {
+			return err
+		}
+	}
+
+	ca.Root = rootFile
+	for _, in := range opts.Intermediates {
+		resp, err := kr.RotateKey(&apiv1.RotateKeyRequest{
+			Name: in.Key.Name,
+		})
+		if err != nil {
+			return err
+		}
+		cert, err := CreateIntermediateCertificate(resp.PublicKey, in, root, signer, opts)
+		if err != nil {
+			return err
+		}
+		keyURI, err := CreatedKeyURI(i.KMS, resp)
+		if err != nil {
+			return err
+		}
+		if err := i.WriteIntermediate(&PKICertificate{
+			Name:        in.Name,
+			Certificate: cert,
+			Key:         resp,
+			KeyURI:      keyURI,
+		}, ca); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateIntermediateCSR creates the intermediate key with the given request
+// and writes to csrFile a certificate signing request signed by it, so the
+// intermediate can be issued by an offline root.
+func (i *Init) CreateIntermediateCSR(req *apiv1.CreateKeyRequest, csrFile string, noWarmup bool) error {
+	resp, err := i.KMS.CreateKey(req)
+	if err != nil {
+		return err
+	}
+
+	signer, err := i.KMS.CreateSigner(&resp.CreateSignerRequest)
+	if err != nil {
+		return err
+	}
+
+	// Prime the connection and credentials before the first real signature.
+	if !noWarmup {
+		if err := Warmup(signer); err != nil {
+			return err
+		}
+	}
+
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: DefaultIntermediateCommonName},
+	}, signer)
+	if err != nil {
+		return errors.Wrap(err, "error creating certificate request")
+	}
+
+	if err = utils.WriteFile(csrFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: b,
+	}), i.FileMode); err != nil {
+		return err
+	}
+
+	keyURI, err := CreatedKeyURI(i.KMS, resp)
+	if err != nil {
+		return err
+	}
+	i.printKey("Intermediate Key", keyURI, resp)
+	i.PrintSelected("Intermediate CSR", csrFile)
+	return nil
+}
+
+// CreateSSHKeys creates the SSH user and host CA keys with the given requests,
+// writes their public keys to SSHUserPublicKeyFile and SSHHostPublicKeyFile,
+// and sets them in the given CAConfig. The name identifies the SSH CA in the
+// comments of the public keys.
+func (i *Init) CreateSSHKeys(name string, user, host *apiv1.CreateKeyRequest, ca *CAConfig) error {
+	// All the keys share the creation date in their comments.
+	now := time.Now()
+	for _, key := range []struct {
+		label, filename string
+		req             *apiv1.CreateKeyRequest
+		uri             *string
+	}{
+		{"SSH User", SSHUserPublicKeyFile, user, &ca.SSHUserKey},
+		{"SSH Host", SSHHostPublicKeyFile, host, &ca.SSHHostKey},
+	} {
+		resp, err := i.KMS.CreateKey(key.req)
+		if err != nil {
+			return err
+		}
+
+		keyURI, err := CreatedKeyURI(i.KMS, resp)
+		if err != nil {
+			return err
+		}
+
+		b, err := SSHAuthorizedKey(resp.PublicKey, SSHCAComment(name+" "+key.label+" CA", keyURI, now))
+		if err != nil {
+			return err
+		}
+
+		filename := filepath.Join(i.Dir, key.filename)
+		if err = utils.WriteFile(filename, b, i.FileMode); err != nil {
+			return err
+		}
+
+		i.PrintSelected(key.label+" Public Key", filename)
+		i.printKey(key.label+" Private Key", keyURI, resp)
+		*key.uri = keyURI
+	}
+
+	return nil
+}
+
+// WriteCAConfig prompts the password of the provisioner and writes a starter
+// ca.json with the given configuration to the given file, see WriteCAConfig.
+func (i *Init) WriteCAConfig(filename string, ca *CAConfig) error {
+	pass, err := ui.PromptPasswordGenerate("What do you want your provisioner password to be? [leave empty and we'll generate one]",
+		ui.WithRichPrompt())
+	if err != nil {
+		return err
+	}
+	if err := WriteCAConfig(filename, *ca, pass); err != nil {
+		return err
+	}
+	i.PrintSelected("CA Configuration", filename)
+	i.PrintSelected("Provisioner", DefaultProvisionerName)
+	return nil
+}
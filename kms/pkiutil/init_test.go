@@ -0,0 +1,485 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// lockedKeyManager is a memoryKeyManager that can create keys concurrently and
+// destroy them.
+type lockedKeyManager struct {
+	*memoryKeyManager
+	mu        sync.Mutex
+	destroyed []string
+}
+
+func (m *lockedKeyManager) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.memoryKeyManager.CreateKey(req)
+}
+
+func (m *lockedKeyManager) DestroyKey(name string) error {
+	m.destroyed = append(m.destroyed, name)
+	return nil
+}
+
+// storingKeyManager is a memoryKeyManager that stores certificates.
+type storingKeyManager struct {
+	*memoryKeyManager
+	certs    map[string]*x509.Certificate
+	loadWith *x509.Certificate
+}
+
+func (m *storingKeyManager) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	if m.loadWith != nil {
+		return m.loadWith, nil
+	}
+	cert, ok := m.certs[req.Name]
+	if !ok {
+		return nil, errors.New("certificate not found")
+	}
+	return cert, nil
+}
+
+func (m *storingKeyManager) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
+	m.certs[req.Name] = req.Certificate
+	return nil
+}
+
+func (m *storingKeyManager) DeleteCertificate(req *apiv1.DeleteCertificateRequest) error {
+	delete(m.certs, req.Name)
+	return nil
+}
+
+// rotatingKeyManager is a memoryKeyManager that rotates keys.
+type rotatingKeyManager struct {
+	*memoryKeyManager
+}
+
+func (m *rotatingKeyManager) RotateKey(req *apiv1.RotateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	resp, err := m.CreateKey(&apiv1.CreateKeyRequest{Name: req.Name})
+	if err != nil {
+		return nil, err
+	}
+	resp.CreateSignerRequest.KeyVersion = "2"
+	return resp, nil
+}
+
+func newTestInit(t *testing.T, k apiv1.KeyManager) *Init {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "pkiutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &Init{
+		KMS:      k,
+		Dir:      dir,
+		FileMode: 0600,
+		Format:   PEMFormat,
+		Quiet:    true,
+	}
+}
+
+func mustCreatePKI(t *testing.T, k apiv1.KeyManager, names ...string) *PKI {
+	t.Helper()
+	opts := PKIOptions{
+		RootKey:    &apiv1.CreateKeyRequest{Name: "root"},
+		SKIDMethod: RFC5280,
+		NoWarmup:   true,
+	}
+	for _, name := range names {
+		opts.Intermediates = append(opts.Intermediates, PKIIntermediate{
+			Name: name,
+			Key:  &apiv1.CreateKeyRequest{Name: IntermediateKeyName(name)},
+		})
+	}
+	pki, err := CreatePKI(k, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pki
+}
+
+func readCertificate(t *testing.T, filename string) *x509.Certificate {
+	t.Helper()
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestInit_WriteCertificate(t *testing.T) {
+	pki := mustCreatePKI(t, newMemoryKeyManager())
+	cert := pki.Root.Certificate
+
+	tests := []struct {
+		name      string
+		format    CertificateFormat
+		der       bool
+		wantFiles []string
+	}{
+		{"ok pem", PEMFormat, false, []string{"root_ca.crt"}},
+		{"ok der", DERFormat, false, []string{"root_ca.cer"}},
+		{"ok pem and der", PEMFormat, true, []string{"root_ca.crt", "root_ca.der"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestInit(t, newMemoryKeyManager())
+			p.Format, p.DER = tt.format, tt.der
+
+			got, err := p.WriteCertificate("Root", "root_ca", cert)
+			if err != nil {
+				t.Fatalf("Init.WriteCertificate() error = %v", err)
+			}
+			if want := filepath.Join(p.Dir, tt.wantFiles[0]); got != want {
+				t.Errorf("Init.WriteCertificate() = %v, want %v", got, want)
+			}
+			for _, name := range tt.wantFiles {
+				if c := readCertificate(t, filepath.Join(p.Dir, name)); !bytes.Equal(c.Raw, cert.Raw) {
+					t.Errorf("Init.WriteCertificate() %s does not match the certificate", name)
+				}
+			}
+		})
+	}
+
+	t.Run("fail write", func(t *testing.T) {
+		p := newTestInit(t, newMemoryKeyManager())
+		p.Dir = filepath.Join(p.Dir, "missing")
+		if _, err := p.WriteCertificate("Root", "root_ca", cert); err == nil {
+			t.Error("Init.WriteCertificate() error = nil, want error")
+		}
+	})
+}
+
+func TestInit_WritePKI(t *testing.T) {
+	k := newMemoryKeyManager()
+	cross := &CrossSigner{}
+	crossPKI := mustCreatePKI(t, newMemoryKeyManager())
+	cross.Root, cross.Signer = crossPKI.Root.Certificate, crossPKI.Signer
+
+	tests := []struct {
+		name      string
+		pki       *PKI
+		cross     *CrossSigner
+		wantCrt   string
+		wantKey   string
+		wantFiles []string
+	}{
+		{"ok", mustCreatePKI(t, k, ""), nil, "intermediate_ca.crt", "memory:intermediate", []string{"root_ca.crt", "intermediate_ca.crt"}},
+		{"ok multiple", mustCreatePKI(t, k, "tls", ""), nil, "intermediate_ca_tls.crt", "memory:intermediate-tls", []string{"root_ca.crt", "intermediate_ca_tls.crt", "intermediate_ca.crt"}},
+		{"ok cross", mustCreatePKI(t, k, "tls"), cross, "intermediate_ca_tls.crt", "memory:intermediate-tls", []string{"root_ca.crt", "intermediate_ca_tls.crt", "intermediate_ca_tls_cross.crt"}},
+		{"ok no intermediate", mustCreatePKI(t, k), nil, "root_ca.crt", "memory:root", []string{"root_ca.crt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestInit(t, k)
+			ca := &CAConfig{}
+			if err := p.WritePKI(tt.pki, tt.cross, ca); err != nil {
+				t.Fatalf("Init.WritePKI() error = %v", err)
+			}
+			want := &CAConfig{
+				Root: filepath.Join(p.Dir, "root_ca.crt"),
+				Crt:  filepath.Join(p.Dir, tt.wantCrt),
+				Key:  tt.wantKey,
+			}
+			if !reflect.DeepEqual(ca, want) {
+				t.Errorf("Init.WritePKI() ca = %v, want %v", ca, want)
+			}
+			for _, name := range tt.wantFiles {
+				readCertificate(t, filepath.Join(p.Dir, name))
+			}
+			if tt.cross != nil {
+				pool := x509.NewCertPool()
+				pool.AddCert(tt.cross.Root)
+				cert := readCertificate(t, filepath.Join(p.Dir, "intermediate_ca_tls_cross.crt"))
+				if _, err := cert.Verify(x509.VerifyOptions{
+					Roots:     pool,
+					KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+				}); err != nil {
+					t.Errorf("Init.WritePKI() cross-signed verify error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestInit_WritePKI_storeCerts(t *testing.T) {
+	k := &storingKeyManager{memoryKeyManager: newMemoryKeyManager(), certs: make(map[string]*x509.Certificate)}
+	pki := mustCreatePKI(t, k, "")
+
+	p := newTestInit(t, k)
+	p.StoreCerts = true
+	if err := p.WritePKI(pki, nil, &CAConfig{}); err != nil {
+		t.Fatalf("Init.WritePKI() error = %v", err)
+	}
+	for _, c := range []*PKICertificate{pki.Root, pki.Intermediates[0]} {
+		if stored := k.certs[c.Key.Name]; stored == nil || !bytes.Equal(stored.Raw, c.Certificate.Raw) {
+			t.Errorf("Init.WritePKI() certificate %s was not stored", c.Key.Name)
+		}
+	}
+
+	// The stored certificate must be the one signed.
+	k.loadWith = pki.Intermediates[0].Certificate
+	if err := p.WritePKI(pki, nil, &CAConfig{}); err == nil {
+		t.Error("Init.WritePKI() error = nil, want error")
+	}
+}
+
+func TestInit_AppendToChain(t *testing.T) {
+	k := newMemoryKeyManager()
+	oldPKI := mustCreatePKI(t, k)
+	newRoot := mustCreatePKI(t, newMemoryKeyManager()).Root.Certificate
+
+	p := newTestInit(t, k)
+	oldRootFile, err := p.WriteCertificate("Old Root", "old_root", oldPKI.Root.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRootFile, err := p.WriteCertificate("New Root", "new_root", newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		newRoot string
+		oldRoot string
+		oldKey  string
+		wantErr bool
+	}{
+		{"ok", newRootFile, oldRootFile, "root", false},
+		{"fail new root", filepath.Join(p.Dir, "missing.crt"), oldRootFile, "root", true},
+		{"fail old root", newRootFile, filepath.Join(p.Dir, "missing.crt"), "root", true},
+		{"fail old key", newRootFile, oldRootFile, "missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.AppendToChain(tt.newRoot, tt.oldRoot, tt.oldKey); (err != nil) != tt.wantErr {
+				t.Fatalf("Init.AppendToChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			cert := readCertificate(t, filepath.Join(p.Dir, CrossSignedRootFile))
+			if err := cert.CheckSignatureFrom(oldPKI.Root.Certificate); err != nil {
+				t.Errorf("Init.AppendToChain() signature error = %v", err)
+			}
+			if !bytes.Equal(cert.RawSubject, newRoot.RawSubject) {
+				t.Error("Init.AppendToChain() subject does not match the new root")
+			}
+		})
+	}
+}
+
+func TestIntermediateKeyRequest(t *testing.T) {
+	template := apiv1.CreateKeyRequest{
+		Name:               "projects/p/locations/l/keyRings/r/cryptoKeys/",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		ProtectionLevel:    apiv1.HSM,
+		Idempotent:         true,
+	}
+
+	tests := []struct {
+		name string
+		in   Intermediate
+		want *apiv1.CreateKeyRequest
+	}{
+		{"ok default", Intermediate{}, &apiv1.CreateKeyRequest{
+			Name:               "projects/p/locations/l/keyRings/r/cryptoKeys/intermediate",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			ProtectionLevel:    apiv1.HSM,
+			Idempotent:         true,
+		}},
+		{"ok key type", Intermediate{Name: "tls", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 3072}, &apiv1.CreateKeyRequest{
+			Name:               "projects/p/locations/l/keyRings/r/cryptoKeys/intermediate-tls",
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			Bits:               3072,
+			ProtectionLevel:    apiv1.HSM,
+			Idempotent:         true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IntermediateKeyRequest(template, tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IntermediateKeyRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The template is not modified.
+	if template.Name != "projects/p/locations/l/keyRings/r/cryptoKeys/" || template.Bits != 0 {
+		t.Errorf("IntermediateKeyRequest() modified the template: %v", template)
+	}
+}
+
+func TestInit_CheckKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		k       apiv1.KeyManager
+		wantErr string
+	}{
+		{"ok", &fakeKeyLister{keys: []apiv1.KeyInfo{{Name: "other"}}}, ""},
+		{"ok not lister", fakeKeyManager{}, ""},
+		{"fail existing", &fakeKeyLister{keys: []apiv1.KeyInfo{{Name: "root", Key: "key-id"}, {Name: "intermediate"}}}, "root: key-id\n   intermediate\n"},
+		{"fail list", &fakeKeyLister{err: errors.New("list failed")}, "list failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Init{KMS: tt.k, Quiet: true}
+			err := p.CheckKeys("", []string{"root", "intermediate"})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Init.CheckKeys() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Init.CheckKeys() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInit_CreateKeys(t *testing.T) {
+	reqs := []*apiv1.CreateKeyRequest{{Name: "root"}, {Name: "intermediate"}, {Name: "intermediate-tls"}}
+
+	t.Run("ok", func(t *testing.T) {
+		k := &lockedKeyManager{memoryKeyManager: newMemoryKeyManager()}
+		got, err := (&Init{KMS: k}).CreateKeys(reqs, true)
+		if err != nil {
+			t.Fatalf("Init.CreateKeys() error = %v", err)
+		}
+		for i, resp := range got {
+			if resp.Name != reqs[i].Name {
+				t.Errorf("Init.CreateKeys() key %d = %v, want %v", i, resp.Name, reqs[i].Name)
+			}
+		}
+	})
+
+	for _, destroy := range []bool{true, false} {
+		k := &lockedKeyManager{memoryKeyManager: &memoryKeyManager{keys: map[string]*ecdsa.PrivateKey{}, failOn: "intermediate"}}
+		if _, err := (&Init{KMS: k}).CreateKeys(reqs, destroy); err == nil {
+			t.Errorf("Init.CreateKeys() destroy = %v, error = nil, want error", destroy)
+		}
+		want := []string{"root", "intermediate-tls"}
+		if !destroy {
+			want = nil
+		}
+		if !reflect.DeepEqual(k.destroyed, want) {
+			t.Errorf("Init.CreateKeys() destroy = %v, destroyed = %v, want %v", destroy, k.destroyed, want)
+		}
+	}
+}
+
+func TestInit_RotateIntermediates(t *testing.T) {
+	k := &rotatingKeyManager{memoryKeyManager: newMemoryKeyManager()}
+	old := mustCreatePKI(t, k, "")
+
+	p := newTestInit(t, k)
+	rootFile, err := p.WriteCertificate("Root", "root_ca", old.Root.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := PKIOptions{
+		SKIDMethod: RFC5280,
+		NoWarmup:   true,
+		Intermediates: []PKIIntermediate{
+			{Key: &apiv1.CreateKeyRequest{Name: "intermediate"}},
+		},
+	}
+	ca := &CAConfig{}
+	if err := p.RotateIntermediates(rootFile, "root", opts, ca); err != nil {
+		t.Fatalf("Init.RotateIntermediates() error = %v", err)
+	}
+
+	cert := readCertificate(t, ca.Crt)
+	if err := cert.CheckSignatureFrom(old.Root.Certificate); err != nil {
+		t.Errorf("Init.RotateIntermediates() signature error = %v", err)
+	}
+	if bytes.Equal(cert.RawSubjectPublicKeyInfo, old.Intermediates[0].Certificate.RawSubjectPublicKeyInfo) {
+		t.Error("Init.RotateIntermediates() did not rotate the intermediate key")
+	}
+	if ca.Root != rootFile || ca.Key != "memory:intermediate" {
+		t.Errorf("Init.RotateIntermediates() ca = %v", ca)
+	}
+
+	if err := newTestInit(t, newMemoryKeyManager()).RotateIntermediates(rootFile, "root", opts, ca); err == nil {
+		t.Error("Init.RotateIntermediates() error = nil, want error")
+	}
+}
+
+func TestInit_CreateIntermediateCSR(t *testing.T) {
+	p := newTestInit(t, newMemoryKeyManager())
+	csrFile := filepath.Join(p.Dir, "intermediate.csr")
+	if err := p.CreateIntermediateCSR(&apiv1.CreateKeyRequest{Name: "intermediate"}, csrFile, true); err != nil {
+		t.Fatalf("Init.CreateIntermediateCSR() error = %v", err)
+	}
+
+	b, err := ioutil.ReadFile(csrFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Fatalf("Init.CreateIntermediateCSR() did not write a PEM certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("Init.CreateIntermediateCSR() signature error = %v", err)
+	}
+	if csr.Subject.CommonName != DefaultIntermediateCommonName {
+		t.Errorf("Init.CreateIntermediateCSR() common name = %v, want %v", csr.Subject.CommonName, DefaultIntermediateCommonName)
+	}
+}
+
+func TestInit_CreateSSHKeys(t *testing.T) {
+	p := newTestInit(t, newMemoryKeyManager())
+	ca := &CAConfig{}
+	if err := p.CreateSSHKeys("Acme", &apiv1.CreateKeyRequest{Name: "ssh-user-key"}, &apiv1.CreateKeyRequest{Name: "ssh-host-key"}, ca); err != nil {
+		t.Fatalf("Init.CreateSSHKeys() error = %v", err)
+	}
+	if ca.SSHUserKey != "memory:ssh-user-key" || ca.SSHHostKey != "memory:ssh-host-key" {
+		t.Errorf("Init.CreateSSHKeys() ca = %v", ca)
+	}
+
+	for filename, comment := range map[string]string{
+		SSHUserPublicKeyFile: "Acme SSH User CA",
+		SSHHostPublicKeyFile: "Acme SSH Host CA",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(p.Dir, filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(b, []byte("ecdsa-sha2-nistp256 ")) || !bytes.Contains(b, []byte(comment)) {
+			t.Errorf("Init.CreateSSHKeys() %s = %s", filename, b)
+		}
+	}
+
+	k := &memoryKeyManager{keys: map[string]*ecdsa.PrivateKey{}, failOn: "ssh-host-key"}
+	if err := newTestInit(t, k).CreateSSHKeys("Acme", &apiv1.CreateKeyRequest{Name: "ssh-user-key"}, &apiv1.CreateKeyRequest{Name: "ssh-host-key"}, ca); err == nil {
+		t.Error("Init.CreateSSHKeys() error = nil, want error")
+	}
+}
@@ -0,0 +1,107 @@
+package pkiutil
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+var intermediateNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// Intermediate defines an intermediate certificate to create in a PKI with
+// more than one intermediate.
+type Intermediate struct {
+	Name               string
+	SignatureAlgorithm apiv1.SignatureAlgorithm
+	Bits               int
+}
+
+// ParseIntermediate parses an intermediate definition with the format
+// `name=<name>[,keytype=<type>]`. The supported key types are P-256, P-384,
+// P-521, RSA-2048, RSA-3072 and RSA-4096. If the key type is not present the
+// signature algorithm of the returned intermediate will be empty.
+func ParseIntermediate(s string) (Intermediate, error) {
+	var in Intermediate
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return Intermediate{}, errors.Errorf("invalid intermediate '%s': '%s' is not a key=value pair", s, kv)
+		}
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		switch key {
+		case "name":
+			if !intermediateNameRegexp.MatchString(value) {
+				return Intermediate{}, errors.Errorf("invalid intermediate '%s': name must contain only letters, numbers, '_' or '-'", s)
+			}
+			in.Name = value
+		case "keytype":
+			alg, bits, err := ParseKeyType(value)
+			if err != nil {
+				return Intermediate{}, errors.Errorf("invalid intermediate '%s': unsupported keytype '%s'", s, value)
+			}
+			in.SignatureAlgorithm, in.Bits = alg, bits
+		default:
+			return Intermediate{}, errors.Errorf("invalid intermediate '%s': unsupported key '%s'", s, key)
+		}
+	}
+	if in.Name == "" {
+		return Intermediate{}, errors.Errorf("invalid intermediate '%s': name is required", s)
+	}
+	return in, nil
+}
+
+// ParseKeyType returns the signature algorithm and the RSA bits for the given
+// key type. The supported key types are P-256, P-384, P-521, RSA-2048,
+// RSA-3072 and RSA-4096, the bits are only set for RSA keys.
+func ParseKeyType(s string) (apiv1.SignatureAlgorithm, int, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "P-256":
+		return apiv1.ECDSAWithSHA256, 0, nil
+	case "P-384":
+		return apiv1.ECDSAWithSHA384, 0, nil
+	case "P-521":
+		return apiv1.ECDSAWithSHA512, 0, nil
+	case "RSA-2048":
+		return apiv1.SHA256WithRSA, 2048, nil
+	case "RSA-3072":
+		return apiv1.SHA256WithRSA, 3072, nil
+	case "RSA-4096":
+		return apiv1.SHA256WithRSA, 4096, nil
+	default:
+		return apiv1.UnspecifiedSignAlgorithm, 0, errors.Errorf("unsupported key type '%s'", s)
+	}
+}
+
+// Intermediates implements flag.Value to allow the definition of multiple
+// intermediates using a repeated flag.
+type Intermediates []Intermediate
+
+// String implements flag.Value and returns the names of the intermediates.
+func (v *Intermediates) String() string {
+	if v == nil {
+		return ""
+	}
+	names := make([]string, len(*v))
+	for i, in := range *v {
+		names[i] = in.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// Set implements flag.Value and adds a new intermediate. Intermediate names
+// must be unique.
+func (v *Intermediates) Set(s string) error {
+	in, err := ParseIntermediate(s)
+	if err != nil {
+		return err
+	}
+	for _, i := range *v {
+		if strings.EqualFold(i.Name, in.Name) {
+			return errors.Errorf("intermediate '%s' is already defined", in.Name)
+		}
+	}
+	*v = append(*v, in)
+	return nil
+}
@@ -0,0 +1,102 @@
+package pkiutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestParseIntermediate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Intermediate
+		wantErr bool
+	}{
+		{"ok name", "name=tls", Intermediate{Name: "tls"}, false},
+		{"ok P-256", "name=tls,keytype=P-256", Intermediate{Name: "tls", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, false},
+		{"ok P-384", "name=ssh,keytype=p-384", Intermediate{Name: "ssh", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, false},
+		{"ok P-521", "keytype=P-521,name=code_signing", Intermediate{Name: "code_signing", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, false},
+		{"ok RSA-2048", "name=tls-rsa,keytype=RSA-2048", Intermediate{Name: "tls-rsa", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}, false},
+		{"ok RSA-3072", "name=tls, keytype=RSA-3072", Intermediate{Name: "tls", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 3072}, false},
+		{"ok RSA-4096", "name=tls,keytype=rsa-4096", Intermediate{Name: "tls", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 4096}, false},
+		{"fail empty", "", Intermediate{}, true},
+		{"fail missing name", "keytype=P-256", Intermediate{}, true},
+		{"fail empty name", "name=", Intermediate{}, true},
+		{"fail bad name", "name=../tls", Intermediate{}, true},
+		{"fail keytype", "name=tls,keytype=Ed25519", Intermediate{}, true},
+		{"fail key", "name=tls,curve=P-256", Intermediate{}, true},
+		{"fail pair", "name=tls,P-256", Intermediate{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseIntermediate(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseIntermediate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIntermediate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeyType(t *testing.T) {
+	tests := []struct {
+		s        string
+		wantAlg  apiv1.SignatureAlgorithm
+		wantBits int
+		wantErr  bool
+	}{
+		{"P-256", apiv1.ECDSAWithSHA256, 0, false},
+		{"p-384", apiv1.ECDSAWithSHA384, 0, false},
+		{"P-521", apiv1.ECDSAWithSHA512, 0, false},
+		{"RSA-2048", apiv1.SHA256WithRSA, 2048, false},
+		{" rsa-3072 ", apiv1.SHA256WithRSA, 3072, false},
+		{"RSA-4096", apiv1.SHA256WithRSA, 4096, false},
+		{"", apiv1.UnspecifiedSignAlgorithm, 0, true},
+		{"RSA-1024", apiv1.UnspecifiedSignAlgorithm, 0, true},
+		{"Ed25519", apiv1.UnspecifiedSignAlgorithm, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			alg, bits, err := ParseKeyType(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKeyType() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if alg != tt.wantAlg || bits != tt.wantBits {
+				t.Errorf("ParseKeyType() = %v, %v, want %v, %v", alg, bits, tt.wantAlg, tt.wantBits)
+			}
+		})
+	}
+}
+
+func TestIntermediates_Set(t *testing.T) {
+	var v Intermediates
+	if err := v.Set("name=tls,keytype=P-256"); err != nil {
+		t.Fatalf("Intermediates.Set() error = %v", err)
+	}
+	if err := v.Set("name=ssh"); err != nil {
+		t.Fatalf("Intermediates.Set() error = %v", err)
+	}
+	if err := v.Set("name=TLS"); err == nil {
+		t.Error("Intermediates.Set() with a duplicated name did not fail")
+	}
+	if err := v.Set("keytype=P-256"); err == nil {
+		t.Error("Intermediates.Set() without a name did not fail")
+	}
+
+	want := Intermediates{
+		{Name: "tls", SignatureAlgorithm: apiv1.ECDSAWithSHA256},
+		{Name: "ssh"},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Intermediates = %v, want %v", v, want)
+	}
+	if s := v.String(); s != "tls,ssh" {
+		t.Errorf("Intermediates.String() = %v, want tls,ssh", s)
+	}
+}
@@ -0,0 +1,383 @@
+package pkiutil
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// pkcs12Iterations is the number of iterations used in the key derivation
+// function of the encryption and mac keys.
+const pkcs12Iterations = 2048
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509Certificate  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTDES    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue
+}
+
+type encryptedPrivateKeyInfo struct {
+	AlgorithmIdentifier pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// EncodePKCS12 returns a password protected PKCS#12 file with the given
+// private key, its certificate and the certificate chain. The key and the
+// certificates are encrypted using pbeWithSHAAnd3-KeyTripleDES-CBC and the
+// integrity is protected with an HMAC-SHA1, a combination supported by
+// OpenSSL, Windows and Java keystores. If the reader is nil, crypto/rand.Reader
+// will be used.
+func EncodePKCS12(r io.Reader, key crypto.PrivateKey, cert *x509.Certificate, chain []*x509.Certificate, password string) ([]byte, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	if cert == nil {
+		return nil, errors.New("certificate cannot be nil")
+	}
+	pass := bmpString(password)
+
+	// The local key id links the private key with its certificate.
+	localKeyID := sha1.Sum(cert.Raw)
+	keyIDAttr, err := newLocalKeyIDAttribute(localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates
+	var certBags []safeBag
+	for i, crt := range append([]*x509.Certificate{cert}, chain...) {
+		bag, err := newCertBag(crt)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			bag.Attributes = []pkcs12Attribute{keyIDAttr}
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling certificates")
+	}
+	certInfo, err := newEncryptedContentInfo(r, certContents, pass)
+	if err != nil {
+		return nil, err
+	}
+
+	// Private key
+	keyBag, err := newPKCS8ShroudedKeyBag(r, key, pass)
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = []pkcs12Attribute{keyIDAttr}
+	keyContents, err := asn1.Marshal([]safeBag{keyBag})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling private key")
+	}
+	keyInfo, err := newDataContentInfo(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticatedSafe, err := asn1.Marshal([]contentInfo{certInfo, keyInfo})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling authenticated safe")
+	}
+	authSafe, err := newDataContentInfo(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mac
+	salt, err := randomBytes(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(salt, pass, pkcs12Iterations, 3, 20))
+	mac.Write(authenticatedSafe)
+
+	b, err := asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: authSafe,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling pkcs12")
+	}
+	return b, nil
+}
+
+// explicit returns the given DER content with a context specific explicit
+// tag 0.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func newLocalKeyIDAttribute(id []byte) (pkcs12Attribute, error) {
+	b, err := asn1.Marshal(id)
+	if err != nil {
+		return pkcs12Attribute{}, errors.Wrap(err, "error marshaling local key id")
+	}
+	return pkcs12Attribute{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: b},
+	}, nil
+}
+
+func newCertBag(cert *x509.Certificate) (safeBag, error) {
+	b, err := asn1.Marshal(cert.Raw)
+	if err != nil {
+		return safeBag{}, errors.Wrap(err, "error marshaling certificate")
+	}
+	b, err = asn1.Marshal(certBag{
+		ID:   oidCertTypeX509Certificate,
+		Data: explicit(b),
+	})
+	if err != nil {
+		return safeBag{}, errors.Wrap(err, "error marshaling certificate")
+	}
+	return safeBag{
+		ID:    oidCertBag,
+		Value: explicit(b),
+	}, nil
+}
+
+func newPKCS8ShroudedKeyBag(r io.Reader, key crypto.PrivateKey, pass []byte) (safeBag, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return safeBag{}, errors.Wrap(err, "error marshaling private key")
+	}
+	alg, ciphertext, err := pbeEncrypt(r, pkcs8, pass)
+	if err != nil {
+		return safeBag{}, err
+	}
+	b, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		AlgorithmIdentifier: alg,
+		EncryptedData:       ciphertext,
+	})
+	if err != nil {
+		return safeBag{}, errors.Wrap(err, "error marshaling private key")
+	}
+	return safeBag{
+		ID:    oidPKCS8ShroudedKeyBag,
+		Value: explicit(b),
+	}, nil
+}
+
+func newDataContentInfo(data []byte) (contentInfo, error) {
+	b, err := asn1.Marshal(data)
+	if err != nil {
+		return contentInfo{}, errors.Wrap(err, "error marshaling content info")
+	}
+	return contentInfo{
+		ContentType: oidDataContentType,
+		Content:     explicit(b),
+	}, nil
+}
+
+func newEncryptedContentInfo(r io.Reader, data, pass []byte) (contentInfo, error) {
+	alg, ciphertext, err := pbeEncrypt(r, data, pass)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	b, err := asn1.Marshal(encryptedData{
+		Version: 0,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: alg,
+			EncryptedContent:           ciphertext,
+		},
+	})
+	if err != nil {
+		return contentInfo{}, errors.Wrap(err, "error marshaling content info")
+	}
+	return contentInfo{
+		ContentType: oidEncryptedDataContentType,
+		Content:     explicit(b),
+	}, nil
+}
+
+// pbeEncrypt encrypts the data using pbeWithSHAAnd3-KeyTripleDES-CBC with a
+// random salt.
+func pbeEncrypt(r io.Reader, data, pass []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt, err := randomBytes(r, 8)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, errors.Wrap(err, "error marshaling pbe parameters")
+	}
+
+	block, err := des.NewTripleDESCipher(pkcs12KDF(salt, pass, pkcs12Iterations, 1, 24))
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, errors.Wrap(err, "error creating cipher")
+	}
+	iv := pkcs12KDF(salt, pass, pkcs12Iterations, 2, block.BlockSize())
+
+	// PKCS#7 padding
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	ciphertext := make([]byte, len(data)+padding)
+	copy(ciphertext, data)
+	for i := len(data); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oidPBEWithSHAAnd3KeyTDES,
+		Parameters: asn1.RawValue{FullBytes: params},
+	}, ciphertext, nil
+}
+
+// pkcs12KDF implements the key derivation function defined in RFC 7292,
+// appendix B.2, using SHA-1. The id is 1 for encryption keys, 2 for
+// initialization vectors and 3 for mac keys.
+func pkcs12KDF(salt, pass []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+
+	D := make([]byte, v)
+	for i := range D {
+		D[i] = id
+	}
+	I := append(fill(salt), fill(pass)...)
+
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(D)
+		h.Write(I)
+		A := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			sum := sha1.Sum(A)
+			A = sum[:]
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^v for each v-bit block of I.
+		B := new(big.Int).SetBytes(fill(A))
+		B.Add(B, one)
+		for j := 0; j < len(I); j += v {
+			Ij := new(big.Int).SetBytes(I[j : j+v])
+			Ij.Add(Ij, B)
+			b := Ij.Bytes()
+			if len(b) > v {
+				b = b[len(b)-v:]
+			}
+			block := I[j : j+v]
+			for k := range block {
+				block[k] = 0
+			}
+			copy(block[v-len(b):], b)
+		}
+	}
+	return out[:size]
+}
+
+// bmpString returns the password encoded as a null terminated BMPString
+// (UTF-16 big-endian) as required by PKCS#12.
+func bmpString(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 0, 2*len(u)+2)
+	for _, c := range u {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return append(b, 0, 0)
+}
+
+func randomBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.Wrap(err, "error generating random bytes")
+	}
+	return b, nil
+}
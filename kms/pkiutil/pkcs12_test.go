@@ -0,0 +1,122 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+func mustCertificate(t *testing.T, cn string, key *ecdsa.PrivateKey, parent *x509.Certificate, signer *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent = template
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := mustCertificate(t, "Root", rootKey, nil, rootKey)
+	intermediate := mustCertificate(t, "Intermediate", key, root, rootKey)
+
+	type args struct {
+		r        io.Reader
+		key      interface{}
+		cert     *x509.Certificate
+		chain    []*x509.Certificate
+		password string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantCerts []*x509.Certificate
+		wantErr   bool
+	}{
+		{"ok", args{nil, key, intermediate, []*x509.Certificate{root}, "password"}, []*x509.Certificate{intermediate, root}, false},
+		{"ok no chain", args{rand.Reader, key, intermediate, nil, "password"}, []*x509.Certificate{intermediate}, false},
+		{"ok unicode password", args{rand.Reader, key, intermediate, nil, "pässwörd"}, []*x509.Certificate{intermediate}, false},
+		{"fail cert", args{rand.Reader, key, nil, nil, "password"}, nil, true},
+		{"fail key", args{rand.Reader, "not a key", intermediate, nil, "password"}, nil, true},
+		{"fail reader", args{bytes.NewReader(nil), key, intermediate, nil, "password"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodePKCS12(tt.args.r, tt.args.key, tt.args.cert, tt.args.chain, tt.args.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncodePKCS12() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if _, err := pkcs12.ToPEM(got, "wrong"); err == nil {
+				t.Error("pkcs12.ToPEM() with wrong password did not fail")
+			}
+			blocks, err := pkcs12.ToPEM(got, tt.args.password)
+			if err != nil {
+				t.Fatalf("pkcs12.ToPEM() error = %v", err)
+			}
+			var certs []*x509.Certificate
+			var priv *ecdsa.PrivateKey
+			for _, block := range blocks {
+				switch block.Type {
+				case "CERTIFICATE":
+					cert, err := x509.ParseCertificate(block.Bytes)
+					if err != nil {
+						t.Fatal(err)
+					}
+					certs = append(certs, cert)
+				// Newer versions of pkcs12 use the PRIVATE KEY type with the
+				// same SEC 1 encoding.
+				case "EC PRIVATE KEY", "PRIVATE KEY":
+					if priv, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+						t.Fatal(err)
+					}
+				default:
+					t.Errorf("unexpected PEM block %s", block.Type)
+				}
+			}
+			if !reflect.DeepEqual(certs, tt.wantCerts) {
+				t.Errorf("EncodePKCS12() certificates = %v, want %v", certs, tt.wantCerts)
+			}
+			if priv == nil || priv.D.Cmp(key.D) != 0 {
+				t.Errorf("EncodePKCS12() private key does not match")
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package pkiutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+const (
+	// DefaultRootCommonName is the common name of the root certificate if
+	// PKIOptions does not define one.
+	DefaultRootCommonName = "Smallstep Root"
+	// DefaultIntermediateCommonName is the common name of an intermediate
+	// certificate without name if PKIIntermediate does not define one.
+	DefaultIntermediateCommonName = "Smallstep Intermediate"
+	// defaultValidity is the validity of the root and intermediate
+	// certificates, ten years.
+	defaultValidity = time.Hour * 24 * 365 * 10
+)
+
+// PKIOptions are the options used to create a PKI.
+type PKIOptions struct {
+	// RootKey is the request used to create the root key in CreatePKI.
+	RootKey *apiv1.CreateKeyRequest
+	// RootCommonName is the common name of the root certificate, it defaults
+	// to DefaultRootCommonName.
+	RootCommonName string
+	// Intermediates are the intermediates signed by the root, without
+	// intermediates the root can only sign leaf certificates.
+	Intermediates []PKIIntermediate
+	SKIDMethod    SubjectKeyIDMethod
+	// Template customizes the root and intermediate certificates.
+	Template *TemplateFile
+	// NoWarmup disables the throwaway signature done before signing the
+	// certificates with the root key.
+	NoWarmup bool
+	// IntermediateExtensions are added to the intermediate certificates before
+	// the template, see ParseCSRExtensions.
+	IntermediateExtensions []pkix.Extension
+	// PolicyOIDs are the certificate policies added to the intermediate
+	// certificates, and to the root certificate if RootPolicies is set.
+	PolicyOIDs   PolicyOIDs
+	RootPolicies bool
+	// Rand is the source of randomness used in the serial numbers and the
+	// signatures of the certificates, it defaults to crypto/rand.Reader.
+	Rand io.Reader
+	// ExistingRootKey is the name of an existing key used as the root key in
+	// CreatePKI instead of creating a new one with RootKey.
+	ExistingRootKey string
+	// NotBefore and NotAfter are the validity of the root and intermediate
+	// certificates, by default they are valid from now and for ten years. If
+	// they are set, the intermediates must be within the validity of the root.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// randReader returns the source of randomness of the options.
+func (o PKIOptions) randReader() io.Reader {
+	if o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+// PKIIntermediate is an intermediate created by CreatePKI. An intermediate
+// without name is the default intermediate.
+type PKIIntermediate struct {
+	Name string
+	// CommonName is the common name of the certificate, it defaults to
+	// DefaultIntermediateCommonName, or "Smallstep <name> Intermediate" if the
+	// intermediate has a name.
+	CommonName string
+	// Key is the request used to create the intermediate key in CreatePKI.
+	Key *apiv1.CreateKeyRequest
+}
+
+func (in PKIIntermediate) commonName() string {
+	switch {
+	case in.CommonName != "":
+		return in.CommonName
+	case in.Name != "":
+		return "Smallstep " + in.Name + " Intermediate"
+	default:
+		return DefaultIntermediateCommonName
+	}
+}
+
+// PKICertificate is a certificate created by CreatePKI with its key.
+type PKICertificate struct {
+	Name        string
+	Certificate *x509.Certificate
+	Key         *apiv1.CreateKeyResponse
+	KeyURI      string
+}
+
+// PKI is the result of CreatePKI.
+type PKI struct {
+	Root          *PKICertificate
+	Intermediates []*PKICertificate
+	// Signer is the signer of the root key.
+	Signer crypto.Signer
+}
+
+// CreatePKI creates in the given key manager the root key and the keys of the
+// intermediates in the options, and signs the root and intermediate
+// certificates with the root key. The root key is not created if the options
+// have an ExistingRootKey. The keys are created one after the other, use
+// SignPKI to sign the certificates of keys created in a different way.
+func CreatePKI(k apiv1.KeyManager, opts PKIOptions) (*PKI, error) {
+	if opts.RootKey == nil && opts.ExistingRootKey == "" {
+		return nil, errors.New("createPKI: root key request cannot be empty")
+	}
+
+	var reqs []*apiv1.CreateKeyRequest
+	if opts.ExistingRootKey == "" {
+		reqs = append(reqs, opts.RootKey)
+	}
+	for _, in := range opts.Intermediates {
+		if in.Key == nil {
+			return nil, errors.Errorf("createPKI: key request of intermediate '%s' cannot be empty", in.Name)
+		}
+		reqs = append(reqs, in.Key)
+	}
+
+	var keys []*apiv1.CreateKeyResponse
+	if opts.ExistingRootKey != "" {
+		resp, err := ExistingKey(k, opts.ExistingRootKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resp)
+	}
+	for _, req := range reqs {
+		resp, err := k.CreateKey(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating key %s", req.Name)
+		}
+		keys = append(keys, resp)
+	}
+
+	return SignPKI(k, keys, opts)
+}
+
+// ExistingKey returns a CreateKeyResponse for a key that already exists in the
+// given key manager, so it can be used in SignPKI as if it was just created.
+func ExistingKey(k apiv1.KeyManager, name string) (*apiv1.CreateKeyResponse, error) {
+	pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading key %s", name)
+	}
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// SignPKI signs the root and intermediate certificates of the given keys with
+// the root key. The first key is the root key, followed by the keys of the
+// intermediates in the options in the same order.
+func SignPKI(k apiv1.KeyManager, keys []*apiv1.CreateKeyResponse, opts PKIOptions) (*PKI, error) {
+	if len(keys) != len(opts.Intermediates)+1 {
+		return nil, errors.Errorf("signPKI: expected %d keys, got %d", len(opts.Intermediates)+1, len(keys))
+	}
+
+	signer, err := k.CreateSigner(&keys[0].CreateSignerRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prime the connection and credentials before the first real signature.
+	if !opts.NoWarmup {
+		if err := Warmup(signer); err != nil {
+			return nil, err
+		}
+	}
+
+	root, err := CreateRootCertificate(keys[0].PublicKey, signer, opts)
+	if err != nil {
+		return nil, err
+	}
+	keyURI, err := CreatedKeyURI(k, keys[0])
+	if err != nil {
+		return nil, err
+	}
+
+	pki := &PKI{
+		Root: &PKICertificate{
+			Certificate: root,
+			Key:         keys[0],
+			KeyURI:      keyURI,
+		},
+		Signer: signer,
+	}
+
+	for i, in := range opts.Intermediates {
+		resp := keys[i+1]
+		cert, err := CreateIntermediateCertificate(resp.PublicKey, in, root, signer, opts)
+		if err != nil {
+			return nil, err
+		}
+		keyURI, err := CreatedKeyURI(k, resp)
+		if err != nil {
+			return nil, err
+		}
+		pki.Intermediates = append(pki.Intermediates, &PKICertificate{
+			Name:        in.Name,
+			Certificate: cert,
+			Key:         resp,
+			KeyURI:      keyURI,
+		})
+	}
+
+	return pki, nil
+}
+
+// CreateRootCertificate creates a self-signed root certificate for the given
+// public key using the given signer. The root can sign one level of
+// intermediates, or only leaf certificates if the options do not have
+// intermediates. The certificate is customized with the template in the
+// options.
+func CreateRootCertificate(pub crypto.PublicKey, signer crypto.Signer, opts PKIOptions) (*x509.Certificate, error) {
+	serialNumber, err := SerialNumber(opts.Rand)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectKeyID, err := SubjectKeyID(pub, opts.SKIDMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	commonName := opts.RootCommonName
+	if commonName == "" {
+		commonName = DefaultRootCommonName
+	}
+
+	notBefore, notAfter := opts.validity()
+	template := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLen:            1,
+		MaxPathLenZero:        false,
+		Issuer:                pkix.Name{CommonName: commonName},
+		Subject:               pkix.Name{CommonName: commonName},
+		SerialNumber:          serialNumber,
+		SubjectKeyId:          subjectKeyID,
+		AuthorityKeyId:        subjectKeyID,
+	}
+
+	if opts.RootPolicies {
+		template.PolicyIdentifiers = opts.PolicyOIDs
+	}
+
+	// Without an intermediate the root can only sign leaf certificates.
+	if len(opts.Intermediates) == 0 {
+		template.MaxPathLen = 0
+		template.MaxPathLenZero = true
+	}
+
+	if err := opts.Template.Apply(template, TemplateData{Type: RootTemplate}); err != nil {
+		return nil, err
+	}
+
+	b, err := x509.CreateCertificate(opts.randReader(), template, template, pub, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating root certificate")
+	}
+
+	root, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing root certificate")
+	}
+	return root, nil
+}
+
+// CreateIntermediateCertificate creates the certificate of the given
+// intermediate for the given public key, signed by the root with the given
+// signer. The certificate is customized with the extensions and the template in
+// the options, and its signature is verified with the root.
+func CreateIntermediateCertificate(pub crypto.PublicKey, in PKIIntermediate, root *x509.Certificate, signer crypto.Signer, opts PKIOptions) (*x509.Certificate, error) {
+	serialNumber, err := SerialNumber(opts.Rand)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectKeyID, err := SubjectKeyID(pub, opts.SKIDMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore, notAfter := opts.validity()
+	template := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+		Issuer:                root.Subject,
+		Subject:               pkix.Name{CommonName: in.commonName()},
+		SerialNumber:          serialNumber,
+		SubjectKeyId:          subjectKeyID,
+		PolicyIdentifiers:     opts.PolicyOIDs,
+		ExtraExtensions:       append([]pkix.Extension(nil), opts.IntermediateExtensions...),
+	}
+
+	if err := opts.Template.Apply(template, TemplateData{Type: IntermediateTemplate, Name: in.Name}); err != nil {
+		return nil, err
+	}
+
+	if opts.hasValidity() && (template.NotBefore.Before(root.NotBefore) || template.NotAfter.After(root.NotAfter)) {
+		return nil, errors.Errorf("intermediate validity %s to %s is not within the root validity %s to %s",
+			template.NotBefore.Format(time.RFC3339), template.NotAfter.Format(time.RFC3339),
+			root.NotBefore.Format(time.RFC3339), root.NotAfter.Format(time.RFC3339))
+	}
+
+	b, err := x509.CreateCertificate(opts.randReader(), template, root, pub, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating intermediate certificate")
+	}
+
+	// Make sure that the root key has signed the intermediate properly.
+	intermediate, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing intermediate certificate")
+	}
+	if err := intermediate.CheckSignatureFrom(root); err != nil {
+		return nil, errors.Wrap(err, "error validating intermediate certificate signature")
+	}
+	return intermediate, nil
+}
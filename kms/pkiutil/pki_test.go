@@ -0,0 +1,461 @@
+package pkiutil
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/softkms"
+)
+
+// memoryKeyManager is a key manager that creates ECDSA keys in memory.
+type memoryKeyManager struct {
+	fakeKeyManager
+	keys      map[string]*ecdsa.PrivateKey
+	failOn    string
+	failOnSig bool
+}
+
+func newMemoryKeyManager() *memoryKeyManager {
+	return &memoryKeyManager{keys: make(map[string]*ecdsa.PrivateKey)}
+}
+
+func (m *memoryKeyManager) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == m.failOn {
+		return nil, errors.New("create key failed")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[req.Name] = key
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: key.Public(),
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+		KeyURI: "memory:" + req.Name,
+	}, nil
+}
+
+func (m *memoryKeyManager) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	key, ok := m.keys[req.Name]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key.Public(), nil
+}
+
+func (m *memoryKeyManager) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	key, ok := m.keys[req.SigningKey]
+	if !ok || m.failOnSig {
+		return nil, errors.New("create signer failed")
+	}
+	return key, nil
+}
+
+func TestCreatePKI(t *testing.T) {
+	intermediates := []PKIIntermediate{
+		{Key: &apiv1.CreateKeyRequest{Name: "intermediate"}},
+		{Name: "tls", Key: &apiv1.CreateKeyRequest{Name: "intermediate-tls"}},
+	}
+
+	tests := []struct {
+		name      string
+		km        *memoryKeyManager
+		opts      PKIOptions
+		wantNames []string
+		wantErr   bool
+	}{
+		{"ok", newMemoryKeyManager(), PKIOptions{
+			RootKey:       &apiv1.CreateKeyRequest{Name: "root"},
+			Intermediates: intermediates,
+			SKIDMethod:    RFC5280,
+			NoWarmup:      true,
+		}, []string{"Smallstep Intermediate", "Smallstep tls Intermediate"}, false},
+		{"ok no intermediate", newMemoryKeyManager(), PKIOptions{
+			RootKey:        &apiv1.CreateKeyRequest{Name: "root"},
+			RootCommonName: "Acme Root",
+			SKIDMethod:     RFC7093,
+		}, nil, false},
+		{"ok common name", newMemoryKeyManager(), PKIOptions{
+			RootKey:       &apiv1.CreateKeyRequest{Name: "root"},
+			Intermediates: []PKIIntermediate{{CommonName: "Acme Intermediate", Key: &apiv1.CreateKeyRequest{Name: "intermediate"}}},
+			SKIDMethod:    RFC5280,
+		}, []string{"Acme Intermediate"}, false},
+		{"fail root key request", newMemoryKeyManager(), PKIOptions{}, nil, true},
+		{"fail intermediate key request", newMemoryKeyManager(), PKIOptions{
+			RootKey:       &apiv1.CreateKeyRequest{Name: "root"},
+			Intermediates: []PKIIntermediate{{Name: "tls"}},
+		}, nil, true},
+		{"fail create key", &memoryKeyManager{keys: map[string]*ecdsa.PrivateKey{}, failOn: "intermediate-tls"}, PKIOptions{
+			RootKey:       &apiv1.CreateKeyRequest{Name: "root"},
+			Intermediates: intermediates,
+			SKIDMethod:    RFC5280,
+		}, nil, true},
+		{"fail create signer", &memoryKeyManager{keys: map[string]*ecdsa.PrivateKey{}, failOnSig: true}, PKIOptions{
+			RootKey:    &apiv1.CreateKeyRequest{Name: "root"},
+			SKIDMethod: RFC5280,
+		}, nil, true},
+		{"fail skid method", newMemoryKeyManager(), PKIOptions{
+			RootKey:    &apiv1.CreateKeyRequest{Name: "root"},
+			SKIDMethod: "foo",
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreatePKI(tt.km, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreatePKI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			root := got.Root.Certificate
+			if got.Root.KeyURI != "memory:root" {
+				t.Errorf("CreatePKI() root key uri = %v, want memory:root", got.Root.KeyURI)
+			}
+			if err := root.CheckSignatureFrom(root); err != nil {
+				t.Errorf("CreatePKI() root signature error = %v", err)
+			}
+			wantRootName := tt.opts.RootCommonName
+			if wantRootName == "" {
+				wantRootName = DefaultRootCommonName
+			}
+			if root.Subject.CommonName != wantRootName {
+				t.Errorf("CreatePKI() root common name = %v, want %v", root.Subject.CommonName, wantRootName)
+			}
+			if wantZero := len(tt.opts.Intermediates) == 0; root.MaxPathLenZero != wantZero {
+				t.Errorf("CreatePKI() root MaxPathLenZero = %v, want %v", root.MaxPathLenZero, wantZero)
+			}
+
+			if len(got.Intermediates) != len(tt.wantNames) {
+				t.Fatalf("CreatePKI() intermediates = %d, want %d", len(got.Intermediates), len(tt.wantNames))
+			}
+			pool := x509.NewCertPool()
+			pool.AddCert(root)
+			for i, in := range got.Intermediates {
+				if in.Certificate.Subject.CommonName != tt.wantNames[i] {
+					t.Errorf("CreatePKI() intermediate common name = %v, want %v", in.Certificate.Subject.CommonName, tt.wantNames[i])
+				}
+				if in.Name != tt.opts.Intermediates[i].Name {
+					t.Errorf("CreatePKI() intermediate name = %v, want %v", in.Name, tt.opts.Intermediates[i].Name)
+				}
+				if in.KeyURI != "memory:"+tt.opts.Intermediates[i].Key.Name {
+					t.Errorf("CreatePKI() intermediate key uri = %v, want memory:%s", in.KeyURI, tt.opts.Intermediates[i].Key.Name)
+				}
+				if _, err := in.Certificate.Verify(x509.VerifyOptions{
+					Roots:     pool,
+					KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+				}); err != nil {
+					t.Errorf("CreatePKI() intermediate verify error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignPKI(t *testing.T) {
+	km := newMemoryKeyManager()
+	root, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "intermediate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := PKIOptions{
+		Intermediates: []PKIIntermediate{{}},
+		SKIDMethod:    RFC5280,
+	}
+
+	tests := []struct {
+		name    string
+		keys    []*apiv1.CreateKeyResponse
+		wantErr bool
+	}{
+		{"ok", []*apiv1.CreateKeyResponse{root, intermediate}, false},
+		{"fail missing key", []*apiv1.CreateKeyResponse{root}, true},
+		{"fail extra key", []*apiv1.CreateKeyResponse{root, intermediate, intermediate}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SignPKI(km, tt.keys, opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SignPKI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				if err := got.Intermediates[0].Certificate.CheckSignatureFrom(got.Root.Certificate); err != nil {
+					t.Errorf("SignPKI() intermediate signature error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateIntermediateCertificate(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := PKIOptions{Intermediates: []PKIIntermediate{{}}, SKIDMethod: RFC5280}
+	root, err := CreateRootCertificate(rootKey.Public(), rootKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		wantErr bool
+	}{
+		{"ok", rootKey, false},
+		{"fail signer", otherKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateIntermediateCertificate(otherKey.Public(), PKIIntermediate{Name: "ssh"}, root, tt.signer, opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateIntermediateCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Subject.CommonName != "Smallstep ssh Intermediate" {
+				t.Errorf("CreateIntermediateCertificate() common name = %v, want Smallstep ssh Intermediate", got.Subject.CommonName)
+			}
+		})
+	}
+}
+
+func TestCreateIntermediateCertificate_extensions(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := PKIOptions{SKIDMethod: RFC5280}
+	root, err := CreateRootCertificate(rootKey.Public(), rootKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type policyInformation struct {
+		Policy asn1.ObjectIdentifier
+	}
+	exts, err := ParseCSRExtensions(mustCSR(t,
+		mustExtension(t, asn1.ObjectIdentifier{2, 5, 29, 32}, []policyInformation{{Policy: asn1.ObjectIdentifier{1, 2, 3, 4}}}),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.IntermediateExtensions = exts
+	got, err := CreateIntermediateCertificate(rootKey.Public(), PKIIntermediate{}, root, rootKey, opts)
+	if err != nil {
+		t.Fatalf("CreateIntermediateCertificate() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.PolicyIdentifiers, []asn1.ObjectIdentifier{{1, 2, 3, 4}}) {
+		t.Errorf("CreateIntermediateCertificate() policy identifiers = %v, want [1.2.3.4]", got.PolicyIdentifiers)
+	}
+	if !got.IsCA || got.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Error("CreateIntermediateCertificate() is not a CA")
+	}
+}
+
+func TestCreatePKI_policyOIDs(t *testing.T) {
+	oidExtensionCertificatePolicies := asn1.ObjectIdentifier{2, 5, 29, 32}
+	policies := PolicyOIDs{
+		{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1},
+		{2, 23, 140, 1, 2, 1},
+	}
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hasPolicies checks that the policies survive the x509 parsing and that
+	// the extension is not critical.
+	hasPolicies := func(t *testing.T, cert *x509.Certificate, want PolicyOIDs) {
+		t.Helper()
+		cert, err := x509.ParseCertificate(cert.Raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) == 0 {
+			if len(cert.PolicyIdentifiers) != 0 {
+				t.Errorf("certificate policies = %v, want none", cert.PolicyIdentifiers)
+			}
+			return
+		}
+		if !reflect.DeepEqual(cert.PolicyIdentifiers, []asn1.ObjectIdentifier(want)) {
+			t.Errorf("certificate policies = %v, want %v", cert.PolicyIdentifiers, want)
+		}
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidExtensionCertificatePolicies) && ext.Critical {
+				t.Error("certificate policies extension is critical")
+			}
+		}
+	}
+
+	tests := []struct {
+		name         string
+		rootPolicies bool
+		wantRoot     PolicyOIDs
+	}{
+		{"ok intermediate", false, nil},
+		{"ok root", true, policies},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := PKIOptions{SKIDMethod: RFC5280, PolicyOIDs: policies, RootPolicies: tt.rootPolicies}
+			root, err := CreateRootCertificate(rootKey.Public(), rootKey, opts)
+			if err != nil {
+				t.Fatalf("CreateRootCertificate() error = %v", err)
+			}
+			hasPolicies(t, root, tt.wantRoot)
+			intermediate, err := CreateIntermediateCertificate(rootKey.Public(), PKIIntermediate{}, root, rootKey, opts)
+			if err != nil {
+				t.Fatalf("CreateIntermediateCertificate() error = %v", err)
+			}
+			hasPolicies(t, intermediate, policies)
+		})
+	}
+}
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestCreatePKI_rand(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReader{r: rand.Reader}
+	opts := PKIOptions{SKIDMethod: RFC5280, Rand: r}
+	root, err := CreateRootCertificate(rootKey.Public(), rootKey, opts)
+	if err != nil {
+		t.Fatalf("CreateRootCertificate() error = %v", err)
+	}
+	if r.n == 0 {
+		t.Error("CreateRootCertificate() did not read from the options reader")
+	}
+
+	r.n = 0
+	if _, err := CreateIntermediateCertificate(rootKey.Public(), PKIIntermediate{}, root, rootKey, opts); err != nil {
+		t.Fatalf("CreateIntermediateCertificate() error = %v", err)
+	}
+	if r.n == 0 {
+		t.Error("CreateIntermediateCertificate() did not read from the options reader")
+	}
+
+	opts.Rand = bytes.NewReader(nil)
+	if _, err := CreateRootCertificate(rootKey.Public(), rootKey, opts); err == nil {
+		t.Error("CreateRootCertificate() with a failing reader did not fail")
+	}
+	if _, err := CreateIntermediateCertificate(rootKey.Public(), PKIIntermediate{}, root, rootKey, opts); err == nil {
+		t.Error("CreateIntermediateCertificate() with a failing reader did not fail")
+	}
+}
+
+func TestCreatePKI_existingRootKey(t *testing.T) {
+	km := newMemoryKeyManager()
+	if _, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "alias/step-root"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CreatePKI(km, PKIOptions{
+		ExistingRootKey: "alias/step-root",
+		Intermediates:   []PKIIntermediate{{Key: &apiv1.CreateKeyRequest{Name: "intermediate"}}},
+		SKIDMethod:      RFC5280,
+		NoWarmup:        true,
+	})
+	if err != nil {
+		t.Fatalf("CreatePKI() error = %v", err)
+	}
+	if got.Root.KeyURI != "alias/step-root" {
+		t.Errorf("CreatePKI() root key uri = %v, want alias/step-root", got.Root.KeyURI)
+	}
+	if !reflect.DeepEqual(got.Root.Certificate.PublicKey, km.keys["alias/step-root"].Public()) {
+		t.Error("CreatePKI() root certificate does not use the existing key")
+	}
+	if len(km.keys) != 2 {
+		t.Errorf("CreatePKI() created %d keys, want 2", len(km.keys))
+	}
+	if err := got.Intermediates[0].Certificate.CheckSignatureFrom(got.Root.Certificate); err != nil {
+		t.Errorf("CreatePKI() intermediate signature error = %v", err)
+	}
+
+	if _, err := CreatePKI(newMemoryKeyManager(), PKIOptions{
+		ExistingRootKey: "alias/missing",
+		SKIDMethod:      RFC5280,
+	}); err == nil {
+		t.Error("CreatePKI() with a missing root key did not fail")
+	}
+}
+
+func TestCreatePKI_softKMS(t *testing.T) {
+	k, err := softkms.New(context.Background(), apiv1.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pki, err := CreatePKI(k, PKIOptions{
+		RootKey: &apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.ECDSAWithSHA256},
+		Intermediates: []PKIIntermediate{
+			{Key: &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.ECDSAWithSHA256}},
+		},
+		SKIDMethod: RFC5280,
+	})
+	if err != nil {
+		t.Fatalf("CreatePKI() error = %v", err)
+	}
+
+	// The certificates can be stored and read back as with a YubiKey.
+	for _, c := range []*PKICertificate{pki.Root, pki.Intermediates[0]} {
+		if err := StoreCertificate(k, c.Key.Name, c.Certificate); err != nil {
+			t.Fatalf("StoreCertificate() error = %v", err)
+		}
+		if _, err := VerifyStoredCertificate(k, c.Key.Name, c.Certificate); err != nil {
+			t.Errorf("VerifyStoredCertificate() error = %v", err)
+		}
+	}
+
+	// The intermediate key can sign leaf certificates.
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: pki.Intermediates[0].KeyURI})
+	if err != nil {
+		t.Fatalf("CreateSigner() error = %v", err)
+	}
+	leaf, err := CreateIntermediateCertificate(signer.Public(), PKIIntermediate{CommonName: "leaf"}, pki.Intermediates[0].Certificate, signer, PKIOptions{SKIDMethod: RFC5280})
+	if err != nil {
+		t.Fatalf("CreateIntermediateCertificate() error = %v", err)
+	}
+	if err := leaf.CheckSignatureFrom(pki.Intermediates[0].Certificate); err != nil {
+		t.Errorf("CheckSignatureFrom() error = %v", err)
+	}
+}
@@ -0,0 +1,373 @@
+// Package pkiutil contains the helpers shared by the tools used to initialize
+// a PKI using a key management system.
+package pkiutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// serialNumberBits is the number of random bits used in the serial numbers.
+const serialNumberBits = 128
+
+// SerialNumber returns a new random serial number of 128 bits read from the
+// given reader. If the reader is nil, crypto/rand.Reader will be used. The
+// returned serial number is always positive and non-zero.
+func SerialNumber(r io.Reader) (*big.Int, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), serialNumberBits)
+	sn, err := rand.Int(r, serialNumberLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating serial number")
+	}
+	if sn.Sign() <= 0 {
+		return nil, errors.New("error generating serial number: serial number must be positive")
+	}
+	return sn, nil
+}
+
+// StoreCertificate stores the given certificate with the given name if the KMS
+// implements the CertificateManager interface. It returns an error if it does
+// not.
+func StoreCertificate(k apiv1.KeyManager, name string, cert *x509.Certificate) error {
+	cm, ok := k.(apiv1.CertificateManager)
+	if !ok {
+		return errors.Errorf("%T does not support storing certificates", k)
+	}
+	if err := cm.StoreCertificate(&apiv1.StoreCertificateRequest{
+		Name:        name,
+		Certificate: cert,
+	}); err != nil {
+		return errors.Wrapf(err, "error storing certificate %s", name)
+	}
+	return nil
+}
+
+// DeleteCertificate deletes the certificate stored with the given name if the
+// KMS implements the CertificateManager interface. It returns an error if it
+// does not.
+func DeleteCertificate(k apiv1.KeyManager, name string) error {
+	cm, ok := k.(apiv1.CertificateManager)
+	if !ok {
+		return errors.Errorf("%T does not support deleting certificates", k)
+	}
+	if err := cm.DeleteCertificate(&apiv1.DeleteCertificateRequest{
+		Name: name,
+	}); err != nil {
+		return errors.Wrapf(err, "error deleting certificate %s", name)
+	}
+	return nil
+}
+
+// VerifyStoredCertificate loads the certificate with the given name from the
+// KMS and checks that it is the given certificate. It is used to confirm that a
+// certificate stored with StoreCertificate can be read back. It returns the
+// loaded certificate.
+func VerifyStoredCertificate(k apiv1.KeyManager, name string, cert *x509.Certificate) (*x509.Certificate, error) {
+	cm, ok := k.(apiv1.CertificateManager)
+	if !ok {
+		return nil, errors.Errorf("%T does not support loading certificates", k)
+	}
+	stored, err := cm.LoadCertificate(&apiv1.LoadCertificateRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading certificate %s", name)
+	}
+	if !bytes.Equal(stored.Raw, cert.Raw) {
+		return nil, errors.Errorf("error verifying certificate %s: the stored certificate does not match", name)
+	}
+	return stored, nil
+}
+
+// KeyURI returns the URI of the key with the given name, as it should be used
+// in the ca.json. If the KMS does not implement the KeyURIFormatter interface
+// the name is returned as is.
+func KeyURI(k apiv1.KeyManager, name string) (string, error) {
+	kf, ok := k.(apiv1.KeyURIFormatter)
+	if !ok {
+		return name, nil
+	}
+	u, err := kf.KeyURI(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "error formatting key %s", name)
+	}
+	return u, nil
+}
+
+// CreatedKeyURI returns the URI of the key in the given CreateKeyResponse, as
+// it should be used in the ca.json. It uses the KeyURI set by the KMS, and if
+// it is empty it formats the key name with KeyURI.
+func CreatedKeyURI(k apiv1.KeyManager, resp *apiv1.CreateKeyResponse) (string, error) {
+	if resp.KeyURI != "" {
+		return resp.KeyURI, nil
+	}
+	return KeyURI(k, resp.Name)
+}
+
+// ExistingKeys returns the keys in the given parent with one of the given
+// names, using the KeyLister interface. If the KMS does not implement the
+// KeyLister interface it returns an empty list, the existing keys cannot be
+// detected.
+func ExistingKeys(k apiv1.KeyManager, parent string, names []string) ([]apiv1.KeyInfo, error) {
+	kl, ok := k.(apiv1.KeyLister)
+	if !ok {
+		return nil, nil
+	}
+	resp, err := kl.ListKeys(&apiv1.ListKeysRequest{
+		Parent: parent,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing keys")
+	}
+
+	var keys []apiv1.KeyInfo
+	for _, key := range resp.Keys {
+		for _, name := range names {
+			if key.Name == name {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// Warmup performs a throwaway signature of a random digest with the given
+// signer. Some KMS have a cold start overhead, establishing the connection or
+// loading the credentials, and using Warmup before signing a certificate
+// avoids slowing down the first real signature. The hash used depends on the
+// key, SHA-384 for P-384 keys, SHA-512 for P-521 keys, and SHA-256 for any
+// other key.
+func Warmup(signer crypto.Signer) error {
+	h := crypto.SHA256
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		switch pub.Curve.Params().BitSize {
+		case 384:
+			h = crypto.SHA384
+		case 521:
+			h = crypto.SHA512
+		}
+	}
+
+	digest := make([]byte, h.Size())
+	if _, err := rand.Read(digest); err != nil {
+		return errors.Wrap(err, "error generating warm-up digest")
+	}
+	if _, err := signer.Sign(rand.Reader, digest, h); err != nil {
+		return errors.Wrap(err, "error signing warm-up digest")
+	}
+	return nil
+}
+
+// Exit codes returned by ExitCode.
+const (
+	ExitFailure              = 1
+	ExitPermissionDenied     = 3
+	ExitNotFound             = 4
+	ExitAlreadyExists        = 5
+	ExitUnsupportedAlgorithm = 6
+	ExitNotImplemented       = 7
+)
+
+// ExitCode returns the exit code used by the tools for the given error. Errors
+// caused by one of the apiv1 error types get their own code so scripts can
+// distinguish them, for example, retrying with other credentials on
+// ExitPermissionDenied; any other error returns ExitFailure.
+func ExitCode(err error) int {
+	switch errors.Cause(err).(type) {
+	case apiv1.ErrPermissionDenied:
+		return ExitPermissionDenied
+	case apiv1.ErrNotFound:
+		return ExitNotFound
+	case apiv1.ErrAlreadyExists:
+		return ExitAlreadyExists
+	case apiv1.ErrUnsupportedAlgorithm:
+		return ExitUnsupportedAlgorithm
+	case apiv1.ErrNotImplemented:
+		return ExitNotImplemented
+	default:
+		return ExitFailure
+	}
+}
+
+// SubjectKeyIDMethod is the method used to compute the subject key identifier
+// of a certificate.
+type SubjectKeyIDMethod string
+
+const (
+	// RFC5280 computes the subject key identifier as the SHA-1 hash of the DER
+	// encoded subject public key info. This is the default method.
+	RFC5280 SubjectKeyIDMethod = "rfc5280"
+	// RFC7093 computes the subject key identifier as the leftmost 160 bits of
+	// the SHA-256 hash of the value of the BIT STRING subjectPublicKey, the
+	// method 1 described in RFC 7093.
+	RFC7093 SubjectKeyIDMethod = "rfc7093"
+)
+
+// ParseSubjectKeyIDMethod returns the SubjectKeyIDMethod for the given name.
+// An empty name returns the default method.
+func ParseSubjectKeyIDMethod(name string) (SubjectKeyIDMethod, error) {
+	switch m := SubjectKeyIDMethod(strings.ToLower(name)); m {
+	case "":
+		return RFC5280, nil
+	case RFC5280, RFC7093:
+		return m, nil
+	default:
+		return "", errors.Errorf("unsupported subject key identifier method '%s'", name)
+	}
+}
+
+// ParseTouchPolicy returns the apiv1.TouchPolicy for the given name, never,
+// always or cached. An empty name returns apiv1.UnspecifiedTouchPolicy.
+func ParseTouchPolicy(name string) (apiv1.TouchPolicy, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return apiv1.UnspecifiedTouchPolicy, nil
+	case "never":
+		return apiv1.TouchPolicyNever, nil
+	case "always":
+		return apiv1.TouchPolicyAlways, nil
+	case "cached":
+		return apiv1.TouchPolicyCached, nil
+	default:
+		return 0, errors.Errorf("unsupported touch policy '%s'", name)
+	}
+}
+
+// ParsePINPolicy returns the apiv1.PINPolicy for the given name, never, once
+// or always. An empty name returns apiv1.UnspecifiedPINPolicy.
+func ParsePINPolicy(name string) (apiv1.PINPolicy, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return apiv1.UnspecifiedPINPolicy, nil
+	case "never":
+		return apiv1.PINPolicyNever, nil
+	case "once":
+		return apiv1.PINPolicyOnce, nil
+	case "always":
+		return apiv1.PINPolicyAlways, nil
+	default:
+		return 0, errors.Errorf("unsupported pin policy '%s'", name)
+	}
+}
+
+// DefaultFileMode is the permission used by default to write the certificates
+// and keys.
+const DefaultFileMode os.FileMode = 0600
+
+// ParseFileMode parses an octal permission like 0640. An empty string returns
+// DefaultFileMode. The owner must be able to read and write the file, and the
+// group and others can only read it.
+func ParseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return DefaultFileMode, nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid file mode '%s'", s)
+	}
+	mode := os.FileMode(n)
+	if mode&0600 != 0600 || mode&^0644 != 0 {
+		return 0, errors.Errorf("invalid file mode '%s': the owner must read and write, and the group and others can only read", s)
+	}
+	return mode, nil
+}
+
+// CertificateFormat is the encoding used to write the certificates.
+type CertificateFormat string
+
+const (
+	// PEMFormat writes the certificates in PEM, the default, using the .crt
+	// extension.
+	PEMFormat CertificateFormat = "pem"
+	// DERFormat writes the certificates in binary DER using the .cer extension.
+	DERFormat CertificateFormat = "der"
+)
+
+// ParseCertificateFormat returns the certificate format with the given name,
+// pem or der. An empty string returns PEMFormat.
+func ParseCertificateFormat(name string) (CertificateFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "pem":
+		return PEMFormat, nil
+	case "der":
+		return DERFormat, nil
+	default:
+		return "", errors.Errorf("unsupported certificate format '%s'", name)
+	}
+}
+
+// Filename returns the name of the certificate file with the given base name
+// and the extension of the format, e.g. root_ca.crt or root_ca.cer.
+func (f CertificateFormat) Filename(base string) string {
+	if f == DERFormat {
+		return base + ".cer"
+	}
+	return base + ".crt"
+}
+
+// DERFilename returns the name of the copy in binary DER of the certificate
+// with the given base name, e.g. root_ca.der. The copy is written in addition
+// to the certificate in the selected format.
+func DERFilename(base string) string {
+	return base + ".der"
+}
+
+// Encode encodes the given DER certificate in the format.
+func (f CertificateFormat) Encode(der []byte) []byte {
+	if f == DERFormat {
+		return der
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+}
+
+// subjectPublicKeyInfo is the ASN.1 structure of a PKIX public key.
+type subjectPublicKeyInfo struct {
+	Algorithm        pkix.AlgorithmIdentifier
+	SubjectPublicKey asn1.BitString
+}
+
+// SubjectKeyID returns the subject key identifier of the given public key
+// using the given method.
+func SubjectKeyID(key crypto.PublicKey, method SubjectKeyIDMethod) ([]byte, error) {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling public key")
+	}
+	switch method {
+	case "", RFC5280:
+		hash := sha1.Sum(b)
+		return hash[:], nil
+	case RFC7093:
+		var info subjectPublicKeyInfo
+		if _, err := asn1.Unmarshal(b, &info); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling public key")
+		}
+		hash := sha256.Sum256(info.SubjectPublicKey.Bytes)
+		return hash[:20], nil
+	default:
+		return nil, errors.Errorf("unsupported subject key identifier method '%s'", method)
+	}
+}
@@ -0,0 +1,641 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+type fakeKeyManager struct{}
+
+func (fakeKeyManager) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fakeKeyManager) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fakeKeyManager) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fakeKeyManager) Close() error {
+	return nil
+}
+
+type fakeCertificateManager struct {
+	fakeKeyManager
+	store  func(req *apiv1.StoreCertificateRequest) error
+	load   func(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error)
+	delete func(req *apiv1.DeleteCertificateRequest) error
+}
+
+func (f *fakeCertificateManager) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	if f.load == nil {
+		return nil, errors.New("not implemented")
+	}
+	return f.load(req)
+}
+
+func (f *fakeCertificateManager) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
+	return f.store(req)
+}
+
+func (f *fakeCertificateManager) DeleteCertificate(req *apiv1.DeleteCertificateRequest) error {
+	return f.delete(req)
+}
+
+type fakeKeyURIFormatter struct {
+	fakeKeyManager
+}
+
+func (fakeKeyURIFormatter) KeyURI(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("key name cannot be empty")
+	}
+	return "fake:key=" + name, nil
+}
+
+type fakeKeyLister struct {
+	fakeKeyManager
+	keys   []apiv1.KeyInfo
+	err    error
+	parent string
+}
+
+func (f *fakeKeyLister) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	f.parent = req.Parent
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &apiv1.ListKeysResponse{Keys: f.keys}, nil
+}
+
+func TestSerialNumber(t *testing.T) {
+	fixed := bytes.Repeat([]byte{0x01}, 16)
+	want := new(big.Int).SetBytes(fixed)
+
+	type args struct {
+		r io.Reader
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *big.Int
+		wantErr bool
+	}{
+		{"ok deterministic", args{bytes.NewReader(fixed)}, want, false},
+		{"fail zero", args{bytes.NewReader(make([]byte, 16))}, nil, true},
+		{"fail short read", args{bytes.NewReader([]byte{0x01})}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SerialNumber(tt.args.r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SerialNumber() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SerialNumber() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSerialNumber_random(t *testing.T) {
+	limit := new(big.Int).Lsh(big.NewInt(1), serialNumberBits)
+	for _, r := range []io.Reader{nil, rand.Reader} {
+		a, err := SerialNumber(r)
+		if err != nil {
+			t.Fatalf("SerialNumber() error = %v", err)
+		}
+		b, err := SerialNumber(r)
+		if err != nil {
+			t.Fatalf("SerialNumber() error = %v", err)
+		}
+		if a.Sign() <= 0 || a.Cmp(limit) >= 0 {
+			t.Errorf("SerialNumber() = %v, want a positive number of at most %d bits", a, serialNumberBits)
+		}
+		if a.Cmp(b) == 0 {
+			t.Errorf("SerialNumber() returned the same serial number twice: %v", a)
+		}
+	}
+}
+
+func TestStoreCertificate(t *testing.T) {
+	cert := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	stored := func(req *apiv1.StoreCertificateRequest) error {
+		if req.Name != "root" || req.Certificate != cert {
+			return errors.New("unexpected request")
+		}
+		return nil
+	}
+	failed := func(req *apiv1.StoreCertificateRequest) error {
+		return errors.New("an error")
+	}
+
+	type args struct {
+		k    apiv1.KeyManager
+		name string
+		cert *x509.Certificate
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{&fakeCertificateManager{store: stored}, "root", cert}, false},
+		{"fail not supported", args{fakeKeyManager{}, "root", cert}, true},
+		{"fail store", args{&fakeCertificateManager{store: failed}, "root", cert}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := StoreCertificate(tt.args.k, tt.args.name, tt.args.cert); (err != nil) != tt.wantErr {
+				t.Errorf("StoreCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteCertificate(t *testing.T) {
+	deleted := func(req *apiv1.DeleteCertificateRequest) error {
+		if req.Name != "9c" {
+			return errors.New("unexpected request")
+		}
+		return nil
+	}
+	failed := func(req *apiv1.DeleteCertificateRequest) error {
+		return errors.New("an error")
+	}
+
+	type args struct {
+		k    apiv1.KeyManager
+		name string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{&fakeCertificateManager{delete: deleted}, "9c"}, false},
+		{"fail not supported", args{fakeKeyManager{}, "9c"}, true},
+		{"fail delete", args{&fakeCertificateManager{delete: failed}, "9c"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := DeleteCertificate(tt.args.k, tt.args.name); (err != nil) != tt.wantErr {
+				t.Errorf("DeleteCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyStoredCertificate(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("root"), SerialNumber: big.NewInt(1)}
+	loaded := func(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+		if req.Name != "9a" {
+			return nil, errors.New("unexpected request")
+		}
+		return &x509.Certificate{Raw: []byte("root"), SerialNumber: big.NewInt(1)}, nil
+	}
+	mismatch := func(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+		return &x509.Certificate{Raw: []byte("other"), SerialNumber: big.NewInt(2)}, nil
+	}
+	failed := func(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+		return nil, errors.New("an error")
+	}
+
+	type args struct {
+		k    apiv1.KeyManager
+		name string
+		cert *x509.Certificate
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", args{&fakeCertificateManager{load: loaded}, "9a", cert}, &x509.Certificate{Raw: []byte("root"), SerialNumber: big.NewInt(1)}, false},
+		{"fail not supported", args{fakeKeyManager{}, "9a", cert}, nil, true},
+		{"fail load", args{&fakeCertificateManager{load: failed}, "9a", cert}, nil, true},
+		{"fail mismatch", args{&fakeCertificateManager{load: mismatch}, "9a", cert}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyStoredCertificate(tt.args.k, tt.args.name, tt.args.cert)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyStoredCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VerifyStoredCertificate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	type args struct {
+		k    apiv1.KeyManager
+		name string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{"ok", args{fakeKeyURIFormatter{}, "root"}, "fake:key=root", false},
+		{"ok not supported", args{fakeKeyManager{}, "root"}, "root", false},
+		{"fail", args{fakeKeyURIFormatter{}, ""}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := KeyURI(tt.args.k, tt.args.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyURI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("KeyURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatedKeyURI(t *testing.T) {
+	type args struct {
+		k    apiv1.KeyManager
+		resp *apiv1.CreateKeyResponse
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{"ok", args{fakeKeyURIFormatter{}, &apiv1.CreateKeyResponse{Name: "root", KeyURI: "fake:key=1"}}, "fake:key=1", false},
+		{"ok formatter", args{fakeKeyURIFormatter{}, &apiv1.CreateKeyResponse{Name: "root"}}, "fake:key=root", false},
+		{"ok not supported", args{fakeKeyManager{}, &apiv1.CreateKeyResponse{Name: "root"}}, "root", false},
+		{"fail", args{fakeKeyURIFormatter{}, &apiv1.CreateKeyResponse{}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreatedKeyURI(tt.args.k, tt.args.resp)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreatedKeyURI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CreatedKeyURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExistingKeys(t *testing.T) {
+	lister := &fakeKeyLister{keys: []apiv1.KeyInfo{
+		{Name: "root", Key: "fake:key=1"},
+		{Name: "intermediate", Key: "fake:key=2"},
+		{Name: "root", Key: "fake:key=3"},
+		{Name: "other", Key: "fake:key=4"},
+	}}
+
+	type args struct {
+		k      apiv1.KeyManager
+		parent string
+		names  []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []apiv1.KeyInfo
+		wantErr bool
+	}{
+		{"ok", args{lister, "ring", []string{"root", "ssh-user-key"}}, []apiv1.KeyInfo{
+			{Name: "root", Key: "fake:key=1"}, {Name: "root", Key: "fake:key=3"},
+		}, false},
+		{"ok none", args{lister, "ring", []string{"ssh-host-key"}}, nil, false},
+		{"ok not supported", args{fakeKeyManager{}, "ring", []string{"root"}}, nil, false},
+		{"fail", args{&fakeKeyLister{err: errors.New("an error")}, "ring", []string{"root"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExistingKeys(tt.args.k, tt.args.parent, tt.args.names)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExistingKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExistingKeys() = %v, want %v", got, tt.want)
+			}
+			if l, ok := tt.args.k.(*fakeKeyLister); ok && l.parent != tt.args.parent {
+				t.Errorf("ExistingKeys() parent = %v, want %v", l.parent, tt.args.parent)
+			}
+		})
+	}
+}
+
+type hashSigner struct {
+	crypto.Signer
+	hash crypto.Hash
+	err  error
+}
+
+func (s *hashSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.hash = opts.HashFunc()
+	if len(digest) != s.hash.Size() {
+		return nil, errors.New("unexpected digest size")
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestWarmup(t *testing.T) {
+	mustSigner := func(curve elliptic.Curve) crypto.Signer {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	tests := []struct {
+		name     string
+		signer   *hashSigner
+		wantHash crypto.Hash
+		wantErr  bool
+	}{
+		{"ok P-256", &hashSigner{Signer: mustSigner(elliptic.P256())}, crypto.SHA256, false},
+		{"ok P-384", &hashSigner{Signer: mustSigner(elliptic.P384())}, crypto.SHA384, false},
+		{"ok P-521", &hashSigner{Signer: mustSigner(elliptic.P521())}, crypto.SHA512, false},
+		{"fail sign", &hashSigner{Signer: mustSigner(elliptic.P256()), err: errors.New("an error")}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Warmup(tt.signer); (err != nil) != tt.wantErr {
+				t.Errorf("Warmup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.signer.hash != tt.wantHash {
+				t.Errorf("Warmup() hash = %v, want %v", tt.signer.hash, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"failure", errors.New("an error"), ExitFailure},
+		{"permission denied", apiv1.ErrPermissionDenied{}, ExitPermissionDenied},
+		{"permission denied wrapped", pkgerrors.Wrap(apiv1.ErrPermissionDenied{}, "error creating key"), ExitPermissionDenied},
+		{"not found", apiv1.ErrNotFound{}, ExitNotFound},
+		{"already exists", pkgerrors.Wrap(apiv1.ErrAlreadyExists{}, "error creating key"), ExitAlreadyExists},
+		{"unsupported algorithm", apiv1.ErrUnsupportedAlgorithm{}, ExitUnsupportedAlgorithm},
+		{"not implemented", apiv1.ErrNotImplemented{}, ExitNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubjectKeyIDMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    SubjectKeyIDMethod
+		wantErr bool
+	}{
+		{"", RFC5280, false},
+		{"rfc5280", RFC5280, false},
+		{"RFC7093", RFC7093, false},
+		{"sha1", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSubjectKeyIDMethod(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSubjectKeyIDMethod() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSubjectKeyIDMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTouchPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    apiv1.TouchPolicy
+		wantErr bool
+	}{
+		{"", apiv1.UnspecifiedTouchPolicy, false},
+		{"never", apiv1.TouchPolicyNever, false},
+		{"Always", apiv1.TouchPolicyAlways, false},
+		{"cached", apiv1.TouchPolicyCached, false},
+		{"once", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTouchPolicy(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTouchPolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseTouchPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePINPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    apiv1.PINPolicy
+		wantErr bool
+	}{
+		{"", apiv1.UnspecifiedPINPolicy, false},
+		{"never", apiv1.PINPolicyNever, false},
+		{"ONCE", apiv1.PINPolicyOnce, false},
+		{"always", apiv1.PINPolicyAlways, false},
+		{"cached", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePINPolicy(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePINPolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParsePINPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"", 0600, false},
+		{"0600", 0600, false},
+		{"640", 0640, false},
+		{"0644", 0644, false},
+		{"0400", 0, true},
+		{"0660", 0, true},
+		{"0700", 0, true},
+		{"0666", 0, true},
+		{"1600", 0, true},
+		{"0800", 0, true},
+		{"rw-r-----", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFileMode(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFileMode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseFileMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCertificateFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    CertificateFormat
+		wantErr bool
+	}{
+		{"", PEMFormat, false},
+		{"pem", PEMFormat, false},
+		{"DER", DERFormat, false},
+		{"p12", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCertificateFormat(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCertificateFormat() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseCertificateFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateFormat_Encode(t *testing.T) {
+	der := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	tests := []struct {
+		name         string
+		format       CertificateFormat
+		wantFilename string
+		want         []byte
+	}{
+		{"pem", PEMFormat, "root_ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+		{"default", "", "root_ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+		{"der", DERFormat, "root_ca.cer", der},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.Filename("root_ca"); got != tt.wantFilename {
+				t.Errorf("CertificateFormat.Filename() = %v, want %v", got, tt.wantFilename)
+			}
+			if got := tt.format.Encode(der); !bytes.Equal(got, tt.want) {
+				t.Errorf("CertificateFormat.Encode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDERFilename(t *testing.T) {
+	if got := DERFilename("intermediate_ca"); got != "intermediate_ca.der" {
+		t.Errorf("DERFilename() = %v, want intermediate_ca.der", got)
+	}
+}
+
+func TestSubjectKeyID(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(b, &info); err != nil {
+		t.Fatal(err)
+	}
+	sha1Sum := sha1.Sum(b)
+	sha256Sum := sha256.Sum256(info.SubjectPublicKey.Bytes)
+
+	type args struct {
+		key    crypto.PublicKey
+		method SubjectKeyIDMethod
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok default", args{key.Public(), ""}, sha1Sum[:], false},
+		{"ok rfc5280", args{key.Public(), RFC5280}, sha1Sum[:], false},
+		{"ok rfc7093", args{key.Public(), RFC7093}, sha256Sum[:20], false},
+		{"fail method", args{key.Public(), "foo"}, nil, true},
+		{"fail key", args{"foo", RFC5280}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SubjectKeyID(tt.args.key, tt.args.method)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SubjectKeyID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SubjectKeyID() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package pkiutil
+
+import (
+	"encoding/asn1"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePolicyOID parses a certificate policy identifier in dotted notation,
+// e.g. "1.3.6.1.4.1.37476.9000.64.1". The first arc must be 0, 1 or 2, and
+// under 0 and 1 the second arc must be lower than 40, see X.660.
+func ParsePolicyOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("invalid policy oid '%s': it must have at least two arcs", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		if p == "" || strings.TrimLeft(p, "0123456789") != "" || (len(p) > 1 && p[0] == '0') {
+			return nil, errors.Errorf("invalid policy oid '%s': '%s' is not a valid arc", s, p)
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.Errorf("invalid policy oid '%s': '%s' is not a valid arc", s, p)
+		}
+		oid[i] = n
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, errors.Errorf("invalid policy oid '%s': it is not a valid object identifier", s)
+	}
+	return oid, nil
+}
+
+// PolicyOIDs implements flag.Value to allow the definition of multiple
+// certificate policies using a repeated flag.
+type PolicyOIDs []asn1.ObjectIdentifier
+
+// String implements flag.Value and returns the policies in dotted notation.
+func (v *PolicyOIDs) String() string {
+	if v == nil {
+		return ""
+	}
+	oids := make([]string, len(*v))
+	for i, oid := range *v {
+		oids[i] = oid.String()
+	}
+	return strings.Join(oids, ",")
+}
+
+// Set implements flag.Value and adds a new policy. Policies must be unique.
+func (v *PolicyOIDs) Set(s string) error {
+	oid, err := ParsePolicyOID(s)
+	if err != nil {
+		return err
+	}
+	for _, o := range *v {
+		if o.Equal(oid) {
+			return errors.Errorf("policy oid '%s' is already defined", s)
+		}
+	}
+	*v = append(*v, oid)
+	return nil
+}
@@ -0,0 +1,70 @@
+package pkiutil
+
+import (
+	"encoding/asn1"
+	"reflect"
+	"testing"
+)
+
+func TestParsePolicyOID(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    asn1.ObjectIdentifier
+		wantErr bool
+	}{
+		{"ok", "1.3.6.1.4.1.37476.9000.64.1", asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1}, false},
+		{"ok any policy", "2.5.29.32.0", asn1.ObjectIdentifier{2, 5, 29, 32, 0}, false},
+		{"ok joint iso", "2.999.1", asn1.ObjectIdentifier{2, 999, 1}, false},
+		{"fail empty", "", nil, true},
+		{"fail one arc", "1", nil, true},
+		{"fail empty arc", "1..3", nil, true},
+		{"fail trailing dot", "1.3.", nil, true},
+		{"fail not a number", "1.3.a", nil, true},
+		{"fail negative", "1.-3", nil, true},
+		{"fail sign", "1.+3", nil, true},
+		{"fail leading zero", "1.03", nil, true},
+		{"fail first arc", "3.1", nil, true},
+		{"fail second arc", "1.40", nil, true},
+		{"fail overflow", "1.3.99999999999999999999", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicyOID(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePolicyOID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePolicyOID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyOIDs_Set(t *testing.T) {
+	var v PolicyOIDs
+	if err := v.Set("1.3.6.1.4.1.37476.9000.64.1"); err != nil {
+		t.Fatalf("PolicyOIDs.Set() error = %v", err)
+	}
+	if err := v.Set("2.23.140.1.2.1"); err != nil {
+		t.Fatalf("PolicyOIDs.Set() error = %v", err)
+	}
+	if err := v.Set("2.23.140.1.2.1"); err == nil {
+		t.Error("PolicyOIDs.Set() with a duplicated oid did not fail")
+	}
+	if err := v.Set("not an oid"); err == nil {
+		t.Error("PolicyOIDs.Set() with an invalid oid did not fail")
+	}
+
+	want := PolicyOIDs{
+		{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1},
+		{2, 23, 140, 1, 2, 1},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("PolicyOIDs = %v, want %v", v, want)
+	}
+	if s := v.String(); s != "1.3.6.1.4.1.37476.9000.64.1,2.23.140.1.2.1" {
+		t.Errorf("PolicyOIDs.String() = %v, want 1.3.6.1.4.1.37476.9000.64.1,2.23.140.1.2.1", s)
+	}
+}
@@ -0,0 +1,41 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHName is the name used in the comments of the SSH CA public keys if
+// the init tools do not define one.
+const DefaultSSHName = "Smallstep"
+
+// SSHAuthorizedKey returns the given public key in the OpenSSH authorized_keys
+// format. The key type is defined by the key, ECDSA keys on the NIST P-256,
+// P-384 and P-521 curves will be encoded as ecdsa-sha2-nistp256,
+// ecdsa-sha2-nistp384 and ecdsa-sha2-nistp521 respectively. If comment is not
+// empty it is added after the key, any line break or run of spaces in it is
+// replaced by a single space.
+func SSHAuthorizedKey(pub crypto.PublicKey, comment string) ([]byte, error) {
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, errors.Wrap(err, "error converting public key to ssh")
+	}
+	b := ssh.MarshalAuthorizedKey(key)
+	if comment = strings.Join(strings.Fields(comment), " "); comment != "" {
+		b = append(bytes.TrimSuffix(b, []byte("\n")), " "+comment+"\n"...)
+	}
+	return b, nil
+}
+
+// SSHCAComment returns the comment of the public key of an SSH CA, it
+// identifies the CA with its name, e.g. "Smallstep SSH User CA", the creation
+// date and the key name, so the keys can be told apart in the SSH
+// configuration.
+func SSHCAComment(name, keyName string, t time.Time) string {
+	return name + " (created " + t.UTC().Format("2006-01-02") + ", key " + keyName + ")"
+}
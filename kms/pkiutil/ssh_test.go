@@ -0,0 +1,127 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHAuthorizedKey(t *testing.T) {
+	mustECDSA := func(curve elliptic.Curve) crypto.PublicKey {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key.Public()
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		pub      crypto.PublicKey
+		wantType string
+		wantErr  bool
+	}{
+		{"ok P-256", mustECDSA(elliptic.P256()), "ecdsa-sha2-nistp256", false},
+		{"ok P-384", mustECDSA(elliptic.P384()), "ecdsa-sha2-nistp384", false},
+		{"ok P-521", mustECDSA(elliptic.P521()), "ecdsa-sha2-nistp521", false},
+		{"ok RSA", rsaKey.Public(), "ssh-rsa", false},
+		{"ok Ed25519", edKey, "ssh-ed25519", false},
+		{"fail P-224", mustECDSA(elliptic.P224()), "", true},
+		{"fail type", []byte("foo"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SSHAuthorizedKey(tt.pub, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SSHAuthorizedKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !bytes.HasPrefix(got, []byte(tt.wantType+" ")) {
+				t.Errorf("SSHAuthorizedKey() = %s, want type %s", got, tt.wantType)
+			}
+
+			// The output must be parseable as an authorized key.
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(got)
+			if err != nil {
+				t.Fatalf("ssh.ParseAuthorizedKey() error = %v", err)
+			}
+			if len(rest) != 0 {
+				t.Errorf("ssh.ParseAuthorizedKey() rest = %s, want empty", rest)
+			}
+			if key.Type() != tt.wantType {
+				t.Errorf("ssh.ParseAuthorizedKey() type = %s, want %s", key.Type(), tt.wantType)
+			}
+			if cpk, ok := key.(ssh.CryptoPublicKey); !ok || !reflect.DeepEqual(cpk.CryptoPublicKey(), tt.pub) {
+				t.Errorf("ssh.ParseAuthorizedKey() key does not match the original key")
+			}
+		})
+	}
+}
+
+func TestSSHAuthorizedKey_comment(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		comment     string
+		wantComment string
+	}{
+		{"ok", "Smallstep SSH User CA", "Smallstep SSH User CA"},
+		{"ok empty", "", ""},
+		{"ok spaces", "  ", ""},
+		{"ok line breaks", "Smallstep\nSSH User CA\r\n", "Smallstep SSH User CA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SSHAuthorizedKey(key.Public(), tt.comment)
+			if err != nil {
+				t.Fatalf("SSHAuthorizedKey() error = %v", err)
+			}
+			if !bytes.HasSuffix(got, []byte("\n")) || bytes.Count(got, []byte("\n")) != 1 {
+				t.Errorf("SSHAuthorizedKey() = %q, want a single line", got)
+			}
+			_, comment, _, rest, err := ssh.ParseAuthorizedKey(got)
+			if err != nil {
+				t.Fatalf("ssh.ParseAuthorizedKey() error = %v", err)
+			}
+			if len(rest) != 0 {
+				t.Errorf("ssh.ParseAuthorizedKey() rest = %s, want empty", rest)
+			}
+			if comment != tt.wantComment {
+				t.Errorf("ssh.ParseAuthorizedKey() comment = %q, want %q", comment, tt.wantComment)
+			}
+		})
+	}
+}
+
+func TestSSHCAComment(t *testing.T) {
+	now := time.Date(2020, 9, 1, 23, 30, 0, 0, time.FixedZone("PDT", -7*60*60))
+	got := SSHCAComment("Smallstep SSH User CA", "awskms:key-id=abc", now)
+	want := "Smallstep SSH User CA (created 2020-09-02, key awskms:key-id=abc)"
+	if got != want {
+		t.Errorf("SSHCAComment() = %q, want %q", got, want)
+	}
+}
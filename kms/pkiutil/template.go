@@ -0,0 +1,235 @@
+package pkiutil
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+)
+
+// Certificate types used as the Type in the TemplateData.
+const (
+	RootTemplate         = "root"
+	IntermediateTemplate = "intermediate"
+)
+
+// TemplateData is the data used to render a certificate template. Type is
+// RootTemplate or IntermediateTemplate, and Name is the name of the
+// intermediate defined with the --intermediate flag, it is empty for the root
+// and the default intermediate.
+type TemplateData struct {
+	Type string
+	Name string
+}
+
+// CertificateTemplate defines the properties of a CA certificate that can be
+// customized using a template. Empty properties keep the defaults set by the
+// init tools.
+type CertificateTemplate struct {
+	Subject               *TemplateSubject    `json:"subject,omitempty"`
+	Validity              string              `json:"validity,omitempty"`
+	KeyUsage              []string            `json:"keyUsage,omitempty"`
+	ExtKeyUsage           []string            `json:"extKeyUsage,omitempty"`
+	MaxPathLen            *int                `json:"maxPathLen,omitempty"`
+	OCSPServer            []string            `json:"ocspServer,omitempty"`
+	IssuingCertificateURL []string            `json:"issuingCertificateURL,omitempty"`
+	CRLDistributionPoints []string            `json:"crlDistributionPoints,omitempty"`
+	Extensions            []TemplateExtension `json:"extensions,omitempty"`
+}
+
+// TemplateSubject is the subject of a certificate template.
+type TemplateSubject struct {
+	CommonName         string   `json:"commonName,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	StreetAddress      []string `json:"streetAddress,omitempty"`
+	PostalCode         []string `json:"postalCode,omitempty"`
+}
+
+// TemplateExtension is an extra extension added to a certificate, the value
+// is the base64 encoded DER of the extension value.
+type TemplateExtension struct {
+	ID       string `json:"id"`
+	Critical bool   `json:"critical,omitempty"`
+	Value    []byte `json:"value"`
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// TemplateFile is a Go text/template that renders a CertificateTemplate in
+// JSON format. The same template is rendered for every certificate, the
+// TemplateData can be used to render different properties for the root and
+// the intermediates.
+type TemplateFile struct {
+	tmpl *template.Template
+}
+
+// ParseTemplateFile reads and parses the certificate template in the given
+// file. The template can use the sprig functions.
+func ParseTemplateFile(filename string) (*TemplateFile, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	tmpl, err := template.New(filename).Funcs(sprig.TxtFuncMap()).Parse(string(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", filename)
+	}
+	return &TemplateFile{tmpl: tmpl}, nil
+}
+
+// Render executes the template with the given data and returns the resulting
+// certificate template.
+func (f *TemplateFile) Render(data TemplateData) (*CertificateTemplate, error) {
+	var buf bytes.Buffer
+	if err := f.tmpl.Execute(&buf, data); err != nil {
+		return nil, errors.Wrapf(err, "error executing %s", f.tmpl.Name())
+	}
+	var t CertificateTemplate
+	if err := json.Unmarshal(buf.Bytes(), &t); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling %s %s certificate template", f.tmpl.Name(), data.Type)
+	}
+	return &t, nil
+}
+
+// Apply renders the template with the given data and applies it to the given
+// certificate. It does nothing if the template file is nil, so it can be used
+// when the --template flag is not set.
+func (f *TemplateFile) Apply(cert *x509.Certificate, data TemplateData) error {
+	if f == nil {
+		return nil
+	}
+	t, err := f.Render(data)
+	if err != nil {
+		return err
+	}
+	return t.Apply(cert)
+}
+
+// Apply overwrites the properties of the given certificate with the ones set
+// in the template. The validity is added to the certificate NotBefore.
+func (t *CertificateTemplate) Apply(cert *x509.Certificate) error {
+	if t.Subject != nil {
+		subject := pkix.Name{
+			CommonName:         t.Subject.CommonName,
+			Country:            t.Subject.Country,
+			Organization:       t.Subject.Organization,
+			OrganizationalUnit: t.Subject.OrganizationalUnit,
+			Locality:           t.Subject.Locality,
+			Province:           t.Subject.Province,
+			StreetAddress:      t.Subject.StreetAddress,
+			PostalCode:         t.Subject.PostalCode,
+		}
+		// Self-signed certificates keep the issuer equal to the subject.
+		if reflect.DeepEqual(cert.Issuer, cert.Subject) {
+			cert.Issuer = subject
+		}
+		cert.Subject = subject
+	}
+	if t.Validity != "" {
+		d, err := time.ParseDuration(t.Validity)
+		if err != nil || d <= 0 {
+			return errors.Errorf("certificate template validity '%s' is not a valid duration", t.Validity)
+		}
+		cert.NotAfter = cert.NotBefore.Add(d)
+	}
+	if len(t.KeyUsage) > 0 {
+		var ku x509.KeyUsage
+		for _, s := range t.KeyUsage {
+			v, ok := keyUsages[strings.ToLower(s)]
+			if !ok {
+				return errors.Errorf("certificate template key usage '%s' is not supported", s)
+			}
+			ku |= v
+		}
+		cert.KeyUsage = ku
+	}
+	if len(t.ExtKeyUsage) > 0 {
+		ekus := make([]x509.ExtKeyUsage, len(t.ExtKeyUsage))
+		for i, s := range t.ExtKeyUsage {
+			v, ok := extKeyUsages[strings.ToLower(s)]
+			if !ok {
+				return errors.Errorf("certificate template extended key usage '%s' is not supported", s)
+			}
+			ekus[i] = v
+		}
+		cert.ExtKeyUsage = ekus
+	}
+	if t.MaxPathLen != nil {
+		if *t.MaxPathLen < 0 {
+			return errors.Errorf("certificate template maxPathLen '%d' cannot be negative", *t.MaxPathLen)
+		}
+		cert.MaxPathLen = *t.MaxPathLen
+		cert.MaxPathLenZero = *t.MaxPathLen == 0
+	}
+	if len(t.OCSPServer) > 0 {
+		cert.OCSPServer = t.OCSPServer
+	}
+	if len(t.IssuingCertificateURL) > 0 {
+		cert.IssuingCertificateURL = t.IssuingCertificateURL
+	}
+	if len(t.CRLDistributionPoints) > 0 {
+		cert.CRLDistributionPoints = t.CRLDistributionPoints
+	}
+	for _, e := range t.Extensions {
+		oid, err := parseObjectIdentifier(e.ID)
+		if err != nil {
+			return err
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id:       oid,
+			Critical: e.Critical,
+			Value:    e.Value,
+		})
+	}
+	return nil
+}
+
+func parseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("certificate template extension id '%s' is not valid", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("certificate template extension id '%s' is not valid", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
@@ -0,0 +1,214 @@
+package pkiutil
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTemplate(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestParseTemplateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkiutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ok := writeTemplate(t, dir, "ok.tpl", `{"subject": {"commonName": "{{ .Type }}"}}`)
+	fail := writeTemplate(t, dir, "fail.tpl", `{"subject": {"commonName": "{{ .Type }"}}`)
+
+	tests := []struct {
+		name     string
+		filename string
+		wantErr  bool
+	}{
+		{"ok", ok, false},
+		{"fail parse", fail, true},
+		{"fail missing", filepath.Join(dir, "missing.tpl"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTemplateFile(tt.filename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTemplateFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got == nil {
+				t.Error("ParseTemplateFile() = nil")
+			}
+		})
+	}
+}
+
+func TestTemplateFile_Apply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkiutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := ParseTemplateFile(writeTemplate(t, dir, "template.tpl", `{
+	"subject": {
+		"commonName": "Acme {{ if eq .Type "root" }}Root{{ else }}Intermediate {{ .Name | upper }}{{ end }} CA",
+		"organization": ["Acme"]
+	},
+	{{- if eq .Type "intermediate" }}
+	"validity": "8760h",
+	"maxPathLen": 0,
+	{{- end }}
+	"keyUsage": ["certSign", "crlSign", "digitalSignature"]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := ParseTemplateFile(writeTemplate(t, dir, "bad.tpl", `{"subject": "{{ .Type }}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	newCert := func() *x509.Certificate {
+		return &x509.Certificate{
+			IsCA:       true,
+			NotBefore:  now,
+			NotAfter:   now.Add(10 * time.Hour),
+			KeyUsage:   x509.KeyUsageCertSign,
+			MaxPathLen: 1,
+			Issuer:     pkix.Name{CommonName: "Default Root"},
+			Subject:    pkix.Name{CommonName: "Default Root"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		file    *TemplateFile
+		data    TemplateData
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok nil", nil, TemplateData{Type: RootTemplate}, newCert(), false},
+		{"ok root", f, TemplateData{Type: RootTemplate}, &x509.Certificate{
+			IsCA:       true,
+			NotBefore:  now,
+			NotAfter:   now.Add(10 * time.Hour),
+			KeyUsage:   x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+			MaxPathLen: 1,
+			Issuer:     pkix.Name{CommonName: "Acme Root CA", Organization: []string{"Acme"}},
+			Subject:    pkix.Name{CommonName: "Acme Root CA", Organization: []string{"Acme"}},
+		}, false},
+		{"ok intermediate", f, TemplateData{Type: IntermediateTemplate, Name: "tls"}, &x509.Certificate{
+			IsCA:           true,
+			NotBefore:      now,
+			NotAfter:       now.Add(8760 * time.Hour),
+			KeyUsage:       x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+			MaxPathLen:     0,
+			MaxPathLenZero: true,
+			Issuer:         pkix.Name{CommonName: "Acme Intermediate TLS CA", Organization: []string{"Acme"}},
+			Subject:        pkix.Name{CommonName: "Acme Intermediate TLS CA", Organization: []string{"Acme"}},
+		}, false},
+		{"fail json", bad, TemplateData{Type: RootTemplate}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := newCert()
+			err := tt.file.Apply(cert, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TemplateFile.Apply() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(cert, tt.want) {
+				t.Errorf("TemplateFile.Apply() = %v, want %v", cert, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateTemplate_Apply(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	zero := 0
+	negative := -1
+
+	tests := []struct {
+		name     string
+		template *CertificateTemplate
+		cert     *x509.Certificate
+		want     *x509.Certificate
+		wantErr  bool
+	}{
+		{"ok empty", &CertificateTemplate{}, &x509.Certificate{
+			Subject: pkix.Name{CommonName: "Root"}, NotBefore: now, MaxPathLen: 1,
+		}, &x509.Certificate{
+			Subject: pkix.Name{CommonName: "Root"}, NotBefore: now, MaxPathLen: 1,
+		}, false},
+		{"ok subject", &CertificateTemplate{
+			Subject: &TemplateSubject{CommonName: "Intermediate", Country: []string{"US"}},
+		}, &x509.Certificate{
+			Issuer:  pkix.Name{CommonName: "Root"},
+			Subject: pkix.Name{CommonName: "Default"},
+		}, &x509.Certificate{
+			Issuer:  pkix.Name{CommonName: "Root"},
+			Subject: pkix.Name{CommonName: "Intermediate", Country: []string{"US"}},
+		}, false},
+		{"ok usages", &CertificateTemplate{
+			Validity:    "24h",
+			KeyUsage:    []string{"CertSign", "crlSign"},
+			ExtKeyUsage: []string{"serverAuth", "ClientAuth"},
+			MaxPathLen:  &zero,
+		}, &x509.Certificate{
+			NotBefore: now, NotAfter: now.Add(time.Hour), KeyUsage: x509.KeyUsageDigitalSignature, MaxPathLen: 1,
+		}, &x509.Certificate{
+			NotBefore:      now,
+			NotAfter:       now.Add(24 * time.Hour),
+			KeyUsage:       x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			MaxPathLen:     0,
+			MaxPathLenZero: true,
+		}, false},
+		{"ok urls and extensions", &CertificateTemplate{
+			OCSPServer:            []string{"https://ocsp.acme.com"},
+			IssuingCertificateURL: []string{"https://acme.com/root.crt"},
+			CRLDistributionPoints: []string{"https://acme.com/root.crl"},
+			Extensions:            []TemplateExtension{{ID: "1.2.3.4", Critical: true, Value: []byte{0x05, 0x00}}},
+		}, &x509.Certificate{}, &x509.Certificate{
+			OCSPServer:            []string{"https://ocsp.acme.com"},
+			IssuingCertificateURL: []string{"https://acme.com/root.crt"},
+			CRLDistributionPoints: []string{"https://acme.com/root.crl"},
+			ExtraExtensions: []pkix.Extension{
+				{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Critical: true, Value: []byte{0x05, 0x00}},
+			},
+		}, false},
+		{"fail validity", &CertificateTemplate{Validity: "10y"}, &x509.Certificate{}, nil, true},
+		{"fail negative validity", &CertificateTemplate{Validity: "-1h"}, &x509.Certificate{}, nil, true},
+		{"fail key usage", &CertificateTemplate{KeyUsage: []string{"foo"}}, &x509.Certificate{}, nil, true},
+		{"fail ext key usage", &CertificateTemplate{ExtKeyUsage: []string{"foo"}}, &x509.Certificate{}, nil, true},
+		{"fail max path len", &CertificateTemplate{MaxPathLen: &negative}, &x509.Certificate{}, nil, true},
+		{"fail extension id", &CertificateTemplate{Extensions: []TemplateExtension{{ID: "1"}}}, &x509.Certificate{}, nil, true},
+		{"fail extension id number", &CertificateTemplate{Extensions: []TemplateExtension{{ID: "1.a"}}}, &x509.Certificate{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.template.Apply(tt.cert)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CertificateTemplate.Apply() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.cert, tt.want) {
+				t.Errorf("CertificateTemplate.Apply() = %v, want %v", tt.cert, tt.want)
+			}
+		})
+	}
+}
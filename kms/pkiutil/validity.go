@@ -0,0 +1,57 @@
+package pkiutil
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ParseValidity parses the RFC 3339 times used as the validity of the root and
+// intermediate certificates, e.g. 2030-01-01T00:00:00Z. Empty strings return
+// zero times and the default validity is used, from now and for ten years, or
+// for ten years after the given not before. The times are truncated to seconds,
+// the precision of the certificates, and not before must be before not after.
+func ParseValidity(notBefore, notAfter string) (time.Time, time.Time, error) {
+	var nb, na time.Time
+	var err error
+	if notBefore != "" {
+		if nb, err = time.Parse(time.RFC3339, notBefore); err != nil {
+			return time.Time{}, time.Time{}, errors.Errorf("invalid not before '%s': it must be an RFC 3339 time, e.g. 2030-01-01T00:00:00Z", notBefore)
+		}
+		nb = nb.Truncate(time.Second)
+	}
+	if notAfter != "" {
+		if na, err = time.Parse(time.RFC3339, notAfter); err != nil {
+			return time.Time{}, time.Time{}, errors.Errorf("invalid not after '%s': it must be an RFC 3339 time, e.g. 2030-01-01T00:00:00Z", notAfter)
+		}
+		na = na.Truncate(time.Second)
+		start := nb
+		if start.IsZero() {
+			start = time.Now()
+		}
+		if !start.Before(na) {
+			return time.Time{}, time.Time{}, errors.Errorf("invalid not after '%s': it must be after the not before", notAfter)
+		}
+	}
+	return nb, na, nil
+}
+
+// hasValidity returns true if the options define the validity of the
+// certificates.
+func (o PKIOptions) hasValidity() bool {
+	return !o.NotBefore.IsZero() || !o.NotAfter.IsZero()
+}
+
+// validity returns the not before and not after of the root and intermediate
+// certificates.
+func (o PKIOptions) validity() (time.Time, time.Time) {
+	notBefore := o.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	notAfter := o.NotAfter
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(defaultValidity)
+	}
+	return notBefore, notAfter
+}
@@ -0,0 +1,116 @@
+package pkiutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestParseValidity(t *testing.T) {
+	nb := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	na := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		notBefore     string
+		notAfter      string
+		wantNotBefore time.Time
+		wantNotAfter  time.Time
+		wantErr       bool
+	}{
+		{"ok empty", "", "", time.Time{}, time.Time{}, false},
+		{"ok both", "2030-01-01T00:00:00Z", "2040-01-01T00:00:00Z", nb, na, false},
+		{"ok not before", "2030-01-01T00:00:00Z", "", nb, time.Time{}, false},
+		{"ok not after", "", "2040-01-01T00:00:00Z", time.Time{}, na, false},
+		{"ok truncated", "2030-01-01T00:00:00.999Z", "2040-01-01T00:00:00Z", nb, na, false},
+		{"fail not before", "2030-01-01", "", time.Time{}, time.Time{}, true},
+		{"fail not after", "", "2040-01-01", time.Time{}, time.Time{}, true},
+		{"fail equal", "2030-01-01T00:00:00Z", "2030-01-01T00:00:00Z", time.Time{}, time.Time{}, true},
+		{"fail reversed", "2040-01-01T00:00:00Z", "2030-01-01T00:00:00Z", time.Time{}, time.Time{}, true},
+		{"fail not after in the past", "", "2000-01-01T00:00:00Z", time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotNotBefore, gotNotAfter, err := ParseValidity(tt.notBefore, tt.notAfter)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseValidity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !gotNotBefore.Equal(tt.wantNotBefore) {
+				t.Errorf("ParseValidity() notBefore = %v, want %v", gotNotBefore, tt.wantNotBefore)
+			}
+			if !gotNotAfter.Equal(tt.wantNotAfter) {
+				t.Errorf("ParseValidity() notAfter = %v, want %v", gotNotAfter, tt.wantNotAfter)
+			}
+		})
+	}
+}
+
+func TestCreatePKI_validity(t *testing.T) {
+	nb := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	na := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	k := newMemoryKeyManager()
+	pki, err := CreatePKI(k, PKIOptions{
+		RootKey:       &apiv1.CreateKeyRequest{Name: "root"},
+		Intermediates: []PKIIntermediate{{Key: &apiv1.CreateKeyRequest{Name: "intermediate"}}},
+		NoWarmup:      true,
+		NotBefore:     nb,
+		NotAfter:      na,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*PKICertificate{pki.Root, pki.Intermediates[0]} {
+		if !c.Certificate.NotBefore.Equal(nb) || !c.Certificate.NotAfter.Equal(na) {
+			t.Errorf("CreatePKI() validity = %v to %v, want %v to %v", c.Certificate.NotBefore, c.Certificate.NotAfter, nb, na)
+		}
+	}
+}
+
+func TestCreateIntermediateCertificate_validity(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nb := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	na := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	root, err := CreateRootCertificate(rootKey.Public(), rootKey, PKIOptions{
+		Intermediates: []PKIIntermediate{{}},
+		NotBefore:     nb,
+		NotAfter:      na,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		wantErr   bool
+	}{
+		{"ok same", nb, na, false},
+		{"ok within", nb.Add(time.Hour), na.Add(-time.Hour), false},
+		{"fail before root", nb.Add(-time.Hour), na, true},
+		{"fail after root", nb, na.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := PKIOptions{NotBefore: tt.notBefore, NotAfter: tt.notAfter}
+			_, err := CreateIntermediateCertificate(key.Public(), PKIIntermediate{}, root, rootKey, opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateIntermediateCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
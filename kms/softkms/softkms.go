@@ -7,6 +7,9 @@ import (
 	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
+	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
@@ -44,8 +47,15 @@ var generateKey = func(kty, crv string, size int) (interface{}, interface{}, err
 	return keys.GenerateKeyPair(kty, crv, size)
 }
 
-// SoftKMS is a key manager that uses keys stored in disk.
-type SoftKMS struct{}
+// SoftKMS is a key manager that uses keys stored in disk. The keys created
+// with CreateKey and the certificates stored with StoreCertificate are kept in
+// memory, and they can be used by name in the same SoftKMS, this allows to
+// initialize and test a PKI without a real KMS.
+type SoftKMS struct {
+	mu    sync.RWMutex
+	keys  map[string]crypto.Signer
+	certs map[string]*x509.Certificate
+}
 
 // New returns a new SoftKMS.
 func New(ctx context.Context, opts apiv1.Options) (*SoftKMS, error) {
@@ -63,6 +73,24 @@ func (k *SoftKMS) Close() error {
 	return nil
 }
 
+// Capabilities implements apiv1.CapabilitiesReporter and returns the signature
+// algorithms and protection levels supported by SoftKMS. RSA keys can be
+// created with any size.
+func (k *SoftKMS) Capabilities() *apiv1.Capabilities {
+	caps := &apiv1.Capabilities{
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.Software},
+	}
+	for alg := range signatureAlgorithmMapping {
+		if alg != apiv1.UnspecifiedSignAlgorithm {
+			caps.SignatureAlgorithms = append(caps.SignatureAlgorithms, alg)
+		}
+	}
+	sort.Slice(caps.SignatureAlgorithms, func(i, j int) bool {
+		return caps.SignatureAlgorithms[i] < caps.SignatureAlgorithms[j]
+	})
+	return caps
+}
+
 // CreateSigner returns a new signer configured with the given signing key.
 func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	var opts []pemutil.Options
@@ -84,6 +112,9 @@ func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 		}
 		return sig, nil
 	case req.SigningKey != "":
+		if sig, ok := k.loadKey(req.SigningKey); ok {
+			return sig, nil
+		}
 		v, err := pemutil.Read(req.SigningKey, opts...)
 		if err != nil {
 			return nil, err
@@ -99,9 +130,13 @@ func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 }
 
 func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if err := apiv1.CheckFIPSAlgorithm(req.SignatureAlgorithm, req.Bits); err != nil {
+		return nil, err
+	}
+
 	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
 	if !ok {
-		return nil, errors.Errorf("softKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+		return nil, apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("softKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)}
 	}
 
 	pub, priv, err := generateKey(v.Type, v.Curve, req.Bits)
@@ -113,6 +148,15 @@ func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 		return nil, errors.Errorf("softKMS createKey result is not a crypto.Signer: type %T", priv)
 	}
 
+	if req.Name != "" {
+		k.mu.Lock()
+		if k.keys == nil {
+			k.keys = make(map[string]crypto.Signer)
+		}
+		k.keys[req.Name] = signer
+		k.mu.Unlock()
+	}
+
 	return &apiv1.CreateKeyResponse{
 		Name:       req.Name,
 		PublicKey:  pub,
@@ -124,6 +168,10 @@ func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 }
 
 func (k *SoftKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if sig, ok := k.loadKey(req.Name); ok {
+		return sig.Public(), nil
+	}
+
 	v, err := pemutil.Read(req.Name)
 	if err != nil {
 		return nil, err
@@ -138,3 +186,62 @@ func (k *SoftKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey
 		return nil, errors.Errorf("unsupported public key type %T", v)
 	}
 }
+
+// LoadCertificate implements kms.CertificateManager and returns the
+// certificate stored with the given name, or reads it from disk if there is
+// not a stored certificate with that name.
+func (k *SoftKMS) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	if req.Name == "" {
+		return nil, errors.New("loadCertificateRequest 'name' cannot be empty")
+	}
+
+	k.mu.RLock()
+	cert, ok := k.certs[req.Name]
+	k.mu.RUnlock()
+	if ok {
+		return cert, nil
+	}
+
+	return pemutil.ReadCertificate(req.Name)
+}
+
+// StoreCertificate implements kms.CertificateManager and stores the
+// certificate in memory with the given name.
+func (k *SoftKMS) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
+	switch {
+	case req.Name == "":
+		return errors.New("storeCertificateRequest 'name' cannot be empty")
+	case req.Certificate == nil:
+		return errors.New("storeCertificateRequest 'Certificate' cannot be nil")
+	}
+
+	k.mu.Lock()
+	if k.certs == nil {
+		k.certs = make(map[string]*x509.Certificate)
+	}
+	k.certs[req.Name] = req.Certificate
+	k.mu.Unlock()
+	return nil
+}
+
+// DeleteCertificate implements kms.CertificateManager and removes the
+// certificate stored in memory with the given name. It does nothing if there is
+// not a stored certificate with that name. Files on disk are never removed.
+func (k *SoftKMS) DeleteCertificate(req *apiv1.DeleteCertificateRequest) error {
+	if req.Name == "" {
+		return errors.New("deleteCertificateRequest 'name' cannot be empty")
+	}
+
+	k.mu.Lock()
+	delete(k.certs, req.Name)
+	k.mu.Unlock()
+	return nil
+}
+
+// loadKey returns the signer of the key created with the given name.
+func (k *SoftKMS) loadKey(name string) (crypto.Signer, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	sig, ok := k.keys[name]
+	return sig, ok
+}
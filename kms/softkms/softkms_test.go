@@ -15,6 +15,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/smallstep/certificates/fips"
 	"github.com/smallstep/certificates/kms/apiv1"
 	"github.com/smallstep/cli/crypto/pemutil"
 )
@@ -115,6 +116,7 @@ func TestSoftKMS_CreateSigner(t *testing.T) {
 		{"pem", args{&apiv1.CreateSignerRequest{SigningKeyPEM: pem.EncodeToMemory(pemBlock)}}, pk, false},
 		{"pem password", args{&apiv1.CreateSignerRequest{SigningKeyPEM: pem.EncodeToMemory(pemBlockPassword), Password: []byte("pass")}}, pk, false},
 		{"file", args{&apiv1.CreateSignerRequest{SigningKey: "testdata/priv.pem", Password: []byte("pass")}}, pk2, false},
+		{"memory", args{&apiv1.CreateSignerRequest{SigningKey: "root"}}, pk, false},
 		{"fail", args{&apiv1.CreateSignerRequest{}}, nil, true},
 		{"fail bad pem", args{&apiv1.CreateSignerRequest{SigningKeyPEM: []byte("bad pem")}}, nil, true},
 		{"fail bad password", args{&apiv1.CreateSignerRequest{SigningKey: "testdata/priv.pem", Password: []byte("bad-pass")}}, nil, true},
@@ -124,7 +126,7 @@ func TestSoftKMS_CreateSigner(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := &SoftKMS{}
+			k := &SoftKMS{keys: map[string]crypto.Signer{"root": pk}}
 			got, err := k.CreateSigner(tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SoftKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
@@ -243,6 +245,10 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	type args struct {
 		req *apiv1.GetPublicKeyRequest
@@ -255,12 +261,13 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 	}{
 		{"key", args{&apiv1.GetPublicKeyRequest{Name: "testdata/pub.pem"}}, pub, false},
 		{"cert", args{&apiv1.GetPublicKeyRequest{Name: "testdata/cert.crt"}}, pub, false},
+		{"memory", args{&apiv1.GetPublicKeyRequest{Name: "root"}}, pk.Public(), false},
 		{"fail not exists", args{&apiv1.GetPublicKeyRequest{Name: "testdata/missing"}}, nil, true},
 		{"fail type", args{&apiv1.GetPublicKeyRequest{Name: "testdata/cert.key"}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := &SoftKMS{}
+			k := &SoftKMS{keys: map[string]crypto.Signer{"root": pk}}
 			got, err := k.GetPublicKey(tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SoftKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
@@ -273,6 +280,171 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 	}
 }
 
+func TestSoftKMS_CreateKey_memory(t *testing.T) {
+	k := &SoftKMS{}
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.ECDSAWithSHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "root"})
+	if err != nil {
+		t.Fatalf("SoftKMS.CreateSigner() error = %v", err)
+	}
+	if !reflect.DeepEqual(signer, resp.CreateSignerRequest.Signer) {
+		t.Errorf("SoftKMS.CreateSigner() = %v, want %v", signer, resp.CreateSignerRequest.Signer)
+	}
+
+	pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "root"})
+	if err != nil {
+		t.Fatalf("SoftKMS.GetPublicKey() error = %v", err)
+	}
+	if !reflect.DeepEqual(pub, resp.PublicKey) {
+		t.Errorf("SoftKMS.GetPublicKey() = %v, want %v", pub, resp.PublicKey)
+	}
+}
+
+func TestSoftKMS_CreateKey_fips(t *testing.T) {
+	fips.Enable()
+	defer fips.Disable()
+
+	k := &SoftKMS{}
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.ECDSAWithSHA256}); err != nil {
+		t.Errorf("SoftKMS.CreateKey() error = %v", err)
+	}
+	_, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.PureEd25519})
+	if _, ok := err.(fips.ErrNotPermitted); !ok {
+		t.Errorf("SoftKMS.CreateKey() error = %v, want fips.ErrNotPermitted", err)
+	}
+	_, err = k.CreateKey(&apiv1.CreateKeyRequest{Name: "root", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024})
+	if _, ok := err.(fips.ErrNotPermitted); !ok {
+		t.Errorf("SoftKMS.CreateKey() error = %v, want fips.ErrNotPermitted", err)
+	}
+}
+
+func TestSoftKMS_StoreCertificate(t *testing.T) {
+	cert, err := pemutil.ReadCertificate("testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		req *apiv1.StoreCertificateRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.StoreCertificateRequest{Name: "root", Certificate: cert}}, false},
+		{"fail name", args{&apiv1.StoreCertificateRequest{Certificate: cert}}, true},
+		{"fail certificate", args{&apiv1.StoreCertificateRequest{Name: "root"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			if err := k.StoreCertificate(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.StoreCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				got, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: tt.args.req.Name})
+				if err != nil {
+					t.Errorf("SoftKMS.LoadCertificate() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, cert) {
+					t.Errorf("SoftKMS.LoadCertificate() = %v, want %v", got, cert)
+				}
+			}
+		})
+	}
+}
+
+func TestSoftKMS_LoadCertificate(t *testing.T) {
+	cert, err := pemutil.ReadCertificate("testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		req *apiv1.LoadCertificateRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok memory", args{&apiv1.LoadCertificateRequest{Name: "root"}}, cert, false},
+		{"ok file", args{&apiv1.LoadCertificateRequest{Name: "testdata/cert.crt"}}, cert, false},
+		{"fail name", args{&apiv1.LoadCertificateRequest{}}, nil, true},
+		{"fail missing", args{&apiv1.LoadCertificateRequest{Name: "testdata/missing"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{certs: map[string]*x509.Certificate{"root": cert}}
+			got, err := k.LoadCertificate(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.LoadCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SoftKMS.LoadCertificate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftKMS_DeleteCertificate(t *testing.T) {
+	cert, err := pemutil.ReadCertificate("testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		req *apiv1.DeleteCertificateRequest
+	}
+	tests := []struct {
+		name    string
+		certs   map[string]*x509.Certificate
+		args    args
+		wantErr bool
+	}{
+		{"ok", map[string]*x509.Certificate{"root": cert}, args{&apiv1.DeleteCertificateRequest{Name: "root"}}, false},
+		{"ok not stored", nil, args{&apiv1.DeleteCertificateRequest{Name: "root"}}, false},
+		{"fail name", map[string]*x509.Certificate{"root": cert}, args{&apiv1.DeleteCertificateRequest{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{certs: tt.certs}
+			if err := k.DeleteCertificate(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.DeleteCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				if _, ok := k.certs[tt.args.req.Name]; ok {
+					t.Errorf("SoftKMS.DeleteCertificate() did not delete %s", tt.args.req.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestSoftKMS_Capabilities(t *testing.T) {
+	want := &apiv1.Capabilities{
+		SignatureAlgorithms: []apiv1.SignatureAlgorithm{
+			apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA,
+			apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS,
+			apiv1.ECDSAWithSHA256, apiv1.ECDSAWithSHA384, apiv1.ECDSAWithSHA512, apiv1.PureEd25519,
+		},
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.Software},
+	}
+	k := &SoftKMS{}
+	if got := k.Capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SoftKMS.Capabilities() = %v, want %v", got, want)
+	}
+}
+
 func Test_generateKey(t *testing.T) {
 	type args struct {
 		kty  string
@@ -0,0 +1,48 @@
+// +build cgo
+
+package yubikey
+
+import (
+	"crypto"
+	"crypto/x509"
+
+	"github.com/go-piv/piv-go/piv"
+)
+
+type mockPIV struct {
+	certificate    func(slot piv.Slot) (*x509.Certificate, error)
+	setCertificate func(key [24]byte, slot piv.Slot, cert *x509.Certificate) error
+	generateKey    func(key [24]byte, slot piv.Slot, opts piv.Key) (crypto.PublicKey, error)
+	privateKey     func(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error)
+	attest         func(slot piv.Slot) (*x509.Certificate, error)
+	serial         func() (uint32, error)
+	close          func() error
+}
+
+func (m *mockPIV) Certificate(slot piv.Slot) (*x509.Certificate, error) {
+	return m.certificate(slot)
+}
+
+func (m *mockPIV) SetCertificate(key [24]byte, slot piv.Slot, cert *x509.Certificate) error {
+	return m.setCertificate(key, slot, cert)
+}
+
+func (m *mockPIV) GenerateKey(key [24]byte, slot piv.Slot, opts piv.Key) (crypto.PublicKey, error) {
+	return m.generateKey(key, slot, opts)
+}
+
+func (m *mockPIV) PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error) {
+	return m.privateKey(slot, public, auth)
+}
+
+func (m *mockPIV) Attest(slot piv.Slot) (*x509.Certificate, error) {
+	return m.attest(slot)
+}
+
+func (m *mockPIV) Serial() (uint32, error) {
+	return m.serial()
+}
+
+func (m *mockPIV) Close() error {
+	return m.close()
+}
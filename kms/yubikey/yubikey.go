@@ -3,10 +3,13 @@
 package yubikey
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/x509"
+	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/go-piv/piv-go/piv"
@@ -16,10 +19,22 @@ import (
 
 // YubiKey implements the KMS interface on a YubiKey.
 type YubiKey struct {
-	yk  *piv.YubiKey
+	yk  pivKey
 	pin string
 }
 
+// pivKey contains the methods of *piv.YubiKey used by this package, it allows
+// to mock the device in the tests.
+type pivKey interface {
+	Certificate(slot piv.Slot) (*x509.Certificate, error)
+	SetCertificate(key [24]byte, slot piv.Slot, cert *x509.Certificate) error
+	GenerateKey(key [24]byte, slot piv.Slot, opts piv.Key) (crypto.PublicKey, error)
+	PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error)
+	Attest(slot piv.Slot) (*x509.Certificate, error)
+	Serial() (uint32, error)
+	Close() error
+}
+
 // New initializes a new YubiKey.
 // TODO(mariano): only one card is currently supported.
 func New(ctx context.Context, opts apiv1.Options) (*YubiKey, error) {
@@ -65,16 +80,31 @@ func (k *YubiKey) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Cert
 }
 
 // StoreCertificate implements kms.CertificateManager and stores a certificate
-// in the YubiKey.
+// in the YubiKey. It fails if the public key of the certificate is not the
+// public key of the key in the slot.
 func (k *YubiKey) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 	if req.Certificate == nil {
 		return errors.New("storeCertificateRequest 'Certificate' cannot be nil")
 	}
 
-	slot, err := getSlot(req.Name)
+	slot, name, err := getSlotAndName(req.Name)
+	if err != nil {
+		return err
+	}
+
+	// PIV does not have a command to read the public key of a slot, but the
+	// attestation certificate of the slot contains it.
+	att, err := k.yk.Attest(slot)
+	if err != nil {
+		return errors.Wrapf(err, "error retrieving the public key of %s", name)
+	}
+	ok, err := equalPublicKeys(att.PublicKey, req.Certificate.PublicKey)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return errors.Errorf("error storing certificate: the certificate public key does not match the key in %s", name)
+	}
 
 	err = k.yk.SetCertificate(piv.DefaultManagementKey, slot, req.Certificate)
 	if err != nil {
@@ -84,6 +114,29 @@ func (k *YubiKey) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 	return nil
 }
 
+// DeleteCertificate implements kms.CertificateManager and removes the
+// certificate stored in a YubiKey slot. PIV does not define a delete
+// operation, so the certificate object is overwritten with an empty one. It
+// does nothing if the slot does not have a certificate.
+func (k *YubiKey) DeleteCertificate(req *apiv1.DeleteCertificateRequest) error {
+	slot, err := getSlot(req.Name)
+	if err != nil {
+		return err
+	}
+
+	// An object that cannot be parsed is also overwritten.
+	if _, err := k.yk.Certificate(slot); errors.Is(err, piv.ErrNotFound) {
+		return nil
+	}
+
+	err = k.yk.SetCertificate(piv.DefaultManagementKey, slot, &x509.Certificate{})
+	if err != nil {
+		return errors.Wrap(err, "error deleting certificate")
+	}
+
+	return nil
+}
+
 // GetPublicKey returns the public key present in the YubiKey signature slot.
 func (k *YubiKey) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
 	slot, err := getSlot(req.Name)
@@ -101,6 +154,10 @@ func (k *YubiKey) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey
 
 // CreateKey generates a new key in the YubiKey and returns the public key.
 func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if err := apiv1.CheckFIPSAlgorithm(req.SignatureAlgorithm, req.Bits); err != nil {
+		return nil, err
+	}
+
 	alg, err := getSignatureAlgorithm(req.SignatureAlgorithm, req.Bits)
 	if err != nil {
 		return nil, err
@@ -109,11 +166,19 @@ func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 	if err != nil {
 		return nil, err
 	}
+	touchPolicy, ok := touchPolicyMapping[req.TouchPolicy]
+	if !ok {
+		return nil, errors.Errorf("YubiKey does not support touch policy '%s'", req.TouchPolicy)
+	}
+	pinPolicy, ok := pinPolicyMapping[req.PINPolicy]
+	if !ok {
+		return nil, errors.Errorf("YubiKey does not support pin policy '%s'", req.PINPolicy)
+	}
 
 	pub, err := k.yk.GenerateKey(piv.DefaultManagementKey, slot, piv.Key{
 		Algorithm:   alg,
-		PINPolicy:   piv.PINPolicyAlways,
-		TouchPolicy: piv.TouchPolicyNever,
+		PINPolicy:   pinPolicy,
+		TouchPolicy: touchPolicy,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating key")
@@ -124,6 +189,7 @@ func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 		CreateSignerRequest: apiv1.CreateSignerRequest{
 			SigningKey: name,
 		},
+		KeyURI: name,
 	}, nil
 }
 
@@ -154,6 +220,50 @@ func (k *YubiKey) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 	return signer, nil
 }
 
+// KeyURI returns the given key name as a yubikey URI, e.g.
+// yubikey:slot-id=9c.
+func (k *YubiKey) KeyURI(name string) (string, error) {
+	_, name, err := getSlotAndName(name)
+	return name, err
+}
+
+// CheckHealth verifies that the YubiKey is connected and responding, it reads
+// the serial number of the device and it does not modify any slot. The
+// request name is not used.
+func (k *YubiKey) CheckHealth(req *apiv1.CheckHealthRequest) error {
+	if _, err := k.yk.Serial(); err != nil {
+		return errors.Wrap(err, "yubikey health check failed: error reading serial number")
+	}
+	return nil
+}
+
+// Capabilities implements apiv1.CapabilitiesReporter and returns the signature
+// algorithms, RSA key sizes and protection levels supported by the YubiKey.
+func (k *YubiKey) Capabilities() *apiv1.Capabilities {
+	caps := &apiv1.Capabilities{
+		Bits:             make(map[apiv1.SignatureAlgorithm][]int),
+		ProtectionLevels: []apiv1.ProtectionLevel{apiv1.HSM},
+	}
+	for alg, v := range signatureAlgorithmMapping {
+		if alg == apiv1.UnspecifiedSignAlgorithm {
+			continue
+		}
+		caps.SignatureAlgorithms = append(caps.SignatureAlgorithms, alg)
+		if m, ok := v.(map[int]piv.Algorithm); ok {
+			for bits := range m {
+				if bits > 0 {
+					caps.Bits[alg] = append(caps.Bits[alg], bits)
+				}
+			}
+			sort.Ints(caps.Bits[alg])
+		}
+	}
+	sort.Slice(caps.SignatureAlgorithms, func(i, j int) bool {
+		return caps.SignatureAlgorithms[i] < caps.SignatureAlgorithms[j]
+	})
+	return caps
+}
+
 // Close releases the connection to the YubiKey.
 func (k *YubiKey) Close() error {
 	return errors.Wrap(k.yk.Close(), "error closing yubikey")
@@ -190,7 +300,7 @@ var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]interface{}{
 func getSignatureAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (piv.Algorithm, error) {
 	v, ok := signatureAlgorithmMapping[alg]
 	if !ok {
-		return 0, errors.Errorf("YubiKey does not support signature algorithm '%s'", alg)
+		return 0, apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("YubiKey does not support signature algorithm '%s'", alg)}
 	}
 
 	switch v := v.(type) {
@@ -199,7 +309,7 @@ func getSignatureAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (piv.Algorith
 	case map[int]piv.Algorithm:
 		signatureAlgorithm, ok := v[bits]
 		if !ok {
-			return 0, errors.Errorf("YubiKey does not support signature algorithm '%s' with '%d' bits", alg, bits)
+			return 0, apiv1.ErrUnsupportedAlgorithm{Message: fmt.Sprintf("YubiKey does not support signature algorithm '%s' with '%d' bits", alg, bits)}
 		}
 		return signatureAlgorithm, nil
 	default:
@@ -207,6 +317,37 @@ func getSignatureAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (piv.Algorith
 	}
 }
 
+// touchPolicyMapping is a mapping between the step touch policies and the
+// yubikey ones. By default a touch is never required.
+var touchPolicyMapping = map[apiv1.TouchPolicy]piv.TouchPolicy{
+	apiv1.UnspecifiedTouchPolicy: piv.TouchPolicyNever,
+	apiv1.TouchPolicyNever:       piv.TouchPolicyNever,
+	apiv1.TouchPolicyAlways:      piv.TouchPolicyAlways,
+	apiv1.TouchPolicyCached:      piv.TouchPolicyCached,
+}
+
+// pinPolicyMapping is a mapping between the step PIN policies and the yubikey
+// ones. By default the PIN is always required.
+var pinPolicyMapping = map[apiv1.PINPolicy]piv.PINPolicy{
+	apiv1.UnspecifiedPINPolicy: piv.PINPolicyAlways,
+	apiv1.PINPolicyNever:       piv.PINPolicyNever,
+	apiv1.PINPolicyOnce:        piv.PINPolicyOnce,
+	apiv1.PINPolicyAlways:      piv.PINPolicyAlways,
+}
+
+// equalPublicKeys returns true if both public keys are the same key.
+func equalPublicKeys(a, b crypto.PublicKey) (bool, error) {
+	ab, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling public key")
+	}
+	bb, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling public key")
+	}
+	return bytes.Equal(ab, bb), nil
+}
+
 var slotMapping = map[string]piv.Slot{
 	"9a": piv.SlotAuthentication,
 	"9c": piv.SlotSignature,
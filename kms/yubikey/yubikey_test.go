@@ -0,0 +1,118 @@
+// +build cgo
+
+package yubikey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-piv/piv-go/piv"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func mustCertificate(t *testing.T, cn string, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestYubiKey_StoreCertificate(t *testing.T) {
+	slotKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := mustCertificate(t, "YubiKey PIV Attestation 9c", slotKey)
+	cert := mustCertificate(t, "Root CA", slotKey)
+	mismatched := mustCertificate(t, "Root CA", otherKey)
+
+	var stored *x509.Certificate
+	okPIV := &mockPIV{
+		attest: func(slot piv.Slot) (*x509.Certificate, error) {
+			if slot != piv.SlotSignature {
+				return nil, piv.ErrNotFound
+			}
+			return attestation, nil
+		},
+		setCertificate: func(key [24]byte, slot piv.Slot, c *x509.Certificate) error {
+			stored = c
+			return nil
+		},
+	}
+
+	type fields struct {
+		yk pivKey
+	}
+	type args struct {
+		req *apiv1.StoreCertificateRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", fields{okPIV}, args{&apiv1.StoreCertificateRequest{
+			Name: "yubikey:slot-id=9c", Certificate: cert,
+		}}, cert, false},
+		{"fail nil certificate", fields{okPIV}, args{&apiv1.StoreCertificateRequest{
+			Name: "yubikey:slot-id=9c",
+		}}, nil, true},
+		{"fail slot", fields{okPIV}, args{&apiv1.StoreCertificateRequest{
+			Name: "yubikey:slot-id=01", Certificate: cert,
+		}}, nil, true},
+		{"fail mismatched key", fields{okPIV}, args{&apiv1.StoreCertificateRequest{
+			Name: "yubikey:slot-id=9c", Certificate: mismatched,
+		}}, nil, true},
+		{"fail attest", fields{okPIV}, args{&apiv1.StoreCertificateRequest{
+			Name: "yubikey:slot-id=9a", Certificate: cert,
+		}}, nil, true},
+		{"fail setCertificate", fields{&mockPIV{
+			attest: okPIV.attest,
+			setCertificate: func(key [24]byte, slot piv.Slot, c *x509.Certificate) error {
+				return fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.StoreCertificateRequest{
+			Name: "yubikey:slot-id=9c", Certificate: cert,
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored = nil
+			k := &YubiKey{
+				yk: tt.fields.yk,
+			}
+			if err := k.StoreCertificate(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.StoreCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stored != tt.want {
+				t.Errorf("YubiKey.StoreCertificate() stored = %v, want %v", stored, tt.want)
+			}
+		})
+	}
+}
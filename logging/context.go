@@ -25,6 +25,10 @@ func NewRequestID() string {
 // in the context so it can be written in the logger. If the header does not
 // exists or it's the empty string, it uses github.com/rs/xid to create a new
 // one.
+//
+// The request id is also written in the same header of the response, so a
+// client that gets an error can share it to find the reason in the logs,
+// without exposing it in the response.
 func RequestID(headerName string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, req *http.Request) {
@@ -33,6 +37,7 @@ func RequestID(headerName string) func(next http.Handler) http.Handler {
 				requestID = NewRequestID()
 				req.Header.Set(headerName, requestID)
 			}
+			w.Header().Set(headerName, requestID)
 
 			ctx := WithRequestID(req.Context(), requestID)
 			next.ServeHTTP(w, req.WithContext(ctx))
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{"ok", "the-request-id"},
+		{"ok generated", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID, headerID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var ok bool
+				if ctxID, ok = GetRequestID(r.Context()); !ok {
+					t.Error("GetRequestID() ok = false, want true")
+				}
+				headerID = r.Header.Get("X-Smallstep-Id")
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.requestID != "" {
+				req.Header.Set("X-Smallstep-Id", tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			RequestID("X-Smallstep-Id")(next).ServeHTTP(w, req)
+
+			if ctxID == "" {
+				t.Fatal("RequestID() did not set a request id in the context")
+			}
+			if tt.requestID != "" && ctxID != tt.requestID {
+				t.Errorf("GetRequestID() = %s, want %s", ctxID, tt.requestID)
+			}
+			if headerID != ctxID {
+				t.Errorf("request header = %s, want %s", headerID, ctxID)
+			}
+			if got := w.Header().Get("X-Smallstep-Id"); got != ctxID {
+				t.Errorf("response header = %s, want %s", got, ctxID)
+			}
+		})
+	}
+}
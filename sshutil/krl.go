@@ -0,0 +1,153 @@
+package sshutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KRL constants defined in the OpenSSH PROTOCOL.krl document.
+const (
+	krlMagic                 uint64 = 0x5353484b524c0a00
+	krlFormatVersion         uint32 = 1
+	krlSectionCertificates   byte   = 1
+	krlSectionCertSerialList byte   = 0x20
+	krlSectionCertKeyID      byte   = 0x23
+)
+
+// KRL is an OpenSSH Key Revocation List, it can be used in the RevokedKeys
+// option of sshd to reject the certificates revoked by a CA. Only the
+// revocation of certificates by serial number and key id is supported.
+type KRL struct {
+	Version       uint64
+	GeneratedDate time.Time
+	Comment       string
+	certs         []*krlCertificates
+}
+
+// krlCertificates are the certificates revoked for one CA key. A nil key
+// revokes the key ids for any CA.
+type krlCertificates struct {
+	ca      ssh.PublicKey
+	serials map[uint64]struct{}
+	keyIDs  map[string]struct{}
+}
+
+// NewKRL returns an empty KRL with the given version and comment.
+func NewKRL(version uint64, comment string) *KRL {
+	return &KRL{
+		Version:       version,
+		GeneratedDate: time.Now(),
+		Comment:       comment,
+	}
+}
+
+// RevokeSerial adds the certificate with the given serial number signed by the
+// given CA key to the KRL. Serial number 0 cannot be revoked, and it is
+// ignored.
+func (k *KRL) RevokeSerial(ca ssh.PublicKey, serial uint64) {
+	if ca == nil || serial == 0 {
+		return
+	}
+	k.section(ca).serials[serial] = struct{}{}
+}
+
+// RevokeKeyID adds the certificates with the given key id signed by the given
+// CA key to the KRL. A nil CA key revokes the key id for any CA.
+func (k *KRL) RevokeKeyID(ca ssh.PublicKey, keyID string) {
+	if keyID == "" {
+		return
+	}
+	k.section(ca).keyIDs[keyID] = struct{}{}
+}
+
+func (k *KRL) section(ca ssh.PublicKey) *krlCertificates {
+	for _, s := range k.certs {
+		if (s.ca == nil && ca == nil) || (s.ca != nil && ca != nil && bytes.Equal(s.ca.Marshal(), ca.Marshal())) {
+			return s
+		}
+	}
+	s := &krlCertificates{
+		ca:      ca,
+		serials: make(map[uint64]struct{}),
+		keyIDs:  make(map[string]struct{}),
+	}
+	k.certs = append(k.certs, s)
+	return s
+}
+
+// Marshal returns the KRL in the binary format used by OpenSSH.
+func (k *KRL) Marshal() []byte {
+	var b bytes.Buffer
+	writeUint64(&b, krlMagic)
+	writeUint32(&b, krlFormatVersion)
+	writeUint64(&b, k.Version)
+	writeUint64(&b, uint64(k.GeneratedDate.Unix()))
+	writeUint64(&b, 0) // flags
+	writeString(&b, nil)
+	writeString(&b, []byte(k.Comment))
+
+	for _, s := range k.certs {
+		var data bytes.Buffer
+		if s.ca != nil {
+			writeString(&data, s.ca.Marshal())
+		} else {
+			writeString(&data, nil)
+		}
+		writeString(&data, nil)
+
+		// Serial numbers must be sorted.
+		if len(s.serials) > 0 {
+			serials := make([]uint64, 0, len(s.serials))
+			for sn := range s.serials {
+				serials = append(serials, sn)
+			}
+			sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+			var list bytes.Buffer
+			for _, sn := range serials {
+				writeUint64(&list, sn)
+			}
+			data.WriteByte(krlSectionCertSerialList)
+			writeString(&data, list.Bytes())
+		}
+
+		if len(s.keyIDs) > 0 {
+			keyIDs := make([]string, 0, len(s.keyIDs))
+			for id := range s.keyIDs {
+				keyIDs = append(keyIDs, id)
+			}
+			sort.Strings(keyIDs)
+			var list bytes.Buffer
+			for _, id := range keyIDs {
+				writeString(&list, []byte(id))
+			}
+			data.WriteByte(krlSectionCertKeyID)
+			writeString(&data, list.Bytes())
+		}
+
+		b.WriteByte(krlSectionCertificates)
+		writeString(&b, data.Bytes())
+	}
+
+	return b.Bytes()
+}
+
+func writeUint32(b *bytes.Buffer, v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	b.Write(buf[:])
+}
+
+func writeUint64(b *bytes.Buffer, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	b.Write(buf[:])
+}
+
+func writeString(b *bytes.Buffer, s []byte) {
+	writeUint32(b, uint32(len(s)))
+	b.Write(s)
+}
@@ -0,0 +1,134 @@
+package sshutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+type krlReader []byte
+
+func (r *krlReader) uint32(t *testing.T) uint32 {
+	assert.Fatal(t, len(*r) >= 4, "unexpected end of KRL")
+	v := binary.BigEndian.Uint32(*r)
+	*r = (*r)[4:]
+	return v
+}
+
+func (r *krlReader) uint64(t *testing.T) uint64 {
+	assert.Fatal(t, len(*r) >= 8, "unexpected end of KRL")
+	v := binary.BigEndian.Uint64(*r)
+	*r = (*r)[8:]
+	return v
+}
+
+func (r *krlReader) byte(t *testing.T) byte {
+	assert.Fatal(t, len(*r) >= 1, "unexpected end of KRL")
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v
+}
+
+func (r *krlReader) string(t *testing.T) []byte {
+	n := int(r.uint32(t))
+	assert.Fatal(t, len(*r) >= n, "unexpected end of KRL")
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v
+}
+
+func mustPublicKey(t *testing.T) ssh.PublicKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pub, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	return pub
+}
+
+func TestKRL_Marshal(t *testing.T) {
+	user := mustPublicKey(t)
+	host := mustPublicKey(t)
+	now := time.Unix(1600000000, 0)
+
+	t.Run("empty", func(t *testing.T) {
+		krl := NewKRL(1, "comment")
+		krl.GeneratedDate = now
+		r := krlReader(krl.Marshal())
+		assert.Equals(t, krlMagic, r.uint64(t))
+		assert.Equals(t, krlFormatVersion, r.uint32(t))
+		assert.Equals(t, uint64(1), r.uint64(t))
+		assert.Equals(t, uint64(now.Unix()), r.uint64(t))
+		assert.Equals(t, uint64(0), r.uint64(t))
+		assert.Equals(t, []byte{}, r.string(t))
+		assert.Equals(t, []byte("comment"), r.string(t))
+		assert.Len(t, 0, r)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		krl := NewKRL(2, "")
+		krl.GeneratedDate = now
+		krl.RevokeSerial(user, 3)
+		krl.RevokeSerial(user, 1)
+		krl.RevokeSerial(user, 3)
+		krl.RevokeSerial(user, 0)
+		krl.RevokeSerial(nil, 4)
+		krl.RevokeKeyID(user, "foo")
+		krl.RevokeSerial(host, 2)
+		krl.RevokeKeyID(nil, "bar")
+
+		r := krlReader(krl.Marshal())
+		r.uint64(t)
+		r.uint32(t)
+		assert.Equals(t, uint64(2), r.uint64(t))
+		r.uint64(t)
+		r.uint64(t)
+		r.string(t)
+		r.string(t)
+
+		// user section
+		assert.Equals(t, krlSectionCertificates, r.byte(t))
+		s := krlReader(r.string(t))
+		assert.Equals(t, user.Marshal(), s.string(t))
+		assert.Equals(t, []byte{}, s.string(t))
+		assert.Equals(t, krlSectionCertSerialList, s.byte(t))
+		serials := krlReader(s.string(t))
+		assert.Equals(t, uint64(1), serials.uint64(t))
+		assert.Equals(t, uint64(3), serials.uint64(t))
+		assert.Len(t, 0, serials)
+		assert.Equals(t, krlSectionCertKeyID, s.byte(t))
+		ids := krlReader(s.string(t))
+		assert.Equals(t, []byte("foo"), ids.string(t))
+		assert.Len(t, 0, ids)
+		assert.Len(t, 0, s)
+
+		// host section
+		assert.Equals(t, krlSectionCertificates, r.byte(t))
+		s = krlReader(r.string(t))
+		assert.Equals(t, host.Marshal(), s.string(t))
+		assert.Equals(t, []byte{}, s.string(t))
+		assert.Equals(t, krlSectionCertSerialList, s.byte(t))
+		serials = krlReader(s.string(t))
+		assert.Equals(t, uint64(2), serials.uint64(t))
+		assert.Len(t, 0, serials)
+		assert.Len(t, 0, s)
+
+		// any CA section
+		assert.Equals(t, krlSectionCertificates, r.byte(t))
+		s = krlReader(r.string(t))
+		assert.Equals(t, []byte{}, s.string(t))
+		assert.Equals(t, []byte{}, s.string(t))
+		assert.Equals(t, krlSectionCertKeyID, s.byte(t))
+		ids = krlReader(s.string(t))
+		assert.Equals(t, []byte("bar"), ids.string(t))
+		assert.Len(t, 0, ids)
+		assert.Len(t, 0, s)
+
+		assert.Len(t, 0, r)
+	})
+}